@@ -0,0 +1,71 @@
+package compare
+
+import "slices"
+
+// --- ADDED MATCHES ---
+// Matches can be layered on top of the input's word_to_matches once it is
+// loaded, such as words sharing a phonetic code. They go into
+// WordToMatches for validation and, like the input's own, into
+// TradeoutSets for words longer than one character, so they also find
+// candidates. The pair index is keyed by the names' own words, so it stays
+// valid. Where each added match came from is kept for 'rules show'.
+
+// addMatchGroup makes every word of group match every other, recording
+// source for the matches that weren't there yet. It returns the number of
+// words that gained a match.
+func (d *Data) addMatchGroup(group []uint32, source string) int {
+	if d.MatchSources == nil {
+		d.MatchSources = make(map[uint64]string)
+	}
+	gained := 0
+	for _, w := range group {
+		matches, ok := d.WordToMatches[w]
+		if !ok {
+			// A word without an entry still matches itself
+			matches = []uint32{w}
+		}
+		// Copied, as the entry may be shared with TradeoutSets
+		merged := slices.Clone(matches)
+		for _, o := range group {
+			if !slices.Contains(merged, o) {
+				merged = append(merged, o)
+				d.MatchSources[packPair(w, o)] = source
+			}
+		}
+		if len(merged) == len(matches) && ok {
+			continue
+		}
+		gained++
+		d.WordToMatches[w] = merged
+		if len(d.Dict.GetStr(w)) != 1 {
+			d.TradeoutSets[w] = merged
+		} else if _, ok := d.TradeoutSets[w]; !ok {
+			d.TradeoutSets[w] = []uint32{w}
+		}
+	}
+	return gained
+}
+
+// matchSource returns where the match of word with m came from.
+func (d *Data) matchSource(word, m uint32) string {
+	if source, ok := d.MatchSources[packPair(word, m)]; ok {
+		return source
+	}
+	return SourceWordToMatches
+}
+
+// nameWordIDs returns the distinct word IDs of all names, in ID order.
+func (d *Data) nameWordIDs() []uint32 {
+	seen := make([]bool, d.Dict.Len())
+	var ids []uint32
+	for _, words := range d.NameWords {
+		for _, id := range words {
+			if int(id) < len(seen) && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
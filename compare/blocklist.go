@@ -0,0 +1,86 @@
+package compare
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// --- WORD PAIR BLOCKLISTS ---
+// Some false positives follow from a pair of words that look alike to the
+// rules but never denote the same thing, such as "inc" and "llc". A word
+// pair blocklist rejects every pair where one name has one of the words and
+// the other name has the other, even when the pair otherwise passes.
+
+// WordBlocklist holds blocked word pairs, keyed like pair keys (see
+// packPair).
+type WordBlocklist struct {
+	pairs map[uint64]struct{}
+	// Lines naming a word no name contains
+	unknown int
+}
+
+// ParseWordBlocklist reads one word pair per line, the two words separated
+// by a comma or whitespace. Blank lines and lines starting with # are
+// skipped. Words go through the input's normalization and case folding, so
+// they line up with the names' words; a pair with a word no name contains
+// can't block anything and is only counted (see Unknown).
+func ParseWordBlocklist(r io.Reader, d *Data) (*WordBlocklist, error) {
+	b := &WordBlocklist{pairs: make(map[uint64]struct{})}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		words := strings.FieldsFunc(text, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+		if len(words) != 2 {
+			return nil, &InputError{Line: line, Err: fmt.Errorf("want two words, got %d", len(words))}
+		}
+		a, okA := d.lookupWord(words[0])
+		c, okC := d.lookupWord(words[1])
+		if !okA || !okC {
+			b.unknown++
+			continue
+		}
+		b.pairs[packPair(a, c)] = struct{}{}
+	}
+	return b, scanner.Err()
+}
+
+// lookupWord returns the ID a name's word spelt as word would have.
+func (d *Data) lookupWord(word string) (uint32, bool) {
+	word = d.Normalize.Apply(word)
+	if d.FoldCase {
+		word = strings.ToLower(word)
+	}
+	return d.Dict.Lookup(word)
+}
+
+// Len returns the number of blocked pairs, and Unknown the number of pairs
+// left out for naming a word no name contains.
+func (b *WordBlocklist) Len() int {
+	return len(b.pairs)
+}
+
+func (b *WordBlocklist) Unknown() int {
+	return b.unknown
+}
+
+// blocks reports whether a word of partsA and a different word of partsB
+// form a blocked pair.
+func (b *WordBlocklist) blocks(partsA, partsB []uint32) bool {
+	for _, a := range partsA {
+		for _, c := range partsB {
+			if a == c {
+				continue
+			}
+			if _, ok := b.pairs[packPair(a, c)]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package compare
+
+import (
+	"strings"
+	"testing"
+)
+
+const blocklistInput = `{
+	"all_names": ["acme inc", "acme llc", "acme corp", "Acme Ltd"],
+	"word_to_matches": {
+		"acme": ["acme"], "inc": ["inc", "llc", "corp"], "llc": ["llc", "inc"], "corp": ["corp", "inc"],
+		"ltd": ["ltd", "llc"]
+	}
+}`
+
+func TestParseWordBlocklist(t *testing.T) {
+	data, err := LoadWithOptions(strings.NewReader(blocklistInput), LoadOptions{FoldCase: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParseWordBlocklist(strings.NewReader(`# corporate forms
+inc,llc
+
+  LLC   ltd
+inc,gmbh
+sarl bv
+`), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Case folded like the names, and the pairs with words no name has
+	// only counted
+	if b.Len() != 2 || b.Unknown() != 2 {
+		t.Errorf("%d pairs, %d unknown; want 2 and 2", b.Len(), b.Unknown())
+	}
+
+	for doc, want := range map[string]string{
+		"inc,llc\ninc\n":            "line 2: want two words, got 1",
+		"inc,llc\n\ninc llc corp\n": "line 3: want two words, got 3",
+	} {
+		if _, err := ParseWordBlocklist(strings.NewReader(doc), data); err == nil || err.Error() != want {
+			t.Errorf("%q: error %v, want %q", doc, err, want)
+		}
+	}
+}
+
+func TestWordBlocklistBlocks(t *testing.T) {
+	data := loadString(t, blocklistInput)
+	b, err := ParseWordBlocklist(strings.NewReader("llc,inc\n"), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultMatchConfig()
+	cfg.MinCommonWords = 1
+	m := NewMatcher(data, Options{Match: &cfg})
+	blocked := func() Options {
+		cfg := cfg
+		cfg.Blocklist = b
+		return Options{Match: &cfg}
+	}()
+	mb := NewMatcher(data, blocked)
+	for _, c := range []struct {
+		a, b    string
+		blocked bool
+	}{
+		// Either order of the listed pair blocks
+		{"acme inc", "acme llc", true},
+		{"acme llc", "acme inc", true},
+		// Other pairs of matching words don't
+		{"acme inc", "acme corp", false},
+	} {
+		if ok, _ := m.Validate(c.a, c.b); !ok {
+			t.Errorf("Validate(%q, %q) fails without the blocklist", c.a, c.b)
+		}
+		if ok, _ := mb.Validate(c.a, c.b); ok == c.blocked {
+			t.Errorf("Validate(%q, %q) = %v with the blocklist, want %v", c.a, c.b, ok, !c.blocked)
+		}
+	}
+	e, err := mb.Explain("acme inc", "acme llc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.RejectedBy != ruleBlocklist {
+		t.Errorf("explain: rejected by %q, want %s", e.RejectedBy, ruleBlocklist)
+	}
+}
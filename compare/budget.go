@@ -0,0 +1,83 @@
+package compare
+
+import (
+	"math/bits"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// evaluationBudget hands out Options.MaxEvaluations across the names of a
+// run. Each name reserves a share of what is left in proportion to its cost
+// estimate (see Matcher.schedule) against the estimates of every name not
+// yet started, and gives back what it didn't use. Names that come in under
+// their estimate thereby raise the share of the names after them, instead of
+// the first names taking the whole budget.
+type evaluationBudget struct {
+	mu sync.Mutex
+	// Evaluations not reserved by any name
+	remaining uint64
+	// Sum of the cost estimates of names not yet started
+	pendingCost uint64
+	// Indexes of names that ran out of their share
+	truncated []int
+}
+
+func newEvaluationBudget(max uint64, costs []uint64, order []uint32) *evaluationBudget {
+	b := &evaluationBudget{remaining: max}
+	for _, idx := range order {
+		b.pendingCost += costs[idx]
+	}
+	return b
+}
+
+// reserve takes the share of a name with the given cost estimate.
+func (b *evaluationBudget) reserve(cost uint64) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	share := b.remaining
+	if cost < b.pendingCost {
+		hi, lo := bits.Mul64(b.remaining, cost)
+		share, _ = bits.Div64(hi, lo, b.pendingCost)
+	}
+	// The estimate is an upper bound, so more than it would go unused.
+	// Rounding must not starve a cheap name while budget is left.
+	share = min(max(share, 1), cost, b.remaining)
+	b.pendingCost -= min(cost, b.pendingCost)
+	b.remaining -= share
+	return share
+}
+
+// release gives back the unused part of a share and records whether the name
+// was truncated.
+func (b *evaluationBudget) release(idx int, unused uint64, truncated bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remaining += unused
+	if truncated {
+		b.truncated = append(b.truncated, idx)
+	}
+}
+
+// Evaluations returns how many candidate pairs have been validated so far.
+// It is safe to call while Run is in progress.
+func (m *Matcher) Evaluations() uint64 {
+	return atomic.LoadUint64(&m.evaluations)
+}
+
+// Truncated returns the names, in input order, that ran out of their share
+// of Options.MaxEvaluations before all their candidates were validated.
+func (m *Matcher) Truncated() []string {
+	if m.budget == nil {
+		return nil
+	}
+	m.budget.mu.Lock()
+	idxs := append([]int(nil), m.budget.truncated...)
+	m.budget.mu.Unlock()
+	sort.Ints(idxs)
+	names := make([]string, len(idxs))
+	for i, idx := range idxs {
+		names[i] = m.data.AllNames[idx]
+	}
+	return names
+}
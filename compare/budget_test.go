@@ -0,0 +1,151 @@
+package compare
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// groupsInput has a group of names for each size, the names of a group
+// sharing two words and differing in a third, so each name's candidates are
+// the rest of its group.
+func groupsInput(sizes []int) string {
+	var names, words []string
+	for g, size := range sizes {
+		words = append(words, fmt.Sprintf(`"s%d": ["s%d"]`, g, g), fmt.Sprintf(`"t%d": ["t%d"]`, g, g))
+		for i := range size {
+			names = append(names, fmt.Sprintf(`"s%d t%d u%d_%d"`, g, g, g, i))
+			words = append(words, fmt.Sprintf(`"u%d_%d": ["u%d_%d"]`, g, i, g, i))
+		}
+	}
+	return `{"all_names": [` + strings.Join(names, ", ") + `], "word_to_matches": {` + strings.Join(words, ", ") + `}}`
+}
+
+// budgetRun runs one worker under opts and returns the pairs emitted for
+// each name, which with every candidate passing are its evaluations.
+func budgetRun(t *testing.T, data *Data, opts Options) (*Matcher, map[string]uint64) {
+	t.Helper()
+	var mu sync.Mutex
+	var emitted uint64
+	perName := make(map[string]uint64)
+	opts.Workers = 1
+	// Every candidate validates
+	opts.Match = &MatchConfig{MaxMismatches: -1}
+	opts.OnNameDone = func(_ int, idx int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		perName[data.AllNames[idx]] = emitted
+		emitted = 0
+		return nil
+	}
+	m := NewMatcher(data, opts)
+	err := m.Run(context.Background(), func(Pair) {
+		mu.Lock()
+		defer mu.Unlock()
+		emitted++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, perName
+}
+
+// A cap far below what the run needs is shared out by cost: the heavy group
+// doesn't starve the small ones, every evaluation is counted, and exactly
+// the names cut short are reported.
+func TestMaxEvaluations(t *testing.T) {
+	sizes := []int{40}
+	for range 30 {
+		sizes = append(sizes, 3)
+	}
+	data := loadString(t, groupsInput(sizes))
+	m, full := budgetRun(t, data, Options{})
+	var needed uint64
+	for _, n := range full {
+		needed += n
+	}
+	if needed != 40*39+30*3*2 || m.Evaluations() != needed || m.Truncated() != nil {
+		t.Fatalf("uncapped: %d evaluations counted, %d emitted, truncated %q", m.Evaluations(), needed, m.Truncated())
+	}
+
+	const limit = 300
+	m, capped := budgetRun(t, data, Options{MaxEvaluations: limit})
+	// Estimated costs: a name of the heavy group looks up buckets of 40, 1
+	// and 1 names, one of a small group buckets of 3, 1 and 1
+	const heavyCost, smallCost, totalCost = 42, 5, 40*42 + 90*5
+	var total, small uint64
+	var truncated []string
+	for _, name := range data.AllNames {
+		n := capped[name]
+		total += n
+		cost := uint64(heavyCost)
+		if !strings.HasPrefix(name, "s0 ") {
+			cost = smallCost
+			small += n
+		}
+		// A name's share of what is left moves as others give back
+		// what they didn't use, but stays near its share of the cap
+		if n > limit*cost/totalCost+2 {
+			t.Errorf("%q: %d evaluations, over its share of %d", name, n, limit*cost/totalCost)
+		}
+		if n > full[name] {
+			t.Errorf("%q: %d evaluations, more than its %d candidates", name, n, full[name])
+		}
+		if n < full[name] {
+			truncated = append(truncated, name)
+		}
+	}
+	if total != m.Evaluations() || total > limit {
+		t.Errorf("%d evaluations counted, %d made, cap %d", m.Evaluations(), total, limit)
+	}
+	// The heavy group, run first, leaves the small ones their share
+	if share := uint64(limit * 90 * smallCost / totalCost); small < share {
+		t.Errorf("the small groups made %d of %d evaluations, their share is %d", small, total, share)
+	}
+	if got := m.Truncated(); !slices.Equal(got, truncated) {
+		t.Errorf("truncated %q, want %q", got, truncated)
+	}
+
+	// Workers share the budget without losing count
+	var emitted atomic.Uint64
+	m = NewMatcher(data, Options{Workers: 4, MaxEvaluations: limit, Match: &MatchConfig{MaxMismatches: -1}})
+	if err := m.Run(context.Background(), func(Pair) { emitted.Add(1) }); err != nil {
+		t.Fatal(err)
+	}
+	if emitted.Load() != m.Evaluations() || m.Evaluations() > limit || len(m.Truncated()) == 0 {
+		t.Errorf("4 workers: %d evaluations counted, %d made, %d names truncated", m.Evaluations(), emitted.Load(), len(m.Truncated()))
+	}
+}
+
+func TestEvaluationBudgetReserve(t *testing.T) {
+	costs := []uint64{100, 10, 10, 10, 10, 10}
+	b := newEvaluationBudget(50, costs, []uint32{0, 1, 2, 3, 4, 5})
+	// 50 of 150 estimated: a third of each estimate, rounded down
+	for i, want := range []uint64{33, 3} {
+		if got := b.reserve(costs[i]); got != want {
+			t.Errorf("name %d: share %d, want %d", i, got, want)
+		}
+	}
+	// What a name doesn't use goes to the names after it: 17 left for 40
+	b.release(1, 3, false)
+	if got := b.reserve(10); got != 4 {
+		t.Errorf("share after a release %d, want 4", got)
+	}
+	b.release(2, 0, true)
+	// The last name gets whatever is left
+	for i, want := range []uint64{4, 4, 5} {
+		if got := b.reserve(10); got != want {
+			t.Errorf("name %d: share %d, want %d", i+3, got, want)
+		}
+	}
+	if got := b.reserve(10); got != 0 {
+		t.Errorf("share %d of a spent budget", got)
+	}
+	if !slices.Equal(b.truncated, []int{2}) {
+		t.Errorf("truncated %v", b.truncated)
+	}
+}
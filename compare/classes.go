@@ -0,0 +1,316 @@
+package compare
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// --- TOKEN CLASSES ---
+// Every dictionary word is classified once as an initial, a particle, a
+// generational suffix or a full word, and each class can be given its own
+// matching policy:
+//
+//   - A word in the particle list is a particle, even if it is a single
+//     character. A generational suffix ("jr", "sr", "iii", see
+//     DefaultSuffixes) is a suffix. Otherwise a single-character word is an
+//     initial, and everything else is a full word.
+//   - PolicyRules (the default for every class) matches through
+//     word_to_matches only, exactly as without classes.
+//   - PolicyIgnore removes the class's tokens before validation: they are
+//     never mismatches, and they don't count toward a name's length either,
+//     so "maria de la cruz" validates like "maria cruz". They are left out
+//     of the pair keys the name looks up as well.
+//   - PolicyFirstLetter (initials only) additionally lets an initial match
+//     any non-ignored token on the other side that starts with the same
+//     letter. This is symmetric: "john" matches a "j" initial on the other
+//     side as well.
+//   - PolicyStrict (suffixes only) ignores suffixes like PolicyIgnore, but
+//     rejects a pair whose names both have a suffix and disagree on it, so
+//     "john smith jr" never matches "john smith sr". A suffix on one side
+//     only doesn't matter.
+
+type TokenClass uint8
+
+const (
+	ClassWord TokenClass = iota
+	ClassInitial
+	ClassParticle
+	ClassSuffix
+	numTokenClasses
+)
+
+var tokenClassNames = [numTokenClasses]string{"word", "initial", "particle", "suffix"}
+
+func (c TokenClass) String() string {
+	return tokenClassNames[c]
+}
+
+type ClassPolicy uint8
+
+const (
+	PolicyRules ClassPolicy = iota
+	PolicyIgnore
+	PolicyFirstLetter
+	PolicyStrict
+)
+
+var classPolicyNames = map[string]ClassPolicy{
+	"rules":        PolicyRules,
+	"ignore":       PolicyIgnore,
+	"first-letter": PolicyFirstLetter,
+	"strict":       PolicyStrict,
+}
+
+// ClassPolicies holds the policy for each TokenClass. The zero value applies
+// PolicyRules to every class.
+type ClassPolicies [numTokenClasses]ClassPolicy
+
+// ParseClassPolicies parses a list like "initial=first-letter,particle=ignore".
+// Classes that aren't mentioned keep PolicyRules.
+func ParseClassPolicies(s string) (ClassPolicies, error) {
+	var p ClassPolicies
+	if strings.TrimSpace(s) == "" {
+		return p, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		className, policyName, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return p, &InputError{Err: fmt.Errorf("class policy %q: want class=policy", part)}
+		}
+		class := numTokenClasses
+		for c, name := range tokenClassNames {
+			if name == className {
+				class = TokenClass(c)
+			}
+		}
+		if class == numTokenClasses {
+			return p, &InputError{Err: fmt.Errorf("class policy %q: unknown class %q (want word, initial, particle or suffix)", part, className)}
+		}
+		policy, ok := classPolicyNames[policyName]
+		if !ok {
+			return p, &InputError{Err: fmt.Errorf("class policy %q: unknown policy %q (want rules, ignore, first-letter or strict)", part, policyName)}
+		}
+		if policy == PolicyFirstLetter && class != ClassInitial {
+			return p, &InputError{Err: fmt.Errorf("class policy %q: first-letter only applies to initials", part)}
+		}
+		if policy == PolicyStrict && class != ClassSuffix {
+			return p, &InputError{Err: fmt.Errorf("class policy %q: strict only applies to suffixes", part)}
+		}
+		if policy == PolicyIgnore && class == ClassWord {
+			return p, &InputError{Err: fmt.Errorf("class policy %q: full words can't be ignored", part)}
+		}
+		p[class] = policy
+	}
+	return p, nil
+}
+
+// DefaultParticles is used when ClassifyTokens is given no particle list.
+var DefaultParticles = []string{
+	"al", "bin", "da", "das", "de", "del", "della", "der", "di", "dos", "du",
+	"el", "ibn", "la", "le", "van", "von", "y",
+}
+
+// DefaultSuffixes are the generational suffixes, each group one generation.
+// Words are compared to them lowercased and without a trailing period, so
+// "Jr." and "III" are suffixes too.
+var DefaultSuffixes = [][]string{
+	{"jr", "junior"},
+	{"sr", "senior"},
+	{"ii", "2nd"},
+	{"iii", "3rd"},
+	{"iv", "4th"},
+}
+
+// suffixGeneration returns 1 plus the index of word's group in
+// DefaultSuffixes, or 0 if word isn't a generational suffix.
+func suffixGeneration(word string) uint8 {
+	word = strings.ToLower(strings.TrimSuffix(word, "."))
+	for i, group := range DefaultSuffixes {
+		if slices.Contains(group, word) {
+			return uint8(i + 1)
+		}
+	}
+	return 0
+}
+
+// ClassifyTokens assigns a TokenClass to every dictionary word, filling
+// Data.Classes and Data.FirstRunes. A nil particles list means
+// DefaultParticles.
+func (d *Data) ClassifyTokens(particles []string) {
+	if particles == nil {
+		particles = DefaultParticles
+	}
+	isParticle := make(map[string]bool, len(particles))
+	for _, p := range particles {
+		isParticle[p] = true
+	}
+	n := d.Dict.Len()
+	d.Classes = make([]TokenClass, n)
+	d.FirstRunes = make([]rune, n)
+	for id := 0; id < n; id++ {
+		word := d.Dict.GetStr(uint32(id))
+		first, _ := utf8.DecodeRuneInString(word)
+		d.FirstRunes[id] = first
+		d.Classes[id] = classifyWord(word, isParticle)
+	}
+}
+
+// classifyWord applies the class rules to one word. A nil isParticle means
+// DefaultParticles.
+func classifyWord(word string, isParticle map[string]bool) TokenClass {
+	switch {
+	case isParticle == nil && slices.Contains(DefaultParticles, word), isParticle[word]:
+		return ClassParticle
+	case suffixGeneration(word) != 0:
+		return ClassSuffix
+	case utf8.RuneCountInString(word) == 1:
+		return ClassInitial
+	}
+	return ClassWord
+}
+
+// classRules is what validateOptimized consults; nil when every class uses
+// PolicyRules, which keeps the hot path exactly as it was.
+type classRules struct {
+	classes  []TokenClass
+	first    []rune
+	policies ClassPolicies
+	// Generation of every suffix word (see suffixGeneration), 0 for other
+	// words; nil unless suffixes are strict
+	generations []uint8
+}
+
+func newClassRules(data *Data, policies ClassPolicies) *classRules {
+	if policies == (ClassPolicies{}) {
+		return nil
+	}
+	if data.Classes == nil {
+		data.ClassifyTokens(nil)
+	}
+	r := &classRules{classes: data.Classes, first: data.FirstRunes, policies: policies}
+	if policies[ClassSuffix] == PolicyStrict {
+		r.generations = make([]uint8, len(data.Classes))
+		for id, class := range data.Classes {
+			if class == ClassSuffix {
+				r.generations[id] = suffixGeneration(data.Dict.GetStr(uint32(id)))
+			}
+		}
+	}
+	return r
+}
+
+func (r *classRules) policy(wID uint32) ClassPolicy {
+	if int(wID) >= len(r.classes) {
+		return PolicyRules
+	}
+	return r.policies[r.classes[wID]]
+}
+
+func (r *classRules) ignored(wID uint32) bool {
+	p := r.policy(wID)
+	return p == PolicyIgnore || p == PolicyStrict
+}
+
+// suffixConflict reports whether both names have a generational suffix and
+// one of them has a suffix the other lacks.
+func (r *classRules) suffixConflict(partsA, partsB []uint32) bool {
+	if r.generations == nil {
+		return false
+	}
+	return r.lacksSuffixOf(partsA, partsB) || r.lacksSuffixOf(partsB, partsA)
+}
+
+// lacksSuffixOf reports whether parts has a suffix and some suffix of other
+// isn't among them.
+func (r *classRules) lacksSuffixOf(parts, other []uint32) bool {
+	if !r.hasSuffix(parts) {
+		return false
+	}
+	for _, oID := range other {
+		g := r.generation(oID)
+		if g == 0 {
+			continue
+		}
+		found := false
+		for _, wID := range parts {
+			if r.generation(wID) == g {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *classRules) hasSuffix(parts []uint32) bool {
+	for _, wID := range parts {
+		if r.generation(wID) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *classRules) generation(wID uint32) uint8 {
+	if int(wID) >= len(r.generations) {
+		return 0
+	}
+	return r.generations[wID]
+}
+
+// keyWords returns parts without the tokens of ignored classes, in
+// scratch.words if scratch isn't nil, or parts itself if none is ignored.
+func (r *classRules) keyWords(parts []uint32, scratch *pairScratch) []uint32 {
+	if !slices.ContainsFunc(parts, r.ignored) {
+		return parts
+	}
+	var kept []uint32
+	if scratch != nil {
+		kept = scratch.words[:0]
+	}
+	for _, wID := range parts {
+		if !r.ignored(wID) {
+			kept = append(kept, wID)
+		}
+	}
+	if scratch != nil {
+		scratch.words = kept
+	}
+	return kept
+}
+
+// effectiveLen is the name length with ignored tokens left out.
+func (r *classRules) effectiveLen(parts []uint32) int {
+	n := 0
+	for _, wID := range parts {
+		if !r.ignored(wID) {
+			n++
+		}
+	}
+	return n
+}
+
+// firstLetterMatch reports whether wID matches some token of other through
+// the initials' first-letter policy.
+func (r *classRules) firstLetterMatch(wID uint32, other []uint32) bool {
+	if int(wID) >= len(r.classes) || r.policies[ClassInitial] != PolicyFirstLetter {
+		return false
+	}
+	isInitial := r.classes[wID] == ClassInitial
+	for _, oID := range other {
+		if int(oID) >= len(r.classes) || r.ignored(oID) || r.first[oID] != r.first[wID] {
+			continue
+		}
+		// An initial matches anything with its letter; a full word only
+		// matches an initial
+		if isInitial || r.classes[oID] == ClassInitial {
+			return true
+		}
+	}
+	return false
+}
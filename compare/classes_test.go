@@ -0,0 +1,130 @@
+package compare
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestClassifyWord(t *testing.T) {
+	for word, want := range map[string]TokenClass{
+		"john": ClassWord, "j": ClassInitial, "é": ClassInitial, "de": ClassParticle,
+		"y": ClassParticle, "jr": ClassSuffix, "Jr.": ClassSuffix, "iii": ClassSuffix, "junior": ClassSuffix,
+	} {
+		if got := classifyWord(word, nil); got != want {
+			t.Errorf("classifyWord(%q) = %v, want %v", word, got, want)
+		}
+	}
+	// A particle list of its own replaces the defaults
+	if got := classifyWord("de", map[string]bool{"bin": true}); got != ClassWord {
+		t.Errorf("de with its own particle list: %v", got)
+	}
+	if got := classifyWord("y", map[string]bool{"bin": true}); got != ClassInitial {
+		t.Errorf("y with its own particle list: %v", got)
+	}
+}
+
+func TestParseClassPolicies(t *testing.T) {
+	policies := []string{"rules", "ignore", "first-letter", "strict"}
+	valid := map[string]bool{
+		"word=rules": true, "initial=rules": true, "initial=ignore": true, "initial=first-letter": true,
+		"particle=rules": true, "particle=ignore": true, "suffix=rules": true, "suffix=ignore": true, "suffix=strict": true,
+	}
+	for _, class := range tokenClassNames {
+		for _, policy := range policies {
+			s := class + "=" + policy
+			_, err := ParseClassPolicies(s)
+			if (err == nil) != valid[s] {
+				t.Errorf("ParseClassPolicies(%q): %v, want valid %v", s, err, valid[s])
+			}
+		}
+	}
+	for _, s := range []string{"initial", "middle=rules", "initial=loose"} {
+		if _, err := ParseClassPolicies(s); err == nil {
+			t.Errorf("ParseClassPolicies(%q) succeeded", s)
+		}
+	}
+}
+
+const classInput = `{
+	"all_names": ["john q smith", "jon quentin smith", "jon peter smith", "maria de cruz", "mary la cruz",
+		"john smith jr", "jon smith sr", "jon smith junior", "jon smith"],
+	"word_to_matches": {
+		"john": ["john", "jon"], "jon": ["jon", "john"], "smith": ["smith"], "quentin": ["quentin"], "peter": ["peter"],
+		"maria": ["maria", "mary"], "mary": ["mary", "maria"], "cruz": ["cruz"]
+	}
+}`
+
+// Every class under every policy it accepts, against pairs that tell the
+// policies apart.
+func TestClassPolicyMatrix(t *testing.T) {
+	data := loadString(t, classInput)
+	for _, c := range []struct {
+		a, b string
+		// Verdict by the policy of the class the pair is about
+		want map[string]bool
+	}{
+		// An unknown initial is a mismatch that makes a three-word name
+		// strict; ignored it shortens the name, and first-letter matches
+		// it to a word of the same letter only
+		{"john q smith", "jon quentin smith", map[string]bool{
+			"initial=rules": false, "initial=ignore": true, "initial=first-letter": true}},
+		{"john q smith", "jon peter smith", map[string]bool{
+			"initial=rules": false, "initial=ignore": true, "initial=first-letter": false}},
+		{"maria de cruz", "mary la cruz", map[string]bool{
+			"particle=rules": false, "particle=ignore": true}},
+		// Strict suffixes are ignored unless both names have one and they
+		// differ
+		{"john smith jr", "jon smith sr", map[string]bool{
+			"suffix=rules": false, "suffix=ignore": true, "suffix=strict": false}},
+		{"john smith jr", "jon smith junior", map[string]bool{
+			"suffix=rules": false, "suffix=ignore": true, "suffix=strict": true}},
+		{"john smith jr", "jon smith", map[string]bool{
+			"suffix=rules": true, "suffix=ignore": true, "suffix=strict": true}},
+		// Policies of other classes leave a pair without their tokens alone
+		{"john q smith", "jon quentin smith", map[string]bool{
+			"word=rules": false, "particle=ignore": false, "suffix=strict": false}},
+	} {
+		for spec, want := range c.want {
+			policies, err := ParseClassPolicies(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := NewMatcher(data, Options{ClassPolicies: policies})
+			if got, _ := m.Validate(c.a, c.b); got != want {
+				t.Errorf("%s: Validate(%q, %q) = %v, want %v", spec, c.a, c.b, got, want)
+			}
+		}
+	}
+}
+
+// Ignored tokens are left out of the pair keys a name looks up, and still
+// the names that differ only by them pair up.
+func TestIgnoredTokensLeaveKeys(t *testing.T) {
+	data := loadString(t, `{
+		"all_names": ["ann de lee", "bob de ray", "ann lee"],
+		"word_to_matches": {"ann": ["ann"], "lee": ["lee"], "bob": ["bob"], "ray": ["ray"], "de": ["de"]}
+	}`)
+	for _, c := range []struct {
+		spec    string
+		lookups uint64
+	}{
+		{"", 7},
+		{"particle=ignore", 3},
+	} {
+		policies, err := ParseClassPolicies(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := NewMatcher(data, Options{ClassPolicies: policies})
+		if err := m.Run(context.Background(), func(Pair) {}); err != nil {
+			t.Fatal(err)
+		}
+		if got := m.Diagnosis().Lookups; got != c.lookups {
+			t.Errorf("%q: %d lookups, want %d", c.spec, got, c.lookups)
+		}
+		if got := fmt.Sprint(runPairs(t, data, Options{ClassPolicies: policies})); got != "[ann de lee|ann lee]" {
+			t.Errorf("%q: pairs %s", c.spec, got)
+		}
+	}
+}
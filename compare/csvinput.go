@@ -0,0 +1,223 @@
+package compare
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// --- CSV INPUT ---
+// Corpora often live in spreadsheet or CRM exports, so an input can also be
+// given as CSV files: one of names and, optionally, one each for
+// word_to_matches and pair_to_names. They feed the same visitor as a JSON
+// document, so everything after decoding is shared.
+
+// CSVInput is an input given as CSV files. Every file starts with a header
+// row.
+type CSVInput struct {
+	// The names, one row each
+	Names io.Reader
+	// Columns of Names holding the parts of a name, by header or 1-based
+	// position. The non-empty ones are joined with spaces, so a name split
+	// over first, middle and last name columns comes out whole. Empty means
+	// the first column.
+	NameColumns []string
+	// Rows of a word followed by its matches, one or more per row; a word
+	// may span several rows. nil for no word_to_matches.
+	WordMatches io.Reader
+	// Rows of a pair key followed by names in its bucket, one or more per
+	// row; nil to build pair_to_names from the names.
+	PairNames io.Reader
+}
+
+// LoadCSV loads an input given as CSV files. Dictionary files (see
+// LoadWithDictionary) only apply to JSON documents, so opts.Dict must be
+// nil.
+func LoadCSV(in CSVInput, opts LoadOptions) (*Data, error) {
+	if opts.Dict != nil {
+		return nil, &InputError{Err: errors.New("dictionary files only apply to JSON input")}
+	}
+	return load(func(v InputVisitor) error { return StreamCSVInput(in, v) }, opts)
+}
+
+// StreamCSVInput passes the entries of a CSV input to v, like StreamInput
+// does for a JSON document. Rows of a word are gathered before it is
+// passed on, while pair keys are passed on for each run of rows sharing a
+// key.
+func StreamCSVInput(in CSVInput, v InputVisitor) error {
+	if err := streamCSVNames(in.Names, in.NameColumns, v.Name); err != nil {
+		return csvError("names", err)
+	}
+	if in.WordMatches != nil {
+		if err := streamCSVWordMatches(in.WordMatches, v.WordMatches); err != nil {
+			return csvError("word_to_matches", err)
+		}
+	}
+	if in.PairNames != nil {
+		if err := streamCSVPairNames(in.PairNames, v.PairNames); err != nil {
+			return csvError("pair_to_names", err)
+		}
+	}
+	return nil
+}
+
+// csvError is the InputError of a CSV file, on the line of a parse error.
+func csvError(field string, err error) error {
+	var parse *csv.ParseError
+	if errors.As(err, &parse) {
+		return &InputError{Field: field, Line: parse.Line, Err: parse.Err}
+	}
+	return &InputError{Field: field, Err: err}
+}
+
+func newCSVReader(r io.Reader) *csv.Reader {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	return cr
+}
+
+func streamCSVNames(r io.Reader, columns []string, fn func(string)) error {
+	cr := newCSVReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	indexes, err := csvColumns(header, columns)
+	if err != nil {
+		return err
+	}
+	var parts []string
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		parts = parts[:0]
+		for _, i := range indexes {
+			if i < len(record) {
+				if part := strings.TrimSpace(record[i]); part != "" {
+					parts = append(parts, part)
+				}
+			}
+		}
+		if len(parts) > 0 && fn != nil {
+			fn(strings.Join(parts, " "))
+		}
+	}
+}
+
+// csvColumns resolves column specs against a header row.
+func csvColumns(header, columns []string) ([]int, error) {
+	if len(columns) == 0 {
+		return []int{0}, nil
+	}
+	indexes := make([]int, len(columns))
+	for i, col := range columns {
+		col = strings.TrimSpace(col)
+		if n, err := strconv.Atoi(col); err == nil {
+			if n < 1 {
+				return nil, fmt.Errorf("column %d: positions start at 1", n)
+			}
+			indexes[i] = n - 1
+			continue
+		}
+		indexes[i] = -1
+		for j, h := range header {
+			if strings.TrimSpace(h) == col {
+				indexes[i] = j
+				break
+			}
+		}
+		if indexes[i] < 0 {
+			return nil, fmt.Errorf("no column %q in header", col)
+		}
+	}
+	return indexes, nil
+}
+
+func streamCSVWordMatches(r io.Reader, fn func(string, []string)) error {
+	var words []string
+	matches := make(map[string][]string)
+	err := forEachCSVRow(r, func(key string, values []string) {
+		if _, ok := matches[key]; !ok {
+			words = append(words, key)
+			matches[key] = []string{}
+		}
+		matches[key] = append(matches[key], values...)
+	})
+	if err != nil {
+		return err
+	}
+	if fn != nil {
+		for _, word := range words {
+			fn(word, matches[word])
+		}
+	}
+	return nil
+}
+
+func streamCSVPairNames(r io.Reader, fn func(string, []string)) error {
+	var pair string
+	var names []string
+	flush := func() {
+		if len(names) > 0 && fn != nil {
+			fn(pair, names)
+		}
+		names = nil
+	}
+	err := forEachCSVRow(r, func(key string, values []string) {
+		if key != pair {
+			flush()
+			pair = key
+		}
+		names = append(names, values...)
+	})
+	if err != nil {
+		return err
+	}
+	flush()
+	return nil
+}
+
+// forEachCSVRow calls fn with the first field and the other non-empty
+// fields of every row after the header. Rows with an empty first field are
+// skipped.
+func forEachCSVRow(r io.Reader, fn func(key string, values []string)) error {
+	cr := newCSVReader(r)
+	if _, err := cr.Read(); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		key := strings.TrimSpace(record[0])
+		if key == "" {
+			continue
+		}
+		var values []string
+		for _, field := range record[1:] {
+			if field = strings.TrimSpace(field); field != "" {
+				values = append(values, field)
+			}
+		}
+		fn(key, values)
+	}
+}
@@ -0,0 +1,56 @@
+package compare
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// smallInput as CSV files, with the names split over two columns and a
+// word's matches spread over two rows.
+const (
+	smallNamesCSV = `id,first,last
+1,john,smith
+2,jon,smith
+3,john,smyth
+4,mary,jones
+5,mary,smith
+6,john,smith
+`
+	smallMatchesCSV = `word,matches
+john,john,jon
+jon,jon,john
+smith,smith
+smith,smyth
+smyth,smyth,smith
+mary,mary
+jones,jones
+`
+)
+
+// A CSV input finds the pairs of the same input as JSON, whether its
+// pair_to_names is built from the names or not.
+func TestLoadCSV(t *testing.T) {
+	want := runPairs(t, loadString(t, smallInput), Options{})
+	data, err := LoadCSV(CSVInput{
+		Names:       strings.NewReader(smallNamesCSV),
+		NameColumns: []string{"first", "3"},
+		WordMatches: strings.NewReader(smallMatchesCSV),
+	}, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(data.AllNames, loadString(t, smallInput).AllNames) {
+		t.Errorf("names %q", data.AllNames)
+	}
+	if got := runPairs(t, data, Options{}); !slices.Equal(got, want) {
+		t.Errorf("pairs %q, want %q", got, want)
+	}
+
+	var input *InputError
+	_, err = LoadCSV(CSVInput{Names: strings.NewReader("name\njohn smith\n\"jon smith\n")}, LoadOptions{})
+	if !errors.As(err, &input) || input.Field != "names" || input.Line != 3 {
+		t.Errorf("an unterminated quote: %v, want an InputError in names on line 3", err)
+	}
+}
@@ -0,0 +1,147 @@
+package compare
+
+import "sort"
+
+// Diagnosis breaks down where a run's candidates went, for runs that found
+// suspiciously few pairs. Zero matches is rarely a property of the corpus:
+// pair keys in a different format than the names produce show up as a hit
+// rate of zero, missing rules as words without rules, and thresholds that
+// are too strict as one rule rejecting everything.
+type Diagnosis struct {
+	// Names the run processes, and those with the two words needed to look
+	// anything up
+	Names         int
+	NamesWithKeys int
+	// Distinct words of those names, and those with a word_to_matches entry
+	Words          int
+	WordsWithRules int
+	// Pair keys looked up, and how many found a bucket
+	Lookups uint64
+	Hits    uint64
+	// Names whose buckets held at least one other name, and the number of
+	// candidates over all lookups
+	NamesWithCandidates int
+	Candidates          uint64
+	// Candidates validated, and how many of them passed; the rest are
+	// counted by the rule that rejected them
+	Validated  int
+	Passed     int
+	RejectedBy map[string]int
+}
+
+// RuleCount is a validation rule and the number of pairs it rejected.
+type RuleCount struct {
+	Rule  string
+	Pairs int
+}
+
+// TopRejections returns the rules that rejected pairs, most first.
+func (d *Diagnosis) TopRejections() []RuleCount {
+	counts := make([]RuleCount, 0, len(d.RejectedBy))
+	for rule, n := range d.RejectedBy {
+		counts = append(counts, RuleCount{Rule: rule, Pairs: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Pairs != counts[j].Pairs {
+			return counts[i].Pairs > counts[j].Pairs
+		}
+		return counts[i].Rule < counts[j].Rule
+	})
+	return counts
+}
+
+// Cause names the most likely reason for a run finding few pairs, going
+// down the pipeline and stopping at the first stage that lost everything.
+func (d *Diagnosis) Cause() string {
+	switch {
+	case d.NamesWithKeys == 0:
+		return "no name has two words, so no name looks anything up"
+	case d.NamesWithCandidates == 0 && d.Hits*100 < d.Lookups:
+		// A stray hit or two, such as keys of single letters, doesn't
+		// change the picture
+		return "hardly any pair key found a bucket, so pair_to_names doesn't use the names' words as keys; check the key format, and that --normalize and --fold-case-compare match how the index was built"
+	case d.NamesWithCandidates == 0:
+		return "every bucket found held only the name that looked it up"
+	case d.WordsWithRules == 0:
+		return "no word of any name has a word_to_matches entry, so no word matches any other"
+	case d.Validated > 0 && d.Passed == 0:
+		top := d.TopRejections()
+		return "every validated candidate was rejected, most by " + top[0].Rule + "; check that threshold"
+	case d.Passed > 0:
+		return "candidates do pass validation, so either the corpus is sparse or pairs were dropped afterwards by review states, exclusions or two-list filtering"
+	}
+	return "no stage lost every candidate; the corpus may just be sparse"
+}
+
+// runStats counts what one worker's lookups and validations found, for
+// Diagnosis. Each worker only touches its own, so counting is as cheap as
+// the evaluation counter next to it.
+type runStats struct {
+	lookups, hits, candidates uint64
+	namesWithCandidates       int
+	passed                    int
+	// Keeps the rule that rejected the last candidate, without outcomes
+	trace validationTrace
+	// Few distinct rules reject pairs, so a slice beats a map
+	rejected []RuleCount
+	// Keeps the counters of two workers off the same cache line
+	_ [64]byte
+}
+
+func (s *runStats) reject() {
+	for i := range s.rejected {
+		if s.rejected[i].Rule == s.trace.rule {
+			s.rejected[i].Pairs++
+			return
+		}
+	}
+	s.rejected = append(s.rejected, RuleCount{Rule: s.trace.rule, Pairs: 1})
+}
+
+// Diagnosis returns what the lookups and validations of the last Run
+// found, counted while it ran, along with the words of the names it covers.
+// Validated is the evaluation counter.
+// Names skipped by Options.Skip or left by a cancelled run count as names
+// without candidates. It must not be called while Run is in progress.
+func (m *Matcher) Diagnosis() *Diagnosis {
+	data := m.data
+	diag := &Diagnosis{Validated: int(m.Evaluations()), RejectedBy: make(map[string]int)}
+	seenWords := make(map[uint32]struct{})
+	visit := func(idx int) {
+		diag.Names++
+		parts := data.NameWords[data.AllNames[idx]]
+		for _, id := range parts {
+			if _, ok := seenWords[id]; !ok {
+				seenWords[id] = struct{}{}
+				diag.Words++
+				if _, ok := data.WordToMatches[id]; ok {
+					diag.WordsWithRules++
+				}
+			}
+		}
+		if len(parts) >= 2 {
+			diag.NamesWithKeys++
+		}
+	}
+	if data.Queries != nil {
+		for _, idx := range data.Queries {
+			visit(int(idx))
+		}
+	} else {
+		for idx := range data.AllNames {
+			visit(idx)
+		}
+	}
+	for i := range m.stats {
+		s := &m.stats[i]
+		diag.Lookups += s.lookups
+		diag.Hits += s.hits
+		diag.Candidates += s.candidates
+		diag.NamesWithCandidates += s.namesWithCandidates
+		diag.Passed += s.passed
+		for _, r := range s.rejected {
+			diag.RejectedBy[r.Rule] += r.Pairs
+		}
+	}
+	return diag
+}
@@ -0,0 +1,149 @@
+package compare
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// --- DICTIONARY FILES ---
+// A dictionary file lets an external producer write word IDs instead of
+// strings. It is a TSV of id<TAB>word rows, in ID order, after a header row
+// carrying the normalization hash. A producer must tokenize names exactly as
+// Load does, so the hash pins that down and a mismatch is refused.
+
+// tokenizerConfig describes how names are split into words. Change it along
+// with any change to tokenization so old dictionary files stop loading.
+func tokenizerConfig(opts LoadOptions) string {
+	config := "split=unicode-whitespace;case=preserve"
+	if opts.FoldCase {
+		config = "split=unicode-whitespace;case=fold"
+	}
+	// Kept out of the string when off so hashes from before normalization
+	// existed stay valid
+	if opts.Normalize.enabled() {
+		config += ";normalize=" + opts.Normalize.String()
+	}
+	return config
+}
+
+const dictHeader = "#normalization"
+
+// NormalizationHash identifies the tokenization dictionary IDs were
+// assigned under. Only the tokenization settings of opts matter.
+func NormalizationHash(opts LoadOptions) string {
+	sum := sha256.Sum256([]byte(tokenizerConfig(opts)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// WriteDictionary writes every interned word with its ID, headed by the
+// normalization hash of the settings d was loaded with.
+func (d *Data) WriteDictionary(w io.Writer) error {
+	return d.Dict.writeTSV(w, NormalizationHash(LoadOptions{FoldCase: d.FoldCase, Normalize: d.Normalize}))
+}
+
+func (d *Dictionary) writeTSV(w io.Writer, hash string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s\t%s\n", dictHeader, hash)
+	for id, s := range d.intToStr {
+		fmt.Fprintf(bw, "%d\t%s\n", id, s)
+	}
+	return bw.Flush()
+}
+
+// ReadDictionaryTSV reads a file written by WriteDictionary. IDs must run
+// from 0 without gaps and the normalization hash must match the
+// tokenization settings of opts, which the input will be loaded with.
+func ReadDictionaryTSV(r io.Reader, opts LoadOptions) (*Dictionary, error) {
+	want := NormalizationHash(opts)
+	d := NewDictionary()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if line == 1 {
+			tag, hash, _ := strings.Cut(text, "\t")
+			if tag != dictHeader {
+				return nil, &InputError{Field: "dictionary", Line: 1, Err: fmt.Errorf("missing %s header", dictHeader)}
+			}
+			if hash != want {
+				return nil, &MismatchError{Field: "dictionary", Err: fmt.Errorf("normalization hash %s does not match %s", hash, want)}
+			}
+			continue
+		}
+		idStr, word, ok := strings.Cut(text, "\t")
+		if !ok || word == "" {
+			return nil, &InputError{Field: "dictionary", Line: line, Err: errors.New("expected id<TAB>word")}
+		}
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil || int(id) != d.Len() {
+			return nil, &InputError{Field: "dictionary", Line: line, Err: fmt.Errorf("expected id %d, got %q", d.Len(), idStr)}
+		}
+		if _, dup := d.strToInt[word]; dup {
+			return nil, &InputError{Field: "dictionary", Line: line, Err: fmt.Errorf("duplicate word %q", word)}
+		}
+		d.GetID(word)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &InputError{Field: "dictionary", Line: line + 1, Err: err}
+	}
+	if line == 0 {
+		return nil, &InputError{Field: "dictionary", Err: errors.New("empty file")}
+	}
+	return d, nil
+}
+
+// LoadWithDictionary loads an input document whose word_to_matches keys are
+// word IDs from dict, whose match lists are arrays of word IDs, and whose
+// pair_to_names keys are "<id>_<id>". all_names still holds the names
+// themselves. Any ID outside dict is an error.
+func LoadWithDictionary(r io.Reader, dict *Dictionary) (*Data, error) {
+	return LoadWithOptions(r, LoadOptions{Dict: dict})
+}
+
+// idInput resolves the numeric references of an ID-based input document.
+// Only IDs present in the dictionary file are valid; words first seen in
+// all_names get IDs after them.
+type idInput struct {
+	dict  *Dictionary
+	known uint32
+}
+
+func (in *idInput) check(id uint32) error {
+	if id >= in.known {
+		return &MismatchError{Field: "word ID", Err: fmt.Errorf("%d out of range (dictionary has %d words)", id, in.known)}
+	}
+	return nil
+}
+
+func (in *idInput) parse(s string) (uint32, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, &InputError{Err: fmt.Errorf("word ID %q: not a number", s)}
+	}
+	return uint32(id), in.check(uint32(id))
+}
+
+// pairKey splits "<id>_<id>" into its two word IDs.
+func (in *idInput) pairKey(key string) (uint32, uint32, error) {
+	a, b, ok := strings.Cut(key, "_")
+	if !ok {
+		return 0, 0, &InputError{Err: fmt.Errorf("pair key %q: expected <id>_<id>", key)}
+	}
+	idA, err := in.parse(a)
+	if err != nil {
+		return 0, 0, err
+	}
+	idB, err := in.parse(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	return idA, idB, nil
+}
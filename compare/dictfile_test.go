@@ -0,0 +1,117 @@
+package compare
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// idDocument rewrites a string-based input document as an ID-based one over
+// dict, the way a producer using an exported dictionary writes it.
+func idDocument(t *testing.T, doc string, dict *Dictionary) string {
+	t.Helper()
+	var in struct {
+		AllNames      []string            `json:"all_names"`
+		WordToMatches map[string][]string `json:"word_to_matches"`
+		PairToNames   map[string][]string `json:"pair_to_names"`
+	}
+	if err := json.Unmarshal([]byte(doc), &in); err != nil {
+		t.Fatal(err)
+	}
+	id := func(word string) uint32 {
+		wID, ok := dict.strToInt[word]
+		if !ok {
+			t.Fatalf("%q is not in the dictionary", word)
+		}
+		return wID
+	}
+	key := func(word string) string { return strconv.FormatUint(uint64(id(word)), 10) }
+	matches := make(map[string][]uint32)
+	for word, list := range in.WordToMatches {
+		for _, m := range list {
+			matches[key(word)] = append(matches[key(word)], id(m))
+		}
+	}
+	pairs := make(map[string][]string)
+	for pair, names := range in.PairToNames {
+		a, b, _ := strings.Cut(pair, "_")
+		pairs[key(a)+"_"+key(b)] = names
+	}
+	out, err := json.Marshal(map[string]any{"all_names": in.AllNames, "word_to_matches": matches, "pair_to_names": pairs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// Exporting the dictionary and feeding an input rebuilt on its IDs back in
+// finds the same pairs as the string-based input.
+func TestDictionaryRoundTrip(t *testing.T) {
+	var doc bytes.Buffer
+	if err := loadString(t, validateInput).WriteInput(&doc); err != nil {
+		t.Fatal(err)
+	}
+	data := loadString(t, doc.String())
+	want := runPairs(t, data, Options{})
+	if len(want) == 0 {
+		t.Fatal("no pairs from the string-based input")
+	}
+
+	var tsv bytes.Buffer
+	if err := data.WriteDictionary(&tsv); err != nil {
+		t.Fatal(err)
+	}
+	dict, err := ReadDictionaryTSV(bytes.NewReader(tsv.Bytes()), LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(dict.intToStr, data.Dict.intToStr) {
+		t.Fatalf("dictionary read back %q, want %q", dict.intToStr, data.Dict.intToStr)
+	}
+	byID, err := LoadWithDictionary(strings.NewReader(idDocument(t, doc.String(), data.Dict)), dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := runPairs(t, byID, Options{}); !slices.Equal(got, want) {
+		t.Errorf("ID-based input: pairs %q, want %q", got, want)
+	}
+}
+
+func TestDictionaryErrors(t *testing.T) {
+	data := loadString(t, smallInput)
+	var tsv bytes.Buffer
+	if err := data.WriteDictionary(&tsv); err != nil {
+		t.Fatal(err)
+	}
+	// Exported without case folding, read for a run with it
+	if _, err := ReadDictionaryTSV(bytes.NewReader(tsv.Bytes()), LoadOptions{FoldCase: true}); !errors.Is(err, ErrIndexCorpusMismatch) ||
+		!strings.Contains(err.Error(), "normalization hash") {
+		t.Errorf("other tokenization: %v", err)
+	}
+	header, _, _ := strings.Cut(tsv.String(), "\n")
+	for _, body := range []string{"0\tjohn\n2\tjon\n", "0\tjohn\n1\tjohn\n", "0\n"} {
+		if _, err := ReadDictionaryTSV(strings.NewReader(header+"\n"+body), LoadOptions{}); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("dictionary %q: %v", body, err)
+		}
+	}
+	dict, err := ReadDictionaryTSV(bytes.NewReader(tsv.Bytes()), LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		doc  string
+		want error
+	}{
+		{`{"all_names": ["john smith"], "word_to_matches": {"999": [0]}}`, ErrIndexCorpusMismatch},
+		{`{"all_names": ["john smith"], "word_to_matches": {"0": [999]}}`, ErrIndexCorpusMismatch},
+		{`{"all_names": ["john smith"], "pair_to_names": {"0-1": ["john smith"]}}`, ErrInvalidInput},
+	} {
+		if _, err := LoadWithDictionary(strings.NewReader(c.doc), dict); !errors.Is(err, c.want) {
+			t.Errorf("%s: %v, want %v", c.doc, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Package compare finds likely matches in a large list of names. Names are
+// bucketed by pairs of their words, so only names sharing a bucket are ever
+// compared, and each candidate pair is validated against the word_to_matches
+// rules.
+package compare
+
+import "strings"
+
+// --- INTERNING SYSTEM ---
+// We convert strings to uint32 to avoid string hashing in the hot path.
+// IDs are handed out in the order strings are first interned, never by
+// iterating a map, so the same input always gets the same IDs. Dictionary
+// files and ID-based inputs depend on that.
+type Dictionary struct {
+	strToInt map[string]uint32
+	intToStr []string
+
+	// With case folding on, the ID of each string's lowercase form
+	fold   bool
+	folded []uint32
+}
+
+func NewDictionary() *Dictionary {
+	return &Dictionary{
+		strToInt: make(map[string]uint32),
+		intToStr: make([]string, 0),
+	}
+}
+
+func (d *Dictionary) GetID(s string) uint32 {
+	if id, ok := d.strToInt[s]; ok {
+		return id
+	}
+	id := uint32(len(d.intToStr))
+	d.intToStr = append(d.intToStr, s)
+	d.strToInt[s] = id
+	if d.fold {
+		d.folded = append(d.folded, id)
+		if lower := strings.ToLower(s); lower != s {
+			d.folded[id] = d.GetID(lower)
+		}
+	}
+	return id
+}
+
+// FoldCase turns on case folding: from now on every interned string also
+// interns its lowercase form as an alias, available through Folded. The
+// original strings keep their own IDs.
+func (d *Dictionary) FoldCase() {
+	if d.fold {
+		return
+	}
+	n := len(d.intToStr)
+	for id := 0; id < n; id++ {
+		d.GetID(strings.ToLower(d.intToStr[id]))
+	}
+	d.folded = make([]uint32, len(d.intToStr))
+	for id, s := range d.intToStr {
+		d.folded[id] = d.strToInt[strings.ToLower(s)]
+	}
+	d.fold = true
+}
+
+// Folded returns the ID of the lowercase form of id, or id itself when case
+// folding is off.
+func (d *Dictionary) Folded(id uint32) uint32 {
+	if !d.fold {
+		return id
+	}
+	return d.folded[id]
+}
+
+func (d *Dictionary) GetStr(id uint32) string {
+	return d.intToStr[id]
+}
+
+// Len returns the number of interned strings; IDs are always below it.
+func (d *Dictionary) Len() int {
+	return len(d.intToStr)
+}
+
+// Lookup returns the ID of s without interning it.
+func (d *Dictionary) Lookup(s string) (uint32, bool) {
+	id, ok := d.strToInt[s]
+	return id, ok
+}
@@ -0,0 +1,109 @@
+package compare
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDictionaryFoldCase(t *testing.T) {
+	d := NewDictionary()
+	john := d.GetID("John")
+	d.FoldCase()
+	upper := d.GetID("JOHN")
+	lower, ok := d.Lookup("john")
+	if !ok {
+		t.Fatal("no alias interned for a string from before FoldCase")
+	}
+	if john == lower || upper == lower || john == upper {
+		t.Errorf("distinct strings share IDs: John %d, JOHN %d, john %d", john, upper, lower)
+	}
+	for _, id := range []uint32{john, upper, lower} {
+		if d.Folded(id) != lower {
+			t.Errorf("%q folds to %q, want john", d.GetStr(id), d.GetStr(d.Folded(id)))
+		}
+	}
+	if d.GetStr(john) != "John" || d.GetStr(upper) != "JOHN" {
+		t.Errorf("originals read back as %q and %q", d.GetStr(john), d.GetStr(upper))
+	}
+}
+
+const mixedCaseNames = `"all_names": ["John Smith", "JOHN SMITH", "jon Smyth", "Mary Jones", "MARY jones", "mary jones"]`
+
+const mixedCaseMatches = `"word_to_matches": {
+	"John": ["John", "Jon"], "jon": ["jon", "JOHN"],
+	"Smith": ["smith", "SMYTH"], "smyth": ["Smyth", "smith"],
+	"mary": ["Mary"], "JONES": ["jones"]
+}`
+
+// casedPairIndex is pythonPairIndex with its keys recased by keyCase, the
+// buckets of keys that come out the same merged.
+func casedPairIndex(t *testing.T, names []string, keyCase func(string) string) string {
+	t.Helper()
+	var index struct {
+		PairToNames map[string][]string `json:"pair_to_names"`
+	}
+	if err := json.Unmarshal([]byte("{"+pythonPairIndex(names)+"}"), &index); err != nil {
+		t.Fatal(err)
+	}
+	recased := make(map[string][]string)
+	for key, bucket := range index.PairToNames {
+		for _, name := range bucket {
+			if !slices.Contains(recased[keyCase(key)], name) {
+				recased[keyCase(key)] = append(recased[keyCase(key)], name)
+			}
+		}
+	}
+	raw, err := json.Marshal(recased)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return `"pair_to_names": ` + string(raw)
+}
+
+// Folding matches words across case whichever case the pair index keys are
+// in, and never merges names that differ only in case: each is output as
+// given.
+func TestFoldCaseMixedInput(t *testing.T) {
+	names := loadString(t, "{"+mixedCaseNames+"}").AllNames
+	want := []string{
+		"JOHN SMITH|John Smith",
+		"JOHN SMITH|jon Smyth",
+		"John Smith|jon Smyth",
+		"MARY jones|Mary Jones",
+		"MARY jones|mary jones",
+		"Mary Jones|mary jones",
+	}
+	identity := func(s string) string { return s }
+	for _, c := range []struct {
+		name  string
+		index string
+	}{
+		{"built", ""},
+		{"original-case keys", ", " + casedPairIndex(t, names, identity)},
+		{"lower-case keys", ", " + casedPairIndex(t, names, strings.ToLower)},
+		{"upper-case keys", ", " + casedPairIndex(t, names, strings.ToUpper)},
+	} {
+		doc := "{" + mixedCaseNames + ", " + mixedCaseMatches + c.index + "}"
+		data, err := LoadWithOptions(strings.NewReader(doc), LoadOptions{FoldCase: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(data.AllNames, names) {
+			t.Errorf("%s: names %q, want %q", c.name, data.AllNames, names)
+		}
+		if got := runPairs(t, data, Options{}); !slices.Equal(got, want) {
+			t.Errorf("%s: pairs %q, want %q", c.name, got, want)
+		}
+		if got := runPairs(t, data, Options{SkipSymmetric: true}); !slices.Equal(got, want) {
+			t.Errorf("%s, skipping symmetric pairs: %q, want %q", c.name, got, want)
+		}
+	}
+
+	// Without folding the differently cased words are strangers
+	data := loadString(t, "{"+mixedCaseNames+", "+mixedCaseMatches+"}")
+	if got := runPairs(t, data, Options{}); len(got) >= len(want) {
+		t.Errorf("without folding: pairs %q", got)
+	}
+}
@@ -0,0 +1,247 @@
+package compare
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+)
+
+// --- PAIR BUCKETS ON DISK ---
+// On the largest inputs pair_to_names alone exceeds memory, while a run only
+// ever looks buckets up by key. OpenIndex can leave the buckets in the pairs
+// section of an index file: only the sorted keys and where each bucket lies
+// stay in memory, and buckets are read as they are looked up, through a
+// cache of recently used ones. A run then slows down to disk reads instead
+// of running out of memory.
+//
+// Where it can, the file is mapped read-only rather than read, so several
+// runs on one machine over the same index (shards, say) share one copy of
+// its pages instead of each reading the buckets into memory of its own.
+
+const pairCacheShards = 64
+
+// Bytes a cached bucket costs beyond its IDs: the list element, map entry
+// and slice header
+const pairCacheOverhead = 128
+
+// DiskPairs serves the pair_to_names buckets of an index file.
+type DiskPairs struct {
+	file *os.File
+	// The file mapped into memory; nil when it is read instead
+	mapped []byte
+	// Sorted, like the pairs section
+	keys  []uint64
+	spans []pairSpan
+
+	cache [pairCacheShards]pairCache
+
+	errOnce sync.Once
+	err     error
+}
+
+// pairSpan locates the IDs of one bucket in the file.
+type pairSpan struct {
+	off  int64
+	size uint32
+	n    uint32
+}
+
+type pairCache struct {
+	mu      sync.Mutex
+	entries map[uint64]*list.Element
+	order   list.List
+	bytes   int64
+	limit   int64
+}
+
+type cachedBucket struct {
+	key uint64
+	ids []uint32
+}
+
+func newDiskPairs(file *os.File, cacheBytes int64) *DiskPairs {
+	p := &DiskPairs{file: file}
+	for i := range p.cache {
+		p.cache[i].entries = make(map[uint64]*list.Element)
+		p.cache[i].limit = cacheBytes / pairCacheShards
+	}
+	return p
+}
+
+// Len returns the number of buckets.
+func (p *DiskPairs) Len() int {
+	return len(p.keys)
+}
+
+// SetCacheLimit changes how many bytes of buckets are kept cached. It must
+// not be called while buckets are looked up.
+func (p *DiskPairs) SetCacheLimit(bytes int64) {
+	for i := range p.cache {
+		p.cache[i].limit = bytes / pairCacheShards
+	}
+}
+
+// Err returns the first error reading a bucket. A bucket that can't be read
+// is looked up as empty, so a run must check Err once it is done.
+func (p *DiskPairs) Err() error {
+	return p.err
+}
+
+// Mapped reports whether the index file is mapped into memory rather than
+// read.
+func (p *DiskPairs) Mapped() bool {
+	return p.mapped != nil
+}
+
+// Close unmaps and closes the index file.
+func (p *DiskPairs) Close() error {
+	if p.mapped != nil {
+		if err := unmapFile(p.mapped); err != nil {
+			p.file.Close()
+			return err
+		}
+		p.mapped = nil
+	}
+	return p.file.Close()
+}
+
+func (p *DiskPairs) fail(err error) {
+	p.errOnce.Do(func() { p.err = err })
+}
+
+func (p *DiskPairs) find(key uint64) (int, bool) {
+	return slices.BinarySearch(p.keys, key)
+}
+
+// bucket returns the bucket of key from the cache, or reads it. It is safe
+// for concurrent use.
+func (p *DiskPairs) bucket(key uint64) ([]uint32, bool) {
+	i, ok := p.find(key)
+	if !ok {
+		return nil, false
+	}
+	c := &p.cache[key%pairCacheShards]
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*cachedBucket).ids, true
+	}
+	c.mu.Unlock()
+
+	ids, err := p.read(p.spans[i])
+	if err != nil {
+		p.fail(fmt.Errorf("pair bucket %d: %w", key, err))
+		return nil, true
+	}
+	c.add(key, ids)
+	return ids, true
+}
+
+func (p *DiskPairs) read(span pairSpan) ([]uint32, error) {
+	var buf []byte
+	if p.mapped != nil {
+		end := span.off + int64(span.size)
+		if end > int64(len(p.mapped)) {
+			return nil, fmt.Errorf("bucket at offset %d past the end of the file", span.off)
+		}
+		buf = p.mapped[span.off:end]
+	} else {
+		buf = make([]byte, span.size)
+		if _, err := p.file.ReadAt(buf, span.off); err != nil {
+			return nil, err
+		}
+	}
+	ids := make([]uint32, span.n)
+	for j := range ids {
+		id, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, fmt.Errorf("corrupt bucket at offset %d", span.off)
+		}
+		ids[j], buf = uint32(id), buf[n:]
+	}
+	return ids, nil
+}
+
+// add caches a bucket, evicting the least recently used ones over the
+// limit. Buckets handed out before stay valid; eviction only drops the
+// cache's reference.
+func (c *pairCache) add(key uint64, ids []uint32) {
+	size := int64(len(ids))*4 + pairCacheOverhead
+	if size > c.limit {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		// Read by another worker meanwhile
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cachedBucket{key: key, ids: ids})
+	c.bytes += size
+	for c.bytes > c.limit {
+		e := c.order.Back()
+		evicted := c.order.Remove(e).(*cachedBucket)
+		delete(c.entries, evicted.key)
+		c.bytes -= int64(len(evicted.ids))*4 + pairCacheOverhead
+	}
+}
+
+// bucket returns the names in the bucket of a pair key, wherever the
+// buckets are kept.
+func (d *Data) bucket(key uint64) ([]uint32, bool) {
+	if d.DiskPairs != nil {
+		return d.DiskPairs.bucket(key)
+	}
+	ids, ok := d.PairToNames[key]
+	return ids, ok
+}
+
+// bucketLen returns the size of the bucket of key without reading it.
+func (d *Data) bucketLen(key uint64) int {
+	if d.DiskPairs != nil {
+		if i, ok := d.DiskPairs.find(key); ok {
+			return int(d.DiskPairs.spans[i].n)
+		}
+		return 0
+	}
+	return len(d.PairToNames[key])
+}
+
+// bucketKeys returns the key of every bucket, in no particular order.
+func (d *Data) bucketKeys() []uint64 {
+	if d.DiskPairs != nil {
+		return d.DiskPairs.keys
+	}
+	keys := make([]uint64, 0, len(d.PairToNames))
+	for key := range d.PairToNames {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Buckets returns the number of pair_to_names buckets.
+func (d *Data) Buckets() int {
+	if d.DiskPairs != nil {
+		return d.DiskPairs.Len()
+	}
+	return len(d.PairToNames)
+}
+
+// BucketSizes returns the size of every bucket, in no particular order.
+func (d *Data) BucketSizes() []int {
+	sizes := make([]int, 0, d.Buckets())
+	if d.DiskPairs != nil {
+		for _, span := range d.DiskPairs.spans {
+			sizes = append(sizes, int(span.n))
+		}
+		return sizes
+	}
+	for _, bucket := range d.PairToNames {
+		sizes = append(sizes, len(bucket))
+	}
+	return sizes
+}
@@ -0,0 +1,69 @@
+package compare
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+// --- DUPLICATE NAMES ---
+// Names with the same words, in any order ("john smith", "smith john", or
+// a name listed twice in different case under --fold-case-compare), look up
+// the same pair keys and validate the same way against every other name.
+// With Options.CollapseDuplicates, only the first name of each such group
+// is processed, and every pair it validates is emitted for the others too,
+// each subject to its own review state and exclusions. Validation is
+// symmetric except for rule files (words_a, first_b and so on), and in
+// two-list mode reverse candidates are per name, so neither collapses.
+
+// collapseDuplicates removes from order the names whose words equal those
+// of an earlier name of order, recording them as that name's duplicates.
+func (m *Matcher) collapseDuplicates(order []uint32) []uint32 {
+	if !m.opts.CollapseDuplicates || m.isQuery != nil || m.opts.Match.Rules != nil {
+		return order
+	}
+	data := m.data
+	first := make(map[string]uint32)
+	var key []byte
+	var words []uint32
+	kept := order[:0]
+	for _, idx := range order {
+		parts := data.NameWords[data.AllNames[idx]]
+		if len(parts) < 2 {
+			kept = append(kept, idx)
+			continue
+		}
+		words = append(words[:0], parts...)
+		slices.Sort(words)
+		key = key[:0]
+		for _, w := range words {
+			key = binary.LittleEndian.AppendUint32(key, w)
+		}
+		if rep, ok := first[string(key)]; ok {
+			if m.duplicates == nil {
+				m.duplicates = make(map[uint32][]uint32)
+			}
+			m.duplicates[rep] = append(m.duplicates[rep], idx)
+			m.collapsed++
+			continue
+		}
+		first[string(key)] = idx
+		kept = append(kept, idx)
+	}
+	return kept
+}
+
+// Collapsed returns how many names Run left to the first name with the
+// same words (see Options.CollapseDuplicates).
+func (m *Matcher) Collapsed() int {
+	return m.collapsed
+}
+
+// swapped returns the counts with the two names exchanged.
+func (c WordCounts) swapped() WordCounts {
+	c.WordsA, c.WordsB = c.WordsB, c.WordsA
+	c.MismatchesA, c.MismatchesB = c.MismatchesB, c.MismatchesA
+	c.weightA, c.weightB = c.weightB, c.weightA
+	c.mismatchWeightA, c.mismatchWeightB = c.mismatchWeightB, c.mismatchWeightA
+	c.mismatched = c.mismatched&(positionFirstA|positionLastA)<<2 | c.mismatched&(positionFirstB|positionLastB)>>2
+	return c
+}
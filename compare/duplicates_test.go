@@ -0,0 +1,158 @@
+package compare
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// randomInput returns an input document of n names of two to four words
+// drawn from a small vocabulary, so names share words, repeat and come in
+// other word orders. Words match the others of their spelling group, and
+// with oneWay also their initials, which don't match them back, the way a
+// hand-made word_to_matches may have it.
+func randomInput(seed uint64, n int, oneWay bool) string {
+	r := rand.New(rand.NewPCG(seed, 1))
+	groups := [][]string{
+		{"john", "jon", "jonathan"}, {"mary", "marie"}, {"smith", "smyth"}, {"ann", "anne", "anna"},
+		{"lee", "li"}, {"jones"}, {"brown", "browne"}, {"de_la"}, {"cruz", "crus"}, {"paul"},
+	}
+	var vocab []string
+	matches := make(map[string][]string)
+	for _, group := range groups {
+		for _, w := range group {
+			vocab = append(vocab, w)
+			matches[w] = group
+		}
+	}
+	if oneWay {
+		for _, initial := range []string{"j", "m", "a"} {
+			for _, w := range vocab {
+				if strings.HasPrefix(w, initial) {
+					matches[w] = append(slices.Clone(matches[w]), initial)
+				}
+			}
+			vocab = append(vocab, initial)
+			matches[initial] = []string{initial}
+		}
+	}
+	names := make([]string, n)
+	for i := range names {
+		words := make([]string, 2+r.IntN(3))
+		for j := range words {
+			words[j] = vocab[r.IntN(len(vocab))]
+		}
+		names[i] = strings.Join(words, " ")
+	}
+	doc, err := json.Marshal(map[string]any{"all_names": names, "word_to_matches": matches})
+	if err != nil {
+		panic(err)
+	}
+	return string(doc)
+}
+
+// runOutput runs a Matcher and returns every pair it emits as
+// "a|b|score|tag", sorted, repeats included.
+func runOutput(t *testing.T, data *Data, opts Options) []string {
+	t.Helper()
+	return runMatcher(t, NewMatcher(data, opts))
+}
+
+// runMatcher is runOutput for a Matcher of the caller's.
+func runMatcher(t *testing.T, m *Matcher) []string {
+	t.Helper()
+	var mu sync.Mutex
+	var lines []string
+	err := m.Run(context.Background(), func(p Pair) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, fmt.Sprintf("%s|%s|%.4f|%s", p.A, p.B, p.Score, p.Tag))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(lines)
+	return lines
+}
+
+// reviewSome marks some of the pairs as rejected, unsure or excluded, to
+// check that duplicates get their own review states and exclusions.
+func reviewSome(t *testing.T, data *Data, pairs []string) (*ReviewStates, *PairSet) {
+	t.Helper()
+	var csv strings.Builder
+	exclude := NewPairSet(data)
+	for i, line := range pairs {
+		a, rest, _ := strings.Cut(line, "|")
+		b, _, _ := strings.Cut(rest, "|")
+		switch {
+		case i%5 == 0:
+			csv.WriteString(`"` + a + `","` + b + `",rejected` + "\n")
+		case i%7 == 0:
+			csv.WriteString(`"` + a + `","` + b + `",unsure` + "\n")
+		case i%11 == 0:
+			exclude.Add(a, b)
+		}
+	}
+	review, err := LoadReviewStates(strings.NewReader(csv.String()), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return review, exclude
+}
+
+// Collapsing names with the same words gives the output of processing each
+// of them, review states and exclusions included.
+func TestCollapseDuplicatesEquivalence(t *testing.T) {
+	collapsed := 0
+	for seed := range uint64(6) {
+		doc := randomInput(seed, 300, seed%2 == 1)
+		opts := LoadOptions{}
+		if seed >= 3 {
+			// Some names again in upper case, the same words once folded
+			var input map[string]any
+			if err := json.Unmarshal([]byte(doc), &input); err != nil {
+				t.Fatal(err)
+			}
+			names := input["all_names"].([]any)
+			for i := 0; i < len(names); i += 9 {
+				names = append(names, strings.ToUpper(names[i].(string)))
+			}
+			input["all_names"] = names
+			raw, err := json.Marshal(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, opts.FoldCase = string(raw), true
+		}
+		data, err := LoadWithOptions(strings.NewReader(doc), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		review, exclude := reviewSome(t, data, runOutput(t, data, Options{}))
+		for _, base := range []Options{
+			{Workers: 1},
+			{Workers: 4},
+			{Workers: 4, Review: review, Exclude: exclude},
+			{Workers: 4, SkipSymmetric: true},
+		} {
+			want := slices.Compact(runOutput(t, data, base))
+			opts := base
+			opts.CollapseDuplicates = true
+			m := NewMatcher(data, opts)
+			got := slices.Compact(runMatcher(t, m))
+			collapsed += m.Collapsed()
+			if !slices.Equal(got, want) {
+				t.Errorf("seed %d, %d workers, review %v: %d distinct pairs collapsed, %d not",
+					seed, base.Workers, base.Review != nil, len(got), len(want))
+			}
+		}
+	}
+	if collapsed == 0 {
+		t.Error("no input had names with the same words")
+	}
+}
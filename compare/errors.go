@@ -0,0 +1,119 @@
+package compare
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// --- ERRORS ---
+// Services embedding the package tell failures apart with errors.Is against
+// the sentinels below, and get their details with errors.As on the error
+// types that wrap them. Every exported function returning an error returns
+// one of these for the failures they describe; other errors, such as those
+// of the underlying reader, are returned as they come.
+
+var (
+	// The input, or a file given alongside it, is malformed
+	ErrInvalidInput = errors.New("invalid input")
+	// A file was written in a format version this build doesn't read
+	ErrSchemaVersion = errors.New("unsupported schema version")
+	// An index, dictionary or checkpoint was built from another corpus or
+	// with other options than the ones it is used with
+	ErrIndexCorpusMismatch = errors.New("index does not match the corpus")
+	// The run would exceed a limit it was given
+	ErrResourceLimit = errors.New("resource limit exceeded")
+	// The run was cancelled before every name was processed
+	ErrInterrupted = errors.New("interrupted")
+)
+
+// InputError is an ErrInvalidInput with where the input went wrong.
+type InputError struct {
+	// The section, column or file the error is in, empty if the input has
+	// no parts to tell apart
+	Field string
+	// 1-based line of the error, 0 if unknown. For a JSON document it is
+	// the line the decoder had reached, which may be just past the error.
+	Line int
+	Err  error
+}
+
+func (e *InputError) Error() string {
+	var parts []string
+	if e.Field != "" {
+		parts = append(parts, e.Field)
+	}
+	if e.Line > 0 {
+		parts = append(parts, fmt.Sprintf("line %d", e.Line))
+	}
+	return strings.Join(append(parts, e.Err.Error()), ": ")
+}
+
+func (e *InputError) Unwrap() []error {
+	return []error{ErrInvalidInput, e.Err}
+}
+
+// SchemaVersionError is an ErrSchemaVersion with the versions involved.
+type SchemaVersionError struct {
+	// What carries the version, such as "index"
+	What      string
+	Got, Want int
+}
+
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("%s version %d, want %d (rebuild the %s)", e.What, e.Got, e.Want, e.What)
+}
+
+func (e *SchemaVersionError) Is(target error) bool {
+	return target == ErrSchemaVersion
+}
+
+// MismatchError is an ErrIndexCorpusMismatch with what disagrees.
+type MismatchError struct {
+	// What disagrees, such as "normalization" or "word ID"
+	Field string
+	Err   error
+}
+
+func (e *MismatchError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *MismatchError) Unwrap() []error {
+	return []error{ErrIndexCorpusMismatch, e.Err}
+}
+
+// ResourceLimitError is an ErrResourceLimit with the limit it hit.
+type ResourceLimitError struct {
+	// The limit, such as "memory" or "max-memory"
+	Limit string
+	// What the run needs and what the limit allows, in the limit's unit
+	Need, Available uint64
+}
+
+func (e *ResourceLimitError) Error() string {
+	return fmt.Sprintf("%s limit exceeded: need %d, have %d", e.Limit, e.Need, e.Available)
+}
+
+func (e *ResourceLimitError) Is(target error) bool {
+	return target == ErrResourceLimit
+}
+
+// InterruptedError is the ErrInterrupted that Matcher.Run returns when its
+// context is cancelled. It also wraps the context's error.
+type InterruptedError struct {
+	// Names taken up by the workers, of the names the run had to process
+	Processed, Total uint64
+	// Every finished name was reported through Options.OnNameDone, so a
+	// caller that kept them can resume with Options.Skip
+	Resumable bool
+	Err       error
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("interrupted after %d of %d names: %v", e.Processed, e.Total, e.Err)
+}
+
+func (e *InterruptedError) Unwrap() []error {
+	return []error{ErrInterrupted, e.Err}
+}
@@ -0,0 +1,82 @@
+package compare
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Every kind of failure is reported through the public entry points as its
+// sentinel, with its details reachable through errors.As.
+func TestErrorTypes(t *testing.T) {
+	data := loadString(t, smallInput)
+	var index, tsv bytes.Buffer
+	if err := data.WriteIndex(&index); err != nil {
+		t.Fatal(err)
+	}
+	if err := data.WriteDictionary(&tsv); err != nil {
+		t.Fatal(err)
+	}
+	oldIndex := bytes.Clone(index.Bytes())
+	oldIndex[len(IndexMagic)] = IndexVersion + 1
+	dict, err := ReadDictionaryTSV(bytes.NewReader(tsv.Bytes()), LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("input", func(t *testing.T) {
+		_, err := Load(strings.NewReader("{\"all_names\": [\n\"john smith\",\n5]}"))
+		var input *InputError
+		if !errors.Is(err, ErrInvalidInput) || !errors.As(err, &input) {
+			t.Fatalf("error %v", err)
+		}
+		if input.Field != "all_names" || input.Line != 3 {
+			t.Errorf("field %q line %d, want all_names line 3", input.Field, input.Line)
+		}
+		_, err = ParseMismatchTable("5/5")
+		if !errors.As(err, &input) || !strings.Contains(err.Error(), "mismatch table entry") {
+			t.Errorf("mismatch table error %v", err)
+		}
+	})
+
+	t.Run("schema version", func(t *testing.T) {
+		_, err := ReadIndex(bytes.NewReader(oldIndex))
+		var version *SchemaVersionError
+		if !errors.Is(err, ErrSchemaVersion) || !errors.As(err, &version) {
+			t.Fatalf("error %v", err)
+		}
+		if version.What != "index" || version.Got != IndexVersion+1 || version.Want != IndexVersion {
+			t.Errorf("version error %+v", version)
+		}
+		if errors.Is(err, ErrInvalidInput) {
+			t.Error("a version mismatch is also invalid input")
+		}
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		_, err := ReadDictionaryTSV(bytes.NewReader(tsv.Bytes()), LoadOptions{FoldCase: true})
+		var mismatch *MismatchError
+		if !errors.Is(err, ErrIndexCorpusMismatch) || !errors.As(err, &mismatch) || mismatch.Field != "dictionary" {
+			t.Errorf("other tokenization: %v", err)
+		}
+		_, err = LoadWithDictionary(strings.NewReader(`{"all_names": ["john smith"], "word_to_matches": {"0": [999]}}`), dict)
+		if !errors.Is(err, ErrIndexCorpusMismatch) || !errors.As(err, &mismatch) || mismatch.Field != "word ID" {
+			t.Errorf("word ID out of range: %v", err)
+		}
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := NewMatcher(data, Options{OnNameDone: func(int, int) error { return nil }}).Run(ctx, func(Pair) {})
+		var interrupted *InterruptedError
+		if !errors.Is(err, ErrInterrupted) || !errors.Is(err, context.Canceled) || !errors.As(err, &interrupted) {
+			t.Fatalf("error %v", err)
+		}
+		if interrupted.Total != uint64(len(data.AllNames)) || interrupted.Processed > interrupted.Total || !interrupted.Resumable {
+			t.Errorf("interrupted error %+v", interrupted)
+		}
+	})
+}
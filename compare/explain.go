@@ -0,0 +1,195 @@
+package compare
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// Explanation describes how the matcher treats one pair of names: whether
+// they are ever compared, and why validation accepts or rejects them.
+type Explanation struct {
+	// The two names in the order the matcher validates them (A sorts first)
+	NameA  string          `json:"name_a"`
+	NameB  string          `json:"name_b"`
+	WordsA []ExplainedWord `json:"words_a"`
+	WordsB []ExplainedWord `json:"words_b"`
+
+	// Pair keys each name looks up after tradeout expansion
+	PairKeysA []string `json:"pair_keys_a"`
+	PairKeysB []string `json:"pair_keys_b"`
+	// Keys whose bucket holds the other name. The pair is only ever
+	// compared if there is at least one.
+	SharedKeys []string `json:"shared_keys"`
+
+	// Word counts and mismatches as the thresholds saw them
+	LengthA     int     `json:"length_a"`
+	LengthB     int     `json:"length_b"`
+	MismatchesA int     `json:"mismatches_a"`
+	MismatchesB int     `json:"mismatches_b"`
+	Score       float64 `json:"score"`
+	// Score weighting words by IDF, if weights are in use (see
+	// MatchConfig.Weights)
+	WeightedScore *float64 `json:"weighted_score,omitempty"`
+
+	// Whether validation passed, and if not, which rule failed:
+	// suffix, strict-length, min-common-words, max-mismatches,
+	// mismatch-table, min-score,
+	// min-weighted-score, word-blocklist or the name of a --validation-rules
+	// rule
+	Valid      bool   `json:"valid"`
+	RejectedBy string `json:"rejected_by,omitempty"`
+	// Review state of the pair, if review states are in use
+	Review string `json:"review,omitempty"`
+	// Whether a run would output the pair
+	Output bool `json:"output"`
+}
+
+// ExplainedWord is one word of a name and how validation treated it.
+type ExplainedWord struct {
+	Word string `json:"word"`
+	// Class, when token classes are in use
+	Class string `json:"class,omitempty"`
+	// Words this one can stand in for when building pair keys
+	Tradeouts []string `json:"tradeouts"`
+	// Words of the other name whose word_to_matches include this word
+	MatchedBy []string `json:"matched_by"`
+	// matched, mismatch, duplicate, ignored, first-letter, jaro-winkler or
+	// edit-distance
+	Outcome string `json:"outcome"`
+}
+
+// Explain runs the matcher's logic for a single pair and records each
+// decision. The names don't have to be in all_names, but all their words
+// must be known to the input.
+func (m *Matcher) Explain(nameA, nameB string) (*Explanation, error) {
+	if nameA > nameB {
+		nameA, nameB = nameB, nameA
+	}
+	partsA, err := m.tokenize(nameA)
+	if err != nil {
+		return nil, err
+	}
+	partsB, err := m.tokenize(nameB)
+	if err != nil {
+		return nil, err
+	}
+	data := m.data
+
+	trace := &validationTrace{
+		outcomesA: make([]wordOutcome, len(partsA)),
+		outcomesB: make([]wordOutcome, len(partsB)),
+	}
+	buffer := m.matchBuffer()
+	keysA := m.pairKeys(partsA, nil)
+	keysB := m.pairKeys(partsB, nil)
+	valid, counts := validateOptimized(partsA, partsB, m.matches, data.Dict, buffer, m.rules, m.opts.Match, trace)
+
+	e := &Explanation{
+		NameA:       nameA,
+		NameB:       nameB,
+		WordsA:      m.explainWords(partsA, partsB, trace.outcomesA),
+		WordsB:      m.explainWords(partsB, partsA, trace.outcomesB),
+		PairKeysA:   data.pairKeyStrings(keysA),
+		PairKeysB:   data.pairKeyStrings(keysB),
+		LengthA:     counts.WordsA,
+		LengthB:     counts.WordsB,
+		MismatchesA: counts.MismatchesA,
+		MismatchesB: counts.MismatchesB,
+		Score:       counts.Score(),
+		Valid:       valid,
+		RejectedBy:  trace.rule,
+	}
+	if m.opts.Match.Weights != nil {
+		weighted := counts.WeightedScore()
+		e.WeightedScore = &weighted
+	}
+	e.SharedKeys = append(sharedKeys(data, keysA, nameB), sharedKeys(data, keysB, nameA)...)
+	if e.SharedKeys == nil {
+		e.SharedKeys = []string{}
+	}
+	slices.Sort(e.SharedKeys)
+	e.SharedKeys = slices.Compact(e.SharedKeys)
+
+	e.Output = valid && len(e.SharedKeys) > 0
+	if m.opts.Review != nil {
+		idA, okA := data.NameIDs[nameA]
+		idB, okB := data.NameIDs[nameB]
+		if okA && okB {
+			switch m.opts.Review.lookup(idA, idB) {
+			case reviewConfirmed:
+				e.Review, e.Output = "confirmed", true
+			case reviewRejected:
+				e.Review, e.Output = "rejected", false
+			case reviewUnsure:
+				e.Review = "unsure"
+			}
+		}
+	}
+	return e, nil
+}
+
+// tokenize returns the word IDs of name without adding to the dictionary.
+func (m *Matcher) tokenize(name string) ([]uint32, error) {
+	if ids, ok := m.data.NameWords[name]; ok {
+		return ids, nil
+	}
+	words := splitName(name, m.data.Normalize)
+	ids := make([]uint32, 0, len(words))
+	for _, w := range words {
+		if m.data.Mask != nil && m.data.Mask.pattern(w) >= 0 {
+			continue
+		}
+		id, ok := m.data.Dict.Lookup(w)
+		if !ok {
+			return nil, &InputError{Field: strconv.Quote(name), Err: fmt.Errorf("word %q does not appear in the input", w)}
+		}
+		ids = append(ids, m.data.Dict.Folded(id))
+	}
+	return ids, nil
+}
+
+func (m *Matcher) explainWords(parts, other []uint32, outcomes []wordOutcome) []ExplainedWord {
+	dict := m.data.Dict
+	words := make([]ExplainedWord, len(parts))
+	for i, id := range parts {
+		w := ExplainedWord{Word: dict.GetStr(id), Outcome: outcomes[i].String(), Tradeouts: []string{}, MatchedBy: []string{}}
+		if m.rules != nil && int(id) < len(m.rules.classes) {
+			w.Class = m.rules.classes[id].String()
+		}
+		for _, t := range m.data.TradeoutSets[id] {
+			w.Tradeouts = append(w.Tradeouts, dict.GetStr(t))
+		}
+		for _, o := range other {
+			if slices.Contains(m.data.WordToMatches[o], id) && !slices.Contains(w.MatchedBy, dict.GetStr(o)) {
+				w.MatchedBy = append(w.MatchedBy, dict.GetStr(o))
+			}
+		}
+		words[i] = w
+	}
+	return words
+}
+
+// pairKeyStrings spells out pair keys (see pairKeyString).
+func (d *Data) pairKeyStrings(keys []uint64) []string {
+	strs := make([]string, len(keys))
+	for i, key := range keys {
+		strs[i] = d.pairKeyString(key)
+	}
+	return strs
+}
+
+// sharedKeys returns the keys whose bucket contains name, spelled out.
+func sharedKeys(data *Data, keys []uint64, name string) []string {
+	id, ok := data.NameIDs[name]
+	if !ok {
+		return nil
+	}
+	var shared []string
+	for _, key := range keys {
+		if bucket, _ := data.bucket(key); slices.Contains(bucket, id) {
+			shared = append(shared, data.pairKeyString(key))
+		}
+	}
+	return shared
+}
@@ -0,0 +1,257 @@
+package compare
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// --- FIXTURES ---
+// A fixture is a sample of a corpus that can be shared where the corpus
+// can't. Names sampled at random would hardly ever share a bucket, so the
+// sample is grown along candidate pairs instead: each seed name pulls in the
+// names it would be compared with, then theirs, keeping the hubs and large
+// buckets that make real corpora slow. Words can be replaced by keyed
+// pseudonyms, consistently, so which names share which words is kept.
+
+// FixtureOptions configures WriteFixture.
+type FixtureOptions struct {
+	// Number of distinct names to sample
+	Names int
+	// Number of names picked at random to grow the sample from
+	Seeds int
+	// Seed of the random choice, so a fixture can be made again
+	RandomSeed uint64
+	// Replaces every word of the output (see HMACRedactor); nil keeps them
+	Redact func(word string) string
+}
+
+// WriteFixture writes a sample of d as an input document. all_names keeps
+// the input order of the sampled names, word_to_matches is cut down to the
+// words of the sample, and each pair_to_names bucket to its sampled names.
+// query_names is left out. It returns the number of distinct names written.
+func (d *Data) WriteFixture(w io.Writer, opts FixtureOptions) (int, error) {
+	sampled := d.sampleNames(opts)
+	redact := opts.Redact
+	if redact == nil {
+		redact = func(word string) string { return word }
+	}
+	redactName := func(name string) string {
+		words := strings.Fields(name)
+		for i, word := range words {
+			words[i] = redact(word)
+		}
+		return strings.Join(words, " ")
+	}
+
+	inSample := make([]bool, d.Dict.Len())
+	count := 0
+	for id, name := range d.Names {
+		if sampled[id] {
+			count++
+			for _, word := range d.NameWords[name] {
+				inSample[word] = true
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`{"all_names":`)
+	var names []string
+	for _, name := range d.AllNames {
+		if sampled[d.NameIDs[name]] {
+			names = append(names, redactName(name))
+		}
+	}
+	writeJSONStrings(bw, names)
+
+	// Different words can share a pseudonym, so entries are merged by it,
+	// in ID order so the merged lists come out the same every time
+	words := make([]uint32, 0, len(d.WordToMatches))
+	for id := range d.WordToMatches {
+		if inSample[id] {
+			words = append(words, id)
+		}
+	}
+	slices.Sort(words)
+	matches := make(map[string][]string)
+	for _, id := range words {
+		word := redact(d.Dict.GetStr(id))
+		for _, m := range d.WordToMatches[id] {
+			if inSample[m] && !slices.Contains(matches[word], redact(d.Dict.GetStr(m))) {
+				matches[word] = append(matches[word], redact(d.Dict.GetStr(m)))
+			}
+		}
+		if _, ok := matches[word]; !ok {
+			matches[word] = []string{}
+		}
+	}
+	bw.WriteString(`,"word_to_matches":`)
+	writeJSONMap(bw, matches)
+
+	keys := make([]uint64, 0, len(d.PairToNames))
+	for key := range d.PairToNames {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	buckets := make(map[string][]string)
+	for _, key := range keys {
+		var kept []string
+		for _, id := range d.PairToNames[key] {
+			if sampled[id] {
+				kept = append(kept, redactName(d.Names[id]))
+			}
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		a, b := redact(d.Dict.GetStr(uint32(key>>32))), redact(d.Dict.GetStr(uint32(key)))
+		if a > b {
+			a, b = b, a
+		}
+		str := a + "_" + b
+		if prev, ok := buckets[str]; ok {
+			for _, name := range kept {
+				if !slices.Contains(prev, name) {
+					prev = append(prev, name)
+				}
+			}
+			kept = prev
+		}
+		buckets[str] = kept
+	}
+	bw.WriteString(`,"pair_to_names":`)
+	writeJSONMap(bw, buckets)
+	bw.WriteString("}\n")
+	return count, bw.Flush()
+}
+
+// sampleNames returns, by name ID, the names of the sample. Seeds are
+// taken from all_names; bucket order decides which candidates are pulled
+// in first, so the same options always give the same sample.
+func (d *Data) sampleNames(opts FixtureOptions) []bool {
+	var candidates []uint32
+	for id, name := range d.Names {
+		if _, ok := d.NameWords[name]; ok {
+			candidates = append(candidates, uint32(id))
+		}
+	}
+	rng := rand.New(rand.NewPCG(opts.RandomSeed, 0))
+	rng.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	sampled := make([]bool, len(d.Names))
+	target := min(opts.Names, len(candidates))
+	count := 0
+	take := func(id uint32) bool {
+		if sampled[id] || count == target {
+			return false
+		}
+		sampled[id] = true
+		count++
+		return true
+	}
+
+	seeds := max(opts.Seeds, 1)
+	var queue []uint32
+	var scratch pairScratch
+	next := 0
+	for count < target {
+		// Seeds go in a few at a time, and again whenever the names
+		// reachable from them run out
+		if len(queue) == 0 {
+			for added := 0; added < seeds && next < len(candidates); next++ {
+				if take(candidates[next]) {
+					queue = append(queue, candidates[next])
+					added++
+				}
+			}
+			if len(queue) == 0 {
+				break
+			}
+		}
+		id := queue[0]
+		queue = queue[1:]
+		parts := d.NameWords[d.Names[id]]
+		if len(parts) < 2 {
+			continue
+		}
+		for _, key := range buildExpandedPairMappings(parts, d.TradeoutSets, &scratch) {
+			for _, other := range d.PairToNames[key] {
+				if _, ok := d.NameWords[d.Names[other]]; ok && take(other) {
+					queue = append(queue, other)
+				}
+			}
+		}
+	}
+	return sampled
+}
+
+// HMACRedactor returns a redaction for WriteFixture that replaces each word
+// with a pseudonym keyed by key. A word's first letter is mapped through a
+// keyed permutation of a-z, keeping its case, and the rest of the word is
+// replaced by a hash of the whole word, so single letters stay single
+// letters and initials still stand for the words they stood for. Letters
+// outside a-z are kept. The hash is of the lowercase word, so words that
+// differ in case only after their first letter share a pseudonym.
+func HMACRedactor(key []byte) func(string) string {
+	sum := func(s string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(s))
+		return mac.Sum(nil)
+	}
+	letters := []rune("abcdefghijklmnopqrstuvwxyz")
+	perm := slices.Clone(letters)
+	sums := make(map[rune][]byte, len(perm))
+	for _, r := range perm {
+		sums[r] = sum(string(r))
+	}
+	sort.Slice(perm, func(i, j int) bool { return bytes.Compare(sums[perm[i]], sums[perm[j]]) < 0 })
+
+	return func(word string) string {
+		if word == "" {
+			return ""
+		}
+		first, size := utf8.DecodeRuneInString(word)
+		if lower := unicode.ToLower(first); lower >= 'a' && lower <= 'z' {
+			mapped := perm[lower-'a']
+			if unicode.IsUpper(first) {
+				mapped = unicode.ToUpper(mapped)
+			}
+			first = mapped
+		}
+		if size == len(word) {
+			return string(first)
+		}
+		return string(first) + hex.EncodeToString(sum(strings.ToLower(word))[:5])
+	}
+}
+
+// writeJSONMap writes m as a JSON object of string lists, keys sorted.
+func writeJSONMap(w *bufio.Writer, m map[string][]string) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	w.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		writeJSONString(w, key)
+		w.WriteByte(':')
+		writeJSONStrings(w, m[key])
+	}
+	w.WriteByte('}')
+}
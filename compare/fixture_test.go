@@ -0,0 +1,102 @@
+package compare
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fixtureCorpus has families of names of heavy-tailed sizes, the names of a
+// family sharing their family word and drawing the others from small pools,
+// so buckets range from single names to hundreds and some names are hubs
+// with far more candidates than the rest.
+func fixtureCorpus(n int) string {
+	rng := rand.New(rand.NewPCG(1, 1))
+	seen := make(map[string]bool)
+	var names, words []string
+	word := func(w string) {
+		if !seen[w] {
+			seen[w] = true
+			words = append(words, fmt.Sprintf("%q: [%q]", w, w))
+		}
+	}
+	for family := 0; len(names) < n; family++ {
+		size := min(int(1/(1-rng.Float64())), 400)
+		for range size {
+			name := fmt.Sprintf("given%d mid%d family%d", rng.IntN(60), rng.IntN(30), family)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			names = append(names, fmt.Sprintf("%q", name))
+			for _, w := range strings.Fields(name) {
+				word(w)
+			}
+		}
+	}
+	return `{"all_names": [` + strings.Join(names, ", ") + `], "word_to_matches": {` + strings.Join(words, ", ") + `}}`
+}
+
+// fixtureStats returns the p50, p90, p99 and max of the bucket sizes and of
+// the names' estimated candidates, which show the hubs.
+func fixtureStats(t *testing.T, data *Data) (buckets, hubs [4]int) {
+	t.Helper()
+	quantiles := func(values []int) [4]int {
+		slices.Sort(values)
+		at := func(q float64) int { return values[int(q*float64(len(values)-1))] }
+		return [4]int{at(0.5), at(0.9), at(0.99), values[len(values)-1]}
+	}
+	_, costs := NewMatcher(data, Options{}).schedule()
+	candidates := make([]int, len(costs))
+	for i, c := range costs {
+		candidates[i] = int(c)
+	}
+	return quantiles(data.BucketSizes()), quantiles(candidates)
+}
+
+// A fixture of a quarter of the corpus has its bucket size and hub
+// distributions within a third (plus one, for small values) of the
+// corpus's at every quantile, and redacting it changes no structure.
+func TestFixtureStats(t *testing.T) {
+	corpus := loadString(t, fixtureCorpus(20000))
+	corpusBuckets, corpusHubs := fixtureStats(t, corpus)
+	within := func(got, want int) bool {
+		return got*3 >= want*2-3 && got*3 <= want*4+3
+	}
+	for seed := range uint64(3) {
+		opts := FixtureOptions{Names: 5000, Seeds: 100, RandomSeed: seed}
+		var plain, redacted bytes.Buffer
+		n, err := corpus.WriteFixture(&plain, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 5000 {
+			t.Errorf("seed %d: %d names written", seed, n)
+		}
+		fixture := loadString(t, plain.String())
+		buckets, hubs := fixtureStats(t, fixture)
+		for i, q := range []string{"p50", "p90", "p99", "max"} {
+			if !within(buckets[i], corpusBuckets[i]) {
+				t.Errorf("seed %d: bucket size %s %d, corpus %d", seed, q, buckets[i], corpusBuckets[i])
+			}
+			if !within(hubs[i], corpusHubs[i]) {
+				t.Errorf("seed %d: candidates per name %s %d, corpus %d", seed, q, hubs[i], corpusHubs[i])
+			}
+		}
+
+		opts.Redact = HMACRedactor([]byte("key"))
+		if _, err := corpus.WriteFixture(&redacted, opts); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(redacted.String(), "family") {
+			t.Errorf("seed %d: a word left unredacted", seed)
+		}
+		redactedBuckets, redactedHubs := fixtureStats(t, loadString(t, redacted.String()))
+		if redactedBuckets != buckets || redactedHubs != hubs {
+			t.Errorf("seed %d: redacted stats %v %v, plain %v %v", seed, redactedBuckets, redactedHubs, buckets, hubs)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package compare
+
+import "unicode/utf8"
+
+// --- SPELLING FALLBACKS ---
+// word_to_matches only knows the spellings it was built with, so a simple
+// misspelling ("jonh", "johnathan") is a mismatch. With
+// MatchConfig.FuzzyThreshold or MaxEditDistance set, a word that matched
+// nothing is compared to the other name's words by Jaro-Winkler similarity
+// or edit distance before it is counted as a mismatch. Both compare runes
+// in stack buffers, so validation stays allocation-free.
+
+// maxFuzzyRunes bounds the words the fallbacks compare. Longer words never
+// match this way.
+const maxFuzzyRunes = 64
+
+// fallback reports whether a word that matched nothing otherwise matches a
+// word of other through one of the spelling fallbacks, and through which.
+func (c *MatchConfig) fallback(wID uint32, other []uint32, dict *Dictionary, rules *classRules) (wordOutcome, bool) {
+	if c.FuzzyThreshold > 0 && fuzzyMatch(wID, other, dict, rules, c.FuzzyThreshold) {
+		return outcomeFuzzy, true
+	}
+	if c.MaxEditDistance > 0 && editMatch(wID, other, dict, rules, c.MaxEditDistance, c.EditMinLength) {
+		return outcomeEdit, true
+	}
+	return 0, false
+}
+
+// fuzzyMatch reports whether wID is at least threshold similar to some
+// token of other that isn't ignored by the class rules.
+func fuzzyMatch(wID uint32, other []uint32, dict *Dictionary, rules *classRules, threshold float64) bool {
+	word := dict.GetStr(wID)
+	for _, oID := range other {
+		if oID == wID || (rules != nil && rules.ignored(oID)) {
+			continue
+		}
+		if jaroWinkler(word, dict.GetStr(oID)) >= threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// jaroWinkler returns the Jaro-Winkler similarity of a and b, compared rune
+// by rune, with the usual prefix scale of 0.1 over at most four runes.
+func jaroWinkler(a, b string) float64 {
+	var ra, rb [maxFuzzyRunes]rune
+	la, ok := toRunes(a, &ra)
+	if !ok {
+		return 0
+	}
+	lb, ok := toRunes(b, &rb)
+	if !ok {
+		return 0
+	}
+	if la == 0 || lb == 0 {
+		return 0
+	}
+
+	window := max(la, lb)/2 - 1
+	if window < 0 {
+		window = 0
+	}
+	var matchedA, matchedB [maxFuzzyRunes]bool
+	matches := 0
+	for i := 0; i < la; i++ {
+		lo, hi := max(0, i-window), min(lb, i+window+1)
+		for j := lo; j < hi; j++ {
+			if !matchedB[j] && ra[i] == rb[j] {
+				matchedA[i], matchedB[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	// Matched runes that appear in a different order, counted in halves
+	transpositions := 0
+	j := 0
+	for i := 0; i < la; i++ {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if ra[i] != rb[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(la) + m/float64(lb) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < min(4, la, lb) && ra[prefix] == rb[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+// editMatch reports whether wID is within maxDist edits of some token of
+// other that isn't ignored by the class rules, both being at least minLen
+// runes long.
+func editMatch(wID uint32, other []uint32, dict *Dictionary, rules *classRules, maxDist, minLen int) bool {
+	var ra, rb [maxFuzzyRunes]rune
+	la, ok := toRunes(dict.GetStr(wID), &ra)
+	if !ok || la < minLen {
+		return false
+	}
+	for _, oID := range other {
+		if oID == wID || (rules != nil && rules.ignored(oID)) {
+			continue
+		}
+		lb, ok := toRunes(dict.GetStr(oID), &rb)
+		if !ok || lb < minLen || abs(la-lb) > maxDist {
+			continue
+		}
+		if withinEdits(ra[:la], rb[:lb], maxDist) {
+			return true
+		}
+	}
+	return false
+}
+
+// withinEdits reports whether the Levenshtein distance of a and b is at
+// most maxDist, giving up on a row once every entry exceeds it.
+func withinEdits(a, b []rune, maxDist int) bool {
+	var prev, cur [maxFuzzyRunes + 1]int
+	for j := range len(b) + 1 {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			best = min(best, cur[j])
+		}
+		if best > maxDist {
+			return false
+		}
+		prev = cur
+	}
+	return prev[len(b)] <= maxDist
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// toRunes decodes s into buf, failing if it has more than maxFuzzyRunes.
+func toRunes(s string, buf *[maxFuzzyRunes]rune) (int, bool) {
+	n := 0
+	for len(s) > 0 {
+		if n == maxFuzzyRunes {
+			return 0, false
+		}
+		r, size := utf8.DecodeRuneInString(s)
+		buf[n] = r
+		n++
+		s = s[size:]
+	}
+	return n, true
+}
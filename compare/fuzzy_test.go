@@ -0,0 +1,135 @@
+package compare
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// Published reference values, to three decimals.
+// ABCVWXYZ and CABVWXYZ have three half transpositions, which must not be
+// rounded down to one transposition.
+func TestJaroWinkler(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want float64
+	}{
+		{"MARTHA", "MARHTA", 0.961},
+		{"DIXON", "DICKSONX", 0.813},
+		{"DWAYNE", "DUANE", 0.840},
+		{"ABCVWXYZ", "CABVWXYZ", 0.9375},
+		{"MARTHA", "MARTHA", 1},
+		{"ABC", "XYZ", 0},
+	} {
+		for _, pair := range [][2]string{{c.a, c.b}, {c.b, c.a}} {
+			if got := jaroWinkler(pair[0], pair[1]); math.Abs(got-c.want) > 0.0005 {
+				t.Errorf("jaroWinkler(%q, %q) = %.4f, want %.3f", pair[0], pair[1], got, c.want)
+			}
+		}
+	}
+}
+
+// levenshtein is the textbook distance, without withinEdits' cutoff.
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func TestWithinEdits(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		dist int
+	}{
+		{"jonathan", "jonathan", 0},
+		{"jonathan", "johnathan", 1},
+		{"jonathan", "jonahtan", 2},
+		{"kitten", "sitting", 3},
+		{"", "abc", 3},
+		{"mueller", "müller", 2},
+	} {
+		a, b := []rune(c.a), []rune(c.b)
+		for maxDist := 0; maxDist <= 4; maxDist++ {
+			want := c.dist <= maxDist
+			if got := withinEdits(a, b, maxDist); got != want {
+				t.Errorf("withinEdits(%q, %q, %d) = %v, want %v", c.a, c.b, maxDist, got, want)
+			}
+			if got := withinEdits(b, a, maxDist); got != want {
+				t.Errorf("withinEdits(%q, %q, %d) = %v, want %v", c.b, c.a, maxDist, got, want)
+			}
+		}
+	}
+}
+
+// Giving up on a row once every entry exceeds the bound never changes the
+// answer.
+func TestWithinEditsCutoff(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	word := func() []rune {
+		w := make([]rune, r.IntN(10))
+		for i := range w {
+			w[i] = rune('a' + r.IntN(3))
+		}
+		return w
+	}
+	for range 5000 {
+		a, b := word(), word()
+		dist := levenshtein(a, b)
+		for maxDist := 0; maxDist <= 3; maxDist++ {
+			if got := withinEdits(a, b, maxDist); got != (dist <= maxDist) {
+				t.Fatalf("withinEdits(%q, %q, %d) = %v, distance %d", string(a), string(b), maxDist, got, dist)
+			}
+		}
+	}
+}
+
+func TestEditMatch(t *testing.T) {
+	dict := NewDictionary()
+	ids := func(words ...string) []uint32 {
+		out := make([]uint32, len(words))
+		for i, w := range words {
+			out[i] = dict.GetID(w)
+		}
+		return out
+	}
+	for _, c := range []struct {
+		word    string
+		other   []string
+		maxDist int
+		minLen  int
+		want    bool
+	}{
+		{"johnathan", []string{"smith", "jonathan"}, 1, 5, true},
+		{"johnathan", []string{"smith", "jonathan"}, 1, 10, false},
+		{"jonahtan", []string{"jonathan"}, 1, 5, false},
+		{"jonahtan", []string{"jonathan"}, 2, 5, true},
+		// Both words must be long enough, not just the one that matched
+		// nothing
+		{"jonh", []string{"john"}, 2, 5, false},
+		{"jonh", []string{"john"}, 2, 4, true},
+		{"smithe", []string{"smith"}, 1, 6, false},
+		// Lengths further apart than the bound are skipped unexamined
+		{"jo", []string{"jonathan"}, 3, 1, false},
+		// The word itself is never its own match
+		{"jonathan", []string{"jonathan"}, 1, 1, false},
+	} {
+		got := editMatch(dict.GetID(c.word), ids(c.other...), dict, nil, c.maxDist, c.minLen)
+		if got != c.want {
+			t.Errorf("editMatch(%q, %q, %d, %d) = %v, want %v", c.word, c.other, c.maxDist, c.minLen, got, c.want)
+		}
+	}
+}
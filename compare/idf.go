@@ -0,0 +1,47 @@
+package compare
+
+import "math"
+
+// --- IDF WEIGHTS ---
+// Sharing "john" says little about two names, sharing a rare surname a lot.
+// A word's weight is its smoothed inverse document frequency over the
+// names, ln((1+N)/(1+df)) + 1 for N names of which df contain the word, so
+// every weight is at least 1. The weighted score of a pair is, per name,
+// the weight of its matched words over the weight of all its counted
+// words, averaged over both names (see WordCounts.WeightedScore).
+
+// TokenWeights holds the IDF weight of every word ID.
+type TokenWeights struct {
+	weights []float64
+	// Weight of a word no name contains, such as a word of an explained
+	// name that isn't in the input
+	unseen float64
+}
+
+// IDFWeights computes the weight of every dictionary word from the names.
+func (d *Data) IDFWeights() *TokenWeights {
+	df := make([]int, d.Dict.Len())
+	// Name each word was last counted for, so repeated words count once
+	last := make([]int, d.Dict.Len())
+	for i, name := range d.AllNames {
+		for _, id := range d.NameWords[name] {
+			if int(id) < len(df) && last[id] != i+1 {
+				last[id] = i + 1
+				df[id]++
+			}
+		}
+	}
+	n := float64(len(d.AllNames))
+	w := &TokenWeights{weights: make([]float64, len(df)), unseen: math.Log(1+n) + 1}
+	for id, count := range df {
+		w.weights[id] = math.Log((1+n)/(1+float64(count))) + 1
+	}
+	return w
+}
+
+func (w *TokenWeights) weight(id uint32) float64 {
+	if int(id) < len(w.weights) {
+		return w.weights[id]
+	}
+	return w.unseen
+}
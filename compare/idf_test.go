@@ -0,0 +1,77 @@
+package compare
+
+import (
+	"math"
+	"testing"
+)
+
+// Four names: "john" is in three of them, twice in one, "lee" in two and
+// the rest in one each
+const idfInput = `{
+	"all_names": ["john smith", "john jones", "john john lee", "mary lee"],
+	"word_to_matches": {"john": ["john"], "smith": ["smith"], "jones": ["jones"], "lee": ["lee"], "mary": ["mary"]}
+}`
+
+func TestIDFWeights(t *testing.T) {
+	data := loadString(t, idfInput)
+	w := data.IDFWeights()
+	idf := func(df float64) float64 { return math.Log(5/(1+df)) + 1 }
+	for word, want := range map[string]float64{
+		"john":  idf(3),
+		"lee":   idf(2),
+		"smith": idf(1),
+		"mary":  idf(1),
+	} {
+		id, ok := data.Dict.Lookup(word)
+		if !ok {
+			t.Fatalf("%q not in the dictionary", word)
+		}
+		if got := w.weight(id); math.Abs(got-want) > 1e-9 {
+			t.Errorf("weight of %q = %f, want %f", word, got, want)
+		}
+	}
+	if got, want := w.weight(uint32(data.Dict.Len())+10), idf(0); math.Abs(got-want) > 1e-9 {
+		t.Errorf("weight of an unseen word = %f, want %f", got, want)
+	}
+}
+
+func TestWeightedScore(t *testing.T) {
+	data := loadString(t, idfInput)
+	if got := (WordCounts{WordsA: 2, WordsB: 2, MismatchesA: 1}).WeightedScore(); got != 0 {
+		t.Errorf("weighted score without weights = %f, want 0", got)
+	}
+	john, smith, jones := math.Log(5.0/4)+1, math.Log(5.0/2)+1, math.Log(5.0/2)+1
+	// Only "john" matches, which weighs less than the surnames
+	want := (john/(john+smith) + john/(john+jones)) / 2
+	for _, c := range []struct {
+		min float64
+		ok  bool
+	}{
+		{0, true},
+		{want - 0.01, true},
+		{want + 0.01, false},
+	} {
+		cfg := DefaultMatchConfig()
+		cfg.MinCommonWords = 1
+		cfg.Weights = data.IDFWeights()
+		cfg.MinWeightedScore = c.min
+		m := NewMatcher(data, Options{Match: &cfg})
+		e, err := m.Explain("john smith", "john jones")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.WeightedScore == nil || math.Abs(*e.WeightedScore-want) > 1e-9 {
+			t.Fatalf("weighted score %v, want %f", e.WeightedScore, want)
+		}
+		// The unweighted score counts every word alike
+		if e.Score != 0.5 {
+			t.Errorf("score %f, want 0.5", e.Score)
+		}
+		if e.Valid != c.ok {
+			t.Errorf("--min-weighted-score %f: valid %v, want %v (rejected by %q)", c.min, e.Valid, c.ok, e.RejectedBy)
+		}
+		if !c.ok && e.RejectedBy != ruleWeightedScore {
+			t.Errorf("--min-weighted-score %f: rejected by %q", c.min, e.RejectedBy)
+		}
+	}
+}
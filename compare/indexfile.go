@@ -0,0 +1,462 @@
+package compare
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+)
+
+// --- INDEX FILES ---
+// Loading a large input spends most of its time decoding JSON, interning
+// words and, without a pair_to_names, building the pair index. An index file
+// keeps the result, so runs against the same input can skip all of that.
+//
+// Layout, every integer a uvarint and every string its length then bytes:
+//
+//	header:  "CNIX" | version (1 byte)
+//	options: fold case (0/1) | normalization | pair index built (0/1)
+//	mask:    pattern count | (pattern, words dropped)*
+//	words:   count | word*                      in ID order
+//	names:   count | name*                      in ID order
+//	jobs:    count | name ID*                   all_names
+//	queries: count + 1 | index*                 0 for no queries
+//	tokens:  count | (name ID, count, word ID*)*
+//	matches: count | (word ID, count, word ID*)*
+//	pairs:   count | (pair key, count, name ID*)*
+//	trailer: CRC32C of all of the above (uint32 LE)
+//
+// Map sections are written in key order, so the same Data always gives the
+// same file. Tradeouts follow from the matches and aren't stored.
+
+const (
+	IndexMagic   = "CNIX"
+	IndexVersion = 1
+
+	// Larger counts can only come from corruption
+	maxIndexCount = 1 << 31
+)
+
+var indexCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// WriteIndex writes d as an index file, which ReadIndex loads back. Matches
+// added after loading are written as if they came with the input.
+func (d *Data) WriteIndex(w io.Writer) error {
+	crc := crc32.New(indexCRC)
+	iw := &indexWriter{w: bufio.NewWriter(io.MultiWriter(w, crc))}
+	iw.w.WriteString(IndexMagic)
+	iw.w.WriteByte(IndexVersion)
+
+	iw.bool(d.FoldCase)
+	iw.string(d.Normalize.String())
+	iw.bool(d.PairIndexBuilt)
+	if d.Mask == nil {
+		iw.uint(0)
+	} else {
+		iw.uint(uint64(len(d.Mask.patterns)))
+		for i, re := range d.Mask.patterns {
+			iw.string(re.String())
+			iw.uint(uint64(d.Mask.dropped[i]))
+		}
+	}
+
+	iw.strings(d.Dict.intToStr)
+	iw.strings(d.Names)
+	iw.uint(uint64(len(d.AllNames)))
+	for _, name := range d.AllNames {
+		iw.uint(uint64(d.NameIDs[name]))
+	}
+	if d.Queries == nil {
+		iw.uint(0)
+	} else {
+		iw.uint(uint64(len(d.Queries)) + 1)
+		iw.ids(d.Queries)
+	}
+
+	tokens := make(map[uint32][]uint32, len(d.NameWords))
+	for name, ids := range d.NameWords {
+		tokens[d.NameIDs[name]] = ids
+	}
+	writeIDMap(iw, tokens)
+	writeIDMap(iw, d.WordToMatches)
+	// Buckets may be on disk, so the pairs go through bucket
+	keys := slices.Clone(d.bucketKeys())
+	slices.Sort(keys)
+	iw.uint(uint64(len(keys)))
+	for _, key := range keys {
+		bucket, _ := d.bucket(key)
+		iw.uint(key)
+		iw.uint(uint64(len(bucket)))
+		iw.ids(bucket)
+	}
+	if d.DiskPairs != nil && d.DiskPairs.Err() != nil {
+		return d.DiskPairs.Err()
+	}
+
+	if err := iw.w.Flush(); err != nil {
+		return err
+	}
+	if iw.err != nil {
+		return iw.err
+	}
+	return binary.Write(w, binary.LittleEndian, crc.Sum32())
+}
+
+// Hash identifies the data by the index file it writes, which is the same
+// for the same input loaded the same way (see WriteIndex). It costs about as
+// much as writing the index.
+func (d *Data) Hash() (string, error) {
+	h := sha256.New()
+	if err := d.WriteIndex(h); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+type indexWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (iw *indexWriter) uint(v uint64) {
+	if _, err := iw.w.Write(binary.AppendUvarint(iw.buf[:0], v)); err != nil && iw.err == nil {
+		iw.err = err
+	}
+}
+
+func (iw *indexWriter) bool(b bool) {
+	if b {
+		iw.uint(1)
+	} else {
+		iw.uint(0)
+	}
+}
+
+func (iw *indexWriter) string(s string) {
+	iw.uint(uint64(len(s)))
+	iw.w.WriteString(s)
+}
+
+func (iw *indexWriter) strings(strs []string) {
+	iw.uint(uint64(len(strs)))
+	for _, s := range strs {
+		iw.string(s)
+	}
+}
+
+func (iw *indexWriter) ids(ids []uint32) {
+	for _, id := range ids {
+		iw.uint(uint64(id))
+	}
+}
+
+func writeIDMap[K uint32 | uint64](iw *indexWriter, m map[K][]uint32) {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	iw.uint(uint64(len(keys)))
+	for _, k := range keys {
+		iw.uint(uint64(k))
+		iw.uint(uint64(len(m[k])))
+		iw.ids(m[k])
+	}
+}
+
+// ReadIndex loads an index file written by WriteIndex. The file is checked
+// against its CRC, and every ID in it against the words and names it holds.
+func ReadIndex(r io.Reader) (*Data, error) {
+	return readIndex(r, nil)
+}
+
+// OpenIndex is ReadIndex for the index file at path, leaving its buckets
+// on disk (see DiskPairs) with up to cacheBytes of them cached, and mapping
+// the file where it can. The file is still read through once to check it.
+// Close the returned Data's DiskPairs when done.
+func OpenIndex(path string, cacheBytes int64) (*Data, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := readIndex(file, newDiskPairs(file, cacheBytes))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	d.DiskPairs.mapped = mapFile(file)
+	return d, nil
+}
+
+// readIndex reads an index file, scanning its pairs section into disk
+// instead of PairToNames if disk isn't nil. Errors other than a version
+// mismatch are an InputError of the "index" field.
+func readIndex(r io.Reader, disk *DiskPairs) (*Data, error) {
+	d, err := decodeIndex(r, disk)
+	var version *SchemaVersionError
+	if err != nil && !errors.As(err, &version) {
+		return nil, &InputError{Field: "index", Err: err}
+	}
+	return d, err
+}
+
+func decodeIndex(r io.Reader, disk *DiskPairs) (*Data, error) {
+	ir := &indexReader{r: bufio.NewReader(r), crc: crc32.New(indexCRC)}
+	header := make([]byte, len(IndexMagic)+1)
+	if _, err := io.ReadFull(ir, header); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	if string(header[:len(IndexMagic)]) != IndexMagic {
+		return nil, errors.New("not an index file")
+	}
+	if v := header[len(IndexMagic)]; v != IndexVersion {
+		return nil, &SchemaVersionError{What: "index", Got: int(v), Want: IndexVersion}
+	}
+
+	d := &Data{FoldCase: ir.bool()}
+	norm := ir.string()
+	if norm != "none" && ir.err == nil {
+		var err error
+		if d.Normalize, err = ParseNormalization(norm); err != nil {
+			return nil, fmt.Errorf("normalization: %w", err)
+		}
+	}
+	d.PairIndexBuilt = ir.bool()
+	if n := ir.count(); n > 0 {
+		d.Mask = &TokenMask{}
+		for range n {
+			pattern, dropped := ir.string(), ir.uint()
+			if ir.err != nil {
+				break
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("mask: %w", err)
+			}
+			d.Mask.patterns = append(d.Mask.patterns, re)
+			d.Mask.dropped = append(d.Mask.dropped, int(dropped))
+		}
+	}
+
+	d.Dict = NewDictionary()
+	for range ir.count() {
+		word := ir.string()
+		if _, dup := d.Dict.strToInt[word]; dup && ir.err == nil {
+			return nil, fmt.Errorf("duplicate word %q", word)
+		}
+		d.Dict.GetID(word)
+	}
+	if d.FoldCase {
+		d.Dict.FoldCase()
+	}
+	words := uint64(d.Dict.Len())
+
+	d.Names = ir.strings()
+	d.NameIDs = make(map[string]uint32, len(d.Names))
+	for id, name := range d.Names {
+		d.NameIDs[name] = uint32(id)
+	}
+	names := uint64(len(d.Names))
+	jobs := ir.ids(names)
+	if ir.err != nil {
+		return nil, ir.err
+	}
+	d.AllNames = make([]string, len(jobs))
+	for i, id := range jobs {
+		d.AllNames[i] = d.Names[id]
+	}
+	if n := ir.count(); n > 0 {
+		d.Queries = make([]uint32, 0, n-1)
+		for range n - 1 {
+			d.Queries = append(d.Queries, uint32(ir.id(uint64(len(d.AllNames)))))
+		}
+	}
+
+	tokens := readIDMap[uint32](ir, names, words)
+	if ir.err != nil {
+		return nil, ir.err
+	}
+	d.NameWords = make(map[string][]uint32, len(tokens))
+	for id, ids := range tokens {
+		d.NameWords[d.Names[id]] = ids
+	}
+	d.WordToMatches = readIDMap[uint32](ir, words, words)
+	if ir.err != nil {
+		return nil, ir.err
+	}
+	d.TradeoutSets = make(map[uint32][]uint32, len(d.WordToMatches))
+	for k, matches := range d.WordToMatches {
+		if len(d.Dict.GetStr(k)) != 1 {
+			d.TradeoutSets[k] = matches
+		} else {
+			d.TradeoutSets[k] = []uint32{k}
+		}
+	}
+	if disk != nil {
+		scanPairs(ir, disk, words<<32, names)
+		d.DiskPairs = disk
+	} else {
+		d.PairToNames = readIDMap[uint64](ir, words<<32, names)
+	}
+	if ir.err != nil {
+		return nil, ir.err
+	}
+
+	sum := ir.crc.Sum32()
+	var stored uint32
+	if err := binary.Read(ir.r, binary.LittleEndian, &stored); err != nil {
+		return nil, fmt.Errorf("trailer: %w", err)
+	}
+	if stored != sum {
+		return nil, fmt.Errorf("CRC mismatch (stored %08x, computed %08x)", stored, sum)
+	}
+	return d, nil
+}
+
+// indexReader decodes the fields of an index file, feeding every byte to
+// the CRC. The first error sticks and zero values are returned from then on.
+type indexReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	// Bytes read so far
+	off int64
+	err error
+}
+
+func (ir *indexReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	ir.crc.Write(p[:n])
+	ir.off += int64(n)
+	return n, err
+}
+
+func (ir *indexReader) ReadByte() (byte, error) {
+	b, err := ir.r.ReadByte()
+	if err == nil {
+		ir.crc.Write([]byte{b})
+		ir.off++
+	}
+	return b, err
+}
+
+func (ir *indexReader) fail(err error) {
+	if ir.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		ir.err = err
+	}
+}
+
+func (ir *indexReader) uint() uint64 {
+	if ir.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(ir)
+	if err != nil {
+		ir.fail(err)
+	}
+	return v
+}
+
+func (ir *indexReader) bool() bool {
+	return ir.uint() != 0
+}
+
+func (ir *indexReader) count() uint64 {
+	n := ir.uint()
+	if n > maxIndexCount {
+		ir.fail(fmt.Errorf("count %d out of range", n))
+		return 0
+	}
+	return n
+}
+
+// id reads an ID, which must be below limit.
+func (ir *indexReader) id(limit uint64) uint64 {
+	id := ir.uint()
+	if id >= limit && ir.err == nil {
+		ir.fail(fmt.Errorf("ID %d out of range (%d known)", id, limit))
+	}
+	if ir.err != nil {
+		return 0
+	}
+	return id
+}
+
+func (ir *indexReader) string() string {
+	n := ir.count()
+	if ir.err != nil {
+		return ""
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(ir, buf); err != nil {
+		ir.fail(err)
+		return ""
+	}
+	return string(buf)
+}
+
+func (ir *indexReader) strings() []string {
+	n := ir.count()
+	strs := make([]string, 0, min(n, 1<<16))
+	for range n {
+		strs = append(strs, ir.string())
+	}
+	return strs
+}
+
+// ids reads a count and that many IDs below limit.
+func (ir *indexReader) ids(limit uint64) []uint32 {
+	n := ir.count()
+	ids := make([]uint32, 0, min(n, 1<<16))
+	for range n {
+		ids = append(ids, uint32(ir.id(limit)))
+	}
+	return ids
+}
+
+// readIDMap reads a map section whose keys are below keyLimit and whose
+// lists hold IDs below idLimit.
+func readIDMap[K uint32 | uint64](ir *indexReader, keyLimit, idLimit uint64) map[K][]uint32 {
+	n := ir.count()
+	m := make(map[K][]uint32, min(n, 1<<16))
+	for range n {
+		k := K(ir.id(keyLimit))
+		m[k] = ir.ids(idLimit)
+	}
+	return m
+}
+
+// scanPairs reads a pairs section like readIDMap, but only records where
+// each bucket lies.
+func scanPairs(ir *indexReader, disk *DiskPairs, keyLimit, idLimit uint64) {
+	n := ir.count()
+	disk.keys = make([]uint64, 0, min(n, 1<<16))
+	disk.spans = make([]pairSpan, 0, min(n, 1<<16))
+	for range n {
+		key := ir.id(keyLimit)
+		if len(disk.keys) > 0 && key <= disk.keys[len(disk.keys)-1] && ir.err == nil {
+			ir.fail(fmt.Errorf("pair key %d out of order", key))
+		}
+		count := ir.count()
+		start := ir.off
+		for range count {
+			ir.id(idLimit)
+		}
+		if ir.err != nil {
+			return
+		}
+		disk.keys = append(disk.keys, key)
+		disk.spans = append(disk.spans, pairSpan{off: start, size: uint32(ir.off - start), n: uint32(count)})
+	}
+}
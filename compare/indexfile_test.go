@@ -0,0 +1,149 @@
+package compare
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// dictWords returns the words of dict in ID order.
+func dictWords(dict *Dictionary) []string {
+	words := make([]string, dict.Len())
+	for id := range words {
+		words[id] = dict.GetStr(uint32(id))
+	}
+	return words
+}
+
+// writeFiles returns what d writes as an index, a dictionary and an input
+// document, in that order.
+func writeFiles(t *testing.T, d *Data) [3][]byte {
+	t.Helper()
+	var index, dict, input bytes.Buffer
+	if err := d.WriteIndex(&index); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WriteDictionary(&dict); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WriteInput(&input); err != nil {
+		t.Fatal(err)
+	}
+	return [3][]byte{index.Bytes(), dict.Bytes(), input.Bytes()}
+}
+
+// Building the index twice from the same input gives the same dictionary
+// and the same bytes in every file written from it, however Go orders its
+// maps in between.
+func TestIndexDeterministic(t *testing.T) {
+	for _, doc := range []string{smallInput, validateInput, "{" + underscoreNames + "}"} {
+		first := loadString(t, doc)
+		want := writeFiles(t, first)
+		for range 5 {
+			data := loadString(t, doc)
+			if got := dictWords(data.Dict); !slices.Equal(got, dictWords(first.Dict)) {
+				t.Fatalf("dictionary %q, want %q", got, dictWords(first.Dict))
+			}
+			got := writeFiles(t, data)
+			for i, file := range []string{"index", "dictionary", "input"} {
+				if !bytes.Equal(got[i], want[i]) {
+					t.Errorf("%s differs between builds of the same input", file)
+				}
+			}
+		}
+	}
+}
+
+// An index read back writes the bytes it was read from.
+func TestIndexRoundTrip(t *testing.T) {
+	data := loadString(t, smallInput)
+	var first bytes.Buffer
+	if err := data.WriteIndex(&first); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadIndex(bytes.NewReader(first.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(dictWords(read.Dict), dictWords(data.Dict)) {
+		t.Errorf("dictionary %q, want %q", dictWords(read.Dict), dictWords(data.Dict))
+	}
+	var second bytes.Buffer
+	if err := read.WriteIndex(&second); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("index read back writes different bytes")
+	}
+}
+
+func TestDataHash(t *testing.T) {
+	hash := func(data *Data) string {
+		t.Helper()
+		h, err := data.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	want := hash(loadString(t, smallInput))
+	if got := hash(loadString(t, smallInput)); got != want {
+		t.Errorf("same input: hash %s, then %s", want, got)
+	}
+	// Same number of names, one of them different
+	other := loadString(t, strings.Replace(smallInput, `"mary jones"`, `"mary jonas"`, 1))
+	if hash(other) == want {
+		t.Error("different names hash the same")
+	}
+	folded, err := LoadWithOptions(strings.NewReader(smallInput), LoadOptions{FoldCase: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash(folded) == want {
+		t.Error("the same input loaded case-folded hashes the same")
+	}
+}
+
+// An index opened with its buckets on disk finds the same pairs and writes
+// the same bytes as one read into memory, even with a cache too small to
+// keep any bucket.
+func TestOpenIndex(t *testing.T) {
+	data := loadString(t, smallInput)
+	var index bytes.Buffer
+	if err := data.WriteIndex(&index); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "small.idx")
+	if err := os.WriteFile(path, index.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, cache := range []int64{0, 1 << 20} {
+		disk, err := OpenIndex(path, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if disk.Buckets() != data.Buckets() {
+			t.Errorf("cache %d: %d buckets, want %d", cache, disk.Buckets(), data.Buckets())
+		}
+		want := runPairs(t, data, Options{})
+		if got := runPairs(t, disk, Options{Workers: 4}); !slices.Equal(got, want) {
+			t.Errorf("cache %d: pairs %q, want %q", cache, got, want)
+		}
+		var written bytes.Buffer
+		if err := disk.WriteIndex(&written); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(written.Bytes(), index.Bytes()) {
+			t.Errorf("cache %d: index written from disk buckets differs", cache)
+		}
+		if err := disk.DiskPairs.Err(); err != nil {
+			t.Error(err)
+		}
+		if err := disk.DiskPairs.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
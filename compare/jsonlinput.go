@@ -0,0 +1,75 @@
+package compare
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// --- JSON LINES INPUT ---
+// A producer that can't hold the whole corpus can't easily write one JSON
+// object around it either, so an input can also be a stream of records, one
+// per line, each carrying a single entry:
+//
+//	{"name": "john c weymouth"}
+//	{"word": "john", "matches": ["john", "jon", "j"]}
+//	{"pair": "john_weymouth", "names": ["john c weymouth"]}
+//	{"query_name": "jon weymouth"}
+//
+// Records may come in any order. A word's matches must be on one record,
+// as in a JSON document, while a pair may span several.
+
+type jsonlRecord struct {
+	Name      *string  `json:"name"`
+	Word      string   `json:"word"`
+	Matches   []string `json:"matches"`
+	Pair      string   `json:"pair"`
+	Names     []string `json:"names"`
+	QueryName *string  `json:"query_name"`
+}
+
+// LoadJSONL loads an input given as JSON Lines. Dictionary files (see
+// LoadWithDictionary) only apply to JSON documents, so opts.Dict must be
+// nil.
+func LoadJSONL(r io.Reader, opts LoadOptions) (*Data, error) {
+	if opts.Dict != nil {
+		return nil, &InputError{Err: errors.New("dictionary files only apply to JSON input")}
+	}
+	return load(func(v InputVisitor) error { return StreamJSONLInput(r, v) }, opts)
+}
+
+// StreamJSONLInput passes the records of a JSON Lines input to v as they
+// are decoded, like StreamInput does for a JSON document.
+func StreamJSONLInput(r io.Reader, v InputVisitor) error {
+	dec := json.NewDecoder(bufio.NewReaderSize(r, 1<<20))
+	dec.DisallowUnknownFields()
+	for n := 1; ; n++ {
+		var rec jsonlRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return &InputError{Line: n, Err: err}
+		}
+		switch {
+		case rec.Name != nil:
+			if v.Name != nil {
+				v.Name(*rec.Name)
+			}
+		case rec.Word != "":
+			if v.WordMatches != nil {
+				v.WordMatches(rec.Word, rec.Matches)
+			}
+		case rec.Pair != "":
+			if v.PairNames != nil {
+				v.PairNames(rec.Pair, rec.Names)
+			}
+		case rec.QueryName != nil:
+			if v.QueryName != nil {
+				v.QueryName(*rec.QueryName)
+			}
+		default:
+			return &InputError{Line: n, Err: errors.New("expected one of name, word, pair or query_name")}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package compare
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// smallInput as JSON Lines, shuffled, with a bucket split over two records.
+const smallJSONL = `{"word": "john", "matches": ["john", "jon"]}
+{"name": "john smith"}
+{"pair": "john_smith", "names": ["john smith"]}
+{"name": "jon smith"}
+{"word": "smith", "matches": ["smith", "smyth"]}
+{"name": "john smyth"}
+{"word": "jon", "matches": ["jon", "john"]}
+{"name": "mary jones"}
+{"pair": "john_smith", "names": ["jon smith"]}
+{"word": "smyth", "matches": ["smyth", "smith"]}
+{"name": "mary smith"}
+{"word": "mary", "matches": ["mary"]}
+{"word": "jones", "matches": ["jones"]}
+{"name": "john smith"}
+`
+
+// JSON Lines finds the pairs of the same input as a JSON document, and
+// merges the records of a pair into one bucket.
+func TestLoadJSONL(t *testing.T) {
+	data, err := LoadJSONL(strings.NewReader(smallJSONL), LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	john, _ := data.Dict.Lookup("john")
+	smith, _ := data.Dict.Lookup("smith")
+	key := packPair(john, smith)
+	if got := len(data.PairToNames[key]); got != 2 {
+		t.Errorf("john_smith bucket holds %d names, want 2", got)
+	}
+	want := runPairs(t, loadString(t, smallInput), Options{})
+	if got := runPairs(t, data, Options{}); !slices.Equal(got, want) {
+		t.Errorf("pairs %q, want %q", got, want)
+	}
+
+	var input *InputError
+	_, err = LoadJSONL(strings.NewReader(`{"name": "john smith"}`+"\n{}\n"), LoadOptions{})
+	if !errors.As(err, &input) || input.Line != 2 {
+		t.Errorf("an empty record: %v, want an InputError on line 2", err)
+	}
+}
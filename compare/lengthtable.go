@@ -0,0 +1,95 @@
+package compare
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// --- MISMATCH TABLES ---
+// How many mismatches a pair can bear depends on how long its names are:
+// one in two words is a different person, one in seven words of a company
+// name is noise. A mismatch table maps buckets of name lengths to the
+// mismatches allowed on each side, as a list like
+//
+//	2-3/2-3=0,4+/4+=1,7+/7+=2
+//
+// Each entry is lengths of one name / lengths of the other = allowed
+// mismatches per name. A length is a number, a range lo-hi, lo+ for lo or
+// more, or * for any. Entries are symmetric and the first one matching a
+// pair applies; pairs no entry matches are left to the other rules.
+
+// MismatchBucket is one entry of a mismatch table.
+type MismatchBucket struct {
+	MinA, MaxA, MinB, MaxB int
+	MaxMismatches          int
+}
+
+// ParseMismatchTable parses a mismatch table; the empty string is no table.
+func ParseMismatchTable(s string) ([]MismatchBucket, error) {
+	var table []MismatchBucket
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		lengths, allowed, ok := strings.Cut(entry, "=")
+		a, b, ok2 := strings.Cut(lengths, "/")
+		if !ok || !ok2 {
+			return nil, &InputError{Err: fmt.Errorf("mismatch table entry %q: want lengths/lengths=mismatches", entry)}
+		}
+		var bucket MismatchBucket
+		var err error
+		if bucket.MinA, bucket.MaxA, err = parseLengthRange(a); err != nil {
+			return nil, &InputError{Err: fmt.Errorf("mismatch table entry %q: %w", entry, err)}
+		}
+		if bucket.MinB, bucket.MaxB, err = parseLengthRange(b); err != nil {
+			return nil, &InputError{Err: fmt.Errorf("mismatch table entry %q: %w", entry, err)}
+		}
+		if bucket.MaxMismatches, err = strconv.Atoi(strings.TrimSpace(allowed)); err != nil || bucket.MaxMismatches < 0 {
+			return nil, &InputError{Err: fmt.Errorf("mismatch table entry %q: want 0 or more mismatches", entry)}
+		}
+		table = append(table, bucket)
+	}
+	return table, nil
+}
+
+func parseLengthRange(s string) (lo, hi int, err error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "*":
+		return 0, math.MaxInt, nil
+	case strings.HasSuffix(s, "+"):
+		lo, err = strconv.Atoi(strings.TrimSuffix(s, "+"))
+		hi = math.MaxInt
+	case strings.Contains(s, "-"):
+		l, h, _ := strings.Cut(s, "-")
+		if lo, err = strconv.Atoi(l); err == nil {
+			hi, err = strconv.Atoi(h)
+		}
+	default:
+		lo, err = strconv.Atoi(s)
+		hi = lo
+	}
+	if err != nil || lo < 0 || lo > hi {
+		return 0, 0, fmt.Errorf("bad length %q (want n, lo-hi, lo+ or *)", s)
+	}
+	return lo, hi, nil
+}
+
+// allowedMismatches returns the mismatches the first matching entry allows
+// per name for names of lenA and lenB words, or false if none matches.
+func (c *MatchConfig) allowedMismatches(lenA, lenB int) (int, bool) {
+	for i := range c.MismatchTable {
+		b := &c.MismatchTable[i]
+		if b.contains(lenA, lenB) || b.contains(lenB, lenA) {
+			return b.MaxMismatches, true
+		}
+	}
+	return 0, false
+}
+
+func (b *MismatchBucket) contains(lenA, lenB int) bool {
+	return lenA >= b.MinA && lenA <= b.MaxA && lenB >= b.MinB && lenB <= b.MaxB
+}
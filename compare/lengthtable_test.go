@@ -0,0 +1,109 @@
+package compare
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMismatchTable(t *testing.T) {
+	table, err := ParseMismatchTable(" 2-3/2-3=0, 4+/*=1 ,7/7=2,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MismatchBucket{
+		{MinA: 2, MaxA: 3, MinB: 2, MaxB: 3, MaxMismatches: 0},
+		{MinA: 4, MaxA: math.MaxInt, MinB: 0, MaxB: math.MaxInt, MaxMismatches: 1},
+		{MinA: 7, MaxA: 7, MinB: 7, MaxB: 7, MaxMismatches: 2},
+	}
+	if !reflect.DeepEqual(table, want) {
+		t.Errorf("table %+v, want %+v", table, want)
+	}
+	if table, err := ParseMismatchTable(""); err != nil || table != nil {
+		t.Errorf("empty table: %v, %v", table, err)
+	}
+
+	for _, c := range []struct {
+		spec, want string
+	}{
+		{"2-3/2-3", "want lengths/lengths=mismatches"},
+		{"2-3=1", "want lengths/lengths=mismatches"},
+		{"3-2/4=1", `bad length "3-2"`},
+		{"x/4=1", `bad length "x"`},
+		{"-1/4=1", `bad length "-1"`},
+		{"2+/a+=1", `bad length "a+"`},
+		{"2/2=-1", "want 0 or more mismatches"},
+		{"2/2=many", "want 0 or more mismatches"},
+	} {
+		_, err := ParseMismatchTable(c.spec)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: error %v, want %q", c.spec, err, c.want)
+		}
+	}
+}
+
+func TestAllowedMismatches(t *testing.T) {
+	table, err := ParseMismatchTable("2-3/2-3=0,4+/4-6=1,5+/5+=2,*/*=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := MatchConfig{MismatchTable: table}
+	for _, c := range []struct {
+		lenA, lenB int
+		want       int
+	}{
+		{2, 3, 0},
+		{3, 2, 0},
+		// Entries are symmetric
+		{4, 6, 1},
+		{6, 4, 1},
+		// 5/5 is in both the second and third entries, and the first
+		// matching one applies
+		{5, 5, 1},
+		{7, 7, 2},
+		{7, 3, 3},
+	} {
+		if got, ok := cfg.allowedMismatches(c.lenA, c.lenB); !ok || got != c.want {
+			t.Errorf("allowedMismatches(%d, %d) = %d, %v; want %d", c.lenA, c.lenB, got, ok, c.want)
+		}
+	}
+	// Without a catch-all, lengths no entry covers are left to the other
+	// rules
+	cfg.MismatchTable = table[:3]
+	if got, ok := cfg.allowedMismatches(7, 3); ok {
+		t.Errorf("allowedMismatches(7, 3) = %d, want no entry", got)
+	}
+}
+
+func TestMismatchTableInValidation(t *testing.T) {
+	table, err := ParseMismatchTable("4+/4+=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultMatchConfig()
+	cfg.MismatchTable = table
+	m := NewMatcher(loadString(t, validateInput), Options{Match: &cfg})
+	for _, c := range []struct {
+		a, b string
+		ok   bool
+	}{
+		// One mismatch on each side of two four-word names
+		{"john paul jones smith", "jon paul mary smyth", true},
+		// Two mismatches
+		{"john paul jones smith", "jon ann mary smyth", false},
+		// Two-word names aren't in the table
+		{"john smith", "jon smyth", true},
+	} {
+		if ok, _ := m.Validate(c.a, c.b); ok != c.ok {
+			t.Errorf("Validate(%q, %q) = %v, want %v", c.a, c.b, ok, c.ok)
+		}
+	}
+	e, err := m.Explain("john paul jones smith", "jon ann mary smyth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.RejectedBy != ruleMismatchTable {
+		t.Errorf("explain: rejected by %q, want %s", e.RejectedBy, ruleMismatchTable)
+	}
+}
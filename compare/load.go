@@ -0,0 +1,560 @@
+package compare
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+)
+
+// --- DATA STRUCTURES ---
+
+// Data is the interned form of an input document.
+type Data struct {
+	// Every entry of all_names in input order; these are the jobs
+	AllNames []string
+	// Names converted to lists of word IDs
+	NameWords map[string][]uint32
+	// Matches converted to lists of word IDs
+	WordToMatches map[uint32][]uint32
+	// Tradeouts converted to lists of word IDs
+	TradeoutSets map[uint32][]uint32
+	// Buckets of name IDs, keyed by pairs of word IDs packed like pairs of
+	// names (see packPair)
+	PairToNames map[uint64][]uint32
+	// Buckets left in an index file instead of PairToNames (see
+	// OpenIndex); nil when PairToNames holds them
+	DiskPairs *DiskPairs
+
+	Dict *Dictionary
+
+	// Every distinct name gets an ID so pairs of names can be packed into
+	// a single uint64 (see packPair), and buckets hold IDs, not strings.
+	// Names in buckets but not in all_names get IDs too.
+	NameIDs map[string]uint32
+	// Names by ID
+	Names []string
+
+	// Indexes into AllNames of the names to process in two-list mode (see
+	// AddQueries); nil processes every name
+	Queries []uint32
+
+	// Whether Load had to build PairToNames itself
+	PairIndexBuilt bool
+	// Whether words are compared case-insensitively; NameWords then holds
+	// the folded IDs (see Dictionary.Folded)
+	FoldCase bool
+	// Normalization the tokens went through before interning
+	Normalize Normalization
+	// Patterns of the words left out of names; nil for none
+	Mask *TokenMask
+
+	// Class and first letter of every word ID, filled by ClassifyTokens
+	Classes    []TokenClass
+	FirstRunes []rune
+
+	// Source of each match added after loading (see addMatchGroup), keyed
+	// by the two word IDs packed like a pair key
+	MatchSources map[uint64]string
+}
+
+// Load streams an input document straight into its interned form, so the
+// raw JSON maps never exist in memory alongside the processed ones. The
+// top-level keys may come in any order. When pair_to_names is missing or
+// empty it is built from the names (see BuildPairIndex). An input with a
+// query_names list is loaded in two-list mode, as if its query names were
+// passed to AddQueries.
+//
+// Word and name IDs follow the order of first appearance in the document,
+// and buckets keep their names in document order, so loading the same
+// document with the same options always gives the same Data.
+func Load(r io.Reader) (*Data, error) {
+	return LoadWithOptions(r, LoadOptions{})
+}
+
+// LoadOptions configures LoadWithOptions.
+type LoadOptions struct {
+	// Dictionary the input's word IDs refer to (see LoadWithDictionary);
+	// nil for an input that spells words out
+	Dict *Dictionary
+	// Compare words case-insensitively. Names keep their original case, so
+	// names differing only in case stay distinct and are output as given;
+	// word_to_matches entries and pair_to_names buckets whose keys differ
+	// only in case are merged.
+	FoldCase bool
+	// Normalization applied to every token before interning. As with
+	// FoldCase, entries and buckets whose keys collapse together are merged.
+	Normalize Normalization
+	// Words matching the mask are left out of names, and so never take part
+	// in pair keys or validation
+	Mask *TokenMask
+}
+
+// LoadWithOptions is Load with the given options.
+func LoadWithOptions(r io.Reader, opts LoadOptions) (*Data, error) {
+	return load(func(v InputVisitor) error { return StreamInput(r, v) }, opts)
+}
+
+// InputSection is one section of an input document given as its own file.
+type InputSection struct {
+	// all_names, word_to_matches, pair_to_names or query_names
+	Name string
+	R    io.Reader
+}
+
+// LoadSplit is LoadWithOptions for an input document some of whose sections
+// come as separate files, so a pipeline producing them at different stages
+// never has to merge them. The document r may be nil when every section is
+// given separately.
+func LoadSplit(r io.Reader, sections []InputSection, opts LoadOptions) (*Data, error) {
+	return load(func(v InputVisitor) error {
+		if r != nil {
+			if err := StreamInput(r, v); err != nil {
+				return err
+			}
+		}
+		for _, s := range sections {
+			if err := StreamSection(s.R, s.Name, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, opts)
+}
+
+// load interns the entries stream passes to its visitor.
+func load(stream func(InputVisitor) error, opts LoadOptions) (*Data, error) {
+	dict := opts.Dict
+	var refs *idInput
+	if dict != nil {
+		refs = &idInput{dict: dict, known: uint32(dict.Len())}
+	} else {
+		dict = NewDictionary()
+	}
+	if opts.FoldCase {
+		dict.FoldCase()
+	}
+
+	w2m := make(map[uint32][]uint32)
+	tradeouts := make(map[uint32][]uint32)
+	nameWords := make(map[string][]uint32)
+	nameIDs := make(map[string]uint32)
+	pairToNames := make(map[uint64][]uint32)
+	var allNames, names, queryNames []string
+	nameID := func(name string) uint32 {
+		id, ok := nameIDs[name]
+		if !ok {
+			id = uint32(len(names))
+			nameIDs[name] = id
+			names = append(names, name)
+		}
+		return id
+	}
+
+	merge := opts.FoldCase || opts.Normalize.enabled()
+	addWordMatches := func(kID uint32, matchIDs []uint32) {
+		if opts.FoldCase {
+			kID = dict.Folded(kID)
+			for i, m := range matchIDs {
+				matchIDs[i] = dict.Folded(m)
+			}
+		}
+		if prev, ok := w2m[kID]; ok && merge {
+			matchIDs = mergeIDs(prev, matchIDs)
+		}
+		w2m[kID] = matchIDs
+
+		// Logic: v if len(k) != 1 else set(k)
+		if len(dict.GetStr(kID)) != 1 {
+			// Use the slice we just created (read-only shared is fine)
+			tradeouts[kID] = matchIDs
+		} else {
+			tradeouts[kID] = []uint32{kID}
+		}
+	}
+	// Buckets whose keys come out the same are merged, which besides
+	// folding and normalization covers keys giving the words in either order
+	addPair := func(a, b uint32, ids []uint32) {
+		key := packPair(dict.Folded(a), dict.Folded(b))
+		if prev, ok := pairToNames[key]; ok {
+			ids = mergeIDs(prev, ids)
+		}
+		pairToNames[key] = ids
+	}
+	bucketIDs := func(bucket []string) []uint32 {
+		ids := make([]uint32, len(bucket))
+		for i, name := range bucket {
+			ids[i] = nameID(name)
+		}
+		return ids
+	}
+	// Keys of words holding underscores, which can be cut in more than one
+	// place, wait until every word is known (see splitPairKey)
+	var unsplit []unsplitPair
+
+	var idErr error
+	visitor := InputVisitor{
+		// Pre-tokenize all names so we don't do strings.Fields repeatedly
+		Name: func(name string) {
+			allNames = append(allNames, name)
+			if _, ok := nameWords[name]; ok {
+				return
+			}
+			nameID(name)
+			nameWords[name] = internName(name, dict, opts.Normalize, opts.Mask)
+		},
+		WordMatches: func(k string, v []string) {
+			if opts.Normalize.enabled() {
+				if k = opts.Normalize.Apply(k); k == "" {
+					return
+				}
+				normalized := make([]string, 0, len(v))
+				for _, m := range v {
+					if m = opts.Normalize.Apply(m); m != "" {
+						normalized = append(normalized, m)
+					}
+				}
+				v = normalized
+			}
+			// Convert match list to IDs
+			matchIDs := make([]uint32, len(v))
+			for i, m := range v {
+				matchIDs[i] = dict.GetID(m)
+			}
+			addWordMatches(dict.GetID(k), matchIDs)
+		},
+		PairNames: func(pair string, bucket []string) {
+			// Keys are "a_b"; a key without two words can never be looked up
+			if strings.Count(pair, "_") > 1 {
+				unsplit = append(unsplit, unsplitPair{pair, bucketIDs(bucket)})
+				return
+			}
+			a, b, ok := strings.Cut(pair, "_")
+			if opts.Normalize.enabled() {
+				a, b = opts.Normalize.Apply(a), opts.Normalize.Apply(b)
+			}
+			if !ok || a == "" || b == "" {
+				return
+			}
+			addPair(dict.GetID(a), dict.GetID(b), bucketIDs(bucket))
+		},
+		QueryName: func(name string) {
+			if queryNames == nil {
+				queryNames = []string{}
+			}
+			queryNames = append(queryNames, name)
+		},
+	}
+	if refs != nil {
+		visitor.WordMatches = nil
+		visitor.WordMatchIDs = func(k string, v []uint32) {
+			if idErr != nil {
+				return
+			}
+			kID, err := refs.parse(k)
+			for _, m := range v {
+				if err == nil {
+					err = refs.check(m)
+				}
+			}
+			if err != nil {
+				idErr = fmt.Errorf("word_to_matches: %w", err)
+				return
+			}
+			addWordMatches(kID, v)
+		}
+		visitor.PairNames = func(pair string, bucket []string) {
+			if idErr != nil {
+				return
+			}
+			a, b, err := refs.pairKey(pair)
+			if err != nil {
+				idErr = fmt.Errorf("pair_to_names: %w", err)
+				return
+			}
+			addPair(a, b, bucketIDs(bucket))
+		}
+	}
+	err := stream(visitor)
+	if err == nil {
+		err = idErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range unsplit {
+		for _, words := range splitPairKey(p.key, dict, opts.Normalize) {
+			addPair(words[0], words[1], p.ids)
+		}
+	}
+
+	data := &Data{
+		AllNames:      allNames,
+		NameWords:     nameWords,
+		WordToMatches: w2m,
+		TradeoutSets:  tradeouts,
+		PairToNames:   pairToNames,
+		Dict:          dict,
+		NameIDs:       nameIDs,
+		Names:         names,
+		FoldCase:      opts.FoldCase,
+		Normalize:     opts.Normalize,
+		Mask:          opts.Mask,
+	}
+	if len(pairToNames) == 0 {
+		data.BuildPairIndex(runtime.GOMAXPROCS(0))
+		data.PairIndexBuilt = true
+	}
+	if queryNames != nil {
+		data.AddQueries(queryNames)
+	}
+	return data, nil
+}
+
+// unsplitPair is a pair_to_names entry whose key has more than one
+// underscore.
+type unsplitPair struct {
+	key string
+	ids []uint32
+}
+
+// splitPairKey returns the word pairs a key of the form "a_b" can stand for
+// when a word may itself hold underscores ("de_la_cruz" is de + la_cruz or
+// de_la + cruz). The original lookups build the key string from the two
+// words, so a bucket is found through every cut whose halves are both words
+// of the input. Called once the whole input is interned, so only its words
+// count; a cut with a half no name or match list has can't be looked up.
+func splitPairKey(key string, dict *Dictionary, norm Normalization) [][2]uint32 {
+	var pairs [][2]uint32
+	for i := 0; i < len(key); i++ {
+		if key[i] != '_' {
+			continue
+		}
+		a, b := key[:i], key[i+1:]
+		if norm.enabled() {
+			a, b = norm.Apply(a), norm.Apply(b)
+		}
+		idA, okA := lookupWord(dict, a)
+		idB, okB := lookupWord(dict, b)
+		if okA && okB {
+			pairs = append(pairs, [2]uint32{idA, idB})
+		}
+	}
+	return pairs
+}
+
+// lookupWord returns the ID of an interned word, by its lowercase form too
+// when case folding is on.
+func lookupWord(dict *Dictionary, word string) (uint32, bool) {
+	if word == "" {
+		return 0, false
+	}
+	if id, ok := dict.Lookup(word); ok {
+		return id, true
+	}
+	if dict.fold {
+		return dict.Lookup(strings.ToLower(word))
+	}
+	return 0, false
+}
+
+// mergeIDs appends the IDs of add missing from list.
+func mergeIDs(list, add []uint32) []uint32 {
+	merged := append([]uint32(nil), list...)
+	seen := make(map[uint32]struct{}, len(list)+len(add))
+	for _, id := range list {
+		seen[id] = struct{}{}
+	}
+	for _, id := range add {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			merged = append(merged, id)
+		}
+	}
+	return merged
+}
+
+// InputVisitor receives the entries of an input document as they are decoded.
+// A nil callback means the section is read and discarded.
+type InputVisitor struct {
+	Name        func(name string)
+	WordMatches func(word string, matches []string)
+	PairNames   func(pair string, names []string)
+	// WordMatchIDs, when set, replaces WordMatches for documents whose
+	// match lists are arrays of word IDs
+	WordMatchIDs func(word string, matches []uint32)
+	QueryName    func(name string)
+}
+
+// StreamInput walks the top-level keys of an input document one entry at a
+// time, so callers never need the whole document in memory. Keys may appear
+// in any order and unknown keys are skipped. A bare array instead of a
+// document is taken as all_names. A malformed document is an InputError
+// naming the top-level key and the line the decoder had reached.
+func StreamInput(r io.Reader, v InputVisitor) error {
+	lines := &lineCounter{r: r}
+	br := bufio.NewReaderSize(lines, 1<<20)
+	if first, err := firstNonSpace(br); err == nil && first == '[' {
+		return StreamSection(br, "all_names", v)
+	}
+	dec := json.NewDecoder(br)
+	fail := func(key string, err error) error {
+		buffered, _ := br.Peek(br.Buffered())
+		return &InputError{Field: key, Line: lines.at(dec, buffered), Err: err}
+	}
+	if err := expectDelim(dec, '{'); err != nil {
+		return fail("", err)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return fail("", err)
+		}
+		key, _ := tok.(string)
+		if err := streamSection(dec, key, v); err != nil {
+			return fail(key, err)
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return fail("", err)
+	}
+	return nil
+}
+
+// StreamSection walks the value of one top-level key given on its own, such
+// as a file holding just the word_to_matches object, like StreamInput does
+// for a whole document.
+func StreamSection(r io.Reader, section string, v InputVisitor) error {
+	switch section {
+	case "all_names", "word_to_matches", "pair_to_names", "query_names":
+	default:
+		return &InputError{Err: fmt.Errorf("unknown input section %q", section)}
+	}
+	lines := &lineCounter{r: r}
+	dec := json.NewDecoder(lines)
+	if err := streamSection(dec, section, v); err != nil {
+		return &InputError{Field: section, Line: lines.at(dec, nil), Err: err}
+	}
+	return nil
+}
+
+// lineCounter counts the newlines read through it, to tell which line a
+// decoder reading from it had reached.
+type lineCounter struct {
+	r     io.Reader
+	lines int
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.lines += bytes.Count(p[:n], newline)
+	return n, err
+}
+
+var newline = []byte{'\n'}
+
+// at returns the 1-based line dec is at: the newlines read, less those
+// still waiting in dec's buffer and in buffered, read ahead between the
+// counter and dec.
+func (c *lineCounter) at(dec *json.Decoder, buffered []byte) int {
+	ahead, _ := io.ReadAll(dec.Buffered())
+	return 1 + c.lines - bytes.Count(ahead, newline) - bytes.Count(buffered, newline)
+}
+
+func streamSection(dec *json.Decoder, key string, v InputVisitor) error {
+	switch key {
+	case "all_names":
+		return streamStringArray(dec, v.Name)
+	case "word_to_matches":
+		if v.WordMatchIDs != nil {
+			return streamListMap(dec, v.WordMatchIDs)
+		}
+		return streamListMap(dec, v.WordMatches)
+	case "pair_to_names":
+		return streamListMap(dec, v.PairNames)
+	case "query_names":
+		return streamStringArray(dec, v.QueryName)
+	}
+	var skip json.RawMessage
+	return dec.Decode(&skip)
+}
+
+// firstNonSpace peeks at the first byte of r that isn't JSON whitespace.
+func firstNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\n', '\r':
+			continue
+		}
+		return b, r.UnreadByte()
+	}
+}
+
+func streamStringArray(dec *json.Decoder, fn func(string)) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return fmt.Errorf("expected array, got %v", tok)
+	}
+	for dec.More() {
+		var s string
+		if err := dec.Decode(&s); err != nil {
+			return err
+		}
+		if fn != nil {
+			fn(s)
+		}
+	}
+	return expectDelim(dec, ']')
+}
+
+func streamListMap[T any](dec *json.Decoder, fn func(string, []T)) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return fmt.Errorf("expected object, got %v", tok)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		var values []T
+		if err := dec.Decode(&values); err != nil {
+			return fmt.Errorf("%q: %w", key, err)
+		}
+		if fn != nil {
+			fn(key, values)
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("expected %q, got %v", want, tok)
+	}
+	return nil
+}
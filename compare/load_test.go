@@ -0,0 +1,225 @@
+package compare
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/metrics"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Names with words holding underscores, as the Python package writes
+// particles ("de_la") and joined tokens
+const underscoreNames = `"all_names": ["maria de_la cruz", "mary de_la cruz", "maria de_la crus", "maria de_la", "mary de_la", "ana x_y z", "anna x_y z", "ana x y_z"],
+	"word_to_matches": {
+		"maria": ["maria", "mary"], "mary": ["mary", "maria"],
+		"ana": ["ana", "anna"], "anna": ["anna", "ana"],
+		"de_la": ["de_la"], "cruz": ["cruz", "crus"], "crus": ["crus", "cruz"],
+		"x_y": ["x_y"], "x": ["x"], "y_z": ["y_z"], "z": ["z"]
+	}`
+
+// pythonPairIndex builds pair_to_names the way the Python package does: a
+// key "a_b" for every pair of words of a name, in string order.
+func pythonPairIndex(names []string) string {
+	buckets := make(map[string][]string)
+	var keys []string
+	for _, name := range names {
+		words := strings.Fields(name)
+		for i := range words {
+			for j := i + 1; j < len(words); j++ {
+				a, b := words[i], words[j]
+				if a > b {
+					a, b = b, a
+				}
+				key := a + "_" + b
+				if _, ok := buckets[key]; !ok {
+					keys = append(keys, key)
+				}
+				if !slices.Contains(buckets[key], name) {
+					buckets[key] = append(buckets[key], name)
+				}
+			}
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString(`"pair_to_names": {`)
+	for i, key := range keys {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(`"` + key + `": ["` + strings.Join(buckets[key], `", "`) + `"]`)
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// A supplied pair_to_names whose keys hold words with underscores must find
+// the same pairs as the index built from the names.
+func TestPairKeysWithUnderscores(t *testing.T) {
+	built := loadString(t, "{"+underscoreNames+"}")
+	want := runPairs(t, built, Options{})
+	if len(want) == 0 {
+		t.Fatal("no pairs from the built index")
+	}
+
+	supplied := loadString(t, "{"+underscoreNames+", "+pythonPairIndex(built.AllNames)+"}")
+	if supplied.PairIndexBuilt {
+		t.Fatal("pair_to_names was ignored")
+	}
+	if got := runPairs(t, supplied, Options{}); !slices.Equal(got, want) {
+		t.Errorf("supplied index: pairs %q, want %q", got, want)
+	}
+
+	// WriteInput spells the built index out; loading it back must not lose
+	// buckets whose keys spell the same
+	var buf bytes.Buffer
+	if err := built.WriteInput(&buf); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := loadString(t, buf.String())
+	if got := runPairs(t, reloaded, Options{}); !slices.Equal(got, want) {
+		t.Errorf("reloaded index: pairs %q, want %q", got, want)
+	}
+}
+
+func TestSplitPairKey(t *testing.T) {
+	dict := NewDictionary()
+	for _, w := range []string{"de", "de_la", "la_cruz", "cruz", "x"} {
+		dict.GetID(w)
+	}
+	for _, c := range []struct {
+		key  string
+		want []string
+	}{
+		{"de_la_cruz", []string{"de+la_cruz", "de_la+cruz"}},
+		{"x_de_la", []string{"x+de_la"}},
+		// No cut has two known words
+		{"la_la_la", nil},
+		{"_de_x", nil},
+	} {
+		var got []string
+		for _, p := range splitPairKey(c.key, dict, Normalization{}) {
+			got = append(got, dict.GetStr(p[0])+"+"+dict.GetStr(p[1]))
+		}
+		if !slices.Equal(got, c.want) {
+			t.Errorf("splitPairKey(%q) = %q, want %q", c.key, got, c.want)
+		}
+	}
+}
+
+// A bare array of names with word_to_matches in a file of its own finds the
+// pairs of the whole document, and a malformed section is reported by name.
+func TestLoadSplit(t *testing.T) {
+	const matches = `{"john": ["john", "jon"], "jon": ["jon", "john"], "smith": ["smith", "smyth"], "smyth": ["smyth", "smith"]}`
+	names := `["john smith", "jon smith", "john smyth", "mary jones"]`
+	want := runPairs(t, loadString(t, `{"all_names": `+names+`, "word_to_matches": `+matches+`}`), Options{})
+	if len(want) == 0 {
+		t.Fatal("no pairs from the whole document")
+	}
+	sections := []InputSection{{Name: "word_to_matches", R: strings.NewReader(matches)}}
+	data, err := LoadSplit(strings.NewReader(names), sections, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := runPairs(t, data, Options{}); !slices.Equal(got, want) {
+		t.Errorf("pairs %q, want %q", got, want)
+	}
+
+	sections = []InputSection{{Name: "pair_to_names", R: strings.NewReader("{\n\"john_smith\": 5}")}}
+	_, err = LoadSplit(strings.NewReader(names), sections, LoadOptions{})
+	var input *InputError
+	if !errors.As(err, &input) || input.Field != "pair_to_names" || input.Line != 2 {
+		t.Errorf("a malformed section: %v, want an InputError in pair_to_names on line 2", err)
+	}
+}
+
+// syntheticInput returns an input document of n names, each word matching
+// a few spelling variants, with pair_to_names spelled out.
+func syntheticInput(n int) []byte {
+	var names, words, pairs []string
+	for i := range n {
+		given, family := fmt.Sprintf("given%d", i%5000), fmt.Sprintf("family%d", i)
+		names = append(names, `"`+given+" "+family+`"`)
+		words = append(words, fmt.Sprintf(`%q: [%q, "%sa", "%sb"]`, family, family, family, family))
+		pairs = append(pairs, fmt.Sprintf(`"%s_%s": ["%s %s"]`, family, given, given, family))
+	}
+	for i := range 5000 {
+		words = append(words, fmt.Sprintf(`"given%d": ["given%d", "given%d"]`, i, i, (i+1)%5000))
+	}
+	doc := `{"all_names": [` + strings.Join(names, ", ") + `], "word_to_matches": {` + strings.Join(words, ", ") +
+		`}, "pair_to_names": {` + strings.Join(pairs, ", ") + `}}`
+	return []byte(doc)
+}
+
+// liveHeap returns the bytes of heap objects, reachable or not yet swept.
+func liveHeap() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+// peakHeap samples liveHeap until stop is closed and sends the highest
+// value it saw.
+func peakHeap(stop <-chan struct{}, peak chan<- uint64) {
+	var highest uint64
+	for {
+		highest = max(highest, liveHeap())
+		select {
+		case <-stop:
+			peak <- highest
+			return
+		case <-time.After(100 * time.Microsecond):
+		}
+	}
+}
+
+// BenchmarkLoad compares the peak heap of streaming the input into Data
+// with decoding the whole document first, as loading used to: the decoded
+// maps and the interned Data are alive together.
+func BenchmarkLoad(b *testing.B) {
+	doc := syntheticInput(100_000)
+	for _, c := range []struct {
+		name string
+		load func() (any, error)
+	}{
+		{"stream", func() (any, error) { return Load(bytes.NewReader(doc)) }},
+		{"decode", func() (any, error) {
+			var raw struct {
+				AllNames      []string            `json:"all_names"`
+				WordToMatches map[string][]string `json:"word_to_matches"`
+				PairToNames   map[string][]string `json:"pair_to_names"`
+			}
+			if err := json.Unmarshal(doc, &raw); err != nil {
+				return nil, err
+			}
+			data, err := Load(bytes.NewReader(doc))
+			runtime.KeepAlive(&raw)
+			return data, err
+		}},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var highest uint64
+			for b.Loop() {
+				runtime.GC()
+				before := liveHeap()
+				stop, peak := make(chan struct{}), make(chan uint64)
+				go peakHeap(stop, peak)
+				data, err := c.load()
+				if err != nil {
+					b.Fatal(err)
+				}
+				close(stop)
+				p := <-peak
+				highest = max(highest, p-min(p, before))
+				runtime.KeepAlive(data)
+			}
+			b.ReportMetric(float64(highest), "peak-heap-B")
+		})
+	}
+}
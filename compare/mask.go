@@ -0,0 +1,108 @@
+package compare
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// --- TOKEN MASKS ---
+// Noise tokens such as case numbers or bracketed annotations are too varied
+// for a word list, so they are described by regular expressions and dropped
+// from names before matching. A word is tested once, when it is first seen
+// in a name, so the cost is bounded by the vocabulary, not the corpus.
+
+// TokenMask drops the words matching any of its patterns.
+type TokenMask struct {
+	patterns []*regexp.Regexp
+	// Words dropped per pattern; a word matching several patterns counts
+	// toward the first
+	dropped []int
+	// Per dictionary ID: 0 not yet tested, 1 kept, else 2 + pattern index
+	verdicts []int32
+}
+
+// ParseTokenMask reads one regular expression per line. Blank lines and
+// lines starting with # are skipped. Patterns are unanchored, so a pattern
+// meant to match whole words needs ^ and $.
+func ParseTokenMask(r io.Reader) (*TokenMask, error) {
+	m := &TokenMask{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		re, err := regexp.Compile(text)
+		if err != nil {
+			return nil, &InputError{Line: line, Err: err}
+		}
+		m.patterns = append(m.patterns, re)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	m.dropped = make([]int, len(m.patterns))
+	return m, nil
+}
+
+// MaskCount is the number of words a pattern dropped.
+type MaskCount struct {
+	Pattern string
+	Words   int
+}
+
+// Counts reports how many words of names each pattern dropped so far.
+func (m *TokenMask) Counts() []MaskCount {
+	counts := make([]MaskCount, len(m.patterns))
+	for i, re := range m.patterns {
+		counts[i] = MaskCount{Pattern: re.String(), Words: m.dropped[i]}
+	}
+	return counts
+}
+
+// pattern returns the index of the first pattern matching word, or -1.
+func (m *TokenMask) pattern(word string) int {
+	for i, re := range m.patterns {
+		if re.MatchString(word) {
+			return i
+		}
+	}
+	return -1
+}
+
+// drop reports whether the word with the given ID is masked, counting it
+// if so. It caches its verdicts, so it must not be called concurrently.
+func (m *TokenMask) drop(dict *Dictionary, id uint32) bool {
+	for int(id) >= len(m.verdicts) {
+		m.verdicts = append(m.verdicts, 0)
+	}
+	if m.verdicts[id] == 0 {
+		m.verdicts[id] = 1
+		if p := m.pattern(dict.GetStr(id)); p >= 0 {
+			m.verdicts[id] = int32(2 + p)
+		}
+	}
+	if m.verdicts[id] == 1 {
+		return false
+	}
+	m.dropped[m.verdicts[id]-2]++
+	return true
+}
+
+// internName splits a name into words (see splitName), interns them and
+// returns their IDs, folded when the dictionary folds case. Words masked
+// by mask, which may be nil, are left out.
+func internName(name string, dict *Dictionary, n Normalization, mask *TokenMask) []uint32 {
+	words := splitName(name, n)
+	ids := make([]uint32, 0, len(words))
+	for _, w := range words {
+		id := dict.GetID(w)
+		if mask != nil && mask.drop(dict, id) {
+			continue
+		}
+		ids = append(ids, dict.Folded(id))
+	}
+	return ids
+}
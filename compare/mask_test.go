@@ -0,0 +1,111 @@
+package compare
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const noisePatterns = `# case numbers, then any word with a digit
+^[a-z]\d[a-z]\d
+\d
+
+^\W+$
+^\[.*\]$
+`
+
+func loadMasked(t *testing.T, doc string) *Data {
+	t.Helper()
+	mask, err := ParseTokenMask(strings.NewReader(noisePatterns))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := LoadWithOptions(strings.NewReader(doc), LoadOptions{Mask: mask})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseTokenMask(t *testing.T) {
+	_, err := ParseTokenMask(strings.NewReader("^x$\n\n# note\n[a-\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 4:") {
+		t.Errorf("bad pattern: %v", err)
+	}
+}
+
+const noisyInput = `{
+	"all_names": ["john smith a1b2c3", "jon smith x9", "jon smith [dup]", "john -- smith", "a1b2c3 smith", "[note] --"],
+	"word_to_matches": {"john": ["john", "jon"], "jon": ["jon", "john"], "smith": ["smith"]}
+}`
+
+// A word matching several patterns counts toward the first, and names the
+// masks leave with fewer than two words find no pairs.
+func TestMaskTokens(t *testing.T) {
+	data := loadMasked(t, noisyInput)
+	want := []MaskCount{{`^[a-z]\d[a-z]\d`, 2}, {`\d`, 1}, {`^\W+$`, 2}, {`^\[.*\]$`, 2}}
+	if got := data.Mask.Counts(); !slices.Equal(got, want) {
+		t.Errorf("counts %v, want %v", got, want)
+	}
+	for name, words := range map[string]int{"john smith a1b2c3": 2, "john -- smith": 2, "a1b2c3 smith": 1, "[note] --": 0} {
+		if got := len(data.NameWords[name]); got != words {
+			t.Errorf("%q: %d words, want %d", name, got, words)
+		}
+	}
+	wantPairs := []string{
+		"john -- smith|john smith a1b2c3",
+		"john -- smith|jon smith [dup]",
+		"john -- smith|jon smith x9",
+		"john smith a1b2c3|jon smith [dup]",
+		"john smith a1b2c3|jon smith x9",
+		"jon smith [dup]|jon smith x9",
+	}
+	if got := runPairs(t, data, Options{}); !slices.Equal(got, wantPairs) {
+		t.Errorf("pairs %q, want %q", got, wantPairs)
+	}
+}
+
+// Masked words are dropped alike from the corpus, from query names added
+// to it and from names checked one pair at a time.
+func TestMaskQueryConsistency(t *testing.T) {
+	batch := loadMasked(t, noisyInput)
+
+	query := loadMasked(t, strings.Replace(noisyInput, `"word_to_matches"`,
+		`"query_names": ["john smith a1b2c3", "jon smith z7q8 [new]"], "word_to_matches"`, 1))
+	if got, want := query.NameWords["jon smith z7q8 [new]"], query.NameWords["jon smith x9"]; !slices.Equal(got, want) {
+		t.Errorf("query name words %v, corpus name %v", got, want)
+	}
+	// Pairs with a query name on a side: those of the query name already in
+	// the corpus, and the new one's pairs, like the corpus name it masks to
+	var want []string
+	for _, p := range runPairs(t, batch, Options{}) {
+		if strings.Contains(p, "john smith a1b2c3") {
+			want = append(want, p)
+		}
+	}
+	want = append(want,
+		"john -- smith|jon smith z7q8 [new]",
+		"john smith a1b2c3|jon smith z7q8 [new]",
+		"jon smith [dup]|jon smith z7q8 [new]",
+		"jon smith x9|jon smith z7q8 [new]",
+	)
+	slices.Sort(want)
+	if got := runPairs(t, query, Options{}); !slices.Equal(got, want) {
+		t.Errorf("query pairs %q, want %q", got, want)
+	}
+
+	m := NewMatcher(batch, Options{})
+	for _, c := range []struct {
+		a, b string
+		ok   bool
+	}{
+		{"john smith a1b2c3", "jon smith x9", true},
+		// Not in the input: tokenized on the fly through the same masks
+		{"john q7r8 smith", "jon smith (b4)", true},
+		{"a1b2c3 smith", "john smith", false},
+	} {
+		if ok, _ := m.Validate(c.a, c.b); ok != c.ok {
+			t.Errorf("Validate(%q, %q) = %v, want %v", c.a, c.b, ok, c.ok)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package compare
+
+// --- MATCH BUFFERS ---
+// Each validation phase marks the words the other name's matches cover, by
+// word ID. By default a word is marked by storing the phase's generation in
+// it, so a new phase needs no clearing at all, at 8 bytes per dictionary word
+// and worker. On large dictionaries that adds up, so Options.BitsetBuffer
+// keeps one bit per word instead (64 times smaller). Every phase then
+// clears what it marked by zeroing the touched words of the bitset, which
+// costs about as much again as marking.
+
+// matchBuffer marks word IDs for one validation phase at a time. Each
+// worker has its own.
+type matchBuffer struct {
+	// Generation per word ID; a word is marked when it holds gen
+	gens []uint64
+	gen  uint64
+	// With Options.BitsetBuffer, one bit per word ID instead of gens, all
+	// zero between phases
+	bits []uint64
+}
+
+func newMatchBuffer(words int, bitset bool) *matchBuffer {
+	if bitset {
+		return &matchBuffer{bits: make([]uint64, (words+63)/64)}
+	}
+	return &matchBuffer{gens: make([]uint64, words)}
+}
+
+// matchBuffer returns a buffer for the Matcher's dictionary.
+func (m *Matcher) matchBuffer() *matchBuffer {
+	return newMatchBuffer(m.data.Dict.Len(), m.opts.BitsetBuffer)
+}
+
+// words returns how many word IDs the buffer covers.
+func (b *matchBuffer) words() int {
+	if b.bits != nil {
+		return len(b.bits) * 64
+	}
+	return len(b.gens)
+}
+
+// nextPhase starts a phase with no word marked. With a bitset the previous
+// phase must have been cleared (see matchSets.unpaint).
+func (b *matchBuffer) nextPhase() {
+	b.gen++
+}
+
+func (b *matchBuffer) mark(id uint32) {
+	if b.bits != nil {
+		// Bounds check to be safe, though dictSize should cover it
+		if w := int(id >> 6); w < len(b.bits) {
+			b.bits[w] |= 1 << (id & 63)
+		}
+		return
+	}
+	if int(id) < len(b.gens) {
+		b.gens[id] = b.gen
+	}
+}
+
+func (b *matchBuffer) marked(id uint32) bool {
+	if b.bits != nil {
+		w := int(id >> 6)
+		return w < len(b.bits) && b.bits[w]&(1<<(id&63)) != 0
+	}
+	return int(id) < len(b.gens) && b.gens[id] == b.gen
+}
+
+// unmark clears the bitset word holding id, which may hold other marks of
+// the same phase; a generation buffer needs no clearing.
+func (b *matchBuffer) unmark(id uint32) {
+	if w := int(id >> 6); w < len(b.bits) {
+		b.bits[w] = 0
+	}
+}
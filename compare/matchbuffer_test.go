@@ -0,0 +1,25 @@
+package compare
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// A bitset buffer finds the same pairs with the same scores as the
+// generation buffer, though it has to clear itself after every phase.
+func TestBitsetBufferEquivalence(t *testing.T) {
+	for seed := range uint64(4) {
+		data, err := Load(strings.NewReader(randomInput(seed, 300, seed%2 == 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, workers := range []int{1, 4} {
+			want := runOutput(t, data, Options{Workers: workers})
+			got := runOutput(t, data, Options{Workers: workers, BitsetBuffer: true})
+			if !slices.Equal(got, want) {
+				t.Errorf("seed %d, %d workers: %d pairs with a bitset, %d without", seed, workers, len(got), len(want))
+			}
+		}
+	}
+}
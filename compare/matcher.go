@@ -0,0 +1,560 @@
+package compare
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Pair is one validated match. A sorts before B.
+type Pair struct {
+	A, B string
+	// Tag is "confirmed" or "unsure" for pairs with a review state, else empty
+	Tag string
+	// Score is the fraction of matched words averaged over both names (see
+	// MatchConfig.MinScore). It depends only on the two names.
+	Score float64
+	// The word and mismatch counts behind Score
+	Counts WordCounts
+	// Worker is the index of the worker that found the pair, for callers
+	// that keep per-worker output
+	Worker int
+}
+
+// Options configures a Matcher. The zero value is usable.
+type Options struct {
+	// Number of worker goroutines; 0 means runtime.GOMAXPROCS(0)
+	Workers int
+	// Most names handed to a worker at once (see batches); 0 means
+	// DefaultJobBatch
+	JobBatch int
+	// Optional reviewer decisions applied to every candidate pair
+	Review *ReviewStates
+	// Matching policy per token class (see ClassPolicies). The zero value
+	// matches every token through word_to_matches only.
+	ClassPolicies ClassPolicies
+	// Validation thresholds; nil means DefaultMatchConfig()
+	Match *MatchConfig
+	// Skip reports whether the name at AllNames[idx] should not be processed
+	// (e.g. because a previous run already completed it).
+	Skip func(idx int) bool
+	// OnNameDone is called by a worker after every pair for AllNames[idx]
+	// has been emitted. An error stops the run and is returned by Run.
+	OnNameDone func(worker, idx int) error
+	// OnWorkerDone is called by a worker once it has no names left, while
+	// the other workers may still be running. An error stops the run and is
+	// returned by Run.
+	OnWorkerDone func(worker int) error
+	// Pairs that are never emitted, e.g. those of a previous output
+	Exclude *PairSet
+	// In two-list mode (see Data.AddQueries), leave out pairs of two query
+	// names so only query-vs-reference pairs are emitted
+	SkipQueryPairs bool
+	// Upper bound on candidate pairs validated over the whole run, shared
+	// out across names by their cost estimates (see evaluationBudget); 0
+	// means no limit
+	MaxEvaluations uint64
+	// Process names with the same words once (see collapseDuplicates)
+	CollapseDuplicates bool
+	// Validate each pair from only one of its names when every name is
+	// found back by its candidates (see symmetricCandidates)
+	SkipSymmetric bool
+	// Mark matches in a bitset per worker instead of a generation buffer
+	// (see matchBuffer): 64 times less memory, for about twice the marking
+	BitsetBuffer bool
+}
+
+// Matcher runs the all-to-all comparison over a loaded Data.
+type Matcher struct {
+	data      *Data
+	opts      Options
+	rules     *classRules
+	matches   *matchSets
+	processed uint64
+	// Names Run has to process, duplicates included (see InterruptedError)
+	toProcess uint64
+	// Candidate pairs validated so far
+	evaluations uint64
+	// Pairs emitted so far
+	pairs uint64
+	// Per worker, set by Run (see Diagnosis)
+	stats []runStats
+	// Set while Run enforces Options.MaxEvaluations
+	budget *evaluationBudget
+	// Query flag per name ID, set in two-list mode
+	isQuery []bool
+	// Reference names per query name ID whose candidates include the query
+	// name (see reverseCandidates), set by Run in two-list mode
+	reverse map[uint32][]uint32
+	// Names left to the first name of order with the same words, by that
+	// name's AllNames index (see collapseDuplicates)
+	duplicates map[uint32][]uint32
+	collapsed  int
+	// Name ID each name's pairs are validated from, with SkipSymmetric
+	// when candidates are symmetric; nil otherwise
+	owner []uint32
+
+	// Scratch space for Validate
+	queryBuffer *matchBuffer
+}
+
+func NewMatcher(data *Data, opts Options) *Matcher {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.GOMAXPROCS(0)
+	}
+	if opts.JobBatch <= 0 {
+		opts.JobBatch = DefaultJobBatch
+	}
+	if opts.Match == nil {
+		cfg := DefaultMatchConfig()
+		opts.Match = &cfg
+	}
+	if opts.Exclude != nil {
+		opts.Exclude.compact()
+	}
+	m := &Matcher{data: data, opts: opts, rules: newClassRules(data, opts.ClassPolicies), matches: newMatchSets(data.WordToMatches)}
+	if data.Queries != nil {
+		m.isQuery = data.isQuery()
+	}
+	return m
+}
+
+// Pairs returns how many pairs have been emitted so far. A pair found from
+// both of its names by different workers counts twice. It is safe to call
+// while Run is in progress.
+func (m *Matcher) Pairs() uint64 {
+	return atomic.LoadUint64(&m.pairs)
+}
+
+// Workers returns the number of worker goroutines Run uses.
+func (m *Matcher) Workers() int {
+	return m.opts.Workers
+}
+
+// Processed returns how many names have been taken up by workers so far.
+// In two-list mode only query names are taken up.
+// It is safe to call while Run is in progress.
+func (m *Matcher) Processed() uint64 {
+	return atomic.LoadUint64(&m.processed)
+}
+
+// Run compares every name against its candidates and calls emit for each
+// validated pair. emit is called concurrently from the worker goroutines
+// (see Pair.Worker). Confirmed review pairs are emitted first, from the
+// calling goroutine. Run stops early when ctx is cancelled and then returns
+// an *InterruptedError wrapping ctx.Err().
+func (m *Matcher) Run(ctx context.Context, emit func(Pair)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if m.opts.Review != nil {
+		m.emitConfirmed(emit)
+	}
+
+	var firstErr error
+	var errOnce sync.Once
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	if m.isQuery != nil {
+		m.reverse = m.reverseCandidates()
+	}
+	order, costs := m.schedule()
+	if m.opts.MaxEvaluations > 0 {
+		m.budget = newEvaluationBudget(m.opts.MaxEvaluations, costs, order)
+	}
+
+	m.stats = make([]runStats, m.opts.Workers)
+	batches := m.batches(order, costs)
+	jobs := make(chan []uint32, max(1, 1000/m.opts.JobBatch))
+	var wg sync.WaitGroup
+	for i := 0; i < m.opts.Workers; i++ {
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			err := m.processBatch(ctx, workerID, jobs, costs, emit)
+			if err == nil && m.opts.OnWorkerDone != nil {
+				err = m.opts.OnWorkerDone(workerID)
+			}
+			if err != nil {
+				fail(err)
+			}
+		}(i)
+	}
+
+feed:
+	for _, batch := range batches {
+		select {
+		case jobs <- batch:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return &InterruptedError{
+			Processed: m.Processed(),
+			Total:     m.toProcess,
+			Resumable: m.opts.OnNameDone != nil,
+			Err:       err,
+		}
+	}
+	return nil
+}
+
+// schedule returns the names to process, most expensive first. A name's
+// cost is estimated as the total size of the buckets its pair keys look up.
+// Handing out heavy names early means the tail of the run is made of cheap
+// ones, instead of one heavy name pinning a worker while the others sit
+// idle. Each name still runs on a single worker, so its output and
+// OnNameDone stay together. Ties keep input order. The estimates are
+// returned indexed like AllNames.
+func (m *Matcher) schedule() ([]uint32, []uint64) {
+	data := m.data
+	var order []uint32
+	add := func(i uint32) {
+		if m.opts.Skip == nil || !m.opts.Skip(int(i)) {
+			order = append(order, i)
+		}
+	}
+	if data.Queries != nil {
+		for _, i := range data.Queries {
+			add(i)
+		}
+	} else {
+		for i := range data.AllNames {
+			add(uint32(i))
+		}
+	}
+	m.toProcess = uint64(len(order))
+	order = m.collapseDuplicates(order)
+	if m.opts.SkipSymmetric && m.symmetricCandidates() {
+		m.owner = m.owners()
+	}
+
+	costs := make([]uint64, len(data.AllNames))
+	chunk := (len(order) + m.opts.Workers - 1) / m.opts.Workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(order); start += chunk {
+		end := min(start+chunk, len(order))
+		wg.Add(1)
+		go func(part []uint32) {
+			defer wg.Done()
+			var scratch pairScratch
+			for _, idx := range part {
+				parts := data.NameWords[data.AllNames[idx]]
+				if len(parts) < 2 {
+					continue
+				}
+				var cost uint64
+				for _, key := range m.pairKeys(parts, &scratch) {
+					cost += uint64(data.bucketLen(key))
+				}
+				costs[idx] = cost + uint64(len(m.reverse[data.NameIDs[data.AllNames[idx]]]))
+			}
+		}(order[start:end])
+	}
+	wg.Wait()
+
+	sort.SliceStable(order, func(a, b int) bool { return costs[order[a]] > costs[order[b]] })
+	return order, costs
+}
+
+// DefaultJobBatch is how many names a worker takes at most at once by
+// default.
+const DefaultJobBatch = 64
+
+// batches splits order into the slices handed to workers. Taking one name
+// per channel operation shows up at tens of millions of names, so workers
+// take up to Options.JobBatch at a time. A batch doesn't cost more than the
+// most expensive name, though: the heavy names at the front still go out
+// one by one and spread over the workers, and only cheap ones are grouped.
+func (m *Matcher) batches(order []uint32, costs []uint64) [][]uint32 {
+	if len(order) == 0 {
+		return nil
+	}
+	maxCost := costs[order[0]]
+	batches := make([][]uint32, 0, len(order)/m.opts.JobBatch+1)
+	for start := 0; start < len(order); {
+		end, cost := start+1, costs[order[start]]
+		for end < len(order) && end-start < m.opts.JobBatch && cost+costs[order[end]] <= maxCost {
+			cost += costs[order[end]]
+			end++
+		}
+		batches = append(batches, order[start:end])
+		start = end
+	}
+	return batches
+}
+
+// emitConfirmed emits every confirmed review pair. They bypass validation
+// entirely, so the workers skip them.
+func (m *Matcher) emitConfirmed(emit func(Pair)) {
+	names := m.data.Names
+	// Confirmed pairs aren't validated, but still get their score
+	buffer := m.matchBuffer()
+
+	keys := make([]uint64, 0, len(m.opts.Review.states))
+	for key, state := range m.opts.Review.states {
+		if state == reviewConfirmed {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, key := range keys {
+		a, b := unpackPair(key)
+		if m.isQuery != nil && !m.wantsQueryPair(a, b) {
+			continue
+		}
+		if m.opts.Exclude != nil && m.opts.Exclude.contains(a, b) {
+			continue
+		}
+		n1, n2 := names[a], names[b]
+		if n1 > n2 {
+			n1, n2 = n2, n1
+		}
+		_, counts := validateOptimized(m.data.NameWords[n1], m.data.NameWords[n2], m.matches, m.data.Dict, buffer, m.rules, m.opts.Match, nil)
+		atomic.AddUint64(&m.pairs, 1)
+		emit(Pair{A: n1, B: n2, Tag: "confirmed", Score: counts.Score(), Counts: counts})
+	}
+}
+
+// wantsQueryPair reports whether a two-list run emits the pair of the given
+// name IDs.
+func (m *Matcher) wantsQueryPair(a, b uint32) bool {
+	if m.opts.SkipQueryPairs {
+		return m.isQuery[a] != m.isQuery[b]
+	}
+	return m.isQuery[a] || m.isQuery[b]
+}
+
+func (m *Matcher) processBatch(
+	ctx context.Context,
+	id int,
+	jobs <-chan []uint32,
+	costs []uint64,
+	emit func(Pair),
+) error {
+	// Pass the dictionary size to pre-allocate buffers
+	matchesBuffer := m.matchBuffer()
+
+	// Other names already validated against the current name
+	seenMatches := make(map[uint32]struct{})
+	var scratch pairScratch
+
+	for batch := range jobs {
+		if err := m.processNames(ctx, id, batch, costs, matchesBuffer, seenMatches, &scratch, emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processNames matches the names of one batch.
+func (m *Matcher) processNames(
+	ctx context.Context,
+	id int,
+	batch []uint32,
+	costs []uint64,
+	matchesBuffer *matchBuffer,
+	seenMatches map[uint32]struct{},
+	scratch *pairScratch,
+	emit func(Pair),
+) error {
+	data := m.data
+	for _, idx := range batch {
+		if ctx.Err() != nil {
+			return nil
+		}
+		duplicates := m.duplicates[idx]
+		atomic.AddUint64(&m.processed, uint64(1+len(duplicates)))
+		name := data.AllNames[idx]
+
+		namePartsIDs := data.NameWords[name]
+		if len(namePartsIDs) >= 2 {
+			clear(seenMatches)
+			limit := int64(-1)
+			if m.budget != nil {
+				limit = int64(m.budget.reserve(costs[idx]))
+			}
+			evaluated, truncated := m.matchName(name, data.NameIDs[name], namePartsIDs, duplicates, matchesBuffer, seenMatches, scratch, id, limit, emit)
+			atomic.AddUint64(&m.evaluations, uint64(evaluated))
+			if m.budget != nil {
+				m.budget.release(int(idx), uint64(limit-evaluated), truncated)
+			}
+		}
+
+		if m.opts.OnNameDone != nil {
+			if err := m.opts.OnNameDone(id, int(idx)); err != nil {
+				return err
+			}
+			for _, dup := range duplicates {
+				if err := m.opts.OnNameDone(id, int(dup)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// pairKeys returns the pair keys a name looks up (see
+// buildExpandedPairMappings), leaving out the words of ignored classes:
+// they don't count in validation, so they don't find candidates either.
+func (m *Matcher) pairKeys(parts []uint32, scratch *pairScratch) []uint64 {
+	if m.rules != nil {
+		parts = m.rules.keyWords(parts, scratch)
+	}
+	return buildExpandedPairMappings(parts, m.data.TradeoutSets, scratch)
+}
+
+// matchName validates the candidates of one name, stopping once limit of
+// them have been validated; a negative limit means no limit. Validated
+// pairs are also emitted for the name's duplicates. A candidate found
+// through several pair keys is validated once: validation only depends on
+// the two names, so seenMatches records every candidate tried. What the
+// lookups and validations find is counted in the worker's runStats.
+func (m *Matcher) matchName(
+	name string,
+	nameID uint32,
+	namePartsIDs []uint32,
+	duplicates []uint32,
+	matchesBuffer *matchBuffer,
+	seenMatches map[uint32]struct{},
+	scratch *pairScratch,
+	worker int,
+	limit int64,
+	emit func(Pair),
+) (evaluated int64, truncated bool) {
+	data := m.data
+	stats := &m.stats[worker]
+	pairs := m.pairKeys(namePartsIDs, scratch)
+	stats.lookups += uint64(len(pairs))
+	candidates := stats.candidates
+	defer func() {
+		if stats.candidates > candidates {
+			stats.namesWithCandidates++
+		}
+	}()
+
+	for i := 0; i <= len(pairs); i++ {
+		var others []uint32
+		if i < len(pairs) {
+			var ok bool
+			if others, ok = data.bucket(pairs[i]); ok {
+				stats.hits++
+			}
+		} else if m.reverse != nil {
+			others = m.reverse[nameID]
+		}
+
+		for _, other := range others {
+			if other == nameID {
+				continue
+			}
+			stats.candidates++
+			if _, seen := seenMatches[other]; seen {
+				continue
+			}
+			// The other name's job validates the pair
+			if m.owner != nil && m.owner[other] < m.owner[nameID] {
+				continue
+			}
+			// A pair the name doesn't want may still be wanted by a
+			// duplicate
+			tag, wanted := m.pairTag(nameID, other)
+			if !wanted && len(duplicates) == 0 {
+				continue
+			}
+
+			n1, n2 := name, data.Names[other]
+			if n1 > n2 {
+				n1, n2 = n2, n1
+			}
+
+			if evaluated == limit {
+				return evaluated, true
+			}
+			evaluated++
+			seenMatches[other] = struct{}{}
+
+			ids1 := data.NameWords[n1]
+			ids2 := data.NameWords[n2]
+
+			ok, counts := validateOptimized(ids1, ids2, m.matches, data.Dict, matchesBuffer, m.rules, m.opts.Match, &stats.trace)
+			if !ok {
+				stats.reject()
+				continue
+			}
+			stats.passed++
+			if wanted {
+				atomic.AddUint64(&m.pairs, 1)
+				emit(Pair{A: n1, B: n2, Tag: tag, Score: counts.Score(), Counts: counts, Worker: worker})
+			}
+			m.emitDuplicates(duplicates, name, other, counts, worker, emit)
+		}
+	}
+	return evaluated, false
+}
+
+// pairTag returns the tag of the pair of nameID and other, or false if the
+// pair is never emitted from the workers: a pair of two query names with
+// SkipQueryPairs, an excluded pair, or a rejected or confirmed one.
+func (m *Matcher) pairTag(nameID, other uint32) (string, bool) {
+	if m.opts.SkipQueryPairs && m.isQuery != nil && m.isQuery[other] {
+		return "", false
+	}
+	if m.opts.Exclude != nil && m.opts.Exclude.contains(nameID, other) {
+		return "", false
+	}
+	if m.opts.Review != nil {
+		switch m.opts.Review.lookup(nameID, other) {
+		case reviewRejected, reviewConfirmed:
+			// Rejected pairs are never emitted and confirmed ones were
+			// already emitted before the workers started
+			return "", false
+		case reviewUnsure:
+			return "unsure", true
+		}
+	}
+	return "", true
+}
+
+// emitDuplicates emits the pair of other with each of name's duplicates,
+// which validates like name's pair with other, counted as counts.
+func (m *Matcher) emitDuplicates(duplicates []uint32, name string, other uint32, counts WordCounts, worker int, emit func(Pair)) {
+	data := m.data
+	otherName := data.Names[other]
+	for _, dup := range duplicates {
+		dupName := data.AllNames[dup]
+		dupID := data.NameIDs[dupName]
+		if dupID == other {
+			continue
+		}
+		tag, ok := m.pairTag(dupID, other)
+		if !ok {
+			continue
+		}
+		c := counts
+		if (dupName < otherName) != (name < otherName) {
+			c = c.swapped()
+		}
+		a, b := dupName, otherName
+		if a > b {
+			a, b = b, a
+		}
+		atomic.AddUint64(&m.pairs, 1)
+		emit(Pair{A: a, B: b, Tag: tag, Score: c.Score(), Counts: c, Worker: worker})
+	}
+}
@@ -0,0 +1,267 @@
+package compare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A small input where john/jon and smith/smyth match each other
+const smallInput = `{
+	"all_names": ["john smith", "jon smith", "john smyth", "mary jones", "mary smith", "john smith"],
+	"word_to_matches": {
+		"john": ["john", "jon"],
+		"jon": ["jon", "john"],
+		"smith": ["smith", "smyth"],
+		"smyth": ["smyth", "smith"],
+		"mary": ["mary"],
+		"jones": ["jones"]
+	}
+}`
+
+func loadString(t testing.TB, doc string) *Data {
+	t.Helper()
+	data, err := Load(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// runPairs runs a Matcher and returns its distinct pairs as "a|b", sorted.
+func runPairs(t testing.TB, data *Data, opts Options) []string {
+	t.Helper()
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	err := NewMatcher(data, opts).Run(context.Background(), func(p Pair) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[p.A+"|"+p.B] = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs := make([]string, 0, len(seen))
+	for pair := range seen {
+		pairs = append(pairs, pair)
+	}
+	slices.Sort(pairs)
+	return pairs
+}
+
+func TestRunInMemory(t *testing.T) {
+	want := []string{
+		"john smith|john smyth",
+		"john smith|jon smith",
+		"john smyth|jon smith",
+	}
+	for _, workers := range []int{1, 4} {
+		got := runPairs(t, loadString(t, smallInput), Options{Workers: workers})
+		if !slices.Equal(got, want) {
+			t.Errorf("%d workers: pairs %q, want %q", workers, got, want)
+		}
+	}
+}
+
+func TestRunPairScores(t *testing.T) {
+	data := loadString(t, smallInput)
+	err := NewMatcher(data, Options{Workers: 1}).Run(context.Background(), func(p Pair) {
+		if p.A >= p.B {
+			t.Errorf("pair %q, %q not in order", p.A, p.B)
+		}
+		if p.Score != 1 || p.Counts.WordsA != 2 || p.Counts.MismatchesA != 0 {
+			t.Errorf("pair %q, %q: score %v, counts %+v", p.A, p.B, p.Score, p.Counts)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Names sharing three pair keys are validated once from each side, whether
+// or not they match.
+func TestRunValidatesCandidateOnce(t *testing.T) {
+	for _, doc := range []string{
+		`{"all_names": ["anna maria lopez", "ana maria lopez"], "word_to_matches": {"anna": ["anna", "ana"], "ana": ["ana", "anna"]}}`,
+		`{"all_names": ["anna maria lopez", "ana maria lopez"]}`,
+	} {
+		data := loadString(t, doc)
+		m := NewMatcher(data, Options{Workers: 1})
+		pairs := runMatcher(t, m)
+		if m.Evaluations() != 2 {
+			t.Errorf("%d validations for %d pairs, want 2", m.Evaluations(), len(pairs))
+		}
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := NewMatcher(loadString(t, smallInput), Options{}).Run(ctx, func(Pair) {})
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrInterrupted) {
+		t.Errorf("Run on a cancelled context returned %v", err)
+	}
+}
+
+// Cancelling a run midway lets the names in progress finish: every name
+// reported done emitted all of its pairs before it, and no name emitted
+// pairs without being reported done.
+func TestRunCancelledMidway(t *testing.T) {
+	sizes := make([]int, 100)
+	for i := range sizes {
+		sizes[i] = 4
+	}
+	data := loadString(t, groupsInput(sizes))
+	// Every candidate validates, so each name emits a pair per other name
+	// of its group
+	match := &MatchConfig{MaxMismatches: -1}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	const workers = 4
+	var pending [workers][]Pair
+	var done atomic.Int64
+	m := NewMatcher(data, Options{
+		Workers:  workers,
+		JobBatch: 8,
+		Match:    match,
+		OnNameDone: func(worker, idx int) error {
+			name := data.AllNames[idx]
+			if len(pending[worker]) != 3 {
+				t.Errorf("%q done after %d pairs, want 3", name, len(pending[worker]))
+			}
+			for _, p := range pending[worker] {
+				if p.A != name && p.B != name {
+					t.Errorf("pair %s|%s emitted for %q", p.A, p.B, name)
+				}
+			}
+			pending[worker] = pending[worker][:0]
+			if done.Add(1) == 50 {
+				cancel()
+			}
+			return nil
+		},
+	})
+	err := m.Run(ctx, func(p Pair) { pending[p.Worker] = append(pending[p.Worker], p) })
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrInterrupted) {
+		t.Errorf("Run returned %v", err)
+	}
+	for worker, pairs := range pending {
+		if len(pairs) > 0 {
+			t.Errorf("worker %d emitted %d pairs of a name it never finished", worker, len(pairs))
+		}
+	}
+	if n := done.Load(); n < 50 || n >= int64(len(data.AllNames)) || uint64(n) != m.Processed() {
+		t.Errorf("%d names done, %d processed, of %d", n, m.Processed(), len(data.AllNames))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	m := NewMatcher(loadString(t, smallInput), Options{})
+	for _, c := range []struct {
+		a, b string
+		ok   bool
+	}{
+		{"john smith", "jon smyth", true},
+		{"john smith", "mary smith", false},
+		// A word the input has never seen is interned on the fly but
+		// matches nothing, not even itself, and a three-word name may
+		// not have a mismatch
+		{"john smith zed", "jon smith zed", false},
+		{"john smith", "john", false},
+	} {
+		if ok, _ := m.Validate(c.a, c.b); ok != c.ok {
+			t.Errorf("Validate(%q, %q) = %v, want %v", c.a, c.b, ok, c.ok)
+		}
+	}
+}
+
+// skewedInput has n two-word names in buckets of their own and one
+// pathological name, "hub name", whose bucket holds heavy of them.
+func skewedInput(n, heavy int) string {
+	names := []string{`"hub name"`}
+	words := []string{`"hub": ["hub"]`, `"name": ["name"]`}
+	pairs := make([]string, 0, n+1)
+	hub := []string{`"hub name"`}
+	for i := range n {
+		name := fmt.Sprintf(`"given%d family%d"`, i%1000, i)
+		names = append(names, name)
+		words = append(words, fmt.Sprintf(`"family%d": ["family%d"]`, i, i))
+		pairs = append(pairs, fmt.Sprintf(`"family%d_given%d": [%s]`, i, i%1000, name))
+		if i < heavy {
+			hub = append(hub, name)
+		}
+	}
+	for i := range 1000 {
+		words = append(words, fmt.Sprintf(`"given%d": ["given%d"]`, i, i))
+	}
+	pairs = append(pairs, `"hub_name": [`+strings.Join(hub, ", ")+`]`)
+	return `{"all_names": [` + strings.Join(names, ", ") + `], "word_to_matches": {` + strings.Join(words, ", ") +
+		`}, "pair_to_names": {` + strings.Join(pairs, ", ") + `}}`
+}
+
+// The pathological name goes out first, in a batch of its own, so it
+// doesn't end up pinning a worker at the tail of the run. Ties keep input
+// order.
+func TestScheduleHeavyFirst(t *testing.T) {
+	data := loadString(t, skewedInput(2000, 500))
+	m := NewMatcher(data, Options{Workers: 4})
+	order, costs := m.schedule()
+	if data.AllNames[order[0]] != "hub name" {
+		t.Fatalf("%q scheduled first, want hub name", data.AllNames[order[0]])
+	}
+	if costs[order[0]] != 501 || costs[order[1]] != 1 {
+		t.Errorf("estimated costs %d and %d, want 501 and 1", costs[order[0]], costs[order[1]])
+	}
+	if !slices.IsSorted(order[1:]) {
+		t.Error("names of equal cost are out of input order")
+	}
+	batches := m.batches(order, costs)
+	if len(batches[0]) != 1 {
+		t.Errorf("hub name batched with %d other names", len(batches[0])-1)
+	}
+	for _, batch := range batches[1:] {
+		if len(batch) > DefaultJobBatch {
+			t.Errorf("a batch of %d names", len(batch))
+		}
+	}
+}
+
+// BenchmarkSkewedRun runs one pathological name among many small ones and
+// reports how busy the workers were: the time each one worked until its
+// last name, over the whole run on every worker. It stays near 1 when the
+// heavy name doesn't serialize the tail.
+func BenchmarkSkewedRun(b *testing.B) {
+	workers := runtime.GOMAXPROCS(0)
+	// The heavy name is about half of a worker's share
+	const n = 200000
+	data := loadString(b, skewedInput(n, n/(2*workers)))
+	var utilization float64
+	for b.Loop() {
+		lastDone := make([]atomic.Int64, workers)
+		start := time.Now()
+		err := NewMatcher(data, Options{
+			Workers: workers,
+			OnNameDone: func(worker, _ int) error {
+				lastDone[worker].Store(int64(time.Since(start)))
+				return nil
+			},
+		}).Run(context.Background(), func(Pair) {})
+		if err != nil {
+			b.Fatal(err)
+		}
+		wall := time.Since(start)
+		var busy int64
+		for i := range lastDone {
+			busy += lastDone[i].Load()
+		}
+		utilization += float64(busy) / float64(int64(workers)*int64(wall))
+	}
+	b.ReportMetric(utilization/float64(b.N), "utilization")
+}
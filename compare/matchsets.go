@@ -0,0 +1,98 @@
+package compare
+
+import "slices"
+
+// --- MATCH SETS ---
+// Validation paints the matches of one name's words into a buffer indexed by
+// word ID, then looks the other name's words up in it. Painting costs the
+// length of every match list, which for hub words (a common given name with
+// thousands of variants) dwarfs the handful of lookups it serves. Lists of
+// at least hubMatches words are kept sorted instead, and the other name's
+// words are binary-searched in them.
+
+const (
+	hubMatches = 64
+	// Hub lists one name sets aside; any further ones are painted, so
+	// validation stays allocation-free
+	maxNameHubs = 8
+)
+
+// matchSets is WordToMatches as validation reads it.
+type matchSets struct {
+	lists map[uint32][]uint32
+	// Sorted copies of the lists of at least hubMatches words
+	hubs map[uint32][]uint32
+}
+
+func newMatchSets(wordToMatches map[uint32][]uint32) *matchSets {
+	s := &matchSets{lists: wordToMatches, hubs: make(map[uint32][]uint32)}
+	for id, matches := range wordToMatches {
+		if len(matches) >= hubMatches {
+			s.hubs[id] = sortUniqueIDs(slices.Clone(matches))
+		}
+	}
+	return s
+}
+
+// hubSet holds the hub lists of one name's words.
+type hubSet struct {
+	lists [maxNameHubs][]uint32
+	n     int
+}
+
+// paint starts a phase of buffer and marks the matches of the words of
+// parts, except those of hub words, which it sets aside in hubs.
+func (s *matchSets) paint(parts []uint32, buffer *matchBuffer, hubs *hubSet) {
+	buffer.nextPhase()
+	hubs.n = 0
+	for _, wordID := range parts {
+		if s.isHub(wordID, hubs.n) {
+			hubs.lists[hubs.n] = s.hubs[wordID]
+			hubs.n++
+			continue
+		}
+		for _, matchID := range s.lists[wordID] {
+			buffer.mark(matchID)
+		}
+	}
+}
+
+// unpaint clears what paint marked, for a bitset buffer.
+func (s *matchSets) unpaint(parts []uint32, buffer *matchBuffer) {
+	if buffer.bits == nil {
+		return
+	}
+	hubs := 0
+	for _, wordID := range parts {
+		if s.isHub(wordID, hubs) {
+			hubs++
+			continue
+		}
+		for _, matchID := range s.lists[wordID] {
+			buffer.unmark(matchID)
+		}
+	}
+}
+
+// isHub reports whether paint sets the list of wordID aside, with n hub
+// lists set aside so far.
+func (s *matchSets) isHub(wordID uint32, n int) bool {
+	if len(s.hubs) == 0 || n >= maxNameHubs {
+		return false
+	}
+	_, ok := s.hubs[wordID]
+	return ok
+}
+
+// matched reports whether id was marked in buffer or is in a hub list.
+func (h *hubSet) matched(id uint32, buffer *matchBuffer) bool {
+	if buffer.marked(id) {
+		return true
+	}
+	for _, list := range h.lists[:h.n] {
+		if _, ok := slices.BinarySearch(list, id); ok {
+			return true
+		}
+	}
+	return false
+}
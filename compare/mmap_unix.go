@@ -0,0 +1,26 @@
+//go:build unix
+
+package compare
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps all of file read-only, or returns nil if it can't be
+// mapped. Processes mapping the same file share its pages.
+func mapFile(file *os.File) []byte {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 || int64(int(info.Size())) != info.Size() {
+		return nil
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func unmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
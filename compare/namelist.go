@@ -0,0 +1,120 @@
+package compare
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// --- NAME LISTS ---
+// The Python package builds word_to_matches and pair_to_names before
+// calling the binary. A plain list of names can be loaded without it: the
+// pair index is built as for any input without one (see BuildPairIndex),
+// and word_to_matches is derived from the words themselves and an optional
+// synonym file.
+
+// LoadNameList loads a list of names, one per line, deriving
+// word_to_matches from its words: every word matches itself, a single
+// letter matches the words starting with it and the other way around, as
+// the Python package's fuzzy matching has it, and the words of a synonym
+// group match each other. synonyms holds one group per line, its words
+// separated by commas or spaces, and may be nil. The Python package's
+// fuzzy spelling and pronunciation rules are not applied; put their
+// matches in the synonym file to use them. opts.Dict must be nil.
+func LoadNameList(names, synonyms io.Reader, opts LoadOptions) (*Data, error) {
+	if opts.Dict != nil {
+		return nil, &InputError{Err: errors.New("dictionary files only apply to JSON input")}
+	}
+	var groups [][]string
+	if synonyms != nil {
+		var err error
+		if groups, err = readSynonymGroups(synonyms); err != nil {
+			return nil, err
+		}
+	}
+	return load(func(v InputVisitor) error { return streamNameList(names, groups, v) }, opts)
+}
+
+func streamNameList(r io.Reader, groups [][]string, v InputVisitor) error {
+	var words []string
+	seen := make(map[string]struct{})
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" {
+			continue
+		}
+		if v.Name != nil {
+			v.Name(name)
+		}
+		for _, w := range strings.Fields(name) {
+			if _, ok := seen[w]; !ok {
+				seen[w] = struct{}{}
+				words = append(words, w)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if v.WordMatches == nil {
+		return nil
+	}
+
+	// Words by first letter, and the single letters among them
+	byFirst := make(map[rune][]string)
+	for _, w := range words {
+		first, _ := utf8.DecodeRuneInString(w)
+		byFirst[first] = append(byFirst[first], w)
+	}
+	synonyms := make(map[string][]string)
+	for _, group := range groups {
+		for _, w := range group {
+			synonyms[w] = append(synonyms[w], group...)
+		}
+	}
+	for _, w := range words {
+		first, size := utf8.DecodeRuneInString(w)
+		matches := []string{w}
+		if size == len(w) {
+			// A single letter stands for every word starting with it
+			for _, other := range byFirst[first] {
+				if other != w {
+					matches = append(matches, other)
+				}
+			}
+		} else if _, ok := seen[string(first)]; ok {
+			matches = append(matches, string(first))
+		}
+		for _, s := range synonyms[w] {
+			if !slices.Contains(matches, s) {
+				matches = append(matches, s)
+			}
+		}
+		v.WordMatches(w, matches)
+	}
+	return nil
+}
+
+// readSynonymGroups reads one group per line, words separated by commas or
+// whitespace. Blank lines and lines starting with # are skipped.
+func readSynonymGroups(r io.Reader) ([][]string, error) {
+	var groups [][]string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		group := strings.FieldsFunc(line, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	return groups, scanner.Err()
+}
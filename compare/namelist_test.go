@@ -0,0 +1,34 @@
+package compare
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Words match themselves, a single letter matches the words starting with
+// it and the other way around, and synonyms match each other.
+func TestLoadNameList(t *testing.T) {
+	names := "john smith\n\nj smith\njon smith\nmary jones\n"
+	synonyms := "# spellings\njohn, jon\n"
+	data, err := LoadNameList(strings.NewReader(names), strings.NewReader(synonyms), LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"john smith", "j smith", "jon smith", "mary jones"}; !slices.Equal(data.AllNames, want) {
+		t.Errorf("names %q, want %q", data.AllNames, want)
+	}
+	want := []string{"j smith|john smith", "j smith|jon smith", "john smith|jon smith"}
+	if got := runPairs(t, data, Options{}); !slices.Equal(got, want) {
+		t.Errorf("pairs %q, want %q", got, want)
+	}
+
+	data, err = LoadNameList(strings.NewReader(names), nil, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"j smith|john smith", "j smith|jon smith"}
+	if got := runPairs(t, data, Options{}); !slices.Equal(got, want) {
+		t.Errorf("without synonyms: pairs %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,44 @@
+package compare
+
+import (
+	_ "embed"
+	"strings"
+)
+
+// --- NICKNAMES ---
+// Records name people by what they were called as often as by their given
+// name ("bob" for "robert", "peggy" for "margaret"). AddNicknames adds a
+// built-in table of English nicknames as matches, instead of every input
+// carrying them in word_to_matches.
+
+//go:embed nicknames.txt
+var nicknameTable string
+
+// AddNicknames makes the words of the names that share a line of the
+// nickname table match each other, ignoring case. It returns the number of
+// words that gained a match.
+func (d *Data) AddNicknames() int {
+	groups, err := readSynonymGroups(strings.NewReader(nicknameTable))
+	if err != nil {
+		panic(err) // The table is embedded, so it always reads
+	}
+	lines := make(map[string][]int)
+	for i, group := range groups {
+		for _, w := range group {
+			lines[w] = append(lines[w], i)
+		}
+	}
+	found := make([][]uint32, len(groups))
+	for _, id := range d.nameWordIDs() {
+		for _, i := range lines[strings.ToLower(d.Dict.GetStr(id))] {
+			found[i] = append(found[i], id)
+		}
+	}
+	gained := 0
+	for _, group := range found {
+		if len(group) > 1 {
+			gained += d.addMatchGroup(group, SourceNicknames)
+		}
+	}
+	return gained
+}
@@ -0,0 +1,172 @@
+package compare
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// --- NORMALIZATION ---
+// Optional token normalization, applied before interning to every name
+// token, word_to_matches key and value, and both words of every
+// pair_to_names key, so that lookups keep lining up. Names themselves are
+// never rewritten, so output shows them as given.
+
+// Normalization selects the normalization steps. The zero value leaves
+// tokens untouched.
+type Normalization struct {
+	// Strip diacritics: the result of NFKD decomposition with combining
+	// marks removed, for Latin-1 and Latin Extended-A letters, plus removal
+	// of any combining mark already in the input
+	StripAccents bool
+	Lowercase    bool
+	// Trim punctuation attached to either end of a token ("j." -> "j")
+	TrimPunct bool
+	// Spell Cyrillic and Greek letters in Latin (see transliterate)
+	Transliterate bool
+}
+
+var normalizationSteps = []string{"accents", "case", "punct", "translit"}
+
+// ParseNormalization parses a comma-separated list of steps: accents, case,
+// punct and translit, or all for every step.
+func ParseNormalization(s string) (Normalization, error) {
+	var n Normalization
+	for _, step := range strings.Split(s, ",") {
+		switch strings.TrimSpace(step) {
+		case "":
+		case "all":
+			n = Normalization{StripAccents: true, Lowercase: true, TrimPunct: true, Transliterate: true}
+		case "accents":
+			n.StripAccents = true
+		case "case":
+			n.Lowercase = true
+		case "punct":
+			n.TrimPunct = true
+		case "translit":
+			n.Transliterate = true
+		default:
+			return n, &InputError{Err: fmt.Errorf("unknown normalization %q (want %s or all)", step, strings.Join(normalizationSteps, ", "))}
+		}
+	}
+	return n, nil
+}
+
+func (n Normalization) enabled() bool {
+	return n != Normalization{}
+}
+
+func (n Normalization) String() string {
+	var steps []string
+	for i, on := range []bool{n.StripAccents, n.Lowercase, n.TrimPunct, n.Transliterate} {
+		if on {
+			steps = append(steps, normalizationSteps[i])
+		}
+	}
+	if steps == nil {
+		return "none"
+	}
+	return strings.Join(steps, ",")
+}
+
+// Apply normalizes one token. The result may be empty, e.g. for a token
+// that is all punctuation.
+func (n Normalization) Apply(token string) string {
+	if n.Transliterate {
+		token = transliterate(token)
+	}
+	if n.StripAccents {
+		token = stripAccents(token)
+	}
+	if n.Lowercase {
+		token = strings.ToLower(token)
+	}
+	if n.TrimPunct {
+		token = strings.TrimFunc(token, unicode.IsPunct)
+	}
+	return token
+}
+
+// splitName tokenizes a name: whitespace-separated words, normalized, with
+// words that normalize to nothing dropped.
+func splitName(name string, n Normalization) []string {
+	words := strings.Fields(name)
+	if !n.enabled() {
+		return words
+	}
+	kept := words[:0]
+	for _, w := range words {
+		if w = n.Apply(w); w != "" {
+			kept = append(kept, w)
+		}
+	}
+	return kept
+}
+
+func stripAccents(s string) string {
+	var sb strings.Builder
+	changed := false
+	for _, r := range s {
+		if base, ok := accentBase[r]; ok {
+			sb.WriteString(base)
+			changed = true
+		} else if unicode.Is(unicode.Mn, r) {
+			changed = true
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	if !changed {
+		return s
+	}
+	return sb.String()
+}
+
+// accentBase maps precomposed letters to what NFKD plus mark removal
+// leaves of them. Letters NFKD doesn't decompose (Ø, Ł, Đ, Æ, ß, ...) are
+// left alone, as NFKD would.
+var accentBase = func() map[rune]string {
+	m := make(map[rune]string)
+	// Runs of consecutive code points sharing a base letter
+	runs := []struct {
+		from, to rune
+		base     string
+	}{
+		{'À', 'Å', "A"}, {'Ç', 'Ç', "C"}, {'È', 'Ë', "E"}, {'Ì', 'Ï', "I"},
+		{'Ñ', 'Ñ', "N"}, {'Ò', 'Ö', "O"}, {'Ù', 'Ü', "U"}, {'Ý', 'Ý', "Y"},
+		{'à', 'å', "a"}, {'ç', 'ç', "c"}, {'è', 'ë', "e"}, {'ì', 'ï', "i"},
+		{'ñ', 'ñ', "n"}, {'ò', 'ö', "o"}, {'ù', 'ü', "u"}, {'ý', 'ý', "y"},
+		{'ÿ', 'ÿ', "y"}, {'Ÿ', 'Ÿ', "Y"}, {'İ', 'İ', "I"}, {'ſ', 'ſ', "s"},
+		{'Ĳ', 'Ĳ', "IJ"}, {'ĳ', 'ĳ', "ij"}, {'Ŀ', 'Ŀ', "L·"}, {'ŀ', 'ŀ', "l·"},
+		{'ŉ', 'ŉ', "ʼn"},
+	}
+	for _, run := range runs {
+		for r := run.from; r <= run.to; r++ {
+			m[r] = run.base
+		}
+	}
+	// Latin Extended-A mostly alternates upper and lower case
+	pairs := []struct {
+		from, to rune
+		base     string
+	}{
+		{'Ā', 'ą', "Aa"}, {'Ć', 'č', "Cc"}, {'Ď', 'ď', "Dd"}, {'Ē', 'ě', "Ee"},
+		{'Ĝ', 'ģ', "Gg"}, {'Ĥ', 'ĥ', "Hh"}, {'Ĩ', 'į', "Ii"}, {'Ĵ', 'ĵ', "Jj"},
+		{'Ķ', 'ķ', "Kk"}, {'Ń', 'ň', "Nn"}, {'Ō', 'ő', "Oo"}, {'Ŕ', 'ř', "Rr"},
+		{'Ś', 'š', "Ss"}, {'Ţ', 'ť', "Tt"}, {'Ũ', 'ų', "Uu"}, {'Ŵ', 'ŵ', "Ww"},
+		{'Ŷ', 'ŷ', "Yy"},
+	}
+	for _, p := range pairs {
+		for r := p.from; r <= p.to; r++ {
+			m[r] = p.base[(r-p.from)%2 : (r-p.from)%2+1]
+		}
+	}
+	// L with acute, cedilla and caron start on an odd code point
+	for r := 'Ĺ'; r <= 'ľ'; r++ {
+		m[r] = "Ll"[(r-'Ĺ')%2 : (r-'Ĺ')%2+1]
+	}
+	for r := 'Ź'; r <= 'ž'; r++ {
+		m[r] = "Zz"[(r-'Ź')%2 : (r-'Ź')%2+1]
+	}
+	return m
+}()
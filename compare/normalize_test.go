@@ -0,0 +1,118 @@
+package compare
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNormalizationApply(t *testing.T) {
+	all := Normalization{StripAccents: true, Lowercase: true, TrimPunct: true, Transliterate: true}
+	for _, c := range []struct {
+		n          Normalization
+		token, out string
+	}{
+		{all, "José", "jose"},
+		{all, "JOSÉ", "jose"},
+		{all, "jose", "jose"},
+		// Decomposed: e followed by a combining acute accent
+		{all, "Jose\u0301", "jose"},
+		{all, "Núñez,", "nunez"},
+		{all, "J.", "j"},
+		{all, "Łukasz", "łukasz"},
+		{all, "...", ""},
+		{all, "Иван", "ivan"},
+		{all, "Щукин", "shchukin"},
+		{Normalization{Transliterate: true}, "Жуков", "Zhukov"},
+		{Normalization{Transliterate: true}, "Θεοδωρου", "Theodorou"},
+		{Normalization{StripAccents: true}, "JOSÉ", "JOSE"},
+		{Normalization{Lowercase: true}, "JOSÉ", "josé"},
+		{Normalization{TrimPunct: true}, "(José)", "José"},
+		{Normalization{}, "José,", "José,"},
+	} {
+		if got := c.n.Apply(c.token); got != c.out {
+			t.Errorf("%s: Apply(%q) = %q, want %q", c.n, c.token, got, c.out)
+		}
+	}
+}
+
+func TestParseNormalization(t *testing.T) {
+	for s, want := range map[string]string{
+		"": "none", "accents": "accents", "case, accents": "accents,case", "all": "accents,case,punct,translit",
+	} {
+		n, err := ParseNormalization(s)
+		if err != nil || n.String() != want {
+			t.Errorf("ParseNormalization(%q) = %s, %v; want %s", s, n, err, want)
+		}
+	}
+	if _, err := ParseNormalization("accents,unicode"); err == nil {
+		t.Error("an unknown step parsed")
+	}
+}
+
+const accentedNames = `"all_names": ["José Núñez", "JOSE NUNEZ", "jose nunez.", "Pepe Nuñez", "María Pérez"]`
+
+// José, JOSE and jose collapse to one word, whose word_to_matches entries
+// are merged.
+const accentedMatches = `"word_to_matches": {
+	"José": ["josé"], "JOSE": ["Pepe"], "pepe": ["jose", "pepe"],
+	"Núñez": ["nunez"], "maria": ["María"], "PÉREZ": ["perez"]
+}`
+
+// Normalization lines up names, word_to_matches and pair_to_names keys
+// spelled with and without accents, in any case and with punctuation, and
+// the output keeps the names as given.
+func TestNormalizeLoad(t *testing.T) {
+	names := loadString(t, "{"+accentedNames+"}").AllNames
+	want := []string{
+		"JOSE NUNEZ|José Núñez",
+		"JOSE NUNEZ|Pepe Nuñez",
+		"JOSE NUNEZ|jose nunez.",
+		"José Núñez|Pepe Nuñez",
+		"José Núñez|jose nunez.",
+		"Pepe Nuñez|jose nunez.",
+	}
+	opts := LoadOptions{Normalize: Normalization{StripAccents: true, Lowercase: true, TrimPunct: true}}
+	for _, c := range []struct {
+		name  string
+		index string
+	}{
+		{"built", ""},
+		{"keys as the names spell them", ", " + pythonPairIndex(names)},
+		{"normalized keys", `, "pair_to_names": {"jose_nunez": ["José Núñez", "JOSE NUNEZ", "jose nunez."],
+			"nunez_pepe": ["Pepe Nuñez"], "maria_perez": ["María Pérez"]}`},
+	} {
+		doc := "{" + accentedNames + ", " + accentedMatches + c.index + "}"
+		data, err := LoadWithOptions(strings.NewReader(doc), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(data.AllNames, names) {
+			t.Errorf("%s: names %q, want %q", c.name, data.AllNames, names)
+		}
+		jose := data.NameWords["José Núñez"]
+		for _, name := range []string{"JOSE NUNEZ", "jose nunez."} {
+			if !slices.Equal(data.NameWords[name], jose) {
+				t.Errorf("%s: %q has words %v, José Núñez %v", c.name, name, data.NameWords[name], jose)
+			}
+		}
+		id, _ := data.Dict.Lookup("jose")
+		var matches []string
+		for _, m := range data.WordToMatches[id] {
+			matches = append(matches, data.Dict.GetStr(m))
+		}
+		slices.Sort(matches)
+		if !slices.Equal(matches, []string{"jose", "pepe"}) {
+			t.Errorf("%s: jose matches %q, want the merged jose, pepe", c.name, matches)
+		}
+		if got := runPairs(t, data, Options{}); !slices.Equal(got, want) {
+			t.Errorf("%s: pairs %q, want %q", c.name, got, want)
+		}
+	}
+
+	// Off by default: only the names spelled alike match
+	data := loadString(t, "{"+accentedNames+", "+accentedMatches+"}")
+	if got := runPairs(t, data, Options{}); len(got) != 0 {
+		t.Errorf("without normalization: pairs %q", got)
+	}
+}
@@ -0,0 +1,168 @@
+package compare
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// BuildPairIndex fills PairToNames from the names themselves: every name is
+// put in the bucket of each unordered pair of its words. This is the same
+// index the Python package's build_simple_pair_mappings produces, so runs
+// behave the same whether the index came with the input or was built here.
+// Tradeout expansion only happens on the lookup side, as it always has.
+//
+// Names are split across workers, each building a local index, and the
+// local indexes are then merged in order so each bucket lists its names in
+// input order.
+func (d *Data) BuildPairIndex(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	chunk := (len(d.Names) + workers - 1) / workers
+	locals := make([]map[uint64][]uint32, workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		start, end := min(w*chunk, len(d.Names)), min((w+1)*chunk, len(d.Names))
+		wg.Add(1)
+		go func(w, start, end int) {
+			defer wg.Done()
+			local := make(map[uint64][]uint32)
+			var keys []uint64
+			for id := start; id < end; id++ {
+				keys = simplePairKeys(d.NameWords[d.Names[id]], keys[:0])
+				for _, key := range keys {
+					local[key] = append(local[key], uint32(id))
+				}
+			}
+			locals[w] = local
+		}(w, start, end)
+	}
+	wg.Wait()
+
+	index := locals[0]
+	for _, local := range locals[1:] {
+		for key, bucket := range local {
+			index[key] = append(index[key], bucket...)
+		}
+	}
+	d.PairToNames = index
+}
+
+// simplePairKeys appends the distinct pair keys of a tokenized name to keys.
+func simplePairKeys(words []uint32, keys []uint64) []uint64 {
+	start := len(keys)
+	for i := 0; i < len(words); i++ {
+		for j := i + 1; j < len(words); j++ {
+			if key := packPair(words[i], words[j]); !slices.Contains(keys[start:], key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// pairKeyString spells out a pair key as the input writes it: "a_b", with
+// the two words in string order.
+func (d *Data) pairKeyString(key uint64) string {
+	a, b := d.Dict.GetStr(uint32(key>>32)), d.Dict.GetStr(uint32(key))
+	if a > b {
+		a, b = b, a
+	}
+	return a + "_" + b
+}
+
+// WriteInput writes d back out as an input document, so an index built by
+// BuildPairIndex can be reused by later runs without rebuilding it.
+func (d *Data) WriteInput(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`{"all_names":`)
+	writeJSONStrings(bw, d.AllNames)
+
+	bw.WriteString(`,"word_to_matches":{`)
+	words := make([]uint32, 0, len(d.WordToMatches))
+	for id := range d.WordToMatches {
+		words = append(words, id)
+	}
+	sort.Slice(words, func(i, j int) bool { return words[i] < words[j] })
+	for i, id := range words {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		writeJSONString(bw, d.Dict.GetStr(id))
+		bw.WriteByte(':')
+		matches := make([]string, len(d.WordToMatches[id]))
+		for j, m := range d.WordToMatches[id] {
+			matches[j] = d.Dict.GetStr(m)
+		}
+		writeJSONStrings(bw, matches)
+	}
+
+	bw.WriteString(`},"pair_to_names":{`)
+	keys := make([]string, 0, d.Buckets())
+	// Words holding underscores can spell two pair keys the same way
+	// ("de_la" + "cruz" and "de" + "la_cruz"); their buckets share the
+	// string key, which loading splits both ways again
+	byString := make(map[string][]uint64, d.Buckets())
+	for _, key := range d.bucketKeys() {
+		str := d.pairKeyString(key)
+		if _, ok := byString[str]; !ok {
+			keys = append(keys, str)
+		}
+		byString[str] = append(byString[str], key)
+	}
+	sort.Strings(keys)
+	var ids []uint32
+	var names []string
+	for i, key := range keys {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		writeJSONString(bw, key)
+		bw.WriteByte(':')
+		ids = ids[:0]
+		for _, pairKey := range byString[key] {
+			bucket, _ := d.bucket(pairKey)
+			ids = append(ids, bucket...)
+		}
+		if len(byString[key]) > 1 {
+			ids = sortUniqueIDs(ids)
+		}
+		names = names[:0]
+		for _, id := range ids {
+			names = append(names, d.Names[id])
+		}
+		writeJSONStrings(bw, names)
+	}
+	bw.WriteByte('}')
+	if d.Queries != nil {
+		bw.WriteString(`,"query_names":`)
+		names := make([]string, len(d.Queries))
+		for i, idx := range d.Queries {
+			names[i] = d.AllNames[idx]
+		}
+		writeJSONStrings(bw, names)
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+func writeJSONString(w *bufio.Writer, s string) {
+	raw, _ := json.Marshal(s)
+	w.Write(raw)
+}
+
+func writeJSONStrings(w *bufio.Writer, list []string) {
+	w.WriteByte('[')
+	for i, s := range list {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		writeJSONString(w, s)
+	}
+	w.WriteByte(']')
+}
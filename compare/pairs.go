@@ -0,0 +1,102 @@
+package compare
+
+import "slices"
+
+// pairScratch holds the per-position option lists and the result of
+// buildExpandedPairMappings, so a worker reuses them from name to name. The
+// buffers grow to the longest name and largest option lists seen.
+type pairScratch struct {
+	options [][]uint32
+	classes []uint32
+	seen    []uint64
+	keys    []uint64
+	// The name's words left once ignored classes are dropped
+	words []uint32
+}
+
+// positions returns n empty option lists.
+func (s *pairScratch) positions(n int) [][]uint32 {
+	for len(s.options) < n {
+		s.options = append(s.options, make([]uint32, 0, 5))
+	}
+	for i := range s.options[:n] {
+		s.options[i] = s.options[i][:0]
+	}
+	return s.options[:n]
+}
+
+// buildExpandedPairMappings returns the pair keys a name looks up: every
+// pair of its words, each word also standing in for its tradeouts. Keys are
+// packed word IDs (see packPair), so the order of the two words doesn't
+// matter. The result lives in scratch and is only valid until the next
+// call with the same scratch, which may be nil for one-off calls.
+func buildExpandedPairMappings(parts []uint32, tradeoutSets map[uint32][]uint32, scratch *pairScratch) []uint64 {
+	if scratch == nil {
+		scratch = &pairScratch{}
+	}
+	// 1. Position Options (IDs)
+	positionOptions := scratch.positions(len(parts))
+	for i, wordID := range parts {
+		opts := append(positionOptions[i], wordID)
+
+		if replacements, ok := tradeoutSets[wordID]; ok {
+			opts = append(opts, replacements...)
+		}
+
+		// Sorted and unique, so equal option lists compare equal
+		positionOptions[i] = sortUniqueIDs(opts)
+	}
+
+	// Positions with the same options produce the same keys, so each pair
+	// of distinct option lists is only expanded once. A position's class is
+	// the first position with its options.
+	classes := scratch.classes[:0]
+	for i, opts := range positionOptions {
+		class := uint32(i)
+		for j := range i {
+			if classes[j] == uint32(j) && slices.Equal(positionOptions[j], opts) {
+				class = uint32(j)
+				break
+			}
+		}
+		classes = append(classes, class)
+	}
+	scratch.classes = classes
+
+	// 2. Pairs
+	seenPairs := scratch.seen[:0]
+	results := scratch.keys[:0]
+	for i := 0; i < len(positionOptions); i++ {
+		for j := i + 1; j < len(positionOptions); j++ {
+			pair := packPair(classes[i], classes[j])
+			if slices.Contains(seenPairs, pair) {
+				continue
+			}
+			seenPairs = append(seenPairs, pair)
+
+			for _, wI := range positionOptions[i] {
+				for _, wJ := range positionOptions[j] {
+					results = append(results, packPair(wI, wJ))
+				}
+			}
+		}
+	}
+	scratch.seen, scratch.keys = seenPairs, results
+	return results
+}
+
+// sortUniqueIDs sorts ids in place and drops repeats. Option lists are
+// mostly a handful of IDs, which insertion sort handles without the
+// overhead of a general sort.
+func sortUniqueIDs(ids []uint32) []uint32 {
+	if len(ids) <= 12 {
+		for i := 1; i < len(ids); i++ {
+			for j := i; j > 0 && ids[j] < ids[j-1]; j-- {
+				ids[j], ids[j-1] = ids[j-1], ids[j]
+			}
+		}
+	} else {
+		slices.Sort(ids)
+	}
+	return slices.Compact(ids)
+}
@@ -0,0 +1,155 @@
+package compare
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSortUniqueIDs(t *testing.T) {
+	for _, n := range []int{0, 1, 5, 12, 13, 200} {
+		r := rand.New(rand.NewPCG(1, uint64(n)))
+		ids := make([]uint32, n)
+		for i := range ids {
+			ids[i] = r.Uint32N(uint32(n/2 + 1))
+		}
+		want := slices.Compact(slices.Sorted(slices.Values(ids)))
+		if got := sortUniqueIDs(slices.Clone(ids)); !slices.Equal(got, want) {
+			t.Errorf("sortUniqueIDs(%v) = %v, want %v", ids, got, want)
+		}
+	}
+}
+
+// expansionInput returns the words of a name of length words, each with
+// options tradeouts, the last word repeating the first.
+func expansionInput(length, options int) ([]uint32, map[uint32][]uint32) {
+	parts := make([]uint32, length)
+	tradeouts := make(map[uint32][]uint32)
+	for i := range parts {
+		parts[i] = uint32(i * 1000)
+		// Unsorted, with a repeat, as the input may list them
+		for k := options; k > 0; k-- {
+			tradeouts[parts[i]] = append(tradeouts[parts[i]], parts[i]+uint32(k))
+		}
+		tradeouts[parts[i]] = append(tradeouts[parts[i]], parts[i]+1)
+	}
+	parts[length-1] = parts[0]
+	return parts, tradeouts
+}
+
+// Every pair of options of two different positions, found the slow way.
+func naiveExpansion(parts []uint32, tradeouts map[uint32][]uint32) []uint64 {
+	seen := make(map[uint64]bool)
+	var keys []uint64
+	for i := range parts {
+		for j := i + 1; j < len(parts); j++ {
+			for _, a := range append([]uint32{parts[i]}, tradeouts[parts[i]]...) {
+				for _, b := range append([]uint32{parts[j]}, tradeouts[parts[j]]...) {
+					if key := packPair(a, b); !seen[key] {
+						seen[key] = true
+						keys = append(keys, key)
+					}
+				}
+			}
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestBuildExpandedPairMappings(t *testing.T) {
+	var scratch pairScratch
+	for _, c := range [][2]int{{2, 0}, {3, 2}, {4, 20}, {2, 3}} {
+		parts, tradeouts := expansionInput(c[0], c[1])
+		got := slices.Clone(buildExpandedPairMappings(parts, tradeouts, &scratch))
+		slices.Sort(got)
+		got = slices.Compact(got)
+		if want := naiveExpansion(parts, tradeouts); !slices.Equal(got, want) {
+			t.Errorf("%d words, %d options: %d keys, want %d", c[0], c[1], len(got), len(want))
+		}
+	}
+}
+
+// Once a worker's scratch has grown to its names, expanding a name
+// allocates nothing.
+func TestBuildExpandedPairMappingsAllocs(t *testing.T) {
+	var scratch pairScratch
+	long, longTradeouts := expansionInput(6, 30)
+	short, shortTradeouts := expansionInput(3, 2)
+	buildExpandedPairMappings(long, longTradeouts, &scratch)
+	allocs := testing.AllocsPerRun(100, func() {
+		buildExpandedPairMappings(short, shortTradeouts, &scratch)
+		buildExpandedPairMappings(long, longTradeouts, &scratch)
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations per run, want 0", allocs)
+	}
+}
+
+func BenchmarkBuildExpandedPairMappings(b *testing.B) {
+	// Small option lists take the insertion sort, large ones slices.Sort
+	for _, c := range [][2]int{{3, 2}, {5, 4}, {3, 40}, {8, 40}} {
+		parts, tradeouts := expansionInput(c[0], c[1])
+		b.Run(fmt.Sprintf("words=%d/options=%d", c[0], c[1]), func(b *testing.B) {
+			b.ReportAllocs()
+			var scratch pairScratch
+			for b.Loop() {
+				buildExpandedPairMappings(parts, tradeouts, &scratch)
+			}
+		})
+	}
+}
+
+// BenchmarkCandidateLookup looks up the candidates of one name per op: by
+// packed word ID keys into buckets of name IDs, and as before them, by
+// joining the two words of each key into a string looked up in buckets of
+// name strings.
+func BenchmarkCandidateLookup(b *testing.B) {
+	data, err := Load(bytes.NewReader(syntheticInput(20000)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	m := NewMatcher(data, Options{})
+	stringKey := func(key uint64) string {
+		w1, w2 := unpackPair(key)
+		return data.Dict.GetStr(w1) + "_" + data.Dict.GetStr(w2)
+	}
+	byString := make(map[string][]string, len(data.PairToNames))
+	for key, ids := range data.PairToNames {
+		names := make([]string, len(ids))
+		for i, id := range ids {
+			// A separate string per bucket entry, as decoding gave them
+			names[i] = strings.Clone(data.Names[id])
+		}
+		byString[stringKey(key)] = names
+	}
+
+	var found int
+	b.Run("packed", func(b *testing.B) {
+		b.ReportAllocs()
+		var scratch pairScratch
+		for i := 0; b.Loop(); i++ {
+			parts := data.NameWords[data.AllNames[i%len(data.AllNames)]]
+			for _, key := range m.pairKeys(parts, &scratch) {
+				ids, _ := data.bucket(key)
+				found += len(ids)
+			}
+		}
+	})
+	b.Run("strings", func(b *testing.B) {
+		b.ReportAllocs()
+		var scratch pairScratch
+		for i := 0; b.Loop(); i++ {
+			parts := data.NameWords[data.AllNames[i%len(data.AllNames)]]
+			for _, key := range m.pairKeys(parts, &scratch) {
+				found += len(byString[stringKey(key)])
+			}
+		}
+	})
+	if found == 0 {
+		b.Fatal("no candidates found")
+	}
+}
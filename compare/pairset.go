@@ -0,0 +1,59 @@
+package compare
+
+import "slices"
+
+// PairSet is a set of name pairs a run must not emit, such as the pairs of
+// a previous output. Pairs are stored as packed name IDs (see packPair) in a
+// sorted slice, 8 bytes per pair, so sets of hundreds of millions of pairs
+// stay affordable.
+type PairSet struct {
+	data   *Data
+	keys   []uint64
+	sorted bool
+	absent int
+}
+
+// NewPairSet returns an empty set for pairs of names in data.
+func NewPairSet(data *Data) *PairSet {
+	return &PairSet{data: data, sorted: true}
+}
+
+// Add puts the pair of a and b in the set. Pairs naming a name that isn't in
+// the corpus can't come up in a run, so they are only counted (see Absent).
+// Add must not be called once a Matcher uses the set.
+func (s *PairSet) Add(a, b string) {
+	idA, okA := s.data.NameIDs[a]
+	idB, okB := s.data.NameIDs[b]
+	if !okA || !okB {
+		s.absent++
+		return
+	}
+	s.keys = append(s.keys, packPair(idA, idB))
+	s.sorted = false
+}
+
+// Len returns the number of distinct pairs in the set.
+func (s *PairSet) Len() int {
+	s.compact()
+	return len(s.keys)
+}
+
+// Absent returns how many added pairs named a name that isn't in the corpus.
+func (s *PairSet) Absent() int {
+	return s.absent
+}
+
+func (s *PairSet) compact() {
+	if s.sorted {
+		return
+	}
+	slices.Sort(s.keys)
+	s.keys = slices.Compact(s.keys)
+	s.keys = slices.Clip(s.keys)
+	s.sorted = true
+}
+
+func (s *PairSet) contains(a, b uint32) bool {
+	_, ok := slices.BinarySearch(s.keys, packPair(a, b))
+	return ok
+}
@@ -0,0 +1,715 @@
+package compare
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// --- PHONETIC MATCHING ---
+// Transcriptions of the same name often differ in spelling but not in
+// sound ("shaun", "shawn", "sean"). AddPhoneticMatches gives every word of
+// the names a phonetic code and makes words with a code in common match,
+// as an added layer on word_to_matches (see addMatchGroup).
+//
+// Soundex is coarse: it keeps the first letter and up to three consonant
+// classes, so it groups "sean" with "shawn" but also "smith" with
+// "schmidt" and "smoot". Double Metaphone follows English and European
+// spelling rules more closely and gives a second code for ambiguous
+// spellings; two words match if any of their codes agree. Either way a
+// common code can group many words, which widens every name's pair keys,
+// so the groups are worth checking with 'rules show'.
+
+type PhoneticAlgorithm uint8
+
+const (
+	PhoneticNone PhoneticAlgorithm = iota
+	PhoneticSoundex
+	PhoneticDoubleMetaphone
+)
+
+// ParsePhoneticAlgorithm parses "soundex" or "double-metaphone"; the empty
+// string is PhoneticNone.
+func ParsePhoneticAlgorithm(s string) (PhoneticAlgorithm, error) {
+	switch s {
+	case "":
+		return PhoneticNone, nil
+	case "soundex":
+		return PhoneticSoundex, nil
+	case "double-metaphone":
+		return PhoneticDoubleMetaphone, nil
+	}
+	return PhoneticNone, &InputError{Err: fmt.Errorf("unknown phonetic algorithm %q (want soundex or double-metaphone)", s)}
+}
+
+// codes returns the phonetic codes of word, none if it has no letters the
+// algorithm knows.
+func (a PhoneticAlgorithm) codes(word string) []string {
+	switch a {
+	case PhoneticSoundex:
+		if code := Soundex(word); code != "" {
+			return []string{code}
+		}
+	case PhoneticDoubleMetaphone:
+		primary, alternate := DoubleMetaphone(word)
+		switch {
+		case primary == "":
+		case alternate == primary:
+			return []string{primary}
+		default:
+			return []string{primary, alternate}
+		}
+	}
+	return nil
+}
+
+// AddPhoneticMatches makes the words of the names that share a phonetic code
+// match each other. One-letter words are left alone, as initials. It
+// returns the number of words that gained a match.
+func (d *Data) AddPhoneticMatches(alg PhoneticAlgorithm) int {
+	if alg == PhoneticNone {
+		return 0
+	}
+	groups := make(map[string][]uint32)
+	var order []string
+	for _, id := range d.nameWordIDs() {
+		word := d.Dict.GetStr(id)
+		if utf8.RuneCountInString(word) < 2 {
+			continue
+		}
+		for _, code := range alg.codes(word) {
+			if _, ok := groups[code]; !ok {
+				order = append(order, code)
+			}
+			groups[code] = append(groups[code], id)
+		}
+	}
+	gained := 0
+	for _, code := range order {
+		if group := groups[code]; len(group) > 1 {
+			gained += d.addMatchGroup(group, SourcePhonetic)
+		}
+	}
+	return gained
+}
+
+// Soundex returns the American Soundex code of word: its first letter and
+// the digits of the next three consonant classes, such as "S500" for
+// "shawn". Letters outside A-Z are skipped; a word without any has no code.
+func Soundex(word string) string {
+	const digits = "01230120022455012623010202" // by letter, A-Z
+	code := make([]byte, 0, 4)
+	var last byte
+	for i := 0; i < len(word) && len(code) < 4; i++ {
+		c := word[i]
+		if 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		if c < 'A' || c > 'Z' {
+			continue
+		}
+		d := digits[c-'A']
+		if len(code) == 0 {
+			code = append(code, c)
+			last = d
+			continue
+		}
+		switch {
+		case c == 'H' || c == 'W':
+			// Don't separate consonants of the same class
+		case d == '0':
+			last = 0
+		case d != last:
+			code = append(code, d)
+			last = d
+		}
+	}
+	if len(code) == 0 {
+		return ""
+	}
+	for len(code) < 4 {
+		code = append(code, '0')
+	}
+	return string(code)
+}
+
+// DoubleMetaphone returns the primary and alternate Double Metaphone codes
+// of word (Lawrence Philips' algorithm, codes of up to four characters).
+// The alternate equals the primary when the spelling isn't ambiguous.
+func DoubleMetaphone(word string) (primary, alternate string) {
+	m := metaphone{value: []rune(strings.ToUpper(strings.TrimSpace(word)))}
+	if len(m.value) == 0 {
+		return "", ""
+	}
+	m.encode()
+	return m.primary.String(), m.alternate.String()
+}
+
+const metaphoneMaxLength = 4
+
+type metaphone struct {
+	value              []rune
+	slavoGermanic      bool
+	primary, alternate strings.Builder
+}
+
+func (m *metaphone) encode() {
+	m.slavoGermanic = m.containsAnywhere("W") || m.containsAnywhere("K") ||
+		m.containsAnywhere("CZ") || m.containsAnywhere("WITZ")
+	index := 0
+	if m.contains(0, 2, "GN", "KN", "PN", "WR", "PS") {
+		index = 1
+	}
+	if m.at(0) == 'X' {
+		m.add("S")
+		index = 1
+	}
+	for !m.complete() && index < len(m.value) {
+		switch m.at(index) {
+		case 'A', 'E', 'I', 'O', 'U', 'Y':
+			if index == 0 {
+				m.add("A")
+			}
+			index++
+		case 'B':
+			m.add("P")
+			index = m.skipDouble(index, 'B')
+		case 'Ç':
+			m.add("S")
+			index++
+		case 'C':
+			index = m.handleC(index)
+		case 'D':
+			index = m.handleD(index)
+		case 'F':
+			m.add("F")
+			index = m.skipDouble(index, 'F')
+		case 'G':
+			index = m.handleG(index)
+		case 'H':
+			index = m.handleH(index)
+		case 'J':
+			index = m.handleJ(index)
+		case 'K':
+			m.add("K")
+			index = m.skipDouble(index, 'K')
+		case 'L':
+			index = m.handleL(index)
+		case 'M':
+			m.add("M")
+			if m.conditionM0(index) {
+				index += 2
+			} else {
+				index++
+			}
+		case 'N':
+			m.add("N")
+			index = m.skipDouble(index, 'N')
+		case 'Ñ':
+			m.add("N")
+			index++
+		case 'P':
+			index = m.handleP(index)
+		case 'Q':
+			m.add("K")
+			index = m.skipDouble(index, 'Q')
+		case 'R':
+			index = m.handleR(index)
+		case 'S':
+			index = m.handleS(index)
+		case 'T':
+			index = m.handleT(index)
+		case 'V':
+			m.add("F")
+			index = m.skipDouble(index, 'V')
+		case 'W':
+			index = m.handleW(index)
+		case 'X':
+			index = m.handleX(index)
+		case 'Z':
+			index = m.handleZ(index)
+		default:
+			index++
+		}
+	}
+}
+
+// --- Buffers and lookups ---
+
+func (m *metaphone) add(both string) {
+	m.addPrimary(both)
+	m.addAlternate(both)
+}
+
+func (m *metaphone) add2(primary, alternate string) {
+	m.addPrimary(primary)
+	m.addAlternate(alternate)
+}
+
+func (m *metaphone) addPrimary(s string) {
+	appendCapped(&m.primary, s)
+}
+
+func (m *metaphone) addAlternate(s string) {
+	appendCapped(&m.alternate, s)
+}
+
+func appendCapped(b *strings.Builder, s string) {
+	if room := metaphoneMaxLength - b.Len(); room > 0 {
+		b.WriteString(s[:min(room, len(s))])
+	}
+}
+
+func (m *metaphone) complete() bool {
+	return m.primary.Len() >= metaphoneMaxLength && m.alternate.Len() >= metaphoneMaxLength
+}
+
+// at returns the rune at i, or 0 outside the word.
+func (m *metaphone) at(i int) rune {
+	if i < 0 || i >= len(m.value) {
+		return 0
+	}
+	return m.value[i]
+}
+
+func (m *metaphone) last() int {
+	return len(m.value) - 1
+}
+
+func isMetaphoneVowel(r rune) bool {
+	return strings.ContainsRune("AEIOUY", r)
+}
+
+// contains reports whether the length runes at start equal one of options.
+func (m *metaphone) contains(start, length int, options ...string) bool {
+	if start < 0 || start+length > len(m.value) {
+		return false
+	}
+	target := string(m.value[start : start+length])
+	for _, o := range options {
+		if target == o {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *metaphone) containsAnywhere(s string) bool {
+	return strings.Contains(string(m.value), s)
+}
+
+// skipDouble steps over the letter at index, and over a repeat of it.
+func (m *metaphone) skipDouble(index int, r rune) int {
+	if m.at(index+1) == r {
+		return index + 2
+	}
+	return index + 1
+}
+
+// --- Letter rules ---
+
+func (m *metaphone) handleC(index int) int {
+	switch {
+	case m.conditionC0(index):
+		// Germanic "ach", as in "bacher"
+		m.add("K")
+		return index + 2
+	case index == 0 && m.contains(index, 6, "CAESAR"):
+		m.add("S")
+		return index + 2
+	case m.contains(index, 2, "CH"):
+		return m.handleCH(index)
+	case m.contains(index, 2, "CZ") && !m.contains(index-2, 4, "WICZ"):
+		m.add2("S", "X")
+		return index + 2
+	case m.contains(index+1, 3, "CIA"):
+		m.add("X")
+		return index + 3
+	case m.contains(index, 2, "CC") && !(index == 1 && m.at(0) == 'M'):
+		return m.handleCC(index)
+	case m.contains(index, 2, "CK", "CG", "CQ"):
+		m.add("K")
+		return index + 2
+	case m.contains(index, 2, "CI", "CE", "CY"):
+		if m.contains(index, 3, "CIO", "CIE", "CIA") {
+			m.add2("S", "X")
+		} else {
+			m.add("S")
+		}
+		return index + 2
+	}
+	m.add("K")
+	switch {
+	case m.contains(index+1, 2, " C", " Q", " G"):
+		return index + 3
+	case m.contains(index+1, 1, "C", "K", "Q") && !m.contains(index+1, 2, "CE", "CI"):
+		return index + 2
+	}
+	return index + 1
+}
+
+func (m *metaphone) conditionC0(index int) bool {
+	switch {
+	case m.contains(index, 4, "CHIA"):
+		return true
+	case index <= 1, isMetaphoneVowel(m.at(index - 2)), !m.contains(index-1, 3, "ACH"):
+		return false
+	}
+	c := m.at(index + 2)
+	return (c != 'I' && c != 'E') || m.contains(index-2, 6, "BACHER", "MACHER")
+}
+
+func (m *metaphone) handleCC(index int) int {
+	if m.contains(index+2, 1, "I", "E", "H") && !m.contains(index+2, 2, "HU") {
+		// "accident", "accede", "succeed"; "bacchus" and "bellocchio" get X
+		if (index == 1 && m.at(index-1) == 'A') || m.contains(index-1, 5, "UCCEE", "UCCES") {
+			m.add("KS")
+		} else {
+			m.add("X")
+		}
+		return index + 3
+	}
+	m.add("K")
+	return index + 2
+}
+
+func (m *metaphone) handleCH(index int) int {
+	switch {
+	case index > 0 && m.contains(index, 4, "CHAE"):
+		m.add2("K", "X")
+	case m.conditionCH0(index), m.conditionCH1(index):
+		m.add("K")
+	case index > 0 && m.contains(0, 2, "MC"):
+		m.add("K")
+	case index > 0:
+		m.add2("X", "K")
+	default:
+		m.add("X")
+	}
+	return index + 2
+}
+
+// conditionCH0 is a Greek "ch" at the start of the word, as in "chorus".
+func (m *metaphone) conditionCH0(index int) bool {
+	if index != 0 {
+		return false
+	}
+	if !m.contains(index+1, 5, "HARAC", "HARIS") && !m.contains(index+1, 3, "HOR", "HYM", "HIA", "HEM") {
+		return false
+	}
+	return !m.contains(0, 5, "CHORE")
+}
+
+// conditionCH1 is a Germanic or Greek "ch" elsewhere, as in "orchestra".
+func (m *metaphone) conditionCH1(index int) bool {
+	return m.contains(0, 4, "VAN ", "VON ") || m.contains(0, 3, "SCH") ||
+		m.contains(index-2, 6, "ORCHES", "ARCHIT", "ORCHID") ||
+		m.contains(index+2, 1, "T", "S") ||
+		((m.contains(index-1, 1, "A", "O", "U", "E") || index == 0) &&
+			(m.contains(index+2, 1, "L", "R", "N", "M", "B", "H", "F", "V", "W", " ") || index+1 == m.last()))
+}
+
+func (m *metaphone) handleD(index int) int {
+	switch {
+	case m.contains(index, 2, "DG"):
+		if m.contains(index+2, 1, "I", "E", "Y") {
+			m.add("J")
+			return index + 3
+		}
+		m.add("TK")
+		return index + 2
+	case m.contains(index, 2, "DT", "DD"):
+		m.add("T")
+		return index + 2
+	}
+	m.add("T")
+	return index + 1
+}
+
+func (m *metaphone) handleG(index int) int {
+	switch {
+	case m.at(index+1) == 'H':
+		return m.handleGH(index)
+	case m.at(index+1) == 'N':
+		switch {
+		case index == 1 && isMetaphoneVowel(m.at(0)) && !m.slavoGermanic:
+			m.add2("KN", "N")
+		case !m.contains(index+2, 2, "EY") && m.at(index+1) != 'Y' && !m.slavoGermanic:
+			m.add2("N", "KN")
+		default:
+			m.add("KN")
+		}
+		return index + 2
+	case m.contains(index+1, 2, "LI") && !m.slavoGermanic:
+		m.add2("KL", "L")
+		return index + 2
+	case index == 0 && (m.at(index+1) == 'Y' ||
+		m.contains(index+1, 2, "ES", "EP", "EB", "EL", "EY", "IB", "IL", "IN", "IE", "EI", "ER")):
+		m.add2("K", "J")
+		return index + 2
+	case (m.contains(index+1, 2, "ER") || m.at(index+1) == 'Y') &&
+		!m.contains(0, 6, "DANGER", "RANGER", "MANGER") &&
+		!m.contains(index-1, 1, "E", "I") && !m.contains(index-1, 3, "RGY", "OGY"):
+		m.add2("K", "J")
+		return index + 2
+	case m.contains(index+1, 1, "E", "I", "Y") || m.contains(index-1, 4, "AGGI", "OGGI"):
+		switch {
+		case m.contains(0, 4, "VAN ", "VON ") || m.contains(0, 3, "SCH") || m.contains(index+1, 2, "ET"):
+			m.add("K")
+		case m.contains(index+1, 3, "IER"):
+			m.add("J")
+		default:
+			m.add2("J", "K")
+		}
+		return index + 2
+	}
+	m.add("K")
+	return m.skipDouble(index, 'G')
+}
+
+func (m *metaphone) handleGH(index int) int {
+	switch {
+	case index > 0 && !isMetaphoneVowel(m.at(index-1)):
+		m.add("K")
+	case index == 0:
+		if m.at(index+2) == 'I' {
+			m.add("J")
+		} else {
+			m.add("K")
+		}
+	case (index > 1 && m.contains(index-2, 1, "B", "H", "D")) ||
+		(index > 2 && m.contains(index-3, 1, "B", "H", "D")) ||
+		(index > 3 && m.contains(index-4, 1, "B", "H")):
+		// Silent, as in "hugh", "bough", "broughton"
+	case index > 2 && m.at(index-1) == 'U' && m.contains(index-3, 1, "C", "G", "L", "R", "T"):
+		// "laugh", "tough"
+		m.add("F")
+	case index > 0 && m.at(index-1) != 'I':
+		m.add("K")
+	}
+	return index + 2
+}
+
+func (m *metaphone) handleH(index int) int {
+	// Only kept between vowels or at the start before one
+	if (index == 0 || isMetaphoneVowel(m.at(index-1))) && isMetaphoneVowel(m.at(index+1)) {
+		m.add("H")
+		return index + 2
+	}
+	return index + 1
+}
+
+func (m *metaphone) handleJ(index int) int {
+	if m.contains(index, 4, "JOSE") || m.contains(0, 4, "SAN ") {
+		if (index == 0 && m.at(index+4) == ' ') || len(m.value) == 4 || m.contains(0, 4, "SAN ") {
+			m.add("H")
+		} else {
+			m.add2("J", "H")
+		}
+		return index + 1
+	}
+	switch {
+	case index == 0:
+		m.add2("J", "A")
+	case isMetaphoneVowel(m.at(index-1)) && !m.slavoGermanic && (m.at(index+1) == 'A' || m.at(index+1) == 'O'):
+		m.add2("J", "H")
+	case index == m.last():
+		m.addPrimary("J")
+	case !m.contains(index+1, 1, "L", "T", "K", "S", "N", "M", "B", "Z") && !m.contains(index-1, 1, "S", "K", "L"):
+		m.add("J")
+	}
+	return m.skipDouble(index, 'J')
+}
+
+func (m *metaphone) handleL(index int) int {
+	if m.at(index+1) != 'L' {
+		m.add("L")
+		return index + 1
+	}
+	if m.conditionL0(index) {
+		// Spanish "ll", as in "cabrillo"
+		m.addPrimary("L")
+	} else {
+		m.add("L")
+	}
+	return index + 2
+}
+
+func (m *metaphone) conditionL0(index int) bool {
+	if index == len(m.value)-3 && m.contains(index-1, 4, "ILLO", "ILLA", "ALLE") {
+		return true
+	}
+	return (m.contains(len(m.value)-2, 2, "AS", "OS") || m.contains(len(m.value)-1, 1, "A", "O")) &&
+		m.contains(index-1, 4, "ALLE")
+}
+
+// conditionM0 is a doubled M, or the silent B of "dumb" and "thumb".
+func (m *metaphone) conditionM0(index int) bool {
+	if m.at(index+1) == 'M' {
+		return true
+	}
+	return m.contains(index-1, 3, "UMB") && (index+1 == m.last() || m.contains(index+2, 2, "ER"))
+}
+
+func (m *metaphone) handleP(index int) int {
+	if m.at(index+1) == 'H' {
+		m.add("F")
+		return index + 2
+	}
+	m.add("P")
+	if m.contains(index+1, 1, "P", "B") {
+		return index + 2
+	}
+	return index + 1
+}
+
+func (m *metaphone) handleR(index int) int {
+	// French final "-ier", as in "rogier"
+	if index == m.last() && !m.slavoGermanic && m.contains(index-2, 2, "IE") && !m.contains(index-4, 2, "ME", "MA") {
+		m.addAlternate("R")
+	} else {
+		m.add("R")
+	}
+	return m.skipDouble(index, 'R')
+}
+
+func (m *metaphone) handleS(index int) int {
+	switch {
+	case m.contains(index-1, 3, "ISL", "YSL"):
+		// Silent, as in "island", "carlisle"
+		return index + 1
+	case index == 0 && m.contains(index, 5, "SUGAR"):
+		m.add2("X", "S")
+		return index + 1
+	case m.contains(index, 2, "SH"):
+		if m.contains(index+1, 4, "HEIM", "HOEK", "HOLM", "HOLZ") {
+			m.add("S")
+		} else {
+			m.add("X")
+		}
+		return index + 2
+	case m.contains(index, 3, "SIO", "SIA") || m.contains(index, 4, "SIAN"):
+		if m.slavoGermanic {
+			m.add("S")
+		} else {
+			m.add2("S", "X")
+		}
+		return index + 3
+	case (index == 0 && m.contains(index+1, 1, "M", "N", "L", "W")) || m.contains(index+1, 1, "Z"):
+		// "smith" and "schmidt", "snider" and "schneider"
+		m.add2("S", "X")
+		if m.contains(index+1, 1, "Z") {
+			return index + 2
+		}
+		return index + 1
+	case m.contains(index, 2, "SC"):
+		return m.handleSC(index)
+	}
+	// French final "-ais", "-ois"
+	if index == m.last() && m.contains(index-2, 2, "AI", "OI") {
+		m.addAlternate("S")
+	} else {
+		m.add("S")
+	}
+	if m.contains(index+1, 1, "S", "Z") {
+		return index + 2
+	}
+	return index + 1
+}
+
+func (m *metaphone) handleSC(index int) int {
+	switch {
+	case m.at(index+2) == 'H':
+		switch {
+		case m.contains(index+3, 2, "ER", "EN"):
+			// Dutch "schermerhorn", "schenker"
+			m.add2("X", "SK")
+		case m.contains(index+3, 2, "OO", "UY", "ED", "EM"):
+			m.add("SK")
+		case index == 0 && !isMetaphoneVowel(m.at(3)) && m.at(3) != 'W':
+			m.add2("X", "S")
+		default:
+			m.add("X")
+		}
+	case m.contains(index+2, 1, "I", "E", "Y"):
+		m.add("S")
+	default:
+		m.add("SK")
+	}
+	return index + 3
+}
+
+func (m *metaphone) handleT(index int) int {
+	switch {
+	case m.contains(index, 4, "TION"), m.contains(index, 3, "TIA", "TCH"):
+		m.add("X")
+		return index + 3
+	case m.contains(index, 2, "TH") || m.contains(index, 3, "TTH"):
+		if m.contains(index+2, 2, "OM", "AM") || m.contains(0, 4, "VAN ", "VON ") || m.contains(0, 3, "SCH") {
+			// "thomas", "thames"
+			m.add("T")
+		} else {
+			m.add2("0", "T")
+		}
+		return index + 2
+	}
+	m.add("T")
+	if m.contains(index+1, 1, "T", "D") {
+		return index + 2
+	}
+	return index + 1
+}
+
+func (m *metaphone) handleW(index int) int {
+	switch {
+	case m.contains(index, 2, "WR"):
+		m.add("R")
+		return index + 2
+	case index == 0 && (isMetaphoneVowel(m.at(index+1)) || m.contains(index, 2, "WH")):
+		// "wasserman" can also be "vasserman"
+		if isMetaphoneVowel(m.at(index + 1)) {
+			m.add2("A", "F")
+		} else {
+			m.add("A")
+		}
+		return index + 1
+	case (index == m.last() && isMetaphoneVowel(m.at(index-1))) ||
+		m.contains(index-1, 5, "EWSKI", "EWSKY", "OWSKI", "OWSKY") || m.contains(0, 3, "SCH"):
+		// Polish "filipowicz"
+		m.addAlternate("F")
+		return index + 1
+	case m.contains(index, 4, "WICZ", "WITZ"):
+		m.add2("TS", "FX")
+		return index + 4
+	}
+	return index + 1
+}
+
+func (m *metaphone) handleX(index int) int {
+	if index == 0 {
+		m.add("S")
+		return index + 1
+	}
+	// French final "-eaux", as in "breaux"
+	if !(index == m.last() && (m.contains(index-3, 3, "IAU", "EAU") || m.contains(index-2, 2, "AU", "OU"))) {
+		m.add("KS")
+	}
+	if m.contains(index+1, 1, "C", "X") {
+		return index + 2
+	}
+	return index + 1
+}
+
+func (m *metaphone) handleZ(index int) int {
+	if m.at(index+1) == 'H' {
+		// Chinese "zhao"
+		m.add("J")
+		return index + 2
+	}
+	if m.contains(index+1, 2, "ZO", "ZI", "ZA") || (m.slavoGermanic && index > 0 && m.at(index-1) != 'T') {
+		m.add2("S", "TS")
+	} else {
+		m.add("S")
+	}
+	return m.skipDouble(index, 'Z')
+}
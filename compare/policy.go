@@ -0,0 +1,184 @@
+package compare
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+// --- VALIDATION RULE FILES ---
+// Datasets differ in what a match is, more than the MatchConfig thresholds
+// can say. A rule file adds rules of its own, checked after those
+// thresholds. It is a JSON document like:
+//
+//	{"rules": [
+//	  {"name": "long-names",
+//	   "when": {"words_a": [6, null], "words_b": [6, null]},
+//	   "reject": {"matched_a": [null, 3]}},
+//	  {"name": "surname", "require": ["last_a", "last_b"]}
+//	]}
+//
+// Conditions bound the counts of the pair by an inclusive [min, max] range,
+// null meaning unbounded: words_a and words_b (words counted for the name),
+// mismatches_a and mismatches_b, and matched_a and matched_b (words less
+// mismatches). A rule applies when all of its "when" conditions hold, or
+// always without any. It then rejects the pair if all of its "reject"
+// conditions hold, or if a position in "require" is a mismatch: first_a,
+// last_a, first_b or last_b, the first and last words counted for the
+// name. A rule with neither rejects every pair it applies to. The rule's
+// name is what explain and the diagnosis report it as.
+//
+// The file is compiled into fixed-size bounds, so checking it doesn't
+// allocate.
+
+// ValidationRules are the compiled rules of a rule file.
+type ValidationRules struct {
+	rules []validationRule
+}
+
+type countField uint8
+
+const (
+	fieldWordsA countField = iota
+	fieldWordsB
+	fieldMismatchesA
+	fieldMismatchesB
+	fieldMatchedA
+	fieldMatchedB
+	numCountFields
+)
+
+var countFieldNames = [numCountFields]string{
+	"words_a", "words_b", "mismatches_a", "mismatches_b", "matched_a", "matched_b",
+}
+
+// Positions of WordCounts.mismatched
+const (
+	positionFirstA uint8 = 1 << iota
+	positionLastA
+	positionFirstB
+	positionLastB
+)
+
+var positionNames = map[string]uint8{
+	"first_a": positionFirstA,
+	"last_a":  positionLastA,
+	"first_b": positionFirstB,
+	"last_b":  positionLastB,
+}
+
+type countBounds [numCountFields]struct{ min, max int }
+
+type validationRule struct {
+	name         string
+	when, reject countBounds
+	hasReject    bool
+	require      uint8
+}
+
+type ruleFile struct {
+	Rules []struct {
+		Name    string             `json:"name"`
+		When    map[string][2]*int `json:"when"`
+		Reject  map[string][2]*int `json:"reject"`
+		Require []string           `json:"require"`
+	} `json:"rules"`
+}
+
+// ParseValidationRules reads and compiles a rule file.
+func ParseValidationRules(r io.Reader) (*ValidationRules, error) {
+	var file ruleFile
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, &InputError{Err: err}
+	}
+	v := &ValidationRules{}
+	for i, raw := range file.Rules {
+		rule := validationRule{name: raw.Name}
+		if rule.name == "" {
+			rule.name = fmt.Sprintf("rule %d", i+1)
+		}
+		var err error
+		if rule.when, err = compileBounds(raw.When); err != nil {
+			return nil, &InputError{Field: rule.name + ": when", Err: err}
+		}
+		if rule.reject, err = compileBounds(raw.Reject); err != nil {
+			return nil, &InputError{Field: rule.name + ": reject", Err: err}
+		}
+		rule.hasReject = len(raw.Reject) > 0
+		for _, p := range raw.Require {
+			bit, ok := positionNames[p]
+			if !ok {
+				return nil, &InputError{Field: rule.name + ": require", Err: fmt.Errorf("unknown position %q (want first_a, last_a, first_b or last_b)", p)}
+			}
+			rule.require |= bit
+		}
+		v.rules = append(v.rules, rule)
+	}
+	return v, nil
+}
+
+func compileBounds(conditions map[string][2]*int) (countBounds, error) {
+	var b countBounds
+	for f := range b {
+		b[f].min, b[f].max = math.MinInt, math.MaxInt
+	}
+	for name, r := range conditions {
+		f := slices.Index(countFieldNames[:], name)
+		if f < 0 {
+			return b, fmt.Errorf("unknown count %q (want %s)", name, strings.Join(countFieldNames[:], ", "))
+		}
+		if r[0] != nil {
+			b[f].min = *r[0]
+		}
+		if r[1] != nil {
+			b[f].max = *r[1]
+		}
+		if b[f].min > b[f].max {
+			return b, fmt.Errorf("%s: empty range [%d, %d]", name, b[f].min, b[f].max)
+		}
+	}
+	return b, nil
+}
+
+// Len returns the number of rules.
+func (v *ValidationRules) Len() int {
+	return len(v.rules)
+}
+
+// reject returns the name of the first rule rejecting a pair with counts c,
+// or "" if none does.
+func (v *ValidationRules) reject(c WordCounts) string {
+	values := [numCountFields]int{
+		c.WordsA, c.WordsB, c.MismatchesA, c.MismatchesB,
+		c.WordsA - c.MismatchesA, c.WordsB - c.MismatchesB,
+	}
+	for i := range v.rules {
+		rule := &v.rules[i]
+		if !rule.when.contain(&values) {
+			continue
+		}
+		switch {
+		case rule.require&c.mismatched != 0:
+			return rule.name
+		case rule.hasReject && rule.reject.contain(&values):
+			return rule.name
+		case !rule.hasReject && rule.require == 0:
+			return rule.name
+		}
+	}
+	return ""
+}
+
+func (b *countBounds) contain(values *[numCountFields]int) bool {
+	for f, v := range values {
+		if v < b[f].min || v > b[f].max {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package compare
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseValidationRulesErrors(t *testing.T) {
+	for _, c := range []struct {
+		doc  string
+		want string
+	}{
+		{`{"rules": [`, "unexpected EOF"},
+		{`{"rulez": []}`, `unknown field "rulez"`},
+		{`{"rules": [{"name": "x", "unless": {}}]}`, `unknown field "unless"`},
+		{`{"rules": [{"name": "x", "when": {"words": [1, 2]}}]}`, `x: when: unknown count "words" (want words_a, words_b,`},
+		{`{"rules": [{"reject": {"matched_a": [3, 2]}}]}`, "rule 1: reject: matched_a: empty range [3, 2]"},
+		{`{"rules": [{"name": "ok"}, {"require": ["middle_a"]}]}`, `rule 2: require: unknown position "middle_a"`},
+		{`{"rules": [{"when": {"words_a": ["2", null]}}]}`, "cannot unmarshal string"},
+	} {
+		_, err := ParseValidationRules(strings.NewReader(c.doc))
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: error %v, want %q", c.doc, err, c.want)
+		}
+	}
+}
+
+func TestValidationRulesReject(t *testing.T) {
+	v, err := ParseValidationRules(strings.NewReader(`{"rules": [
+		{"name": "long-names",
+		 "when": {"words_a": [4, null], "words_b": [4, null]},
+		 "reject": {"matched_a": [null, 2]}},
+		{"name": "surname", "require": ["last_a", "last_b"]},
+		{"when": {"mismatches_a": [3, null]}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Len() != 3 {
+		t.Fatalf("%d rules, want 3", v.Len())
+	}
+	for _, c := range []struct {
+		name   string
+		counts WordCounts
+		want   string
+	}{
+		{"no rule applies", WordCounts{WordsA: 2, WordsB: 2}, ""},
+		{"long names matching enough", WordCounts{WordsA: 4, WordsB: 5, MismatchesA: 1}, ""},
+		{"long names matching too little", WordCounts{WordsA: 4, WordsB: 5, MismatchesA: 2}, "long-names"},
+		// when applies to both names, so a short name is left alone
+		{"one short name", WordCounts{WordsA: 4, WordsB: 3, MismatchesA: 2}, ""},
+		{"surname mismatch", WordCounts{WordsA: 2, WordsB: 3, MismatchesB: 1, mismatched: positionLastB}, "surname"},
+		{"first name mismatch", WordCounts{WordsA: 2, WordsB: 3, MismatchesB: 1, mismatched: positionFirstB}, ""},
+		// A rule without reject or require rejects whatever it applies
+		// to, and is named by its position
+		{"unnamed", WordCounts{WordsA: 6, WordsB: 6, MismatchesA: 3}, "rule 3"},
+		// The first rejecting rule is the one reported
+		{"first rule wins", WordCounts{WordsA: 5, WordsB: 5, MismatchesA: 3, mismatched: positionLastA}, "long-names"},
+	} {
+		if got := v.reject(c.counts); got != c.want {
+			t.Errorf("%s: rejected by %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// A rule file rejects after the thresholds, and explain names the rule.
+func TestValidationRulesInValidation(t *testing.T) {
+	v, err := ParseValidationRules(strings.NewReader(`{"rules": [{"name": "surname", "require": ["last_a", "last_b"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultMatchConfig()
+	cfg.MinCommonWords = 1
+	cfg.Rules = v
+	m := NewMatcher(loadString(t, validateInput), Options{Match: &cfg})
+	for _, c := range []struct {
+		a, b string
+		ok   bool
+	}{
+		{"john smith", "jon smyth", true},
+		{"john smith", "mary smith", true},
+		{"john smith", "john jones", false},
+	} {
+		if ok, _ := m.Validate(c.a, c.b); ok != c.ok {
+			t.Errorf("Validate(%q, %q) = %v, want %v", c.a, c.b, ok, c.ok)
+		}
+	}
+	e, err := m.Explain("john smith", "john jones")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.RejectedBy != "surname" {
+		t.Errorf("explain: rejected by %q, want surname", e.RejectedBy)
+	}
+}
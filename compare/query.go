@@ -0,0 +1,29 @@
+package compare
+
+// Tokenize returns the word IDs of name. A name that isn't in the input is
+// split on the fly like the input's names were, interning any words the
+// dictionary hasn't seen. Words interned this way have no token class, so
+// class policies treat them as ordinary words.
+func (d *Data) Tokenize(name string) []uint32 {
+	if ids, ok := d.NameWords[name]; ok {
+		return ids
+	}
+	return internName(name, d.Dict, d.Normalize, d.Mask)
+}
+
+// Validate checks a single pair of names against the rules, skipping the
+// PairToNames lookup that decides which pairs a run compares. It may intern
+// new words (see Data.Tokenize), so it must not be called concurrently or
+// while Run is in progress.
+func (m *Matcher) Validate(nameA, nameB string) (bool, float64) {
+	if nameA > nameB {
+		nameA, nameB = nameB, nameA
+	}
+	partsA := m.data.Tokenize(nameA)
+	partsB := m.data.Tokenize(nameB)
+	if m.queryBuffer == nil || m.queryBuffer.words() < m.data.Dict.Len() {
+		m.queryBuffer = m.matchBuffer()
+	}
+	ok, counts := validateOptimized(partsA, partsB, m.matches, m.data.Dict, m.queryBuffer, m.rules, m.opts.Match, nil)
+	return ok, counts.Score()
+}
@@ -0,0 +1,129 @@
+package compare
+
+import (
+	"slices"
+	"sync"
+)
+
+// AddQueries marks names as query names, switching the run to two-list
+// mode: only query names are processed, so every emitted pair has at least
+// one query name on a side, while the rest of the corpus is only looked up
+// as reference names. Names that aren't in the corpus yet are appended to
+// AllNames and put in their PairToNames buckets. It returns how many names
+// were appended, which a Data with DiskPairs can't take. AddQueries must be
+// called before ClassifyTokens and before any Matcher is created.
+func (d *Data) AddQueries(names []string) int {
+	if d.Queries == nil {
+		d.Queries = []uint32{}
+	}
+	// Index of the first occurrence of every name
+	first := make(map[string]uint32, len(d.NameIDs))
+	for i := len(d.AllNames) - 1; i >= 0; i-- {
+		first[d.AllNames[i]] = uint32(i)
+	}
+	queued := make(map[uint32]struct{}, len(d.Queries)+len(names))
+	for _, idx := range d.Queries {
+		queued[idx] = struct{}{}
+	}
+
+	added := 0
+	var keys []uint64
+	for _, name := range names {
+		idx, ok := first[name]
+		if !ok {
+			idx = uint32(len(d.AllNames))
+			first[name] = idx
+			d.AllNames = append(d.AllNames, name)
+			// The name may already be in a bucket of the input
+			id, known := d.NameIDs[name]
+			if !known {
+				id = uint32(len(d.Names))
+				d.NameIDs[name] = id
+				d.Names = append(d.Names, name)
+			}
+			d.NameWords[name] = d.Tokenize(name)
+			added++
+
+			keys = simplePairKeys(d.NameWords[name], keys[:0])
+			for _, key := range keys {
+				if !known || !slices.Contains(d.PairToNames[key], id) {
+					d.PairToNames[key] = append(d.PairToNames[key], id)
+				}
+			}
+		}
+		if _, ok := queued[idx]; !ok {
+			queued[idx] = struct{}{}
+			d.Queries = append(d.Queries, idx)
+		}
+	}
+	return added
+}
+
+// isQuery reports, by name ID, which names are query names.
+func (d *Data) isQuery() []bool {
+	flags := make([]bool, len(d.Names))
+	for _, idx := range d.Queries {
+		flags[d.NameIDs[d.AllNames[idx]]] = true
+	}
+	return flags
+}
+
+// reverseCandidates finds the pairs a two-list run would otherwise miss.
+// Candidates are found from one side only: a name is compared with the
+// names in the buckets of its expanded pair keys, and tradeouts need not be
+// symmetric, so a reference name can find a query name that doesn't find it
+// back. Every reference name's expanded keys are looked up in the buckets
+// holding query names, and the reference name is recorded for each query
+// name found there.
+func (m *Matcher) reverseCandidates() map[uint32][]uint32 {
+	data := m.data
+	queryBuckets := make(map[uint64][]uint32)
+	for _, key := range data.bucketKeys() {
+		bucket, _ := data.bucket(key)
+		for _, id := range bucket {
+			if m.isQuery[id] {
+				queryBuckets[key] = append(queryBuckets[key], id)
+			}
+		}
+	}
+
+	workers := m.opts.Workers
+	chunk := (len(data.Names) + workers - 1) / workers
+	locals := make([]map[uint32][]uint32, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		start, end := min(w*chunk, len(data.Names)), min((w+1)*chunk, len(data.Names))
+		wg.Add(1)
+		go func(w, start, end int) {
+			defer wg.Done()
+			local := make(map[uint32][]uint32)
+			found := make(map[uint32]struct{})
+			var scratch pairScratch
+			for id := start; id < end; id++ {
+				parts := data.NameWords[data.Names[id]]
+				if len(parts) < 2 || m.isQuery[id] {
+					continue
+				}
+				clear(found)
+				for _, key := range m.pairKeys(parts, &scratch) {
+					for _, query := range queryBuckets[key] {
+						if _, ok := found[query]; !ok {
+							found[query] = struct{}{}
+							local[query] = append(local[query], uint32(id))
+						}
+					}
+				}
+			}
+			locals[w] = local
+		}(w, start, end)
+	}
+	wg.Wait()
+
+	reverse := locals[0]
+	for _, local := range locals[1:] {
+		for id, list := range local {
+			reverse[id] = append(reverse[id], list...)
+		}
+	}
+	return reverse
+}
@@ -0,0 +1,126 @@
+package compare
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// --- PAIR SETS ---
+// Pairs of names are keyed by their two name IDs packed into a uint64, lower
+// ID first, so a lookup finds the pair no matter which side is being processed.
+// The word pairs keying PairToNames are packed the same way from word IDs.
+
+func packPair(a, b uint32) uint64 {
+	if a > b {
+		a, b = b, a
+	}
+	return uint64(a)<<32 | uint64(b)
+}
+
+func unpackPair(key uint64) (uint32, uint32) {
+	return uint32(key >> 32), uint32(key)
+}
+
+type reviewState uint8
+
+const (
+	reviewNone reviewState = iota
+	reviewConfirmed
+	reviewRejected
+	reviewUnsure
+)
+
+var reviewStateNames = map[string]reviewState{
+	"confirmed": reviewConfirmed,
+	"rejected":  reviewRejected,
+	"unsure":    reviewUnsure,
+}
+
+// ReviewStates holds the reviewer decisions for pairs whose names both
+// exist in the corpus.
+type ReviewStates struct {
+	states     map[uint64]reviewState
+	counts     [4]int
+	absentRows int
+}
+
+// Counts reports how many pairs are in each state, and how many rows were
+// dropped because they named a name that isn't in the corpus.
+func (r *ReviewStates) Counts() (confirmed, rejected, unsure, absent int) {
+	return r.counts[reviewConfirmed], r.counts[reviewRejected], r.counts[reviewUnsure], r.absentRows
+}
+
+func (r *ReviewStates) lookup(a, b uint32) reviewState {
+	return r.states[packPair(a, b)]
+}
+
+// NewReviewStates returns an empty set of review states, for pairs added
+// with AddConfirmed.
+func NewReviewStates() *ReviewStates {
+	return &ReviewStates{states: make(map[uint64]reviewState)}
+}
+
+// AddConfirmed confirms the pair of names a and b, so it is emitted without
+// validation, unless the pair already has a review state. It returns
+// whether the pair was added; a pair naming a name that isn't in data is
+// counted like an absent review row.
+func (r *ReviewStates) AddConfirmed(data *Data, a, b string) bool {
+	idA, okA := data.NameIDs[a]
+	idB, okB := data.NameIDs[b]
+	if !okA || !okB {
+		r.absentRows++
+		return false
+	}
+	key := packPair(idA, idB)
+	if _, ok := r.states[key]; ok || idA == idB {
+		return false
+	}
+	r.states[key] = reviewConfirmed
+	r.counts[reviewConfirmed]++
+	return true
+}
+
+// LoadReviewStates reads a name_a,name_b,state CSV. A header row is optional.
+// Rows naming a name that isn't in data are counted and dropped; when a pair
+// appears more than once the last row wins.
+func LoadReviewStates(rd io.Reader, data *Data) (*ReviewStates, error) {
+	r := &ReviewStates{states: make(map[uint64]reviewState)}
+
+	reader := csv.NewReader(bufio.NewReader(rd))
+	reader.FieldsPerRecord = 3
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, csvError("", err)
+		}
+		if line == 1 && record[0] == "name_a" && record[1] == "name_b" {
+			continue
+		}
+		state, ok := reviewStateNames[strings.ToLower(strings.TrimSpace(record[2]))]
+		if !ok {
+			return nil, &InputError{Line: line, Err: fmt.Errorf("unknown review state %q", record[2])}
+		}
+		idA, okA := data.NameIDs[record[0]]
+		idB, okB := data.NameIDs[record[1]]
+		if !okA || !okB {
+			r.absentRows++
+			continue
+		}
+		if idA == idB {
+			continue
+		}
+		key := packPair(idA, idB)
+		if prev, ok := r.states[key]; ok {
+			r.counts[prev]--
+		}
+		r.states[key] = state
+		r.counts[state]++
+	}
+	return r, nil
+}
@@ -0,0 +1,124 @@
+package compare
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const smallReview = `name_a,name_b,state
+john smith,jon smith,rejected
+jon smith,john smyth,UNSURE
+mary jones,mary smith,confirmed
+john smith,john smyth,unsure
+john smith,john smyth,confirmed
+john smith,nobody,rejected
+`
+
+func TestLoadReviewStates(t *testing.T) {
+	data := loadString(t, smallInput)
+	review, err := LoadReviewStates(strings.NewReader(smallReview), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last row of a pair wins
+	confirmed, rejected, unsure, absent := review.Counts()
+	if confirmed != 2 || rejected != 1 || unsure != 1 || absent != 1 {
+		t.Errorf("counts %d confirmed, %d rejected, %d unsure, %d absent; want 2, 1, 1, 1", confirmed, rejected, unsure, absent)
+	}
+	for pair, want := range map[[2]string]reviewState{
+		{"jon smith", "john smith"}:  reviewRejected,
+		{"john smyth", "jon smith"}:  reviewUnsure,
+		{"john smith", "john smyth"}: reviewConfirmed,
+		{"john smith", "mary jones"}: reviewNone,
+	} {
+		if got := review.lookup(data.NameIDs[pair[0]], data.NameIDs[pair[1]]); got != want {
+			t.Errorf("%q: state %d, want %d", pair, got, want)
+		}
+	}
+	var input *InputError
+	if _, err := LoadReviewStates(strings.NewReader("john smith,jon smith,maybe\n"), data); !errors.As(err, &input) || input.Line != 1 {
+		t.Errorf("an unknown state: %v, want an InputError on line 1", err)
+	}
+}
+
+// An included pair is confirmed unless a review row already gives it a
+// state.
+func TestAddConfirmed(t *testing.T) {
+	data := loadString(t, smallInput)
+	review, err := LoadReviewStates(strings.NewReader(smallReview), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		a, b string
+		want bool
+	}{
+		{"john smith", "mary jones", true},
+		{"mary jones", "john smith", false},
+		{"jon smith", "john smith", false},
+		{"john smith", "nobody", false},
+		{"mary jones", "mary jones", false},
+	} {
+		if got := review.AddConfirmed(data, c.a, c.b); got != c.want {
+			t.Errorf("AddConfirmed(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+	if got := review.lookup(data.NameIDs["jon smith"], data.NameIDs["john smith"]); got != reviewRejected {
+		t.Errorf("a rejected pair included: state %d", got)
+	}
+	confirmed, _, _, absent := review.Counts()
+	if confirmed != 3 || absent != 2 {
+		t.Errorf("counts %d confirmed, %d absent; want 3, 2", confirmed, absent)
+	}
+}
+
+// Confirmed pairs are emitted once whether or not they validate, rejected
+// ones never, and unsure ones as found, tagged.
+func TestRunReviewStates(t *testing.T) {
+	data := loadString(t, smallInput)
+	review, err := LoadReviewStates(strings.NewReader(smallReview), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []Options{
+		{Workers: 1, Review: review},
+		{Workers: 4, Review: review},
+		{Workers: 4, Review: review, SkipSymmetric: true},
+		{Workers: 4, Review: review, CollapseDuplicates: true},
+	} {
+		var mu sync.Mutex
+		emitted := make(map[string]int)
+		err := NewMatcher(data, opts).Run(context.Background(), func(p Pair) {
+			mu.Lock()
+			defer mu.Unlock()
+			emitted[p.A+"|"+p.B+"|"+p.Tag]++
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Unsure pairs are found from both names, like untagged ones,
+		// unless SkipSymmetric validates them once
+		unsure := 2
+		if opts.SkipSymmetric {
+			unsure = 1
+		}
+		want := map[string]int{
+			"john smith|john smyth|confirmed": 1,
+			// Fails validation, emitted all the same
+			"mary jones|mary smith|confirmed": 1,
+			"john smyth|jon smith|unsure":     unsure,
+		}
+		if len(emitted) != len(want) {
+			t.Errorf("%d workers: emitted %v, want %v", opts.Workers, emitted, want)
+			continue
+		}
+		for pair, n := range want {
+			if emitted[pair] != n {
+				t.Errorf("%d workers: %s emitted %d times, want %d", opts.Workers, pair, emitted[pair], n)
+			}
+		}
+	}
+}
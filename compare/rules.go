@@ -0,0 +1,99 @@
+package compare
+
+import (
+	"slices"
+	"strings"
+)
+
+// Sources of match rules: the input's word_to_matches, and the layers
+// added after loading (see addMatchGroup).
+const (
+	SourceWordToMatches = "word_to_matches"
+	SourcePhonetic      = "phonetic"
+	SourceNicknames     = "nicknames"
+)
+
+// WordRules is the fully resolved match set of one word.
+type WordRules struct {
+	Word string `json:"word"`
+	// False when the word appears nowhere in the input
+	Known bool   `json:"known"`
+	Class string `json:"class"`
+	// Number of distinct names containing the word
+	Frequency int         `json:"frequency"`
+	Matches   []RuleEntry `json:"matches"`
+}
+
+// RuleEntry is one word in a match set, with where it came from and what
+// it takes part in.
+type RuleEntry struct {
+	Word   string `json:"word"`
+	Source string `json:"source"`
+	// Used when validating a pair
+	Validation bool `json:"validation"`
+	// Used to generate pair keys, i.e. to find candidates
+	Tradeout bool `json:"tradeout"`
+}
+
+// RuleResolver answers WordRules queries outside the hot path.
+type RuleResolver struct {
+	data      *Data
+	frequency map[uint32]int
+}
+
+func NewRuleResolver(data *Data) *RuleResolver {
+	frequency := make(map[uint32]int)
+	for _, ids := range data.NameWords {
+		for i, id := range ids {
+			if !slices.Contains(ids[:i], id) {
+				frequency[id]++
+			}
+		}
+	}
+	return &RuleResolver{data: data, frequency: frequency}
+}
+
+// Resolve returns the match set of word, normalizing and folding it first
+// as the data was loaded.
+func (r *RuleResolver) Resolve(word string) WordRules {
+	data := r.data
+	word = data.Normalize.Apply(word)
+	if data.FoldCase {
+		word = strings.ToLower(word)
+	}
+	rules := WordRules{Word: word, Matches: []RuleEntry{}}
+	id, ok := data.Dict.Lookup(word)
+	if !ok {
+		rules.Class = classifyWord(word, nil).String()
+		return rules
+	}
+	rules.Known = true
+	rules.Frequency = r.frequency[id]
+	if int(id) < len(data.Classes) {
+		rules.Class = data.Classes[id].String()
+	} else {
+		rules.Class = classifyWord(word, nil).String()
+	}
+
+	tradeouts := data.TradeoutSets[id]
+	for _, m := range data.WordToMatches[id] {
+		rules.Matches = append(rules.Matches, RuleEntry{
+			Word:       data.Dict.GetStr(m),
+			Source:     data.matchSource(id, m),
+			Validation: true,
+			Tradeout:   slices.Contains(tradeouts, m),
+		})
+	}
+	// A one-letter word's only tradeout is itself, whether or not it lists
+	// itself as a match
+	for _, t := range tradeouts {
+		if !slices.Contains(data.WordToMatches[id], t) {
+			rules.Matches = append(rules.Matches, RuleEntry{
+				Word:     data.Dict.GetStr(t),
+				Source:   SourceWordToMatches,
+				Tradeout: true,
+			})
+		}
+	}
+	return rules
+}
@@ -0,0 +1,132 @@
+package compare
+
+// --- SYMMETRIC CANDIDATES ---
+// Every name is a job, so a pair is usually found from both of its names
+// and validated twice. The merge drops the second copy, but the work is
+// done. Whether B is among A's candidates depends on A's expanded pair
+// keys and B's buckets, which isn't symmetric in general: tradeouts can go
+// one way only (an initial never stands in for the full word), and buckets
+// given with the input can hold anything. But when
+//
+//   - every name sits in the bucket of each pair of its words and nowhere
+//     else, as BuildPairIndex (and the Python package) builds them, and
+//   - a word standing in for another can be stood in for by it,
+//
+// B finds A whenever A finds B. With Options.SkipSymmetric each pair is then
+// validated only from the name whose job comes first by name ID, and the
+// other name skips it. Names left to a duplicate (see collapseDuplicates)
+// go by their group's name, whose job handles them.
+
+// symmetricCandidates reports whether every name is found by each of its
+// candidates in turn.
+func (m *Matcher) symmetricCandidates() bool {
+	data := m.data
+	// Class policies drop words from the lookup side only, and two-list
+	// runs have their own reverse candidates
+	if m.rules != nil || data.Queries != nil {
+		return false
+	}
+	if !symmetricTradeouts(data.TradeoutSets) {
+		return false
+	}
+	// Checking buckets on disk would read them all
+	return data.PairIndexBuilt || (data.DiskPairs == nil && data.simplePairIndex())
+}
+
+// symmetricTradeouts reports whether each word is a tradeout of its own
+// tradeouts.
+func symmetricTradeouts(tradeouts map[uint32][]uint32) bool {
+	for w, set := range tradeouts {
+	next:
+		for _, t := range set {
+			if t == w {
+				continue
+			}
+			for _, back := range tradeouts[t] {
+				if back == w {
+					continue next
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// simplePairIndex reports whether PairToNames is what BuildPairIndex would
+// build: each name in the bucket of each pair of its words, once, and in no
+// other bucket.
+func (d *Data) simplePairIndex() bool {
+	// 1 + the number of the last bucket each name was seen in
+	seen := make([]uint32, len(d.Names))
+	entries, bucketNum := 0, uint32(0)
+	for key, bucket := range d.PairToNames {
+		bucketNum++
+		a, b := uint32(key>>32), uint32(key)
+		for _, id := range bucket {
+			if seen[id] == bucketNum {
+				return false
+			}
+			seen[id] = bucketNum
+			words, ok := d.NameWords[d.Names[id]]
+			if !ok || !hasWordPair(words, a, b) {
+				return false
+			}
+			entries++
+		}
+	}
+	// Every entry is one of the names' pairs, so there are no more pairs
+	// than entries; equal counts mean none is missing
+	want := 0
+	var keys []uint64
+	for _, name := range d.Names {
+		keys = simplePairKeys(d.NameWords[name], keys[:0])
+		want += len(keys)
+	}
+	return entries == want
+}
+
+// hasWordPair reports whether a and b are two different positions of words.
+func hasWordPair(words []uint32, a, b uint32) bool {
+	foundA, foundB := false, false
+	for _, w := range words {
+		switch {
+		case w == a && foundA && a == b:
+			return true
+		case w == a:
+			foundA = true
+			if a != b && foundB {
+				return true
+			}
+		case w == b:
+			foundB = true
+			if foundA {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// owners returns the name ID each name's pairs are validated from: its own,
+// or for a duplicate that of the first name of its group.
+func (m *Matcher) owners() []uint32 {
+	data := m.data
+	owners := make([]uint32, len(data.Names))
+	for id := range owners {
+		owners[id] = uint32(id)
+	}
+	for rep, dups := range m.duplicates {
+		repID := data.NameIDs[data.AllNames[rep]]
+		for _, dup := range dups {
+			owners[data.NameIDs[data.AllNames[dup]]] = repID
+		}
+	}
+	return owners
+}
+
+// Symmetric reports whether Run validated each pair from one of its names
+// only (see Options.SkipSymmetric).
+func (m *Matcher) Symmetric() bool {
+	return m.owner != nil
+}
@@ -0,0 +1,40 @@
+package compare
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Skipping symmetric pairs only leaves out the second copy of each pair,
+// which the merge would drop anyway.
+func TestSkipSymmetricEquivalence(t *testing.T) {
+	inputs := []string{smallInput, validateInput, "{" + underscoreNames + "}"}
+	for seed := range uint64(6) {
+		doc := randomInput(seed, 300, seed%2 == 1)
+		// Also with pair_to_names given the way the Python package writes it
+		names := loadString(t, doc).AllNames
+		inputs = append(inputs, doc, strings.TrimSuffix(doc, "}")+", "+pythonPairIndex(names)+"}")
+	}
+	skipped, kept := 0, 0
+	for i, doc := range inputs {
+		data := loadString(t, doc)
+		for _, workers := range []int{1, 4} {
+			want := slices.Compact(runOutput(t, data, Options{Workers: workers}))
+			opts := Options{Workers: workers, SkipSymmetric: true}
+			if NewMatcher(data, opts).symmetricCandidates() {
+				skipped++
+			} else {
+				kept++
+			}
+			got := runOutput(t, data, opts)
+			if !slices.Equal(slices.Compact(got), want) {
+				t.Errorf("input %d, %d workers: %d distinct pairs with SkipSymmetric, %d without", i, workers, len(slices.Compact(got)), len(want))
+			}
+		}
+	}
+	// One-way initials turn skipping off; the other inputs use it
+	if skipped == 0 || kept == 0 {
+		t.Errorf("%d runs skipped symmetric pairs and %d didn't, want some of each", skipped, kept)
+	}
+}
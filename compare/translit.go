@@ -0,0 +1,89 @@
+package compare
+
+import (
+	"strings"
+	"unicode"
+)
+
+// --- TRANSLITERATION ---
+// The translit normalization step spells Cyrillic and Greek letters in
+// Latin, so "Иван Петров" interns like "Ivan Petrov". The schemes are the
+// usual simplified romanizations for names (ж zh, х kh, щ shch, θ th, ου
+// ou); signs without a sound of their own (ъ, ь) are dropped. A capital
+// letter gives a capitalized spelling ("Ж" -> "Zh"). Other scripts are left
+// as they are: CJK needs a dictionary rather than a letter table.
+
+func transliterate(s string) string {
+	if !hasTranslitRune(s) {
+		return s
+	}
+	var sb strings.Builder
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		// ου is one vowel, "ou" rather than "oy"
+		if (r == 'ο' || r == 'Ο') && i+1 < len(runes) && (runes[i+1] == 'υ' || runes[i+1] == 'Υ' || runes[i+1] == 'ύ') {
+			if r == 'Ο' {
+				sb.WriteString("O")
+			} else {
+				sb.WriteString("o")
+			}
+			if runes[i+1] == 'Υ' {
+				sb.WriteString("U")
+			} else {
+				sb.WriteString("u")
+			}
+			i++
+			continue
+		}
+		if latin, ok := translitTable[r]; ok {
+			sb.WriteString(latin)
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+func hasTranslitRune(s string) bool {
+	for _, r := range s {
+		if _, ok := translitTable[r]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// translitTable holds the lowercase letters and, derived from them, their
+// capitals.
+var translitTable = func() map[rune]string {
+	lower := map[rune]string{
+		// Russian
+		'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+		'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+		'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+		'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+		'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+		// Ukrainian, Belarusian, Serbian and Macedonian
+		'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g", 'ў': "u", 'ђ': "dj",
+		'ј': "j", 'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz", 'ѓ': "gj",
+		'ќ': "kj", 'ѕ': "dz",
+		// Greek, with the accented vowels
+		'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i",
+		'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+		'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y",
+		'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+		'ά': "a", 'έ': "e", 'ή': "i", 'ί': "i", 'ό': "o", 'ύ': "y", 'ώ': "o",
+		'ϊ': "i", 'ϋ': "y", 'ΐ': "i", 'ΰ': "y",
+	}
+	m := make(map[rune]string, 2*len(lower))
+	for r, latin := range lower {
+		m[r] = latin
+		if upper := unicode.ToUpper(r); upper != r && latin != "" {
+			m[upper] = strings.ToUpper(latin[:1]) + latin[1:]
+		} else if upper != r {
+			m[upper] = ""
+		}
+	}
+	return m
+}()
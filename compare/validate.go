@@ -0,0 +1,393 @@
+package compare
+
+// MatchConfig holds the thresholds validateOptimized applies once the
+// mismatches on both sides are counted. DefaultMatchConfig reproduces the
+// original hardcoded rules.
+type MatchConfig struct {
+	// Each side needs at least this many words that matched the other side
+	MinCommonWords int
+	// A name whose length is in this list may not have any mismatch when the
+	// other name is at least as long
+	StrictLengths []int
+	// Upper bound on mismatches per side; negative means no limit
+	MaxMismatches int
+	// Mismatches allowed per side by name lengths (see ParseMismatchTable),
+	// checked along with the rules above; empty for none
+	MismatchTable []MismatchBucket
+	// Pairs scoring below this are rejected even if they pass the rules
+	// above (see validateOptimized); 0 keeps every pair
+	MinScore float64
+	// A word that matched nothing through word_to_matches still matches a
+	// word of the other name with at least this Jaro-Winkler similarity
+	// (see fuzzyMatch); 0 turns the fallback off
+	FuzzyThreshold float64
+	// A word that matched nothing otherwise still matches a word of the
+	// other name within this many single-rune edits, if both are at least
+	// EditMinLength runes long (see editMatch); 0 turns the fallback off
+	MaxEditDistance int
+	EditMinLength   int
+	// Only the shorter name's mismatches count, so a pair passes when the
+	// shorter name is contained in the longer one. Of two names of equal
+	// length, the one with fewer mismatches counts.
+	Asymmetric bool
+	// Pairs whose weighted score (see WordCounts.WeightedScore) is below
+	// this are rejected; 0 keeps every pair. Needs Weights.
+	MinWeightedScore float64
+	Weights          *TokenWeights
+	// Rules of a rule file, checked after the thresholds above; nil for
+	// none
+	Rules *ValidationRules
+	// Word pairs that reject a pair whatever the rules above say; nil for
+	// none
+	Blocklist *WordBlocklist
+}
+
+func DefaultMatchConfig() MatchConfig {
+	return MatchConfig{MinCommonWords: 2, StrictLengths: []int{3}, MaxMismatches: -1}
+}
+
+func (c *MatchConfig) isStrict(length int) bool {
+	for _, l := range c.StrictLengths {
+		if l == length {
+			return true
+		}
+	}
+	return false
+}
+
+// WordCounts are the counts validateOptimized decides a pair on. Words
+// leaves out tokens of ignored classes, so it may be less than the number
+// of tokens in the name.
+type WordCounts struct {
+	WordsA, WordsB           int
+	MismatchesA, MismatchesB int
+	// Which of the first and last counted words of each name are
+	// mismatches, as position bits (see ValidationRules)
+	mismatched uint8
+	// Total and mismatched IDF weight of the counted words, when
+	// MatchConfig.Weights is set
+	weightA, weightB                 float64
+	mismatchWeightA, mismatchWeightB float64
+}
+
+// Score is the fraction of each name's words that matched the other name,
+// averaged over both names.
+func (c WordCounts) Score() float64 {
+	return (matchedFraction(c.WordsA, c.MismatchesA) + matchedFraction(c.WordsB, c.MismatchesB)) / 2
+}
+
+// WeightedScore is Score with every word counting by its IDF weight (see
+// TokenWeights), or 0 if validation had no weights.
+func (c WordCounts) WeightedScore() float64 {
+	return (matchedWeight(c.weightA, c.mismatchWeightA) + matchedWeight(c.weightB, c.mismatchWeightB)) / 2
+}
+
+// validationTrace records the decisions of one validateOptimized call, for
+// Explain. Outcomes are indexed like the name's words.
+type validationTrace struct {
+	outcomesA, outcomesB []wordOutcome
+	// Name of the threshold that rejected the pair, empty if it passed
+	rule string
+}
+
+type wordOutcome uint8
+
+const (
+	outcomeMatched wordOutcome = iota
+	outcomeMismatch
+	outcomeDuplicate
+	outcomeIgnored
+	outcomeFirstLetter
+	outcomeFuzzy
+	outcomeEdit
+)
+
+var wordOutcomeNames = [...]string{
+	outcomeMatched:     "matched",
+	outcomeMismatch:    "mismatch",
+	outcomeDuplicate:   "duplicate",
+	outcomeIgnored:     "ignored",
+	outcomeFirstLetter: "first-letter",
+	outcomeFuzzy:       "jaro-winkler",
+	outcomeEdit:        "edit-distance",
+}
+
+func (o wordOutcome) String() string {
+	return wordOutcomeNames[o]
+}
+
+// Rule names recorded in validationTrace.rule
+const (
+	ruleStrictLength   = "strict-length"
+	ruleMinCommonWords = "min-common-words"
+	ruleMaxMismatches  = "max-mismatches"
+	ruleMismatchTable  = "mismatch-table"
+	ruleMinScore       = "min-score"
+	ruleWeightedScore  = "min-weighted-score"
+	ruleBlocklist      = "word-blocklist"
+	ruleSuffix         = "suffix"
+)
+
+// validateOptimized performs the check with ZERO allocations. rules is nil
+// unless token class policies are in use. The counts, and with them the
+// score, are returned even when the pair is rejected. trace is nil except
+// when explaining a pair or counting rejections for Diagnosis.
+func validateOptimized(
+	partsA []uint32,
+	partsB []uint32,
+	matches *matchSets,
+	dict *Dictionary,
+	buffer *matchBuffer,
+	rules *classRules,
+	cfg *MatchConfig,
+	trace *validationTrace,
+) (bool, WordCounts) {
+	lenA := len(partsA)
+	lenB := len(partsB)
+	if rules != nil {
+		lenA = rules.effectiveLen(partsA)
+		lenB = rules.effectiveLen(partsB)
+	}
+
+	// --- Step 1: Check Mismatches in A (relative to B) ---
+
+	// Populate Buffer with matches from B
+	var hubs hubSet
+	matches.paint(partsB, buffer, &hubs)
+
+	// Check A against Buffer
+	mismatchesA := 0
+	// Words counted so far, and whether the last of them mismatched
+	countedA, lastMismatchA := 0, false
+	var mismatched uint8
+	var weightA, mismatchWeightA float64
+	for i := 0; i < len(partsA); i++ {
+		wID := partsA[i]
+		// Naive dupe check
+		isDupe := false
+		for k := 0; k < i; k++ {
+			if partsA[k] == wID {
+				isDupe = true
+				break
+			}
+		}
+		if isDupe {
+			trace.recordA(i, outcomeDuplicate)
+			continue
+		}
+		if rules != nil && rules.ignored(wID) {
+			trace.recordA(i, outcomeIgnored)
+			continue
+		}
+		countedA++
+		lastMismatchA = false
+		if cfg.Weights != nil {
+			weightA += cfg.Weights.weight(wID)
+		}
+
+		if hubs.matched(wID, buffer) {
+			trace.recordA(i, outcomeMatched)
+			continue
+		}
+		if rules != nil && rules.firstLetterMatch(wID, partsB) {
+			trace.recordA(i, outcomeFirstLetter)
+			continue
+		}
+		if o, ok := cfg.fallback(wID, partsB, dict, rules); ok {
+			trace.recordA(i, o)
+			continue
+		}
+		trace.recordA(i, outcomeMismatch)
+		mismatchesA++
+		lastMismatchA = true
+		if cfg.Weights != nil {
+			mismatchWeightA += cfg.Weights.weight(wID)
+		}
+		if countedA == 1 {
+			mismatched |= positionFirstA
+		}
+	}
+	if lastMismatchA {
+		mismatched |= positionLastA
+	}
+
+	matches.unpaint(partsB, buffer)
+
+	// --- Step 2: Check Mismatches in B (relative to A) ---
+	// paint starts a new phase of the buffer (see matchBuffer)
+
+	// Populate Buffer with matches from A
+	matches.paint(partsA, buffer, &hubs)
+
+	// Check B against Buffer
+	mismatchesB := 0
+	countedB, lastMismatchB := 0, false
+	var weightB, mismatchWeightB float64
+	for i := 0; i < len(partsB); i++ {
+		wID := partsB[i]
+		isDupe := false
+		for k := 0; k < i; k++ {
+			if partsB[k] == wID {
+				isDupe = true
+				break
+			}
+		}
+		if isDupe {
+			trace.recordB(i, outcomeDuplicate)
+			continue
+		}
+		if rules != nil && rules.ignored(wID) {
+			trace.recordB(i, outcomeIgnored)
+			continue
+		}
+		countedB++
+		lastMismatchB = false
+		if cfg.Weights != nil {
+			weightB += cfg.Weights.weight(wID)
+		}
+
+		if hubs.matched(wID, buffer) {
+			trace.recordB(i, outcomeMatched)
+			continue
+		}
+		if rules != nil && rules.firstLetterMatch(wID, partsA) {
+			trace.recordB(i, outcomeFirstLetter)
+			continue
+		}
+		if o, ok := cfg.fallback(wID, partsA, dict, rules); ok {
+			trace.recordB(i, o)
+			continue
+		}
+		trace.recordB(i, outcomeMismatch)
+		mismatchesB++
+		lastMismatchB = true
+		if cfg.Weights != nil {
+			mismatchWeightB += cfg.Weights.weight(wID)
+		}
+		if countedB == 1 {
+			mismatched |= positionFirstB
+		}
+	}
+	if lastMismatchB {
+		mismatched |= positionLastB
+	}
+	matches.unpaint(partsA, buffer)
+
+	// The longer name's words needn't all be in the shorter one
+	if cfg.Asymmetric {
+		if lenA < lenB || (lenA == lenB && mismatchesA <= mismatchesB) {
+			mismatchesB, mismatchWeightB = 0, 0
+			mismatched &^= positionFirstB | positionLastB
+		} else {
+			mismatchesA, mismatchWeightA = 0, 0
+			mismatched &^= positionFirstA | positionLastA
+		}
+	}
+
+	counts := WordCounts{
+		WordsA:          lenA,
+		WordsB:          lenB,
+		MismatchesA:     mismatchesA,
+		MismatchesB:     mismatchesB,
+		mismatched:      mismatched,
+		weightA:         weightA,
+		weightB:         weightB,
+		mismatchWeightA: mismatchWeightA,
+		mismatchWeightB: mismatchWeightB,
+	}
+
+	// Disagreeing generational suffixes reject the pair whatever the counts
+	if rules != nil && rules.suffixConflict(partsA, partsB) {
+		return trace.reject(ruleSuffix), counts
+	}
+
+	// --- Step 3: Thresholds (Variable Mapping Correction) ---
+	// Python: num_mismatches_a = len(set(name_b) - matches_of_a)
+	// Go: mismatchesA = words in A - matches of B (This maps to Python's mismatches_b)
+
+	// Python: if (len_a == 3) and (num_mismatches_a) and (len_b >= 3): return False
+	// (Where len_a is name_b length).
+	// So strict translation: if len(B)==3 and mismatches(in B relative to A) > 0 and len(A) >= 3
+	// The 3 is cfg.StrictLengths, generalised to any listed length L.
+
+	if mismatchesB > 0 && cfg.isStrict(lenB) && lenA >= lenB {
+		return trace.reject(ruleStrictLength), counts
+	}
+	if mismatchesA > 0 && cfg.isStrict(lenA) && lenB >= lenA {
+		return trace.reject(ruleStrictLength), counts
+	}
+
+	// Python: if (len_b - num_mismatches_b < 2) or (len_a - num_mismatches_a < 2)
+	// Python len_b is Name A. Python num_mismatches_b is mismatches in A.
+	// The 2 is cfg.MinCommonWords.
+
+	if (lenA-mismatchesA < cfg.MinCommonWords) || (lenB-mismatchesB < cfg.MinCommonWords) {
+		return trace.reject(ruleMinCommonWords), counts
+	}
+
+	if cfg.MaxMismatches >= 0 && (mismatchesA > cfg.MaxMismatches || mismatchesB > cfg.MaxMismatches) {
+		return trace.reject(ruleMaxMismatches), counts
+	}
+
+	if allowed, ok := cfg.allowedMismatches(lenA, lenB); ok && (mismatchesA > allowed || mismatchesB > allowed) {
+		return trace.reject(ruleMismatchTable), counts
+	}
+
+	if counts.Score() < cfg.MinScore {
+		return trace.reject(ruleMinScore), counts
+	}
+
+	if cfg.Weights != nil && counts.WeightedScore() < cfg.MinWeightedScore {
+		return trace.reject(ruleWeightedScore), counts
+	}
+
+	if cfg.Rules != nil {
+		if rule := cfg.Rules.reject(counts); rule != "" {
+			return trace.reject(rule), counts
+		}
+	}
+
+	if cfg.Blocklist != nil && cfg.Blocklist.blocks(partsA, partsB) {
+		return trace.reject(ruleBlocklist), counts
+	}
+
+	return true, counts
+}
+
+func matchedFraction(length, mismatches int) float64 {
+	if length == 0 {
+		return 0
+	}
+	return float64(length-mismatches) / float64(length)
+}
+
+func matchedWeight(weight, mismatched float64) float64 {
+	if weight == 0 {
+		return 0
+	}
+	return (weight - mismatched) / weight
+}
+
+// The trace methods are no-ops on a nil trace, so the hot path only pays for
+// a nil check. A trace without outcome slices, as the workers of a run keep
+// for Diagnosis, only records the rule.
+
+func (t *validationTrace) recordA(i int, o wordOutcome) {
+	if t != nil && t.outcomesA != nil {
+		t.outcomesA[i] = o
+	}
+}
+
+func (t *validationTrace) recordB(i int, o wordOutcome) {
+	if t != nil && t.outcomesB != nil {
+		t.outcomesB[i] = o
+	}
+}
+
+// reject notes the rule that failed and returns false.
+func (t *validationTrace) reject(rule string) bool {
+	if t != nil {
+		t.rule = rule
+	}
+	return false
+}
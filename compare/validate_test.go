@@ -0,0 +1,168 @@
+package compare
+
+import (
+	"strings"
+	"testing"
+)
+
+// Words and matches for the validation tables: a name's words match the
+// words listed for them, and "zed" is missing from word_to_matches
+const validateInput = `{
+	"all_names": ["john smith", "jon smith", "john smyth", "mary jones", "mary ann smith", "john paul smith", "jon paul smyth", "john paul jones smith", "jon paul jones smyth", "john john smith", "smith john", "ann", "zed smith"],
+	"word_to_matches": {
+		"john": ["john", "jon"], "jon": ["jon", "john"],
+		"smith": ["smith", "smyth"], "smyth": ["smyth", "smith"],
+		"mary": ["mary"], "jones": ["jones"], "ann": ["ann", "anne"], "anne": ["anne", "ann"],
+		"paul": ["paul"]
+	}
+}`
+
+// baselineValidate is validateOptimized as it was before the thresholds
+// became configurable: each side counts its distinct words the other side's
+// matches don't cover, a three-word name may not have any mismatch against
+// a name of three or more words, and each side needs two matched words.
+func baselineValidate(a, b string, wordToMatches map[string][]string) bool {
+	partsA, partsB := strings.Fields(a), strings.Fields(b)
+	mismatches := func(parts, other []string) int {
+		painted := make(map[string]bool)
+		for _, w := range other {
+			for _, m := range wordToMatches[w] {
+				painted[m] = true
+			}
+		}
+		seen := make(map[string]bool)
+		n := 0
+		for _, w := range parts {
+			if seen[w] {
+				continue
+			}
+			seen[w] = true
+			if !painted[w] {
+				n++
+			}
+		}
+		return n
+	}
+	lenA, lenB := len(partsA), len(partsB)
+	mismatchesA, mismatchesB := mismatches(partsA, partsB), mismatches(partsB, partsA)
+	if lenB == 3 && mismatchesB > 0 && lenA >= 3 {
+		return false
+	}
+	if lenA == 3 && mismatchesA > 0 && lenB >= 3 {
+		return false
+	}
+	return lenA-mismatchesA >= 2 && lenB-mismatchesB >= 2
+}
+
+// wordMatchStrings spells WordToMatches out in words.
+func wordMatchStrings(data *Data) map[string][]string {
+	out := make(map[string][]string, len(data.WordToMatches))
+	for id, matches := range data.WordToMatches {
+		word := data.Dict.GetStr(id)
+		for _, m := range matches {
+			out[word] = append(out[word], data.Dict.GetStr(m))
+		}
+	}
+	return out
+}
+
+func TestDefaultMatchConfigDecisions(t *testing.T) {
+	data := loadString(t, validateInput)
+	cfg := DefaultMatchConfig()
+	m := NewMatcher(data, Options{Match: &cfg})
+	for _, c := range []struct {
+		name string
+		a, b string
+		ok   bool
+	}{
+		{"all words match", "john smith", "jon smyth", true},
+		{"word order", "john smith", "smith john", true},
+		{"one common word", "john smith", "mary ann smith", false},
+		{"no common word", "john smith", "mary jones", false},
+		{"single word", "ann", "ann", false},
+		{"strict three words, mismatch", "john paul smith", "mary ann smith", false},
+		{"strict three words, no mismatch", "john paul smith", "jon paul smyth", true},
+		// Only a name of exactly three words is strict
+		{"three against two", "john paul smith", "jon smith", true},
+		// The longer name's mismatch doesn't make the three-word name strict
+		{"four against three", "john paul jones smith", "jon paul smyth", true},
+		{"four against three, mismatch in the three", "john paul jones smith", "jon mary smyth", false},
+		{"four against four", "john paul jones smith", "jon paul jones smyth", true},
+		{"repeated word counts once", "john john smith", "jon smith", true},
+		{"repeated word against three", "john john smith", "john paul smith", false},
+		// A word missing from word_to_matches matches nothing, itself included
+		{"unknown word", "zed smith", "zed smyth", false},
+	} {
+		want := baselineValidate(c.a, c.b, wordMatchStrings(data))
+		if want != c.ok {
+			t.Fatalf("%s: the table says %v, the baseline rule %v", c.name, c.ok, want)
+		}
+		if got, _ := m.Validate(c.a, c.b); got != c.ok {
+			t.Errorf("%s: Validate(%q, %q) = %v, want %v", c.name, c.a, c.b, got, c.ok)
+		}
+	}
+}
+
+// Every pair of the input's names gets the decision the hardcoded rules gave
+// it, both ways round.
+func TestDefaultMatchConfigAllPairs(t *testing.T) {
+	data := loadString(t, validateInput)
+	wordToMatches := wordMatchStrings(data)
+	m := NewMatcher(data, Options{})
+	for i, a := range data.AllNames {
+		for _, b := range data.AllNames[i+1:] {
+			want := baselineValidate(a, b, wordToMatches)
+			for _, order := range [][2]string{{a, b}, {b, a}} {
+				if got, _ := m.Validate(order[0], order[1]); got != want {
+					t.Errorf("Validate(%q, %q) = %v, want %v", order[0], order[1], got, want)
+				}
+			}
+		}
+	}
+}
+
+// With Asymmetric, a short alias contained in a longer name passes, while
+// a short name with a word the longer one lacks still fails.
+func TestAsymmetric(t *testing.T) {
+	data := loadString(t, `{
+		"all_names": ["acme bank", "acme trust", "acme national bank", "first acme national bank"],
+		"word_to_matches": {"acme": ["acme"], "bank": ["bank"], "trust": ["trust"], "national": ["national"], "first": ["first"]}
+	}`)
+	cfg := DefaultMatchConfig()
+	cfg.MaxMismatches = 0
+	symmetric := NewMatcher(data, Options{Match: &cfg})
+	asymmetricCfg := cfg
+	asymmetricCfg.Asymmetric = true
+	asymmetric := NewMatcher(data, Options{Match: &asymmetricCfg})
+	for _, c := range []struct {
+		a, b       string
+		symmetric  bool
+		asymmetric bool
+	}{
+		{"acme bank", "acme national bank", false, true},
+		{"acme bank", "first acme national bank", false, true},
+		{"acme national bank", "first acme national bank", false, true},
+		// The shorter name's own mismatch counts
+		{"acme trust", "acme national bank", false, false},
+		// Equal lengths, each with a mismatch
+		{"acme bank", "acme trust", false, false},
+		{"acme bank", "acme bank", true, true},
+	} {
+		for _, order := range [][2]string{{c.a, c.b}, {c.b, c.a}} {
+			if got, _ := symmetric.Validate(order[0], order[1]); got != c.symmetric {
+				t.Errorf("Validate(%q, %q) = %v, want %v", order[0], order[1], got, c.symmetric)
+			}
+			if got, _ := asymmetric.Validate(order[0], order[1]); got != c.asymmetric {
+				t.Errorf("asymmetric Validate(%q, %q) = %v, want %v", order[0], order[1], got, c.asymmetric)
+			}
+		}
+	}
+	// The longer name's mismatches are dropped from the reported counts too
+	e, err := asymmetric.Explain("acme bank", "first acme national bank")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.MismatchesA != 0 || e.MismatchesB != 0 || e.Score != 1 {
+		t.Errorf("mismatches %d and %d, score %v; want 0, 0 and 1", e.MismatchesA, e.MismatchesB, e.Score)
+	}
+}
@@ -52,7 +52,11 @@ def compare_all_names(all_names: list[str], word_to_matches: dict[str, frozenset
         text=True
     )
     
-    if result.returncode != 0:
+    # 3 means the run finished but found suspiciously few matches; the
+    # binary's diagnosis is on stdout
+    if result.returncode == 3:
+        print(result.stdout[result.stdout.find("Warning:"):])
+    elif result.returncode != 0:
         print(f"Go binary error: {result.stderr}")
         raise RuntimeError(f"Go binary failed with return code {result.returncode}")
     
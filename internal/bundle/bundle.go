@@ -0,0 +1,191 @@
+// Package bundle writes failure bundles. With --on-failure-bundle, a run
+// that panics or exits with an error leaves behind a tar.gz with everything
+// needed to diagnose it without asking the user for more. Writing the
+// bundle is best effort: its own errors are only reported, and the original
+// failure and exit code always go through.
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/memory"
+)
+
+const (
+	logLines    = 200
+	inputSample = 512
+)
+
+// Bundle collects what the bundle of a run holds as the run goes on.
+type Bundle struct {
+	path          string
+	inputPath     string
+	noInputSample bool
+	flags         *flag.FlagSet
+	started       time.Time
+
+	// Updated as the run goes on, for the partial run report
+	stage    string
+	progress func() (done, total uint64)
+
+	log *logTail
+}
+
+// New returns nil when no bundle path is set; every method is a no-op on a
+// nil Bundle. Otherwise stdout is teed so the bundle can include the end of
+// the log. The bundle records the flags and arguments of fs.
+func New(path, inputPath string, noInputSample bool, fs *flag.FlagSet) *Bundle {
+	if path == "" {
+		return nil
+	}
+	b := &Bundle{path: path, inputPath: inputPath, noInputSample: noInputSample, flags: fs, started: time.Now(), stage: "setup"}
+	b.log = captureStdout(logLines)
+	return b
+}
+
+// SetStage names the stage the run has reached.
+func (b *Bundle) SetStage(stage string) {
+	if b != nil {
+		b.stage = stage
+	}
+}
+
+// SetProgress sets how the bundle finds out how many names the run has
+// processed.
+func (b *Bundle) SetProgress(progress func() (done, total uint64)) {
+	if b != nil {
+		b.progress = progress
+	}
+}
+
+// Recover is deferred by main. It writes the bundle for a panic and then
+// re-panics, so the usual trace and exit status still happen.
+func (b *Bundle) Recover() {
+	if b == nil {
+		return
+	}
+	if v := recover(); v != nil {
+		b.write(fmt.Sprint(v), debug.Stack())
+		panic(v)
+	}
+	b.log.stop()
+}
+
+// Exit writes the bundle for an error exit and exits with code.
+func (b *Bundle) Exit(code int, reason string) {
+	if b != nil {
+		b.write(reason, nil)
+	}
+	os.Exit(code)
+}
+
+func (b *Bundle) write(reason string, stack []byte) {
+	b.log.stop()
+	defer func() {
+		if v := recover(); v != nil {
+			fmt.Fprintln(os.Stderr, "could not write failure bundle:", v)
+		}
+	}()
+	if err := b.writeArchive(reason, stack); err != nil {
+		fmt.Fprintln(os.Stderr, "could not write failure bundle:", err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Failure bundle written to", b.path)
+}
+
+func (b *Bundle) writeArchive(reason string, stack []byte) error {
+	f, err := os.Create(b.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	add := func(name string, body []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(body)
+		return err
+	}
+	addJSON := func(name string, v any) error {
+		body, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return add(name, append(body, '\n'))
+	}
+
+	config := map[string]any{"args": b.flags.Args()}
+	flags := make(map[string]string)
+	b.flags.VisitAll(func(fl *flag.Flag) { flags[fl.Name] = fl.Value.String() })
+	config["flags"] = flags
+
+	report := map[string]any{
+		"error":      reason,
+		"stage":      b.stage,
+		"started_at": b.started.UTC().Format(time.RFC3339),
+		"failed_at":  time.Now().UTC().Format(time.RFC3339),
+	}
+	if b.progress != nil {
+		done, total := b.progress()
+		report["names_processed"] = done
+		report["total_names"] = total
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	env := map[string]any{
+		"goos":             runtime.GOOS,
+		"goarch":           runtime.GOARCH,
+		"go_version":       runtime.Version(),
+		"num_cpu":          runtime.NumCPU(),
+		"available_memory": memory.Available(),
+		"heap_alloc":       mem.HeapAlloc,
+		"sys":              mem.Sys,
+	}
+
+	steps := []func() error{
+		func() error { return addJSON("config.json", config) },
+		func() error { return addJSON("report.json", report) },
+		func() error { return addJSON("environment.json", env) },
+		func() error { return add("log.txt", []byte(b.log.String())) },
+	}
+	if stack != nil {
+		steps = append(steps, func() error { return add("stack.txt", stack) })
+	}
+	if !b.noInputSample && b.inputPath != "" {
+		steps = append(steps, func() error {
+			in, err := os.Open(b.inputPath)
+			if err != nil {
+				return add("input_sample.err", []byte(err.Error()+"\n"))
+			}
+			defer in.Close()
+			sample := make([]byte, inputSample)
+			n, _ := io.ReadFull(in, sample)
+			return add("input_sample.bin", sample[:n])
+		})
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,88 @@
+package bundle
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// logTail keeps the last lines written to stdout. A carriage return starts
+// the line over, so the progress line only ever takes one slot.
+type logTail struct {
+	mu      sync.Mutex
+	lines   []string
+	max     int
+	current []byte
+
+	orig   *os.File
+	pipe   *os.File
+	copied chan struct{}
+}
+
+// captureStdout swaps os.Stdout for a pipe copied to both the real stdout
+// and the tail. stop undoes it.
+func captureStdout(max int) *logTail {
+	t := &logTail{max: max}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return t
+	}
+	t.orig, t.pipe, t.copied = os.Stdout, w, make(chan struct{})
+	os.Stdout = w
+	go func() {
+		io.Copy(io.MultiWriter(t.orig, t), r)
+		r.Close()
+		close(t.copied)
+	}()
+	return t
+}
+
+// stop restores stdout once everything written so far has been copied.
+func (t *logTail) stop() {
+	if t == nil || t.pipe == nil {
+		return
+	}
+	os.Stdout = t.orig
+	t.pipe.Close()
+	<-t.copied
+	t.pipe = nil
+}
+
+func (t *logTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range p {
+		switch c {
+		case '\n':
+			t.lines = append(t.lines, string(t.current))
+			if len(t.lines) > t.max {
+				t.lines = t.lines[1:]
+			}
+			t.current = t.current[:0]
+		case '\r':
+			t.current = t.current[:0]
+		default:
+			t.current = append(t.current, c)
+		}
+	}
+	return len(p), nil
+}
+
+func (t *logTail) String() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var sb strings.Builder
+	for _, line := range t.lines {
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+	if len(t.current) > 0 {
+		sb.Write(t.current)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
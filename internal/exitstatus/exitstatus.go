@@ -0,0 +1,150 @@
+// Package exitstatus maps the error a run ends with to its exit code, and
+// describes it in the JSON that --exit-status writes, so that a scheduler
+// can tell a bad input from a run worth retrying without parsing stderr.
+package exitstatus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+)
+
+// Class is the kind of ending a run had. Its value is the exit code.
+type Class int
+
+const (
+	OK         Class = 0
+	Error      Class = 1
+	Usage      Class = 2
+	FewMatches Class = 3
+	// The classes of the compare errors, see table
+	InvalidInput        Class = 4
+	SchemaVersion       Class = 5
+	IndexCorpusMismatch Class = 6
+	ResourceLimit       Class = 7
+	Interrupted         Class = 130
+)
+
+// table lists every class with its name in the JSON and, for the classes
+// Classify finds, the sentinel the error wraps. Classify takes the first
+// match, so an interrupted run that also hit something else is reported as
+// interrupted.
+var table = []struct {
+	class Class
+	name  string
+	err   error
+}{
+	{OK, "ok", nil},
+	{Error, "error", nil},
+	{Usage, "usage", nil},
+	{FewMatches, "few_matches", nil},
+	{Interrupted, "interrupted", compare.ErrInterrupted},
+	{ResourceLimit, "resource_limit", compare.ErrResourceLimit},
+	{SchemaVersion, "schema_version", compare.ErrSchemaVersion},
+	{IndexCorpusMismatch, "index_corpus_mismatch", compare.ErrIndexCorpusMismatch},
+	{InvalidInput, "invalid_input", compare.ErrInvalidInput},
+}
+
+// Code is the exit code of the class.
+func (c Class) Code() int {
+	return int(c)
+}
+
+func (c Class) String() string {
+	for _, row := range table {
+		if row.class == c {
+			return row.name
+		}
+	}
+	return "error"
+}
+
+func (c Class) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Class) UnmarshalText(text []byte) error {
+	for _, row := range table {
+		if row.name == string(text) {
+			*c = row.class
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown exit status class %q", text)
+}
+
+// Classify returns the class of a failed run's error: the first in table
+// whose sentinel err wraps, or Error.
+func Classify(err error) Class {
+	for _, row := range table {
+		if row.err != nil && errors.Is(err, row.err) {
+			return row.class
+		}
+	}
+	return Error
+}
+
+// Status is the JSON written by --exit-status. Only the fields of the
+// error's type are set.
+type Status struct {
+	Status int    `json:"status"`
+	Class  Class  `json:"class"`
+	Error  string `json:"error,omitempty"`
+	// compare.InputError and compare.MismatchError
+	Field string `json:"field,omitempty"`
+	Line  int    `json:"line,omitempty"`
+	// compare.SchemaVersionError
+	GotVersion  int `json:"got_version,omitempty"`
+	WantVersion int `json:"want_version,omitempty"`
+	// compare.ResourceLimitError
+	Limit     string `json:"limit,omitempty"`
+	Need      uint64 `json:"need,omitempty"`
+	Available uint64 `json:"available,omitempty"`
+	// compare.InterruptedError
+	Processed uint64 `json:"processed,omitempty"`
+	Total     uint64 `json:"total,omitempty"`
+	Resumable bool   `json:"resumable,omitempty"`
+}
+
+// New returns the status of a run that ended as class, with err nil on
+// success.
+func New(class Class, err error) Status {
+	s := Status{Status: class.Code(), Class: class}
+	if err == nil {
+		return s
+	}
+	s.Error = err.Error()
+	var input *compare.InputError
+	var mismatch *compare.MismatchError
+	var version *compare.SchemaVersionError
+	var limit *compare.ResourceLimitError
+	var interrupted *compare.InterruptedError
+	switch {
+	case errors.As(err, &interrupted):
+		s.Processed, s.Total, s.Resumable = interrupted.Processed, interrupted.Total, interrupted.Resumable
+	case errors.As(err, &limit):
+		s.Limit, s.Need, s.Available = limit.Limit, limit.Need, limit.Available
+	case errors.As(err, &version):
+		s.Field, s.GotVersion, s.WantVersion = version.What, version.Got, version.Want
+	case errors.As(err, &mismatch):
+		s.Field = mismatch.Field
+	case errors.As(err, &input):
+		s.Field, s.Line = input.Field, input.Line
+	}
+	return s
+}
+
+// Write writes s to path; an empty path writes nothing.
+func Write(path string, s Status) error {
+	if path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(raw, '\n'), 0o644)
+}
@@ -0,0 +1,66 @@
+package exitstatus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+)
+
+// Each compare error gets its class, wrapped or not, and its details in the
+// status.
+func TestClassify(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want Status
+	}{
+		{errors.New("disk full"), Status{Status: 1, Class: Error}},
+		{&compare.InputError{Field: "all_names", Line: 3, Err: errors.New("bad name")},
+			Status{Status: 4, Class: InvalidInput, Field: "all_names", Line: 3}},
+		{&compare.SchemaVersionError{What: "index", Got: 1, Want: 2},
+			Status{Status: 5, Class: SchemaVersion, Field: "index", GotVersion: 1, WantVersion: 2}},
+		{&compare.MismatchError{Field: "checkpoint", Err: errors.New("different input")},
+			Status{Status: 6, Class: IndexCorpusMismatch, Field: "checkpoint"}},
+		{&compare.ResourceLimitError{Limit: "memory", Need: 2, Available: 1},
+			Status{Status: 7, Class: ResourceLimit, Limit: "memory", Need: 2, Available: 1}},
+		{&compare.InterruptedError{Processed: 5, Total: 9, Resumable: true, Err: context.Canceled},
+			Status{Status: 130, Class: Interrupted, Processed: 5, Total: 9, Resumable: true}},
+		// A mismatch found while reading an input is still a mismatch
+		{&compare.InputError{Field: "word_to_matches", Err: &compare.MismatchError{Field: "word ID", Err: errors.New("out of range")}},
+			Status{Status: 6, Class: IndexCorpusMismatch, Field: "word ID"}},
+	} {
+		err := fmt.Errorf("loading: %w", c.err)
+		got := New(Classify(err), err)
+		if got.Error != err.Error() {
+			t.Errorf("%v: error %q", c.err, got.Error)
+		}
+		got.Error = ""
+		if got != c.want {
+			t.Errorf("%v: status %+v, want %+v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestStatusJSON(t *testing.T) {
+	s := New(OK, nil)
+	raw, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != `{"status":0,"class":"ok"}` {
+		t.Errorf("success status %s", raw)
+	}
+	var back Status
+	if err := json.Unmarshal([]byte(`{"status":130,"class":"interrupted","resumable":true}`), &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.Class != Interrupted || !back.Resumable {
+		t.Errorf("read back %+v", back)
+	}
+	if err := json.Unmarshal([]byte(`{"class":"bogus"}`), &back); err == nil {
+		t.Error("unknown class read")
+	}
+}
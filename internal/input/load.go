@@ -0,0 +1,333 @@
+package input
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+)
+
+// Source names the files and connection an input is loaded with besides
+// its own path, one field per input flag. The zero Source loads a JSON
+// document, JSON Lines or an index file as it is.
+type Source struct {
+	// Dictionary TSV of an ID-based input (--dict)
+	Dict string
+	// Sections of a JSON input in files of their own (--word-to-matches,
+	// --pair-to-names)
+	WordMatches string
+	PairNames   string
+	// Companion files of a .csv input (--csv-name-columns,
+	// --csv-word-matches, --csv-pair-names)
+	CSVColumns []string
+	CSVMatches string
+	CSVPairs   string
+	// Synonym groups of a .txt input of one name per line (--synonyms)
+	Synonyms string
+	// Postgres connection, with which the input path is the query
+	// selecting the names, and the query selecting synonym groups
+	// (--pg-dsn, --pg-synonyms)
+	PGDSN      string
+	PGSynonyms string
+	// With an index input, leave its pair buckets in the file and cache up
+	// to this many bytes of them (--pairs-on-disk)
+	PairsOnDisk int64
+	// Open an index input with its buckets on disk even though
+	// PairsOnDisk is 0, as a --max-memory run sizes the cache later
+	DiskPairs bool
+}
+
+// IsIndexFile reports whether path is an index file written by build-index.
+func IsIndexFile(path string) bool {
+	return HasMagic(path, []byte(compare.IndexMagic))
+}
+
+// Load loads the input at path in the form its extension (or, for an index,
+// its magic) says, checking that the Source's fields apply to that form.
+func (s Source) Load(path string, opts compare.LoadOptions) (*compare.Data, error) {
+	if IsIndexFile(path) {
+		return s.loadIndex(path, opts)
+	}
+	if s.PairsOnDisk != 0 {
+		return nil, errors.New("--pairs-on-disk needs an index input from build-index")
+	}
+	if s.PGDSN != "" {
+		return s.loadPostgres(path, opts)
+	}
+	if s.PGSynonyms != "" {
+		return nil, errors.New("--pg-synonyms needs --pg-dsn")
+	}
+	split := s.WordMatches != "" || s.PairNames != ""
+	if split && (HasExt(path, ".csv") || HasExt(path, ".txt") || HasExt(path, ".jsonl")) {
+		return nil, errors.New("--word-to-matches and --pair-to-names need a JSON input")
+	}
+	if HasExt(path, ".csv") {
+		return s.loadCSV(path, opts)
+	}
+	if HasExt(path, ".txt") {
+		return s.loadNameList(path, opts)
+	}
+	if s.Synonyms != "" {
+		return nil, errors.New("--synonyms needs a .txt input of one name per line")
+	}
+	if s.CSVColumns != nil || s.CSVMatches != "" || s.CSVPairs != "" {
+		return nil, errors.New("the --csv-* flags need a .csv input")
+	}
+	if split {
+		return s.loadSplit(path, opts)
+	}
+	return LoadFile(path, s.Dict, opts)
+}
+
+// loadIndex loads an index file written by build-index. Tokenization is
+// fixed when the index is built, so the flags for it must agree.
+func (s Source) loadIndex(path string, opts compare.LoadOptions) (*compare.Data, error) {
+	if s.Dict != "" || s.WordMatches != "" || s.PairNames != "" || s.PGDSN != "" || s.Synonyms != "" {
+		return nil, fmt.Errorf("%s: an index holds the whole input; pass those flags to build-index instead", path)
+	}
+	if opts.Mask != nil {
+		return nil, fmt.Errorf("%s: --mask-tokens applies when the index is built", path)
+	}
+	if s.PairsOnDisk < 0 {
+		return nil, fmt.Errorf("--pairs-on-disk %d: want 0 or more MiB", s.PairsOnDisk>>20)
+	}
+	var data *compare.Data
+	var err error
+	if s.PairsOnDisk > 0 || s.DiskPairs {
+		if data, err = compare.OpenIndex(path, s.PairsOnDisk); err != nil {
+			err = fmt.Errorf("%s: %w", path, err)
+		}
+	} else {
+		data, err = ReadIndexFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data.FoldCase != opts.FoldCase || data.Normalize != opts.Normalize {
+		return nil, fmt.Errorf("%s: index was built with --fold-case-compare=%t --normalize %s; pass the same or rebuild it", path, data.FoldCase, data.Normalize)
+	}
+	return data, nil
+}
+
+// opener opens the files of one input and closes them all when the input
+// is loaded, reporting the first error closing them, such as a truncated
+// gzip stream, as the load's.
+type opener struct {
+	closers []func() error
+}
+
+func (o *opener) open(path string) (io.Reader, error) {
+	r, closeInput, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	o.closers = append(o.closers, closeInput)
+	return r, nil
+}
+
+func (o *opener) close(data **compare.Data, err *error) {
+	for _, closeInput := range o.closers {
+		if cerr := closeInput(); *err == nil && cerr != nil {
+			*data, *err = nil, cerr
+		}
+	}
+}
+
+// loadSplit loads a JSON input whose word_to_matches and pair_to_names come
+// as separate files (see compare.LoadSplit). The input may then be a bare
+// array of names.
+func (s Source) loadSplit(path string, opts compare.LoadOptions) (data *compare.Data, err error) {
+	var o opener
+	defer o.close(&data, &err)
+	doc, err := o.open(path)
+	if err != nil {
+		return nil, err
+	}
+	var sections []compare.InputSection
+	for _, sec := range []struct{ name, path string }{
+		{"word_to_matches", s.WordMatches},
+		{"pair_to_names", s.PairNames},
+	} {
+		if sec.path == "" {
+			continue
+		}
+		r, err := o.open(sec.path)
+		if err != nil {
+			return nil, err
+		}
+		sections = append(sections, compare.InputSection{Name: sec.name, R: r})
+	}
+	if opts.Dict, err = ReadDictionary(s.Dict, opts); err != nil {
+		return nil, err
+	}
+	return compare.LoadSplit(doc, sections, opts)
+}
+
+// loadNameList loads a list of names (see compare.LoadNameList) with the
+// synonyms of Synonyms.
+func (s Source) loadNameList(path string, opts compare.LoadOptions) (data *compare.Data, err error) {
+	if s.Dict != "" {
+		return nil, errors.New("--dict applies to JSON documents only")
+	}
+	var o opener
+	defer o.close(&data, &err)
+	names, err := o.open(path)
+	if err != nil {
+		return nil, err
+	}
+	var synonyms io.Reader
+	if s.Synonyms != "" {
+		file, err := os.Open(s.Synonyms)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		synonyms = file
+	}
+	return compare.LoadNameList(names, synonyms, opts)
+}
+
+// loadPostgres loads the names selected by query as a list of names (see
+// compare.LoadNameList), with the synonym groups of PGSynonyms. Queries
+// run through psql, as the standard library has no Postgres driver, so the
+// usual PG* environment variables apply.
+func (s Source) loadPostgres(query string, opts compare.LoadOptions) (data *compare.Data, err error) {
+	if s.Dict != "" || s.Synonyms != "" || s.WordMatches != "" || s.PairNames != "" ||
+		s.CSVColumns != nil || s.CSVMatches != "" || s.CSVPairs != "" {
+		return nil, errors.New("--pg-dsn reads names only; other input flags don't apply")
+	}
+	var o opener
+	defer o.close(&data, &err)
+	run := func(query string) (io.Reader, error) {
+		// Unaligned rows without headers or footers, columns separated by
+		// commas, which readSynonymGroups splits on
+		cmd := exec.Command("psql", "--no-psqlrc", "--quiet", "--no-align", "--tuples-only",
+			"--field-separator=,", "--set=ON_ERROR_STOP=1", "--dbname", s.PGDSN, "--command", query)
+		r, closeInput, err := StartCommand(cmd, "psql")
+		if err != nil {
+			return nil, fmt.Errorf("--pg-dsn needs the psql command: %w", err)
+		}
+		o.closers = append(o.closers, closeInput)
+		return r, nil
+	}
+	names, err := run(query)
+	if err != nil {
+		return nil, err
+	}
+	var synonyms io.Reader
+	if s.PGSynonyms != "" {
+		if synonyms, err = run(s.PGSynonyms); err != nil {
+			return nil, err
+		}
+	}
+	return compare.LoadNameList(names, synonyms, opts)
+}
+
+// loadCSV loads a CSV input (see compare.CSVInput): the names at path and
+// the companion files named by the CSV fields. Any of them may be gzipped.
+func (s Source) loadCSV(path string, opts compare.LoadOptions) (data *compare.Data, err error) {
+	if s.Dict != "" {
+		return nil, errors.New("--dict applies to JSON documents only")
+	}
+	in := compare.CSVInput{NameColumns: s.CSVColumns}
+	var o opener
+	defer o.close(&data, &err)
+	if in.Names, err = o.open(path); err != nil {
+		return nil, err
+	}
+	if s.CSVMatches != "" {
+		if in.WordMatches, err = o.open(s.CSVMatches); err != nil {
+			return nil, err
+		}
+	}
+	if s.CSVPairs != "" {
+		if in.PairNames, err = o.open(s.CSVPairs); err != nil {
+			return nil, err
+		}
+	}
+	return compare.LoadCSV(in, opts)
+}
+
+// LoadFile loads the input at path, a JSON document or, for a .jsonl path,
+// JSON Lines. With a dictPath the input is the ID-based form and its IDs are
+// resolved through that dictionary.
+func LoadFile(path, dictPath string, opts compare.LoadOptions) (data *compare.Data, err error) {
+	var o opener
+	defer o.close(&data, &err)
+	file, err := o.open(path)
+	if err != nil {
+		return nil, err
+	}
+	if HasExt(path, ".jsonl") {
+		if dictPath != "" {
+			return nil, errors.New("--dict applies to JSON documents only")
+		}
+		return compare.LoadJSONL(file, opts)
+	}
+	if opts.Dict, err = ReadDictionary(dictPath, opts); err != nil {
+		return nil, err
+	}
+	return compare.LoadWithOptions(file, opts)
+}
+
+// ReadDictionary reads the dictionary of --dict, or returns nil for no
+// dictPath.
+func ReadDictionary(dictPath string, opts compare.LoadOptions) (*compare.Dictionary, error) {
+	if dictPath == "" {
+		return nil, nil
+	}
+	file, err := os.Open(dictPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	dict, err := compare.ReadDictionaryTSV(file, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", dictPath, err)
+	}
+	return dict, nil
+}
+
+// ReadIndexFile reads a whole index file written by build-index.
+func ReadIndexFile(path string) (*compare.Data, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := compare.ReadIndex(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return data, nil
+}
+
+// ReadReviewStates reads the --review-state CSV at path.
+func ReadReviewStates(path string, data *compare.Data) (*compare.ReviewStates, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r, err := compare.LoadReviewStates(file, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Stream streams the JSON document at path to v (see compare.StreamInput).
+func Stream(path string, v compare.InputVisitor) error {
+	file, closeInput, err := Open(path)
+	if err != nil {
+		return err
+	}
+	err = compare.StreamInput(file, v)
+	if cerr := closeInput(); err == nil {
+		err = cerr
+	}
+	return err
+}
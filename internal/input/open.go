@@ -0,0 +1,255 @@
+// Package input opens the files a run reads, wherever they come from (a
+// local file, stdin, an http(s):// or s3:// URL, a command's output) and
+// however they are compressed, and loads the input with the companion files
+// the input flags name.
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Open opens an input file, stdin for "-", or an http(s):// or s3:// URL,
+// decompressing it when it is gzipped or zstd-compressed (by its .gz or
+// .zst extension or its magic bytes). The JSON decoder stops at the
+// closing brace, so the returned close func reads out the rest of a gzip
+// stream to make sure it was complete before closing the file.
+func Open(path string) (io.Reader, func() error, error) {
+	var file io.ReadCloser = os.Stdin
+	var err error
+	switch {
+	case IsURL(path):
+		if file, err = openURL(path); err != nil {
+			return nil, nil, err
+		}
+	case path != "-":
+		if file, err = os.Open(path); err != nil {
+			return nil, nil, err
+		}
+	}
+	name := trimURLQuery(path)
+	br := bufio.NewReaderSize(file, 1<<20)
+	magic, _ := br.Peek(4)
+	if strings.HasSuffix(name, ".zst") || bytes.HasPrefix(magic, zstdMagic) {
+		in, closeInput, err := openZstd(br, path)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return in, func() error {
+			err := closeInput()
+			file.Close()
+			return err
+		}, nil
+	}
+	if !strings.HasSuffix(name, ".gz") && !bytes.HasPrefix(magic, []byte{0x1f, 0x8b}) {
+		return br, file.Close, nil
+	}
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	in := &gzipInput{gz: gz, path: path}
+	closeInput := func() error {
+		_, err := io.Copy(io.Discard, in)
+		file.Close()
+		return err
+	}
+	return in, closeInput, nil
+}
+
+// gzipInput names the file in decompression errors, so a truncated download
+// fails loudly instead of reading as a short input.
+type gzipInput struct {
+	gz   *gzip.Reader
+	path string
+}
+
+func (g *gzipInput) Read(p []byte) (int, error) {
+	n, err := g.gz.Read(p)
+	switch {
+	case err == io.EOF || err == nil:
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		err = fmt.Errorf("%s: gzip stream is truncated: %w", g.path, err)
+	default:
+		err = fmt.Errorf("%s: %w", g.path, err)
+	}
+	return n, err
+}
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// openZstd decompresses r through the zstd command, as the standard library
+// has no zstd decoder. The returned close func reads out the rest of the
+// stream, like Open's does for gzip.
+func openZstd(r io.Reader, path string) (io.Reader, func() error, error) {
+	cmd := exec.Command("zstd", "-dc")
+	cmd.Stdin = r
+	in, closeInput, err := StartCommand(cmd, path+": zstd")
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: reading zstd input needs the zstd command: %w", path, err)
+	}
+	return in, closeInput, nil
+}
+
+// StartCommand starts cmd and returns its standard output. The returned
+// close func reads out the rest of the output and reports a failed command.
+func StartCommand(cmd *exec.Cmd, label string) (io.Reader, func() error, error) {
+	c := &commandInput{cmd: cmd, label: label}
+	cmd.Stderr = &c.stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	c.out = out
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	closeInput := func() error {
+		_, err := io.Copy(io.Discard, c)
+		return err
+	}
+	return c, closeInput, nil
+}
+
+// commandInput reports a failed command, such as zstd on a truncated file,
+// at the end of its output instead of letting it read as a short input.
+type commandInput struct {
+	cmd    *exec.Cmd
+	out    io.Reader
+	stderr bytes.Buffer
+	label  string
+	err    error
+	done   bool
+}
+
+func (c *commandInput) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, c.err
+	}
+	n, err := c.out.Read(p)
+	if err == io.EOF {
+		c.done, c.err = true, io.EOF
+		if werr := c.cmd.Wait(); werr != nil {
+			msg := strings.TrimSpace(c.stderr.String())
+			if msg == "" {
+				msg = werr.Error()
+			}
+			c.err = fmt.Errorf("%s: %s", c.label, msg)
+		}
+		err = c.err
+	}
+	return n, err
+}
+
+// IsURL reports whether path is an http(s):// or s3:// URL rather than a
+// local file.
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "s3://")
+}
+
+// trimURLQuery strips the query of a URL, such as the signature of a
+// presigned one, so its extension shows.
+func trimURLQuery(path string) string {
+	if IsURL(path) {
+		path, _, _ = strings.Cut(path, "?")
+	}
+	return path
+}
+
+// openURL streams the body of an http(s):// or s3:// URL. S3 objects are
+// fetched through the aws command, as the standard library can't sign
+// requests, so the usual AWS credentials and environment variables apply.
+// A body cut short fails the read, like a truncated gzip stream does.
+func openURL(url string) (io.ReadCloser, error) {
+	if strings.HasPrefix(url, "s3://") {
+		cmd := exec.Command("aws", "s3", "cp", "--only-show-errors", url, "-")
+		r, _, err := StartCommand(cmd, trimURLQuery(url)+": aws s3")
+		if err != nil {
+			return nil, fmt.Errorf("reading s3:// input needs the aws command: %w", err)
+		}
+		// Reading out the rest of a large object just to close it would
+		// download it all, so the download is stopped instead
+		return readCloser{r, func() error {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return nil
+		}}, nil
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", trimURLQuery(url), resp.Status)
+	}
+	return resp.Body, nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error { return r.close() }
+
+// HasExt reports whether path names a file of the given extension, such as
+// ".csv", once a .gz or .zst extension and a URL's query are stripped.
+func HasExt(path, ext string) bool {
+	path = strings.ToLower(trimURLQuery(path))
+	return strings.HasSuffix(strings.TrimSuffix(strings.TrimSuffix(path, ".gz"), ".zst"), ext)
+}
+
+// HasMagic reports whether the local file at path starts with magic.
+func HasMagic(path string, magic []byte) bool {
+	if path == "-" || IsURL(path) {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, len(magic))
+	_, err = io.ReadFull(f, head)
+	return err == nil && bytes.Equal(head, magic)
+}
+
+// ForEachLine calls fn with every line of a file, without the newline.
+func ForEachLine(path string, fn func(string) error) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<30)
+	for scanner.Scan() {
+		if err := fn(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ReadNameList reads a file of names, one per line, skipping blank lines.
+func ReadNameList(path string) ([]string, error) {
+	var names []string
+	err := ForEachLine(path, func(line string) error {
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, line)
+		}
+		return nil
+	})
+	return names, err
+}
@@ -0,0 +1,251 @@
+// Package inputdiff compares two input documents section by section, for
+// telling how much of a change in the output comes from a change in the
+// input. Names are the only part that routinely outgrows RAM, so they are
+// spilled into hash partitions on disk and compared one partition at a
+// time.
+package inputdiff
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/input"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/records"
+)
+
+const partitions = 64
+
+// CountDiff counts the entries of a section added and removed, with the
+// first of each in sorted order as samples.
+type CountDiff struct {
+	Added         int      `json:"added"`
+	Removed       int      `json:"removed"`
+	SampleAdded   []string `json:"sample_added,omitempty"`
+	SampleRemoved []string `json:"sample_removed,omitempty"`
+}
+
+func (c *CountDiff) add(s string, samples int) {
+	c.Added++
+	c.SampleAdded = addSample(c.SampleAdded, s, samples)
+}
+
+func (c *CountDiff) remove(s string, samples int) {
+	c.Removed++
+	c.SampleRemoved = addSample(c.SampleRemoved, s, samples)
+}
+
+// addSample keeps the first n entries in sorted order, so the samples don't
+// depend on the order entries are found in (hash partitions, map
+// iteration).
+func addSample(sample []string, s string, n int) []string {
+	i, _ := slices.BinarySearch(sample, s)
+	if i >= n {
+		return sample
+	}
+	sample = slices.Insert(sample, i, s)
+	if len(sample) > n {
+		sample = sample[:n]
+	}
+	return sample
+}
+
+// BucketDiff also counts the buckets in both inputs whose size changed by
+// more than the resize threshold.
+type BucketDiff struct {
+	CountDiff
+	Resized       int      `json:"resized"`
+	SampleResized []string `json:"sample_resized,omitempty"`
+}
+
+// Report is what changed from one input to the other, section by section.
+type Report struct {
+	Names   CountDiff  `json:"names"`
+	Words   CountDiff  `json:"words"`
+	Edges   CountDiff  `json:"word_to_matches_edges"`
+	Buckets BucketDiff `json:"pair_to_names_buckets"`
+}
+
+// Diff compares the inputs at oldPath and newPath, keeping samples examples
+// per category. A bucket counts as resized when its size changed by more
+// than resizeThreshold of its old size.
+func Diff(oldPath, newPath string, samples int, resizeThreshold float64) (*Report, error) {
+	report := &Report{}
+
+	tempDir, err := os.MkdirTemp("", "input_diff")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	oldNames, err := records.NewHashSpill(tempDir, "old", partitions)
+	if err != nil {
+		return nil, err
+	}
+	defer oldNames.Close()
+	newNames, err := records.NewHashSpill(tempDir, "new", partitions)
+	if err != nil {
+		return nil, err
+	}
+	defer newNames.Close()
+
+	// Pass 1: the old input. Words, edges and bucket sizes are vocabulary
+	// sized and stay in memory; names go to disk.
+	oldWords := make(map[string]map[string]struct{})
+	oldBuckets := make(map[string]int)
+	var spillErr error
+	err = input.Stream(oldPath, compare.InputVisitor{
+		Name: func(name string) {
+			if spillErr == nil {
+				spillErr = oldNames.Write(name)
+			}
+		},
+		WordMatches: func(word string, matches []string) {
+			set := make(map[string]struct{}, len(matches))
+			for _, m := range matches {
+				set[m] = struct{}{}
+			}
+			oldWords[word] = set
+		},
+		PairNames: func(pair string, names []string) {
+			oldBuckets[pair] = len(names)
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", oldPath, err)
+	}
+
+	// Pass 2: the new input, consuming the old maps as it goes so whatever
+	// is left over afterwards was removed.
+	err = input.Stream(newPath, compare.InputVisitor{
+		Name: func(name string) {
+			if spillErr == nil {
+				spillErr = newNames.Write(name)
+			}
+		},
+		WordMatches: func(word string, matches []string) {
+			old, existed := oldWords[word]
+			if !existed {
+				report.Words.add(word, samples)
+			}
+			seen := make(map[string]struct{}, len(matches))
+			for _, m := range matches {
+				if _, dup := seen[m]; dup {
+					continue
+				}
+				seen[m] = struct{}{}
+				if _, ok := old[m]; ok {
+					delete(old, m)
+				} else {
+					report.Edges.add(word+" -> "+m, samples)
+				}
+			}
+			for _, m := range sortedKeys(old) {
+				report.Edges.remove(word+" -> "+m, samples)
+			}
+			delete(oldWords, word)
+		},
+		PairNames: func(pair string, names []string) {
+			oldSize, existed := oldBuckets[pair]
+			if !existed {
+				report.Buckets.add(pair, samples)
+				return
+			}
+			delete(oldBuckets, pair)
+			change := float64(len(names) - oldSize)
+			if change < 0 {
+				change = -change
+			}
+			if oldSize > 0 && change/float64(oldSize) > resizeThreshold {
+				report.Buckets.Resized++
+				report.Buckets.SampleResized = addSample(report.Buckets.SampleResized,
+					fmt.Sprintf("%s (%d -> %d)", pair, oldSize, len(names)), samples)
+			}
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", newPath, err)
+	}
+	if spillErr != nil {
+		return nil, spillErr
+	}
+
+	for _, word := range sortedKeys(oldWords) {
+		report.Words.remove(word, samples)
+		for _, m := range sortedKeys(oldWords[word]) {
+			report.Edges.remove(word+" -> "+m, samples)
+		}
+	}
+	for _, pair := range sortedKeys(oldBuckets) {
+		report.Buckets.remove(pair, samples)
+	}
+
+	// Pass 3: names, one partition pair at a time.
+	if err := oldNames.Flush(); err != nil {
+		return nil, err
+	}
+	if err := newNames.Flush(); err != nil {
+		return nil, err
+	}
+	for i := 0; i < partitions; i++ {
+		oldSet := make(map[string]struct{})
+		if err := oldNames.ReadPartition(i, func(name string) {
+			oldSet[name] = struct{}{}
+		}); err != nil {
+			return nil, err
+		}
+		newSet := make(map[string]struct{})
+		if err := newNames.ReadPartition(i, func(name string) {
+			if _, dup := newSet[name]; dup {
+				return
+			}
+			newSet[name] = struct{}{}
+			if _, ok := oldSet[name]; !ok {
+				report.Names.add(name, samples)
+			}
+		}); err != nil {
+			return nil, err
+		}
+		for _, name := range sortedKeys(oldSet) {
+			if _, ok := newSet[name]; !ok {
+				report.Names.remove(name, samples)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteText writes the report for a terminal.
+func (r *Report) WriteText(w io.Writer) {
+	writeCountDiff(w, "Names", r.Names)
+	writeCountDiff(w, "Words", r.Words)
+	writeCountDiff(w, "Word match edges", r.Edges)
+	writeCountDiff(w, "Pair buckets", r.Buckets.CountDiff)
+	fmt.Fprintf(w, "Pair buckets resized: %d\n", r.Buckets.Resized)
+	for _, s := range r.Buckets.SampleResized {
+		fmt.Fprintf(w, "  ~ %s\n", s)
+	}
+}
+
+func writeCountDiff(w io.Writer, label string, c CountDiff) {
+	fmt.Fprintf(w, "%s: +%d / -%d\n", label, c.Added, c.Removed)
+	for _, s := range c.SampleAdded {
+		fmt.Fprintf(w, "  + %s\n", s)
+	}
+	for _, s := range c.SampleRemoved {
+		fmt.Fprintf(w, "  - %s\n", s)
+	}
+}
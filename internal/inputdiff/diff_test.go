@@ -0,0 +1,115 @@
+package inputdiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeDiffInput writes an input of n names, numbered from from, to file in
+// dir and returns its path. Each given name matches the next one.
+func writeDiffInput(t *testing.T, dir, file string, from, n int) string {
+	t.Helper()
+	var names, words, pairs []string
+	for i := from; i < from+n; i++ {
+		names = append(names, fmt.Sprintf(`"given%d family%d"`, i, i%7))
+		words = append(words, fmt.Sprintf(`"given%d": ["given%d", "given%d"]`, i, i, i+1))
+		pairs = append(pairs, fmt.Sprintf(`"family%d_given%d": ["given%d family%d"]`, i%7, i, i, i%7))
+	}
+	doc := `{"all_names": [` + strings.Join(names, ", ") + `], "word_to_matches": {` + strings.Join(words, ", ") +
+		`}, "pair_to_names": {` + strings.Join(pairs, ", ") + `}}`
+	path := filepath.Join(dir, file)
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInputDiff(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.json")
+	newPath := filepath.Join(dir, "new.json")
+	write := func(path, doc string) {
+		if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(oldPath, `{
+		"all_names": ["john smith", "jon smith", "mary jones", "mary jones"],
+		"word_to_matches": {"john": ["john", "jon"], "jon": ["jon", "john"], "smith": ["smith"], "mary": ["mary"], "jones": ["jones"]},
+		"pair_to_names": {"john_smith": ["john smith"], "jon_smith": ["jon smith"], "jones_mary": ["mary jones"], "jones_x": ["a", "b", "c", "d"]}
+	}`)
+	// A name added twice and one removed; a word added and one removed,
+	// with the edges they bring; smith gains an edge, john loses one; a
+	// bucket added, one removed and one resized
+	write(newPath, `{
+		"all_names": ["john smith", "mary jones", "ann lee", "ann lee", "jon smyth"],
+		"word_to_matches": {"john": ["john"], "smith": ["smith", "smyth"], "mary": ["mary"], "jones": ["jones"], "ann": ["ann", "anne"]},
+		"pair_to_names": {"john_smith": ["john smith"], "jones_mary": ["mary jones"], "jones_x": ["a"], "ann_lee": ["ann lee"]}
+	}`)
+	report, err := Diff(oldPath, newPath, 10, 0.25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		category       string
+		got            CountDiff
+		added, removed []string
+	}{
+		{"names", report.Names, []string{"ann lee", "jon smyth"}, []string{"jon smith"}},
+		{"words", report.Words, []string{"ann"}, []string{"jon"}},
+		{"edges", report.Edges, []string{"ann -> ann", "ann -> anne", "smith -> smyth"},
+			[]string{"john -> jon", "jon -> john", "jon -> jon"}},
+		{"buckets", report.Buckets.CountDiff, []string{"ann_lee"}, []string{"jon_smith"}},
+	} {
+		if c.got.Added != len(c.added) || !slices.Equal(c.got.SampleAdded, c.added) {
+			t.Errorf("%s added: %d %q, want %q", c.category, c.got.Added, c.got.SampleAdded, c.added)
+		}
+		if c.got.Removed != len(c.removed) || !slices.Equal(c.got.SampleRemoved, c.removed) {
+			t.Errorf("%s removed: %d %q, want %q", c.category, c.got.Removed, c.got.SampleRemoved, c.removed)
+		}
+	}
+	if want := []string{"jones_x (4 -> 1)"}; report.Buckets.Resized != 1 || !slices.Equal(report.Buckets.SampleResized, want) {
+		t.Errorf("buckets resized: %d %q, want %q", report.Buckets.Resized, report.Buckets.SampleResized, want)
+	}
+}
+
+// Diffing the same inputs twice gives the same report, samples included,
+// though names are compared by hash partition and the other sections by map.
+func TestInputDiffDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := writeDiffInput(t, dir, "old.json", 0, 200)
+	newPath := writeDiffInput(t, dir, "new.json", 100, 200)
+	var first []byte
+	for run := range 3 {
+		report, err := Diff(oldPath, newPath, 5, 0.25)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := json.Marshal(report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run == 0 {
+			first = got
+			if report.Names.Removed != 100 || report.Names.Added != 100 {
+				t.Errorf("names: %+v", report.Names)
+			}
+			for _, sample := range [][]string{report.Names.SampleRemoved, report.Names.SampleAdded,
+				report.Words.SampleRemoved, report.Edges.SampleRemoved, report.Buckets.SampleRemoved} {
+				if len(sample) != 5 || !slices.IsSorted(sample) {
+					t.Errorf("sample %q is not the first 5 in order", sample)
+				}
+			}
+			if want := []string{"given0 family0", "given1 family1", "given10 family3", "given11 family4", "given12 family5"}; !slices.Equal(report.Names.SampleRemoved, want) {
+				t.Errorf("removed names %q, want %q", report.Names.SampleRemoved, want)
+			}
+		} else if string(got) != string(first) {
+			t.Errorf("run %d:\n%s\nrun 0:\n%s", run, got, first)
+		}
+	}
+}
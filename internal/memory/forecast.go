@@ -0,0 +1,192 @@
+// Package memory forecasts a run's peak memory before its workers start, so
+// an oversized run fails in seconds rather than hours, and handles the byte
+// sizes --max-memory is given in. All tuning constants for the model live
+// here.
+package memory
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+)
+
+const (
+	// Fraction of available memory the forecast may use before aborting.
+	SafetyFactor = 0.9
+	// The Go heap grows to roughly (1 + GOGC/100) times live data before
+	// collecting, so per-worker allocations are scaled by this.
+	gcOverhead = 2.0
+	// Bytes per entry of the map[uint32]struct{} of name IDs, with the
+	// slack of a partly filled table.
+	mapEntryBytes = 16
+	// bufio.Writer default size, one per worker plus one for the merge.
+	writerBuffer = 4096
+)
+
+// Forecast is a rough estimate of a run's peak memory.
+type Forecast struct {
+	Index     uint64 // measured heap in use after preprocessing
+	PerWorker uint64
+	Workers   int
+	Output    uint64
+	Available uint64 // 0 when unknown
+	// Whether Available is the --max-memory budget
+	Budget bool
+}
+
+func (f Forecast) Total() uint64 {
+	return f.Index + f.PerWorker*uint64(f.Workers) + f.Output
+}
+
+// needed returns what Available has to cover. MemAvailable is read after
+// the index was loaded, so it already leaves the index out; a --max-memory
+// budget covers the whole heap, index included.
+func (f Forecast) needed() uint64 {
+	if f.Budget {
+		return f.Total()
+	}
+	return f.PerWorker*uint64(f.Workers) + f.Output
+}
+
+func (f Forecast) exceedsAvailable() bool {
+	return f.Available > 0 && float64(f.needed()) > float64(f.Available)*SafetyFactor
+}
+
+// AbortReason says why the run should not start, or returns "" if the
+// forecast fits.
+func (f Forecast) AbortReason() string {
+	if !f.exceedsAvailable() {
+		return ""
+	}
+	if f.Budget {
+		return "forecast memory use exceeds --max-memory"
+	}
+	return "forecast memory use exceeds available memory"
+}
+
+// Err is AbortReason as a *compare.ResourceLimitError, or nil if the
+// forecast fits.
+func (f Forecast) Err() error {
+	if !f.exceedsAvailable() {
+		return nil
+	}
+	limit := "memory"
+	if f.Budget {
+		limit = "max-memory"
+	}
+	return &compare.ResourceLimitError{Limit: limit, Need: f.needed(), Available: f.Available}
+}
+
+func (f Forecast) String() string {
+	label, available := "available", "unknown"
+	if f.Budget {
+		label = "budget"
+	}
+	if f.Available > 0 {
+		available = FormatBytes(f.Available)
+	}
+	return fmt.Sprintf("Memory forecast: index %s + %d workers x %s + output %s = %s (%s: %s)",
+		FormatBytes(f.Index), f.Workers, FormatBytes(f.PerWorker), FormatBytes(f.Output),
+		FormatBytes(f.Total()), label, available)
+}
+
+// Available returns the memory available to the run. It is a variable so
+// the abort path can be exercised with a fake value.
+var Available = readAvailable
+
+// NewForecast forecasts a run of numWorkers over data, measuring the index
+// as the heap grown since before was read.
+func NewForecast(data *compare.Data, numWorkers int, bitsetBuffer bool, before *runtime.MemStats) Forecast {
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	index := uint64(0)
+	if after.HeapAlloc > before.HeapAlloc {
+		index = after.HeapAlloc - before.HeapAlloc
+	}
+
+	// The per-name dedupe set can hold at most one name ID per candidate, and
+	// a name's candidates are bounded in practice by the largest bucket.
+	largestBucket := 0
+	for _, size := range data.BucketSizes() {
+		largestBucket = max(largestBucket, size)
+	}
+	dedupeSet := uint64(largestBucket) * mapEntryBytes
+
+	matchesBuffer := uint64(data.Dict.Len()) * 8
+	if bitsetBuffer {
+		matchesBuffer = uint64(data.Dict.Len()+63) / 64 * 8
+	}
+	perWorker := uint64(float64(matchesBuffer+dedupeSet)*gcOverhead) + writerBuffer
+
+	return Forecast{
+		Index:     index,
+		PerWorker: perWorker,
+		Workers:   numWorkers,
+		Output:    writerBuffer,
+		Available: Available(),
+	}
+}
+
+// readAvailable returns MemAvailable from /proc/meminfo, or 0 where that
+// isn't available (non-Linux systems), which disables the abort.
+func readAvailable() uint64 {
+	if runtime.GOOS != "linux" {
+		return 0
+	}
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			var kb uint64
+			if _, err := fmt.Sscan(fields[1], &kb); err != nil {
+				return 0
+			}
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+func FormatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// ParseByteSize parses a size such as 512M, 8GiB or 1.5G (binary units), or
+// a plain number of bytes. "" gives 0.
+func ParseByteSize(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	shift := 0
+	if num != "" {
+		if i := strings.IndexByte("KMGT", num[len(num)-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			num = num[:len(num)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad size %q (want e.g. 512M or 8GiB)", s)
+	}
+	return uint64(n * float64(uint64(1)<<shift)), nil
+}
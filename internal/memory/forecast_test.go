@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+)
+
+// fakeAvailable makes NewForecast see n bytes of available memory until the
+// test ends.
+func fakeAvailable(t *testing.T, n uint64) {
+	saved := Available
+	Available = func() uint64 { return n }
+	t.Cleanup(func() { Available = saved })
+}
+
+func TestForecastAbort(t *testing.T) {
+	data, err := compare.Load(strings.NewReader(`{
+		"all_names": ["john smith", "jon smith", "mary jones"],
+		"word_to_matches": {"john": ["john", "jon"], "jon": ["jon", "john"], "smith": ["smith"]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	forecast := NewForecast(data, 4, false, &before)
+	// As if the index had taken much more than the workers will
+	forecast.Index = 1 << 30
+	workers := forecast.PerWorker*4 + forecast.Output
+
+	for _, c := range []struct {
+		name      string
+		available uint64
+		budget    bool
+		want      string
+	}{
+		{"plenty", 1 << 40, false, ""},
+		{"too little", workers / 2, false, "forecast memory use exceeds available memory"},
+		// MemAvailable already excludes the loaded index, so only the
+		// workers and output have to fit
+		{"index counted once", workers * 2, false, ""},
+		// A budget covers the whole heap
+		{"budget", workers * 2, true, "forecast memory use exceeds --max-memory"},
+		{"unknown", 0, false, ""},
+	} {
+		fakeAvailable(t, c.available)
+		f := NewForecast(data, 4, false, &before)
+		f.Index = forecast.Index
+		if c.budget {
+			f.Available, f.Budget = c.available, true
+		}
+		if got := f.AbortReason(); got != c.want {
+			t.Errorf("%s: AbortReason() = %q, want %q (%v)", c.name, got, c.want, f)
+		}
+		var limit *compare.ResourceLimitError
+		switch err := f.Err(); {
+		case c.want == "":
+			if err != nil {
+				t.Errorf("%s: Err() = %v", c.name, err)
+			}
+		case !errors.Is(err, compare.ErrResourceLimit) || !errors.As(err, &limit):
+			t.Errorf("%s: Err() = %v, want a resource limit", c.name, err)
+		case limit.Available != c.available || limit.Need <= limit.Available || (limit.Limit == "max-memory") != c.budget:
+			t.Errorf("%s: Err() = %+v", c.name, limit)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want uint64
+	}{
+		{"", 0}, {"512", 512}, {"512M", 512 << 20}, {"8GiB", 8 << 30}, {"1.5g", 3 << 29}, {"2 KB", 2048},
+	} {
+		if got, err := ParseByteSize(c.in); err != nil || got != c.want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d", c.in, got, err, c.want)
+		}
+	}
+	for _, in := range []string{"G", "-1M", "lots"} {
+		if _, err := ParseByteSize(in); err == nil {
+			t.Errorf("ParseByteSize(%q) succeeded", in)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package output
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/records"
+)
+
+// --- CHECKPOINTS ---
+// With --checkpoint, worker files live in a directory that survives the run,
+// and each worker appends the indices (into AllNames) of the names it has
+// finished to worker_N.done, as TypeIndex records so a torn or corrupted log
+// is caught like a torn worker file. A name is only logged after its output lines
+// have been flushed and fsynced, so after a crash it is either fully present
+// or reprocessed; any lines it wrote before the crash are removed again by
+// the merge dedupe.
+
+// How often a worker syncs its output and logs completed names.
+const checkpointInterval = 30 * time.Second
+
+// CheckpointMeta describes the run a checkpoint belongs to. A resumed run
+// has to match it, or its output would be mixed with pairs of another input
+// or another configuration.
+type CheckpointMeta struct {
+	Input        string `json:"input"`
+	TotalNames   int    `json:"total_names"`
+	OutputFormat string `json:"output_format"`
+	// Identify the loaded input (see compare.Data.Hash) and the settings
+	// deciding which pairs the run finds
+	InputHash  string `json:"input_hash"`
+	ConfigHash string `json:"config_hash"`
+}
+
+// OpenCheckpoint prepares dir for a run. A fresh run requires an empty (or
+// missing) directory. A resumed run requires matching metadata, trims any
+// partially written trailing block from the worker files, and returns which
+// names are already complete.
+func OpenCheckpoint(dir string, meta CheckpointMeta, resume bool) ([]bool, int, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, 0, err
+	}
+	metaPath := filepath.Join(dir, "checkpoint.json")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !resume {
+		if len(entries) > 0 {
+			return nil, 0, fmt.Errorf("checkpoint directory %s is not empty; pass --resume to continue it", dir)
+		}
+		raw, err := json.Marshal(meta)
+		if err != nil {
+			return nil, 0, err
+		}
+		return make([]bool, meta.TotalNames), 0, os.WriteFile(metaPath, raw, 0o644)
+	}
+
+	raw, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	var prev CheckpointMeta
+	if err := json.Unmarshal(raw, &prev); err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", metaPath, err)
+	}
+	if prev.TotalNames != meta.TotalNames || prev.OutputFormat != meta.OutputFormat {
+		return nil, 0, &compare.MismatchError{Field: "checkpoint", Err: fmt.Errorf("written for %d names in %s format, this run has %d names in %s format",
+			prev.TotalNames, prev.OutputFormat, meta.TotalNames, meta.OutputFormat)}
+	}
+	if prev.InputHash != meta.InputHash {
+		return nil, 0, &compare.MismatchError{Field: "checkpoint", Err: fmt.Errorf("written for a different input than %s (input hash %s, this run has %s); start a new one",
+			meta.Input, orNone(prev.InputHash), meta.InputHash)}
+	}
+	if prev.ConfigHash != meta.ConfigHash {
+		return nil, 0, &compare.MismatchError{Field: "checkpoint", Err: fmt.Errorf("written with different matching settings (config hash %s, this run has %s); resume with the flags it was started with",
+			orNone(prev.ConfigHash), meta.ConfigHash)}
+	}
+
+	completed := make([]bool, meta.TotalNames)
+	numCompleted := 0
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		switch filepath.Ext(entry.Name()) {
+		case ".rec":
+			if err := records.TruncateToValid(path); err != nil {
+				return nil, 0, err
+			}
+		case ".txt":
+			return nil, 0, fmt.Errorf("checkpoint %s was written by an older version with text worker files; start a new one", dir)
+		case ".done":
+			// A torn final block is dropped; its names get reprocessed
+			if err := records.TruncateToValid(path); err != nil {
+				return nil, 0, err
+			}
+			err := records.ForEach(path, records.TypeIndex, func(rec string) error {
+				if len(rec) != 4 {
+					return fmt.Errorf("%s: bad name index record", path)
+				}
+				idx := binary.LittleEndian.Uint32([]byte(rec))
+				if int(idx) < len(completed) && !completed[idx] {
+					completed[idx] = true
+					numCompleted++
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return completed, numCompleted, nil
+}
+
+// orNone stands in for a hash missing from an older checkpoint.
+func orNone(hash string) string {
+	if hash == "" {
+		return "none"
+	}
+	return hash
+}
+
+type workerCheckpoint struct {
+	log     *os.File
+	logW    *records.Writer
+	pending []uint32
+	last    time.Time
+}
+
+func newWorkerCheckpoint(dir string, id int) (*workerCheckpoint, error) {
+	path := filepath.Join(dir, fmt.Sprintf("worker_%d.done", id))
+	log, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := log.Stat()
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+	w := records.NewWriter(log)
+	if info.Size() > 0 {
+		w = records.NewAppendWriter(log)
+	}
+	return &workerCheckpoint{log: log, logW: w, last: time.Now()}, nil
+}
+
+// commit makes the worker's output durable and only then logs the pending
+// names as complete.
+func (c *workerCheckpoint) commit(writer *records.Writer, out *os.File) error {
+	c.last = time.Now()
+	if len(c.pending) == 0 {
+		return nil
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	var buf [4]byte
+	for _, idx := range c.pending {
+		binary.LittleEndian.PutUint32(buf[:], idx)
+		if err := c.logW.Write(records.TypeIndex, buf[:]); err != nil {
+			return err
+		}
+	}
+	// One block per commit, so a crash can only tear the last one
+	if err := c.logW.Flush(); err != nil {
+		return err
+	}
+	if err := c.log.Sync(); err != nil {
+		return err
+	}
+	c.pending = c.pending[:0]
+	return nil
+}
+
+func (c *workerCheckpoint) close() {
+	c.log.Close()
+}
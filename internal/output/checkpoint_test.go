@@ -0,0 +1,222 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/records"
+)
+
+var testMeta = CheckpointMeta{
+	Input:        "names.json",
+	TotalNames:   20,
+	OutputFormat: "tuple",
+	InputHash:    "input-1",
+	ConfigHash:   "config-1",
+}
+
+func TestCheckpointRefusesOtherRun(t *testing.T) {
+	dir := t.TempDir()
+	if _, _, err := OpenCheckpoint(dir, testMeta, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := OpenCheckpoint(dir, testMeta, false); err == nil {
+		t.Error("a fresh run reused a checkpoint directory")
+	}
+	for _, c := range []struct {
+		name string
+		edit func(*CheckpointMeta)
+		err  string
+	}{
+		{"names", func(m *CheckpointMeta) { m.TotalNames++ }, "21 names"},
+		{"format", func(m *CheckpointMeta) { m.OutputFormat = "csv" }, "csv format"},
+		// Same size and format, different names
+		{"input", func(m *CheckpointMeta) { m.InputHash = "input-2" }, "different input"},
+		{"config", func(m *CheckpointMeta) { m.ConfigHash = "config-2" }, "different matching settings"},
+	} {
+		meta := testMeta
+		c.edit(&meta)
+		_, _, err := OpenCheckpoint(dir, meta, true)
+		if !errors.Is(err, compare.ErrIndexCorpusMismatch) || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: resume error %v, want a mismatch mentioning %q", c.name, err, c.err)
+		}
+	}
+	if _, n, err := OpenCheckpoint(dir, testMeta, true); err != nil || n != 0 {
+		t.Errorf("resume of the same run: %d names completed, %v", n, err)
+	}
+}
+
+// runNames processes the names of a checkpointed run that aren't completed
+// yet, round robin over numWorkers, stopping after stopAfter names (-1 for
+// all of them). Name i finds the pair ("name i", "other i"). Like a run, it
+// hands the first worker's file to merge as soon as that worker is done.
+func runNames(t *testing.T, dir string, numWorkers int, completed []bool, stopAfter int, merge *Merger) {
+	t.Helper()
+	outputs, err := OpenWorkerOutputs(dir, numWorkers, Tuple, false, false, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker := 0
+	for idx, done := range completed {
+		if done {
+			continue
+		}
+		if stopAfter == 0 {
+			break
+		}
+		stopAfter--
+		outputs.Emit(compare.Pair{A: fmt.Sprintf("name %d", idx), B: fmt.Sprintf("other %d", idx), Worker: worker})
+		if err := outputs.NameDone(worker, idx); err != nil {
+			t.Fatal(err)
+		}
+		worker = (worker + 1) % numWorkers
+	}
+	if merge != nil {
+		path, err := outputs.FinishWorker(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := merge.Add(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := outputs.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A run resumed with a different --workers value keeps the files of worker
+// slots it doesn't use, and merges every file once.
+func TestResumeWithOtherWorkerCount(t *testing.T) {
+	for _, c := range []struct{ before, after int }{{4, 2}, {2, 5}, {3, 3}} {
+		for _, allowDuplicates := range []bool{false, true} {
+			dir := t.TempDir()
+			completed, _, err := OpenCheckpoint(dir, testMeta, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runNames(t, dir, c.before, completed, 9, nil)
+
+			completed, n, err := OpenCheckpoint(dir, testMeta, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 9 {
+				t.Errorf("%d -> %d workers: %d names completed, want 9", c.before, c.after, n)
+			}
+			opts := MergeOptions{AllowDuplicates: allowDuplicates, Sorted: true}
+			merge := NewMerger(dir, opts, c.after)
+			runNames(t, dir, c.after, completed, -1, merge)
+			out := filepath.Join(t.TempDir(), "out.txt")
+			if err := merge.WriteFile(out); err != nil {
+				t.Fatal(err)
+			}
+
+			var want []string
+			for i := range testMeta.TotalNames {
+				want = append(want, fmt.Sprintf(`("name %d", "other %d")`, i, i))
+			}
+			slices.Sort(want)
+			if got := readLines(t, out); !slices.Equal(got, want) {
+				t.Errorf("%d -> %d workers, allow duplicates %v: lines %q, want %q", c.before, c.after, allowDuplicates, got, want)
+			}
+		}
+	}
+}
+
+// A run resumed after --publish-every rotated its worker files continues
+// with a new segment instead of appending a second header to the last one.
+func TestResumeAfterRotation(t *testing.T) {
+	dir := t.TempDir()
+	if _, _, err := OpenCheckpoint(dir, testMeta, false); err != nil {
+		t.Fatal(err)
+	}
+	idx := 0
+	// run processes names names, rotating the worker's file before each
+	// but the first
+	run := func(names int) {
+		outputs, err := OpenWorkerOutputs(dir, 1, Tuple, false, false, true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range names {
+			if i > 0 {
+				outputs.rotateGen.Add(1)
+			}
+			outputs.Emit(compare.Pair{A: fmt.Sprintf("name %d", idx), B: fmt.Sprintf("other %d", idx)})
+			if err := outputs.NameDone(0, idx); err != nil {
+				t.Fatal(err)
+			}
+			if i > 0 {
+				<-outputs.rotated
+			}
+			idx++
+		}
+		if err := outputs.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run(3)
+	if _, n, err := OpenCheckpoint(dir, testMeta, true); err != nil || n != 3 {
+		t.Fatalf("resume: %d names completed, %v", n, err)
+	}
+	run(3)
+
+	var got []string
+	paths, _ := filepath.Glob(filepath.Join(dir, "worker_0*.rec"))
+	for _, path := range paths {
+		err := records.ForEach(path, records.TypeLine, func(line string) error {
+			got = append(got, line)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(path), err)
+		}
+	}
+	var want []string
+	for i := range idx {
+		want = append(want, fmt.Sprintf(`("name %d", "other %d")`, i, i))
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("lines %q, want %q", got, want)
+	}
+	if len(paths) != 5 {
+		t.Errorf("worker files %q, want the first and 4 segments", paths)
+	}
+}
+
+// A corrupted completion log loses its names, which are processed again,
+// rather than marking arbitrary names complete.
+func TestCorruptDoneLog(t *testing.T) {
+	dir := t.TempDir()
+	completed, _, err := OpenCheckpoint(dir, testMeta, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runNames(t, dir, 1, completed, 5, nil)
+	path := filepath.Join(dir, "worker_0.done")
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-1] ^= 0xff
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	completed, n, err := OpenCheckpoint(dir, testMeta, true)
+	if err != nil || n != 0 {
+		t.Fatalf("resume: %d names completed, %v", n, err)
+	}
+	runNames(t, dir, 1, completed, 3, nil)
+	if _, n, err := OpenCheckpoint(dir, testMeta, true); err != nil || n != 3 {
+		t.Errorf("second resume: %d names completed, %v", n, err)
+	}
+}
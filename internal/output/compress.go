@@ -0,0 +1,155 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// --- OUTPUT COMPRESSION ---
+// The merge reads the worker files record by record and writes the output
+// as a single compressed stream, so the output never has to be stitched
+// together from separately compressed pieces. The worker files are
+// compressed block by block with deflate (see records.Writer.Compress)
+// whichever codec the output uses.
+
+type Compression int
+
+const (
+	CompressNone Compression = iota
+	CompressGzip
+	CompressZstd
+)
+
+// ParseCompression picks the codec from --compress, the older
+// --compress-output and the output path's extension, in that order.
+func ParseCompression(name string, gzipFlag bool, outputPath string) (Compression, error) {
+	switch name {
+	case "gzip":
+		return CompressGzip, nil
+	case "zstd":
+		return CompressZstd, nil
+	case "":
+	default:
+		return 0, fmt.Errorf("unknown --compress %q (want gzip or zstd)", name)
+	}
+	switch {
+	case gzipFlag || strings.HasSuffix(outputPath, ".gz"):
+		return CompressGzip, nil
+	case strings.HasSuffix(outputPath, ".zst"):
+		return CompressZstd, nil
+	}
+	return CompressNone, nil
+}
+
+// Stdout is the process's stdout, which main redirects to stderr
+// when the results are written to stdout.
+var Stdout = os.Stdout
+
+// CreateResults opens the output file for the merged results (or a partial
+// publication of them): a database loaded through the sqlite3 command for
+// sqlite output, else a possibly compressed file. An existing database is
+// replaced, like any other output file.
+func CreateResults(path string, opts MergeOptions) (io.WriteCloser, error) {
+	if !opts.SQLite {
+		return Create(path, opts.Compression)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	out, err := startOutputCommand(exec.Command("sqlite3", "-batch", "-bail", path), path+": sqlite3", nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: writing sqlite output needs the sqlite3 command: %w", path, err)
+	}
+	return out, nil
+}
+
+// Create creates the file at path, or writes to stdout for "-",
+// compressing what is written to it with c. Close finishes the compressed
+// stream and closes the file.
+func Create(path string, c Compression) (io.WriteCloser, error) {
+	f := Stdout
+	if path != "-" {
+		var err error
+		if f, err = os.Create(path); err != nil {
+			return nil, err
+		}
+	}
+	switch c {
+	case CompressGzip:
+		return &gzipOutput{Writer: gzip.NewWriter(f), file: f}, nil
+	case CompressZstd:
+		out, err := startOutputCommand(exec.Command("zstd", "-q", "-c"), f.Name()+": zstd", f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: writing zstd output needs the zstd command: %w", path, err)
+		}
+		return out, nil
+	}
+	return f, nil
+}
+
+type gzipOutput struct {
+	*gzip.Writer
+	file *os.File
+}
+
+func (g *gzipOutput) Close() error {
+	err := g.Writer.Close()
+	if cerr := g.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// commandOutput writes to a command's stdin, such as zstd, as the standard
+// library has no zstd encoder, or sqlite3.
+type commandOutput struct {
+	io.WriteCloser // the command's stdin
+	cmd            *exec.Cmd
+	stderr         bytes.Buffer
+	label          string
+	// The command's stdout, if it writes the output there
+	file *os.File
+}
+
+func startOutputCommand(cmd *exec.Cmd, label string, stdout *os.File) (*commandOutput, error) {
+	c := &commandOutput{cmd: cmd, label: label, file: stdout}
+	if stdout != nil {
+		cmd.Stdout = stdout
+	}
+	cmd.Stderr = &c.stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	c.WriteCloser = stdin
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close ends the command's input, waits for it to finish (zstd its frame,
+// sqlite3 its transaction) and reports a failed command.
+func (c *commandOutput) Close() error {
+	err := c.WriteCloser.Close()
+	if werr := c.cmd.Wait(); werr != nil {
+		msg := strings.TrimSpace(c.stderr.String())
+		if msg == "" {
+			msg = werr.Error()
+		}
+		err = fmt.Errorf("%s: %s", c.label, msg)
+	}
+	if c.file != nil {
+		if cerr := c.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package output
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// --- CHECKPOINT CONFIG ---
+// A resumed run must find the same pairs the interrupted one would have, so
+// the checkpoint records a hash of every flag that decides which pairs those
+// are, and of the files such flags name.
+
+// Flags that may change between a checkpointed run and its resume: they
+// decide how the run goes, not which pairs it finds.
+var resumableFlags = map[string]bool{
+	"allow-duplicates": true, "bitset-buffer": true, "checkpoint": true, "collapse-duplicates": true,
+	"dump-pair-index": true, "exit-status": true, "ignore-memory-forecast": true, "job-batch": true,
+	"live-hubs": true, "max-memory": true, "min-expected-matches": true, "no-input-sample": true,
+	"on-failure-bundle": true, "on-few-matches": true, "pairs-on-disk": true, "progress": true,
+	"publish-every": true, "quiet": true, "resume": true, "skip-symmetric": true,
+	"sort-output": true, "workers": true,
+}
+
+// Flags naming files that decide which pairs a run finds but aren't part of
+// the loaded input.
+var pairFileFlags = []string{
+	"changed-names", "exclude-pairs", "exclude-word-pairs", "include-pairs",
+	"particles-file", "previous-output", "review-state", "validation-rules",
+}
+
+// MatchConfigHash identifies the settings of fs that decide which pairs a
+// run finds, including the contents of the files in pairFileFlags.
+func MatchConfigHash(fs *flag.FlagSet) (string, error) {
+	h := sha256.New()
+	fs.VisitAll(func(f *flag.Flag) {
+		if !resumableFlags[f.Name] {
+			fmt.Fprintf(h, "%s=%q\n", f.Name, f.Value.String())
+		}
+	})
+	for _, name := range pairFileFlags {
+		f := fs.Lookup(name)
+		if f == nil || f.Value.String() == "" {
+			continue
+		}
+		path := f.Value.String()
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s:\n", name)
+		_, err = io.Copy(h, file)
+		file.Close()
+		if err != nil {
+			return "", fmt.Errorf("--%s %s: %w", name, path, err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
@@ -0,0 +1,40 @@
+package output
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchConfigHash(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "rules.json")
+	hash := func(rulesJSON string, args ...string) string {
+		t.Helper()
+		if err := os.WriteFile(rules, []byte(rulesJSON), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		fs := flag.NewFlagSet("run", flag.ContinueOnError)
+		fs.Int("workers", 0, "")
+		fs.Int("min-common-words", 2, "")
+		fs.String("validation-rules", "", "")
+		if err := fs.Parse(append(args, "--validation-rules", rules)); err != nil {
+			t.Fatal(err)
+		}
+		h, err := MatchConfigHash(fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	want := hash(`[]`)
+	if got := hash(`[]`, "--workers", "16"); got != want {
+		t.Error("--workers changes the hash")
+	}
+	if hash(`[]`, "--min-common-words", "1") == want {
+		t.Error("--min-common-words doesn't change the hash")
+	}
+	if hash(`[{"reject_if": "words_a > 5"}]`) == want {
+		t.Error("the contents of --validation-rules don't change the hash")
+	}
+}
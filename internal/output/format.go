@@ -0,0 +1,245 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+)
+
+// --- OUTPUT FORMATS ---
+// Worker temp files are written in the final format, so the merge only has
+// to add a header (and a footer). The sqlite format's lines are SQL
+// statements, which the merge feeds to the sqlite3 command.
+
+type Format int
+
+const (
+	Tuple Format = iota
+	CSV
+	JSONL
+	SQLite
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "tuple":
+		return Tuple, nil
+	case "csv":
+		return CSV, nil
+	case "jsonl":
+		return JSONL, nil
+	case "sqlite":
+		return SQLite, nil
+	}
+	return 0, fmt.Errorf("unknown output format %q (want tuple, csv, jsonl or sqlite)", s)
+}
+
+// Describe names the format as recorded in checkpoint metadata, so a run
+// can't resume into files written with different columns.
+func (f Format) Describe(scores bool) string {
+	name := [...]string{Tuple: "tuple", CSV: "csv", JSONL: "jsonl", SQLite: "sqlite"}[f]
+	if scores {
+		name += "+scores"
+	}
+	return name
+}
+
+// Header returns the first line of the merged output, if the format has one.
+// The matches table always has the score and tag columns.
+func (f Format) Header(tagged, scores bool) string {
+	if f == SQLite {
+		return "BEGIN;\nCREATE TABLE matches (name_a TEXT NOT NULL, name_b TEXT NOT NULL, score REAL NOT NULL, tag TEXT);"
+	}
+	if f != CSV {
+		return ""
+	}
+	header := "name_a,name_b"
+	if scores {
+		header += ",score"
+	}
+	if tagged {
+		header += ",tag"
+	}
+	return header
+}
+
+// Footer returns the last line of the merged output, if the format has one.
+// Pairs are stored with name_a < name_b, so finding every pair of a name
+// takes both indexes.
+func (f Format) Footer() string {
+	if f != SQLite {
+		return ""
+	}
+	return "CREATE INDEX matches_name_a ON matches (name_a);\nCREATE INDEX matches_name_b ON matches (name_b);\nCOMMIT;"
+}
+
+// LineEnd returns the record terminator: CRLF for CSV, as RFC 4180 asks,
+// and a plain newline for the line formats.
+func (f Format) LineEnd() string {
+	if f == CSV {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// FormatScore always uses four decimals so reruns diff cleanly.
+func FormatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', 4, 64)
+}
+
+// FormatMatch renders an output line (without the line end). Untagged pairs
+// keep the plain two-element tuple so existing consumers are unaffected.
+// The tuple format does no escaping, to stay byte-identical with older runs.
+// jsonl records also carry the token and mismatch counts the pair was
+// validated on. The score, when enabled, comes after those so the optional
+// tag stays last. CSV rows of a tagged run (see Header) always have the tag
+// column, empty for untagged pairs, so every row has as many fields as the
+// header.
+func (f Format) FormatMatch(p compare.Pair, tagged, scores bool) string {
+	switch f {
+	case CSV:
+		line := csvField(p.A) + "," + csvField(p.B)
+		if scores {
+			line += "," + FormatScore(p.Score)
+		}
+		if tagged || p.Tag != "" {
+			line += "," + csvField(p.Tag)
+		}
+		return line
+	case JSONL:
+		line := `{"name_a":` + jsonString(p.A) + `,"name_b":` + jsonString(p.B) +
+			`,"tokens_a":` + strconv.Itoa(p.Counts.WordsA) + `,"tokens_b":` + strconv.Itoa(p.Counts.WordsB) +
+			`,"mismatches_a":` + strconv.Itoa(p.Counts.MismatchesA) + `,"mismatches_b":` + strconv.Itoa(p.Counts.MismatchesB)
+		if scores {
+			line += `,"score":` + FormatScore(p.Score)
+		}
+		if p.Tag != "" {
+			line += `,"tag":` + jsonString(p.Tag)
+		}
+		return line + "}"
+	case SQLite:
+		tag := "NULL"
+		if p.Tag != "" {
+			tag = sqlString(p.Tag)
+		}
+		return "INSERT INTO matches VALUES (" + sqlString(p.A) + ", " + sqlString(p.B) + ", " + FormatScore(p.Score) + ", " + tag + ");"
+	}
+	line := fmt.Sprintf("(\"%s\", \"%s\"", p.A, p.B)
+	if scores {
+		line += ", " + FormatScore(p.Score)
+	}
+	if p.Tag != "" {
+		line += fmt.Sprintf(", \"%s\"", p.Tag)
+	}
+	return line + ")"
+}
+
+// csvField quotes a field per RFC 4180 when it contains a separator, quote,
+// line break or leading space.
+func csvField(s string) string {
+	if s == "" || (!strings.ContainsAny(s, ",\"\r\n") && s[0] != ' ') {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// --format lines are rendered from templateFields. Score prints with four
+// decimals like in the other formats, but still compares as a number, so
+// {{if ge .Score 0.9}} works. In the template text, \t stands for a tab,
+// as shells don't make one easy to pass.
+
+type templateFields struct {
+	A, B                     string
+	Score                    templateScore
+	Tag                      string
+	TokensA, TokensB         int
+	MismatchesA, MismatchesB int
+}
+
+type templateScore float64
+
+func (s templateScore) String() string {
+	return FormatScore(float64(s))
+}
+
+var templateFuncs = template.FuncMap{
+	"csv":  csvField,
+	"json": jsonString,
+	"sql":  sqlString,
+}
+
+// ParseTemplate parses a --format template and renders a sample pair,
+// so a misspelt field fails the run before any work is done.
+func ParseTemplate(text string) (*template.Template, error) {
+	text = strings.NewReplacer(`\t`, "\t", `\\`, `\`).Replace(text)
+	tmpl, err := template.New("format").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("--format: %w", err)
+	}
+	if err := tmpl.Execute(io.Discard, templateFields{A: "a", B: "b", Tag: "unsure"}); err != nil {
+		return nil, fmt.Errorf("--format: %w", err)
+	}
+	return tmpl, nil
+}
+
+// formatTemplate renders an output line with a --format template. The
+// template was checked by ParseTemplate, so an error here can only come
+// from a template func and is written into the line rather than lost.
+func formatTemplate(tmpl *template.Template, p compare.Pair) string {
+	var sb strings.Builder
+	err := tmpl.Execute(&sb, templateFields{
+		A: p.A, B: p.B, Score: templateScore(p.Score), Tag: p.Tag,
+		TokensA: p.Counts.WordsA, TokensB: p.Counts.WordsB,
+		MismatchesA: p.Counts.MismatchesA, MismatchesB: p.Counts.MismatchesB,
+	})
+	if err != nil {
+		sb.WriteString(" <" + err.Error() + ">")
+	}
+	return sb.String()
+}
+
+// DescribeLines is Format.Describe, or the template for --format.
+func DescribeLines(f Format, scores bool, lineTemplate string) string {
+	if lineTemplate != "" {
+		return "template " + lineTemplate
+	}
+	return f.Describe(scores)
+}
+
+// sqlString quotes s as an SQL string literal.
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// jsonString quotes s as a JSON string. Unlike json.Marshal it leaves <, >
+// and & alone, so names stay readable.
+func jsonString(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s) + 2)
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"':
+			sb.WriteString(`\"`)
+		case r == '\\':
+			sb.WriteString(`\\`)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r < 0x20 || r == 0x2028 || r == 0x2029:
+			fmt.Fprintf(&sb, `\u%04x`, r)
+		default:
+			// Invalid UTF-8 decodes to utf8.RuneError and is written as U+FFFD
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
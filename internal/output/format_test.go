@@ -0,0 +1,146 @@
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+)
+
+// Names that need escaping in one format or another
+var awkwardPairs = []compare.Pair{
+	{A: "smith, john", B: "smith, jon", Score: 1},
+	{A: `john "jack" smith`, B: `jon "jack" smith`, Score: 0.75, Tag: "unsure",
+		Counts: compare.WordCounts{WordsA: 2, WordsB: 2, MismatchesB: 1}},
+	{A: "josé müller", B: "jose muller", Score: 0.5},
+	{A: "Зоя Петрова", B: "李 小龍", Score: 0.25, Tag: "confirmed"},
+	{A: " leading space", B: "line\nbreak"},
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		tagged, scores bool
+	}{{false, false}, {false, true}, {true, false}, {true, true}} {
+		pairs := awkwardPairs
+		if !c.tagged {
+			// An untagged run has no tagged pairs
+			pairs = nil
+			for _, p := range awkwardPairs {
+				p.Tag = ""
+				pairs = append(pairs, p)
+			}
+		}
+		lines := []string{CSV.Header(c.tagged, c.scores)}
+		for _, p := range pairs {
+			lines = append(lines, CSV.FormatMatch(p, c.tagged, c.scores))
+		}
+		// FieldsPerRecord 0 makes the reader insist on the header's count
+		rows, err := csv.NewReader(strings.NewReader(strings.Join(lines, "\n") + "\n")).ReadAll()
+		if err != nil {
+			t.Fatalf("tagged %v, scores %v: %v", c.tagged, c.scores, err)
+		}
+		header := rows[0]
+		for i, p := range pairs {
+			row := rows[i+1]
+			want := []string{p.A, p.B}
+			if c.scores {
+				want = append(want, FormatScore(p.Score))
+			}
+			if c.tagged {
+				want = append(want, p.Tag)
+			}
+			if !slices.Equal(row, want) {
+				t.Errorf("tagged %v, scores %v: row %q, want %q (header %q)", c.tagged, c.scores, row, want, header)
+			}
+		}
+	}
+}
+
+func TestJSONLRoundTrip(t *testing.T) {
+	for _, scores := range []bool{false, true} {
+		for _, p := range awkwardPairs {
+			line := JSONL.FormatMatch(p, true, scores)
+			var rec struct {
+				A           string   `json:"name_a"`
+				B           string   `json:"name_b"`
+				TokensA     int      `json:"tokens_a"`
+				TokensB     int      `json:"tokens_b"`
+				MismatchesA int      `json:"mismatches_a"`
+				MismatchesB int      `json:"mismatches_b"`
+				Score       *float64 `json:"score"`
+				Tag         string   `json:"tag"`
+			}
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				t.Fatalf("%s: %v", line, err)
+			}
+			if rec.A != p.A || rec.B != p.B || rec.Tag != p.Tag || (rec.Score != nil) != scores {
+				t.Errorf("%s decodes to %+v", line, rec)
+			}
+			counts := compare.WordCounts{WordsA: rec.TokensA, WordsB: rec.TokensB, MismatchesA: rec.MismatchesA, MismatchesB: rec.MismatchesB}
+			if counts != p.Counts {
+				t.Errorf("%s: counts %+v, want %+v", line, counts, p.Counts)
+			}
+			if strings.Contains(line, "\n") {
+				t.Errorf("%q spans lines", line)
+			}
+		}
+	}
+}
+
+func TestSQLiteStatements(t *testing.T) {
+	for _, c := range []struct {
+		pair compare.Pair
+		want string
+	}{
+		{awkwardPairs[0], `INSERT INTO matches VALUES ('smith, john', 'smith, jon', 1.0000, NULL);`},
+		{awkwardPairs[1], `INSERT INTO matches VALUES ('john "jack" smith', 'jon "jack" smith', 0.7500, 'unsure');`},
+		{compare.Pair{A: "o'brien pat", B: "obrien pat"}, `INSERT INTO matches VALUES ('o''brien pat', 'obrien pat', 0.0000, NULL);`},
+		{awkwardPairs[3], `INSERT INTO matches VALUES ('Зоя Петрова', '李 小龍', 0.2500, 'confirmed');`},
+	} {
+		if got := SQLite.FormatMatch(c.pair, true, true); got != c.want {
+			t.Errorf("got  %s\nwant %s", got, c.want)
+		}
+	}
+}
+
+// The tuple format stays byte-identical with older runs, so it doesn't
+// escape anything.
+func TestTupleLines(t *testing.T) {
+	for _, c := range []struct {
+		pair   compare.Pair
+		scores bool
+		want   string
+	}{
+		{awkwardPairs[0], false, `("smith, john", "smith, jon")`},
+		{awkwardPairs[0], true, `("smith, john", "smith, jon", 1.0000)`},
+		{awkwardPairs[1], false, `("john "jack" smith", "jon "jack" smith", "unsure")`},
+		{awkwardPairs[2], false, `("josé müller", "jose muller")`},
+	} {
+		if got := Tuple.FormatMatch(c.pair, true, c.scores); got != c.want {
+			t.Errorf("got %s, want %s", got, c.want)
+		}
+	}
+}
+
+// A --format template sees the counts and a score that prints with four
+// decimals but still compares as a number.
+func TestTemplateLines(t *testing.T) {
+	tmpl, err := ParseTemplate(`{{csv .A}}\t{{.B}}\t{{.Score}}\t{{.MismatchesB}}{{if ge .Score 0.7}} close{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "\"smith, john\"\tsmith, jon\t1.0000\t0 close"
+	if got := formatTemplate(tmpl, awkwardPairs[0]); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	want = "\"john \"\"jack\"\" smith\"\tjon \"jack\" smith\t0.7500\t1 close"
+	if got := formatTemplate(tmpl, awkwardPairs[1]); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := ParseTemplate(`{{.Name}}`); err == nil {
+		t.Error("a misspelt field parsed")
+	}
+}
@@ -0,0 +1,366 @@
+package output
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/records"
+)
+
+// dedupePartitionBytes is roughly how much raw output is deduplicated in
+// memory at once during the merge.
+const dedupePartitionBytes = 256 << 20
+
+// MergeOptions configures a Merger.
+type MergeOptions struct {
+	// First and last lines of the output; empty for none
+	Header, Footer string
+	// Ends every line, the header included; empty for "\n"
+	LineEnd string
+	// Keep the duplicate lines of pairs found from both sides
+	AllowDuplicates bool
+	// Codec of the output
+	Compression Compression
+	// Load the output into a SQLite database at the output path
+	SQLite bool
+	// Sort the lines bytewise, so identical runs give identical files
+	Sorted bool
+}
+
+// Merger combines the worker files into the final output. The same pair is
+// found once from each side, usually by different workers, so unless
+// allowDuplicates is set the lines are spilled into hash partitions and each
+// partition is deduplicated in memory on its own. Sorted output is cut into
+// sorted runs instead, which are merged at the end (see writeSorted).
+//
+// Files of workers that finish early can be handed to add while the other
+// workers are still running, so the spilling or run sorting of the bulk of
+// the output overlaps the skewed tail of the run. write then only has to
+// process the files it wasn't given.
+type Merger struct {
+	tempDir string
+	opts    MergeOptions
+	// Number of worker files expected, for sizing the hash partitions
+	expected int
+	// Files already processed by add
+	added map[string]bool
+
+	workDir string
+	spill   *records.HashSpill
+	// Sorted runs written so far, and the lines of the next one
+	runs  []string
+	lines []string
+	size  int
+
+	// Lines written to the output, not counting the header
+	written uint64
+}
+
+func NewMerger(tempDir string, opts MergeOptions, expected int) *Merger {
+	return &Merger{tempDir: tempDir, opts: opts, expected: expected, added: make(map[string]bool)}
+}
+
+// Written returns how many lines WriteFile wrote, not counting the header
+// and footer.
+func (m *Merger) Written() uint64 {
+	return m.written
+}
+
+// Add processes one finished worker file.
+func (m *Merger) Add(path string) error {
+	if m.opts.AllowDuplicates && !m.opts.Sorted {
+		// Concatenation has nothing to do ahead of time
+		return nil
+	}
+	m.added[path] = true
+	if m.workDir == "" {
+		dir, err := os.MkdirTemp(m.tempDir, "merge")
+		if err != nil {
+			return err
+		}
+		m.workDir = dir
+	}
+	if m.opts.Sorted {
+		return records.ForEach(path, records.TypeLine, func(line string) error {
+			m.lines = append(m.lines, line)
+			if m.size += len(line); m.size >= sortRunBytes {
+				return m.writeRun()
+			}
+			return nil
+		})
+	}
+	if m.spill == nil {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		// Worker files are roughly the same size, this one standing in
+		// for the ones still to come
+		if err := m.startSpill(info.Size() * int64(max(m.expected, 1))); err != nil {
+			return err
+		}
+	}
+	return records.ForEach(path, records.TypeLine, m.spill.Write)
+}
+
+// startSpill creates the hash partitions for about totalBytes of output.
+func (m *Merger) startSpill(totalBytes int64) error {
+	if m.workDir == "" {
+		dir, err := os.MkdirTemp(m.tempDir, "merge")
+		if err != nil {
+			return err
+		}
+		m.workDir = dir
+	}
+	spill, err := records.NewHashSpill(m.workDir, "part", int(totalBytes/dedupePartitionBytes)+1)
+	m.spill = spill
+	return err
+}
+
+// WriteFile writes the merged output to path, processing the worker files
+// in tempDir that weren't added yet.
+func (m *Merger) WriteFile(path string) error {
+	defer m.cleanup()
+	out, err := CreateResults(path, m.opts)
+	if err != nil {
+		return err
+	}
+	if err := m.write(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (o MergeOptions) lineEnd() string {
+	if o.LineEnd == "" {
+		return "\n"
+	}
+	return o.LineEnd
+}
+
+func (m *Merger) cleanup() {
+	if m.spill != nil {
+		m.spill.Close()
+	}
+	if m.workDir != "" {
+		os.RemoveAll(m.workDir)
+	}
+}
+
+func (m *Merger) write(out io.Writer) error {
+	bufWriter := bufio.NewWriter(out)
+	lineEnd := m.opts.lineEnd()
+	if m.opts.Header != "" {
+		bufWriter.WriteString(m.opts.Header + lineEnd)
+	}
+	files, err := os.ReadDir(m.tempDir)
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	var totalBytes int64
+	for _, fileEntry := range files {
+		// Skip checkpoint logs and metadata
+		if fileEntry.IsDir() || filepath.Ext(fileEntry.Name()) != ".rec" {
+			continue
+		}
+		info, err := fileEntry.Info()
+		if err != nil {
+			return err
+		}
+		totalBytes += info.Size()
+		paths = append(paths, filepath.Join(m.tempDir, fileEntry.Name()))
+	}
+
+	if m.opts.AllowDuplicates && !m.opts.Sorted {
+		for _, path := range paths {
+			err := records.ForEach(path, records.TypeLine, func(line string) error {
+				m.written++
+				_, err := bufWriter.WriteString(line + lineEnd)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return m.finish(bufWriter)
+	}
+
+	if !m.opts.Sorted && m.spill == nil {
+		if err := m.startSpill(totalBytes); err != nil {
+			return err
+		}
+	}
+	for _, path := range paths {
+		if !m.added[path] {
+			if err := m.Add(path); err != nil {
+				return err
+			}
+		}
+	}
+	if m.opts.Sorted {
+		if err := m.writeSorted(bufWriter); err != nil {
+			return err
+		}
+		return m.finish(bufWriter)
+	}
+	if err := m.spill.Flush(); err != nil {
+		return err
+	}
+	for i := range m.spill.Partitions() {
+		seen := make(map[string]struct{})
+		var writeErr error
+		err := m.spill.ReadPartition(i, func(line string) {
+			if _, dup := seen[line]; dup || writeErr != nil {
+				return
+			}
+			seen[line] = struct{}{}
+			m.written++
+			if _, err := bufWriter.WriteString(line); err != nil {
+				writeErr = err
+				return
+			}
+			_, writeErr = bufWriter.WriteString(lineEnd)
+		})
+		if err != nil {
+			return err
+		}
+		if writeErr != nil {
+			return writeErr
+		}
+	}
+	return m.finish(bufWriter)
+}
+
+// finish writes the footer and flushes.
+func (m *Merger) finish(w *bufio.Writer) error {
+	if m.opts.Footer != "" {
+		w.WriteString(m.opts.Footer + m.opts.lineEnd())
+	}
+	return w.Flush()
+}
+
+// writeRun sorts the pending lines and writes them out as a run.
+func (m *Merger) writeRun() error {
+	if len(m.lines) == 0 {
+		return nil
+	}
+	sort.Strings(m.lines)
+	path := filepath.Join(m.workDir, fmt.Sprintf("run_%d.rec", len(m.runs)))
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := records.NewWriter(f)
+	for i, line := range m.lines {
+		if !m.opts.AllowDuplicates && i > 0 && line == m.lines[i-1] {
+			continue
+		}
+		if err := w.WriteString(records.TypeLine, line); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	m.runs = append(m.runs, path)
+	m.lines, m.size = m.lines[:0], 0
+	return f.Close()
+}
+
+// writeSorted merges the sorted runs into out with one reader each, so
+// memory use doesn't grow with the output. Equal lines end up adjacent, and
+// unless allowDuplicates is set only the first is written.
+func (m *Merger) writeSorted(out *bufio.Writer) error {
+	if err := m.writeRun(); err != nil {
+		return err
+	}
+	m.lines = nil
+
+	h := &runHeap{}
+	for _, path := range m.runs {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r := &sortedRun{r: records.NewReader(bufio.NewReader(f))}
+		if ok, err := r.next(); err != nil {
+			return err
+		} else if ok {
+			h.runs = append(h.runs, r)
+		}
+	}
+	heap.Init(h)
+	last, wrote := "", false
+	for h.Len() > 0 {
+		r := h.runs[0]
+		if m.opts.AllowDuplicates || !wrote || r.line != last {
+			m.written++
+			out.WriteString(r.line)
+			if _, err := out.WriteString(m.opts.lineEnd()); err != nil {
+				return err
+			}
+			last, wrote = r.line, true
+		}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+// sortRunBytes is roughly how much output is sorted in memory at once for
+// --sort-output.
+const sortRunBytes = 256 << 20
+
+// sortedRun reads the lines of one sorted run file in turn.
+type sortedRun struct {
+	r    *records.Reader
+	line string
+}
+
+func (s *sortedRun) next() (bool, error) {
+	for {
+		rec, err := s.r.Next()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if rec.Type == records.TypeLine {
+			s.line = string(rec.Payload)
+			return true, nil
+		}
+	}
+}
+
+// runHeap orders sorted runs by their current line.
+type runHeap struct {
+	runs []*sortedRun
+}
+
+func (h *runHeap) Len() int           { return len(h.runs) }
+func (h *runHeap) Less(i, j int) bool { return h.runs[i].line < h.runs[j].line }
+func (h *runHeap) Swap(i, j int)      { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *runHeap) Push(x any)         { h.runs = append(h.runs, x.(*sortedRun)) }
+func (h *runHeap) Pop() any {
+	last := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return last
+}
@@ -0,0 +1,285 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+)
+
+// writeWorkers writes the pairs of each worker to worker files in dir, the
+// way a run does, and closes them.
+func writeWorkers(t *testing.T, dir string, format Format, workers [][]compare.Pair) {
+	t.Helper()
+	outputs, err := OpenWorkerOutputs(dir, len(workers), format, false, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, pairs := range workers {
+		for _, p := range pairs {
+			p.Worker = id
+			outputs.Emit(p)
+		}
+	}
+	if err := outputs.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n")
+}
+
+func TestMergeDeduplicates(t *testing.T) {
+	pairs := [][]compare.Pair{
+		{{A: "ann lee", B: "anne lee"}, {A: "bob ray", B: "rob ray"}},
+		// The same pairs found from their other names
+		{{A: "bob ray", B: "rob ray"}, {A: "ann lee", B: "anne lee"}, {A: "cy ott", B: "si ott"}},
+	}
+	for _, sorted := range []bool{false, true} {
+		dir := t.TempDir()
+		writeWorkers(t, dir, CSV, pairs)
+		out := filepath.Join(t.TempDir(), "out.csv")
+		m := NewMerger(dir, MergeOptions{Header: CSV.Header(false, false), Sorted: sorted}, len(pairs))
+		if err := m.WriteFile(out); err != nil {
+			t.Fatal(err)
+		}
+		lines := readLines(t, out)
+		if lines[0] != "name_a,name_b" {
+			t.Errorf("sorted %v: header %q", sorted, lines[0])
+		}
+		body := lines[1:]
+		if !sorted {
+			slices.Sort(body)
+		}
+		want := []string{"ann lee,anne lee", "bob ray,rob ray", "cy ott,si ott"}
+		if !slices.Equal(body, want) {
+			t.Errorf("sorted %v: lines %q, want %q", sorted, body, want)
+		}
+		if m.Written() != 3 {
+			t.Errorf("sorted %v: Written() = %d, want 3", sorted, m.Written())
+		}
+	}
+}
+
+func TestMergeAllowDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	writeWorkers(t, dir, Tuple, [][]compare.Pair{
+		{{A: "ann lee", B: "anne lee"}},
+		{{A: "ann lee", B: "anne lee"}},
+	})
+	out := filepath.Join(t.TempDir(), "out.txt")
+	m := NewMerger(dir, MergeOptions{AllowDuplicates: true}, 2)
+	if err := m.WriteFile(out); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`("ann lee", "anne lee")`, `("ann lee", "anne lee")`}
+	if got := readLines(t, out); !slices.Equal(got, want) {
+		t.Errorf("lines %q, want %q", got, want)
+	}
+}
+
+// Files handed to Add before WriteFile are merged once, not again with the
+// rest.
+func TestMergeAddedEarly(t *testing.T) {
+	dir := t.TempDir()
+	writeWorkers(t, dir, Tuple, [][]compare.Pair{
+		{{A: "ann lee", B: "anne lee"}},
+		{{A: "bob ray", B: "rob ray"}},
+	})
+	m := NewMerger(dir, MergeOptions{}, 2)
+	if err := m.Add(filepath.Join(dir, "worker_0.rec")); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "out.txt")
+	if err := m.WriteFile(out); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLines(t, out); len(got) != 2 || m.Written() != 2 {
+		t.Errorf("lines %q, Written() = %d", got, m.Written())
+	}
+}
+
+func TestMergedCSVIsRectangular(t *testing.T) {
+	dir := t.TempDir()
+	outputs, err := OpenWorkerOutputs(dir, 2, CSV, true, true, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range awkwardPairs {
+		p.Worker = i % 2
+		outputs.Emit(p)
+	}
+	if err := outputs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "out.csv")
+	m := NewMerger(dir, MergeOptions{Header: CSV.Header(true, true), LineEnd: CSV.LineEnd()}, 2)
+	if err := m.WriteFile(out); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(raw)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the record ends are CRLF; the line break inside a name stays
+	if got := bytes.Count(raw, []byte("\r\n")); got != len(rows) || !bytes.HasSuffix(raw, []byte("\r\n")) {
+		t.Errorf("%d CRLF record ends for %d rows", got, len(rows))
+	}
+	if want := []string{"name_a", "name_b", "score", "tag"}; !slices.Equal(rows[0], want) {
+		t.Errorf("header %q, want %q", rows[0], want)
+	}
+	got := make(map[string]string)
+	for _, row := range rows[1:] {
+		got[row[0]+"|"+row[1]] = row[3]
+	}
+	for _, p := range awkwardPairs {
+		if tag, ok := got[p.A+"|"+p.B]; !ok || tag != p.Tag {
+			t.Errorf("pair %q, %q: tag %q (found %v), want %q", p.A, p.B, tag, ok, p.Tag)
+		}
+	}
+	if len(rows) != len(awkwardPairs)+1 {
+		t.Errorf("%d rows, want %d", len(rows), len(awkwardPairs)+1)
+	}
+}
+
+// handoffInput has couples of names matching each other, so the output has
+// a pair from each side of each couple, found by different workers.
+func handoffInput(t *testing.T, couples int) *compare.Data {
+	t.Helper()
+	var names, words []string
+	for i := range couples {
+		names = append(names, fmt.Sprintf(`"given%d family%d"`, i, i), fmt.Sprintf(`"given%d familie%d"`, i, i))
+		words = append(words, fmt.Sprintf(`"given%d": ["given%d"]`, i, i),
+			fmt.Sprintf(`"family%d": ["family%d", "familie%d"]`, i, i, i), fmt.Sprintf(`"familie%d": ["familie%d", "family%d"]`, i, i, i))
+	}
+	data, err := compare.Load(strings.NewReader(`{"all_names": [` + strings.Join(names, ", ") + `], "word_to_matches": {` + strings.Join(words, ", ") + `}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// runHandoff runs a Matcher over data into worker files and merges them
+// into out. With handoff, workers hand their files to the merger as they
+// finish, the way main does, and the worker holding the first name waits
+// for the merger to take in a file first. It returns how many files the
+// merger consumed before the last worker finished.
+func runHandoff(t *testing.T, data *compare.Data, opts MergeOptions, out string, handoff bool) int {
+	t.Helper()
+	const numWorkers = 4
+	dir := t.TempDir()
+	outputs, err := OpenWorkerOutputs(dir, numWorkers, CSV, false, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merge := NewMerger(dir, opts, numWorkers)
+	matchOpts := compare.Options{Workers: numWorkers, JobBatch: 4}
+
+	files := make(chan string, numWorkers)
+	consumed := make(chan struct{})
+	var consumedAt []time.Time
+	mergeDone := make(chan error, 1)
+	var lastDone time.Time
+	var mu sync.Mutex
+	if handoff {
+		go func() {
+			var err error
+			for path := range files {
+				if err == nil {
+					err = merge.Add(path)
+					if len(consumedAt) == 0 {
+						close(consumed)
+					}
+					consumedAt = append(consumedAt, time.Now())
+				}
+			}
+			mergeDone <- err
+		}()
+		matchOpts.OnWorkerDone = func(worker int) error {
+			path, err := outputs.FinishWorker(worker)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			lastDone = time.Now()
+			mu.Unlock()
+			files <- path
+			return nil
+		}
+		matchOpts.OnNameDone = func(_, idx int) error {
+			if idx == 0 {
+				select {
+				case <-consumed:
+				case <-time.After(10 * time.Second):
+					t.Error("the merger took in no file while a worker was still running")
+				}
+			}
+			return nil
+		}
+	}
+	if err := compare.NewMatcher(data, matchOpts).Run(context.Background(), outputs.Emit); err != nil {
+		t.Fatal(err)
+	}
+	close(files)
+	if handoff {
+		if err := <-mergeDone; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := outputs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := merge.WriteFile(out); err != nil {
+		t.Fatal(err)
+	}
+	early := 0
+	for _, at := range consumedAt {
+		if at.Before(lastDone) {
+			early++
+		}
+	}
+	return early
+}
+
+// Files handed over as workers finish give the output of merging them all
+// at the end, and the merger works on them before the last worker is done.
+func TestMergeHandoffDuringRun(t *testing.T) {
+	data := handoffInput(t, 300)
+	for _, sorted := range []bool{false, true} {
+		opts := MergeOptions{Header: CSV.Header(false, false), Sorted: sorted}
+		dir := t.TempDir()
+		want, got := filepath.Join(dir, "want.csv"), filepath.Join(dir, "got.csv")
+		runHandoff(t, data, opts, want, false)
+		early := runHandoff(t, data, opts, got, true)
+		wantLines, gotLines := readLines(t, want), readLines(t, got)
+		if !sorted {
+			slices.Sort(wantLines)
+			slices.Sort(gotLines)
+		}
+		if len(wantLines) != 301 || !slices.Equal(gotLines, wantLines) {
+			t.Errorf("sorted %v: %d lines handed over, %d merged at the end", sorted, len(gotLines), len(wantLines))
+		}
+		if early == 0 {
+			t.Errorf("sorted %v: no file consumed before the last worker finished", sorted)
+		}
+	}
+}
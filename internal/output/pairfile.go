@@ -0,0 +1,170 @@
+package output
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/input"
+)
+
+// --- PAIR FILES ---
+// Pair lists in any of the output formats, such as an earlier run's output
+// for an incremental run or a list of known false positives, read back as
+// name pairs that are not to be written, or a list of manual links that
+// always are.
+
+// ReadPairs calls fn with the two names of every pair in a pair file.
+func ReadPairs(path string, fn func(a, b string)) error {
+	open := input.Open
+	if isSQLiteFile(path) {
+		open = querySQLitePairs
+	}
+	in, closeInput, err := open(path)
+	if err != nil {
+		return err
+	}
+	err = readOutputPairs(in, fn)
+	if cerr := closeInput(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+func isSQLiteFile(path string) bool {
+	return input.HasMagic(path, sqliteMagic)
+}
+
+// querySQLitePairs reads the matches table of a sqlite output as headerless
+// CSV, which readOutputPairs takes like a csv output.
+func querySQLitePairs(path string) (io.Reader, func() error, error) {
+	cmd := exec.Command("sqlite3", "-batch", "-csv", "-readonly", path, "SELECT name_a, name_b FROM matches")
+	r, closeInput, err := input.StartCommand(cmd, path+": sqlite3")
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: reading sqlite output needs the sqlite3 command: %w", path, err)
+	}
+	return r, closeInput, nil
+}
+
+// readOutputPairs calls fn with the names of every pair in an output file.
+// The format is told from the first line.
+func readOutputPairs(r io.Reader, fn func(a, b string)) error {
+	br := bufio.NewReaderSize(r, 1<<20)
+	first, _ := br.Peek(1)
+	if len(first) == 1 && first[0] != '(' && first[0] != '{' {
+		// CSV fields may span lines, so the whole stream goes to the reader
+		reader := csv.NewReader(br)
+		reader.FieldsPerRecord = -1
+		for line := 1; ; line++ {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if len(record) < 2 {
+				return fmt.Errorf("line %d: expected at least two fields", line)
+			}
+			if line == 1 && record[0] == "name_a" && record[1] == "name_b" {
+				continue
+			}
+			fn(record[0], record[1])
+		}
+	}
+
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<30)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+		a, b, ok := parseTupleOrJSONL(text)
+		if !ok {
+			return fmt.Errorf("line %d: not an output line: %q", line, text)
+		}
+		fn(a, b)
+	}
+	return scanner.Err()
+}
+
+// parseTupleOrJSONL reads the names of a tuple or jsonl output line. The
+// tuple format doesn't escape quotes, so name a ends at the first `", "`
+// and name b at the next quote followed by ")" or ", ".
+func parseTupleOrJSONL(line string) (string, string, bool) {
+	if line[0] == '{' {
+		var p struct {
+			A *string `json:"name_a"`
+			B *string `json:"name_b"`
+		}
+		if err := json.Unmarshal([]byte(line), &p); err != nil || p.A == nil || p.B == nil {
+			return "", "", false
+		}
+		return *p.A, *p.B, true
+	}
+	rest, ok := strings.CutPrefix(line, `("`)
+	if !ok {
+		return "", "", false
+	}
+	a, rest, ok := strings.Cut(rest, `", "`)
+	if !ok {
+		return "", "", false
+	}
+	for i := 0; i < len(rest); i++ {
+		if rest[i] == '"' && (strings.HasPrefix(rest[i+1:], ")") || strings.HasPrefix(rest[i+1:], ", ")) {
+			return a, rest[:i], true
+		}
+	}
+	return "", "", false
+}
+
+// Verdict is how a pair check reports whether a pair matches.
+func Verdict(ok bool) string {
+	if ok {
+		return "match"
+	}
+	return "no-match"
+}
+
+// CheckPairs validates every name_a<TAB>name_b line of pairsPath and
+// writes name_a, name_b, verdict and score as TSV to outputPath.
+func CheckPairs(matcher *compare.Matcher, pairsPath, outputPath string) error {
+	out, err := Create(outputPath, CompressNone)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	line := 0
+	err = input.ForEachLine(pairsPath, func(text string) error {
+		line++
+		if strings.TrimSpace(text) == "" {
+			return nil
+		}
+		a, b, ok := strings.Cut(text, "\t")
+		if !ok {
+			return fmt.Errorf("%s line %d: expected two tab-separated names", pairsPath, line)
+		}
+		match, score := matcher.Validate(a, b)
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a, b, Verdict(match), FormatScore(score))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,158 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/records"
+)
+
+// --- PROGRESSIVE PUBLISHING ---
+// With --publish-every, the pairs found so far are published while the run
+// is still going. At each interval every worker rotates to a new file at its
+// next name boundary, and the closed files are deduplicated against
+// everything published before and written to <output>.partial-<k>. A
+// manifest (<output>.manifest.json) lists the publications; it is marked
+// complete once the final output, which is always a full merge, exists.
+// Confirmed review pairs only appear in the final output.
+
+type publication struct {
+	Path        string    `json:"path"`
+	Pairs       int       `json:"pairs"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+type publishManifest struct {
+	Output       string        `json:"output"`
+	Publications []publication `json:"publications"`
+	Complete     bool          `json:"complete"`
+}
+
+type Publisher struct {
+	outputs    *WorkerOutputs
+	outputPath string
+	numWorkers int
+	// Only the options that decide how an output file is written apply
+	opts MergeOptions
+	// Hashes of every line published so far
+	seen     map[uint64]struct{}
+	manifest publishManifest
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+func NewPublisher(outputs *WorkerOutputs, outputPath string, numWorkers int, opts MergeOptions) *Publisher {
+	return &Publisher{
+		outputs:    outputs,
+		outputPath: outputPath,
+		numWorkers: numWorkers,
+		opts:       opts,
+		seen:       make(map[uint64]struct{}),
+		manifest:   publishManifest{Output: outputPath},
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+func (p *Publisher) Run(interval time.Duration) {
+	defer close(p.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-ticker.C:
+			if err := p.publish(); err != nil {
+				fmt.Fprintln(os.Stderr, "\nPublishing partial results failed:", err)
+			}
+		}
+	}
+}
+
+// Stop must be called once the matcher has returned. A publication that was
+// still waiting for workers to rotate is abandoned; the final merge covers it.
+func (p *Publisher) Stop() {
+	close(p.quit)
+	<-p.done
+}
+
+func (p *Publisher) publish() error {
+	p.outputs.rotateGen.Add(1)
+	var paths []string
+	for len(paths) < p.numWorkers {
+		select {
+		case path := <-p.outputs.rotated:
+			paths = append(paths, path)
+		case <-p.quit:
+			return nil
+		}
+	}
+
+	k := len(p.manifest.Publications) + 1
+	ext := filepath.Ext(p.outputPath)
+	partialPath := fmt.Sprintf("%s.partial-%d%s", strings.TrimSuffix(p.outputPath, ext), k, ext)
+	out, err := CreateResults(partialPath, p.opts)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	lineEnd := p.opts.lineEnd()
+	if p.opts.Header != "" {
+		w.WriteString(p.opts.Header + lineEnd)
+	}
+	pairs := 0
+	for _, path := range paths {
+		err := records.ForEach(path, records.TypeLine, func(line string) error {
+			h := fnv.New64a()
+			h.Write([]byte(line))
+			key := h.Sum64()
+			if _, ok := p.seen[key]; ok {
+				return nil
+			}
+			p.seen[key] = struct{}{}
+			pairs++
+			_, err := w.WriteString(line + lineEnd)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if p.opts.Footer != "" {
+		w.WriteString(p.opts.Footer + lineEnd)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	p.manifest.Publications = append(p.manifest.Publications, publication{
+		Path:        partialPath,
+		Pairs:       pairs,
+		PublishedAt: time.Now(),
+	})
+	return p.writeManifest()
+}
+
+func (p *Publisher) Complete() error {
+	p.manifest.Complete = true
+	return p.writeManifest()
+}
+
+func (p *Publisher) writeManifest() error {
+	raw, err := json.MarshalIndent(p.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(p.outputPath)
+	return os.WriteFile(strings.TrimSuffix(p.outputPath, ext)+".manifest.json", raw, 0o644)
+}
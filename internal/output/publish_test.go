@@ -0,0 +1,120 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+)
+
+// Two publication cycles and the final merge: the publications hold no line
+// twice, and together with the lines found after the last one they make up
+// the final output.
+func TestPublishCycles(t *testing.T) {
+	dir := t.TempDir()
+	outDir := t.TempDir()
+	outPath := filepath.Join(outDir, "out.csv")
+	const numWorkers = 2
+	outputs, err := OpenWorkerOutputs(dir, numWorkers, CSV, false, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := MergeOptions{Header: CSV.Header(false, false)}
+	pub := NewPublisher(outputs, outPath, numWorkers, opts)
+
+	idx := 0
+	// name emits pairs from worker and ends the name there
+	name := func(worker int, pairs ...string) {
+		for _, p := range pairs {
+			outputs.Emit(compare.Pair{A: p, B: p + "'", Worker: worker})
+		}
+		if err := outputs.NameDone(worker, idx); err != nil {
+			t.Fatal(err)
+		}
+		idx++
+	}
+	// cycle publishes while each worker finishes one more name
+	cycle := func(last [numWorkers][]string) {
+		published := make(chan error)
+		gen := outputs.rotateGen.Load()
+		go func() { published <- pub.publish() }()
+		// Workers only rotate once the publisher has asked them to
+		for outputs.rotateGen.Load() == gen {
+			runtime.Gosched()
+		}
+		for worker, pairs := range last {
+			name(worker, pairs...)
+		}
+		if err := <-published; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	name(0, "ann", "bob")
+	name(1, "bob", "cy")
+	cycle([numWorkers][]string{{"dee"}, {"ann"}})
+	// Pairs found again from their other names
+	name(0, "cy", "eve")
+	name(1, "dee", "fay")
+	cycle([numWorkers][]string{{"gus"}, nil})
+	// The tail after the last publication
+	name(0, "gus", "hal")
+	name(1, "ann", "ivy")
+	if err := outputs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewMerger(dir, opts, numWorkers).WriteFile(outPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.Complete(); err != nil {
+		t.Fatal(err)
+	}
+
+	line := func(p string) string { return fmt.Sprintf("%s,%s'", p, p) }
+	publications := [][]string{
+		{"ann", "bob", "cy", "dee"},
+		{"eve", "fay", "gus"},
+	}
+	var union []string
+	for k, want := range publications {
+		got := readLines(t, filepath.Join(outDir, fmt.Sprintf("out.partial-%d.csv", k+1)))
+		if got[0] != "name_a,name_b" {
+			t.Errorf("partial %d: header %q", k+1, got[0])
+		}
+		got = got[1:]
+		slices.Sort(got)
+		var wantLines []string
+		for _, p := range want {
+			wantLines = append(wantLines, line(p))
+		}
+		if !slices.Equal(got, wantLines) {
+			t.Errorf("partial %d: %q, want %q", k+1, got, wantLines)
+		}
+		union = append(union, got...)
+	}
+	union = append(union, line("hal"), line("ivy"))
+	slices.Sort(union)
+	final := readLines(t, outPath)[1:]
+	slices.Sort(final)
+	if !slices.Equal(final, union) {
+		t.Errorf("final output %q, want the publications and tail %q", final, union)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(outDir, "out.manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest publishManifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if !manifest.Complete || len(manifest.Publications) != 2 ||
+		manifest.Publications[0].Pairs != 4 || manifest.Publications[1].Pairs != 3 {
+		t.Errorf("manifest %+v", manifest)
+	}
+}
@@ -0,0 +1,233 @@
+// Package output writes the results of a run: the per-worker temp files,
+// the formats their lines are written in, checkpoints for resuming, partial
+// publications while the run is going, and the merge into the final output.
+package output
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/records"
+)
+
+// --- WORKER OUTPUT ---
+// Each matcher worker writes to its own temp file, one record per line of
+// the final output format (see internal/records), so emitting needs no
+// locking. Confirmed review pairs, which are emitted before the workers
+// start, go to a file of their own.
+
+type WorkerOutputs struct {
+	dir    string
+	format Format
+	tagged bool
+	scores bool
+	// Set for --format, and then used instead of format
+	Template    *template.Template
+	files       []*os.File
+	writers     []*records.Writer
+	checkpoints []*workerCheckpoint // nil entries unless --checkpoint
+	// Workers whose file was closed by FinishWorker
+	finished   []bool
+	confirmed  *os.File
+	confirmedW *records.Writer
+
+	// Rotation for --publish-every: the Publisher bumps rotateGen, and each
+	// worker, at its next name boundary, closes its file, starts a new
+	// segment and sends the closed file's path on rotated.
+	rotateGen  atomic.Uint64
+	rotatedGen []uint64
+	segments   []int
+	rotated    chan string
+}
+
+// With tagged set, the run applies review states (see Format.FormatMatch).
+// With compress set, the worker files are written as deflated blocks.
+func OpenWorkerOutputs(dir string, numWorkers int, format Format, tagged, scores, checkpoint, compress bool) (*WorkerOutputs, error) {
+	o := &WorkerOutputs{
+		dir:        dir,
+		format:     format,
+		tagged:     tagged,
+		scores:     scores,
+		finished:   make([]bool, numWorkers),
+		rotatedGen: make([]uint64, numWorkers),
+		segments:   make([]int, numWorkers),
+		rotated:    make(chan string, numWorkers),
+	}
+	for id := 0; id < numWorkers; id++ {
+		// A resumed run continues after the segments rotated out before
+		segment, err := lastSegment(dir, id)
+		if err != nil {
+			return nil, err
+		}
+		o.segments[id] = segment
+		path := filepath.Join(dir, fmt.Sprintf("worker_%d.rec", id))
+		// Append so a resumed run keeps what this worker slot wrote before
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		o.files = append(o.files, f)
+		w := records.NewWriter(f)
+		if info.Size() > 0 {
+			w = records.NewAppendWriter(f)
+		}
+		if compress {
+			w.Compress()
+		}
+		o.writers = append(o.writers, w)
+		var cp *workerCheckpoint
+		if checkpoint {
+			if cp, err = newWorkerCheckpoint(dir, id); err != nil {
+				return nil, err
+			}
+		}
+		o.checkpoints = append(o.checkpoints, cp)
+	}
+	f, err := os.Create(filepath.Join(dir, "review_confirmed.rec"))
+	if err != nil {
+		return nil, err
+	}
+	o.confirmed = f
+	o.confirmedW = records.NewWriter(f)
+	if compress {
+		o.confirmedW.Compress()
+	}
+	return o, nil
+}
+
+func (o *WorkerOutputs) Emit(p compare.Pair) {
+	w := o.writers[p.Worker]
+	if p.Tag == "confirmed" {
+		w = o.confirmedW
+	}
+	if o.Template != nil {
+		w.WriteString(records.TypeLine, formatTemplate(o.Template, p))
+		return
+	}
+	w.WriteString(records.TypeLine, o.format.FormatMatch(p, o.tagged, o.scores))
+}
+
+// NameDone runs in the worker's goroutine after each name. It records the
+// name for the checkpoint log and rotates the worker's file if the Publisher
+// asked for it.
+func (o *WorkerOutputs) NameDone(worker, idx int) error {
+	if cp := o.checkpoints[worker]; cp != nil {
+		cp.pending = append(cp.pending, uint32(idx))
+		if time.Since(cp.last) >= checkpointInterval {
+			if err := cp.commit(o.writers[worker], o.files[worker]); err != nil {
+				return err
+			}
+		}
+	}
+	if gen := o.rotateGen.Load(); gen != o.rotatedGen[worker] {
+		o.rotatedGen[worker] = gen
+		return o.rotate(worker)
+	}
+	return nil
+}
+
+// rotate finishes the worker's current file and continues in a new segment.
+// Pending checkpoint names stay pending: their lines are in the old file,
+// which is synced before it is handed over.
+func (o *WorkerOutputs) rotate(worker int) error {
+	old := o.files[worker]
+	if err := o.writers[worker].Flush(); err != nil {
+		return err
+	}
+	if err := old.Sync(); err != nil {
+		return err
+	}
+	if err := old.Close(); err != nil {
+		return err
+	}
+	o.segments[worker]++
+	path := filepath.Join(o.dir, fmt.Sprintf("worker_%d.%d.rec", worker, o.segments[worker]))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	o.files[worker] = f
+	if info.Size() > 0 {
+		o.writers[worker].ResetAppend(f)
+	} else {
+		o.writers[worker].Reset(f)
+	}
+	o.rotated <- old.Name()
+	return nil
+}
+
+// lastSegment returns the highest segment number of the worker's files in
+// dir, or 0 when it has none besides worker_<id>.rec.
+func lastSegment(dir string, worker int) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf("worker_%d.*.rec", worker)))
+	if err != nil {
+		return 0, err
+	}
+	last := 0
+	prefix := fmt.Sprintf("worker_%d.", worker)
+	for _, path := range paths {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), prefix), ".rec"))
+		if err == nil && n > last {
+			last = n
+		}
+	}
+	return last, nil
+}
+
+// FinishWorker runs in the worker's goroutine once it has no names left. It
+// closes the worker's file and returns its path, so the merge can start on
+// it while other workers are still running.
+func (o *WorkerOutputs) FinishWorker(worker int) (string, error) {
+	w := o.writers[worker]
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	if cp := o.checkpoints[worker]; cp != nil {
+		if err := cp.commit(w, o.files[worker]); err != nil {
+			return "", err
+		}
+		cp.close()
+	}
+	o.finished[worker] = true
+	return o.files[worker].Name(), o.files[worker].Close()
+}
+
+func (o *WorkerOutputs) Close() error {
+	for i, w := range o.writers {
+		if o.finished[i] {
+			continue
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if cp := o.checkpoints[i]; cp != nil {
+			if err := cp.commit(w, o.files[i]); err != nil {
+				return err
+			}
+			cp.close()
+		}
+		if err := o.files[i].Close(); err != nil {
+			return err
+		}
+	}
+	if err := o.confirmedW.Flush(); err != nil {
+		return err
+	}
+	return o.confirmed.Close()
+}
@@ -0,0 +1,200 @@
+package progress
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+)
+
+// --- LIVE HUBS ---
+// Names matching far more names than the rest are the usual sign of a bad
+// rule, so --live-hubs reports the emerging ones with the progress while the
+// run is going. Every worker counts the degrees of the names it emits in a
+// space-saving sketch of fixed size, and the reporter merges the sketches.
+// Counts are approximate: a name that took over a slot inherits the count it
+// replaced, which is remembered as its possible overcount. Names are ranked
+// by their count minus that overcount, so a corpus of similar degrees
+// doesn't rank whatever name arrived last. A pair found from both of its
+// names counts twice, as the merge hasn't removed duplicates yet.
+//
+// The sketch keeps its counters in a stream summary: buckets of equal count
+// in a list ordered by count, so counting a match and evicting the smallest
+// count are both constant time.
+
+const hubSketchSize = 1024
+
+// hubCounter counts the matches of one name.
+type hubCounter struct {
+	name       string
+	over       uint64
+	bucket     *hubBucket
+	prev, next *hubCounter
+}
+
+// hubBucket holds the counters of one count, oldest first.
+type hubBucket struct {
+	count       uint64
+	prev, next  *hubBucket
+	first, last *hubCounter
+}
+
+func (b *hubBucket) push(c *hubCounter) {
+	c.bucket, c.prev, c.next = b, b.last, nil
+	if b.last != nil {
+		b.last.next = c
+	} else {
+		b.first = c
+	}
+	b.last = c
+}
+
+func (b *hubBucket) remove(c *hubCounter) {
+	if c.prev != nil {
+		c.prev.next = c.next
+	} else {
+		b.first = c.next
+	}
+	if c.next != nil {
+		c.next.prev = c.prev
+	} else {
+		b.last = c.prev
+	}
+	c.prev, c.next = nil, nil
+}
+
+type hubSketch struct {
+	mu       sync.Mutex
+	counters map[string]*hubCounter
+	// The bucket of the smallest count, the head of the list
+	min *hubBucket
+}
+
+func newHubSketch() *hubSketch {
+	return &hubSketch{counters: make(map[string]*hubCounter)}
+}
+
+// add counts one match of name. When the sketch is full the name takes over
+// the counter of the smallest count; of several, the one longest at that
+// count, so the eviction depends only on the order of the matches.
+func (s *hubSketch) add(name string) {
+	if c, ok := s.counters[name]; ok {
+		s.increment(c)
+		return
+	}
+	if len(s.counters) < hubSketchSize {
+		c := &hubCounter{name: name}
+		s.counters[name] = c
+		if s.min == nil || s.min.count != 1 {
+			s.min = &hubBucket{count: 1, next: s.min}
+			if s.min.next != nil {
+				s.min.next.prev = s.min
+			}
+		}
+		s.min.push(c)
+		return
+	}
+	c := s.min.first
+	delete(s.counters, c.name)
+	c.name, c.over = name, s.min.count
+	s.counters[name] = c
+	s.increment(c)
+}
+
+// increment moves c to the bucket of the next count, creating it if needed
+// and dropping the bucket c leaves if that empties it.
+func (s *hubSketch) increment(c *hubCounter) {
+	from := c.bucket
+	to := from.next
+	if to == nil || to.count != from.count+1 {
+		to = &hubBucket{count: from.count + 1, prev: from, next: from.next}
+		if from.next != nil {
+			from.next.prev = to
+		}
+		from.next = to
+	}
+	from.remove(c)
+	to.push(c)
+	if from.first != nil {
+		return
+	}
+	if from.prev != nil {
+		from.prev.next = from.next
+	} else {
+		s.min = from.next
+	}
+	from.next.prev = from.prev
+}
+
+// HubTracker counts the matches of every name in one sketch per worker.
+type HubTracker struct {
+	sketches []*hubSketch
+}
+
+func NewHubTracker(numWorkers int) *HubTracker {
+	t := &HubTracker{}
+	for i := 0; i < numWorkers; i++ {
+		t.sketches = append(t.sketches, newHubSketch())
+	}
+	return t
+}
+
+// Add counts a pair the worker that found it emits.
+func (t *HubTracker) Add(p compare.Pair) {
+	s := t.sketches[p.Worker]
+	s.mu.Lock()
+	s.add(p.A)
+	s.add(p.B)
+	s.mu.Unlock()
+}
+
+// Hub is a name with its approximate number of matches.
+type Hub struct {
+	Name  string `json:"name"`
+	Count uint64 `json:"count"`
+}
+
+// Top merges the worker sketches and returns the n names with the highest
+// approximate degree.
+func (t *HubTracker) Top(n int) []Hub {
+	type merged struct{ count, over uint64 }
+	counts := make(map[string]merged)
+	for _, s := range t.sketches {
+		s.mu.Lock()
+		for name, c := range s.counters {
+			m := counts[name]
+			m.count += c.bucket.count
+			m.over += c.over
+			counts[name] = m
+		}
+		s.mu.Unlock()
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sort.SliceStable(names, func(i, j int) bool {
+		a, b := counts[names[i]], counts[names[j]]
+		return a.count-a.over > b.count-b.over
+	})
+	if len(names) > n {
+		names = names[:n]
+	}
+	hubs := make([]Hub, len(names))
+	for i, name := range names {
+		hubs[i] = Hub{Name: name, Count: counts[name].count}
+	}
+	return hubs
+}
+
+// FormatHubs lists hubs for a progress line.
+func FormatHubs(hubs []Hub) string {
+	parts := make([]string, len(hubs))
+	for i, h := range hubs {
+		parts[i] = fmt.Sprintf("%q ~%d", h.Name, h.Count)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,126 @@
+package progress
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+)
+
+// The space-saving sketch never undercounts, overcounts by at most the
+// stream length over its size, says how much it may have overcounted, and
+// keeps every name more frequent than that bound.
+func TestHubSketchBounds(t *testing.T) {
+	for seed := range uint64(3) {
+		rng := rand.New(rand.NewPCG(seed, seed))
+		zipf := rand.NewZipf(rng, 1.1+float64(seed)/10, 1, 20*hubSketchSize)
+		s := newHubSketch()
+		exact := make(map[string]uint64)
+		const n = 50000
+		for range n {
+			name := fmt.Sprintf("name %d", zipf.Uint64())
+			exact[name]++
+			s.add(name)
+		}
+		if len(s.counters) > hubSketchSize {
+			t.Fatalf("seed %d: %d names kept", seed, len(s.counters))
+		}
+		bound := uint64(n / hubSketchSize)
+		for name, c := range s.counters {
+			if count := c.bucket.count; count < exact[name] || count-c.over > exact[name] || c.over > bound {
+				t.Errorf("seed %d: %s counted %d (over %d), exactly %d", seed, name, count, c.over, exact[name])
+			}
+		}
+		for name, count := range exact {
+			if _, ok := s.counters[name]; count > bound && !ok {
+				t.Errorf("seed %d: %s seen %d times was dropped", seed, name, count)
+			}
+		}
+
+		// The buckets stay in increasing order of count, none empty, and
+		// hold every counter
+		kept := 0
+		var prev *hubBucket
+		for b := s.min; b != nil; prev, b = b, b.next {
+			if b.first == nil || b.prev != prev || prev != nil && prev.count >= b.count {
+				t.Fatalf("seed %d: bucket of count %d is out of place", seed, b.count)
+			}
+			for c := b.first; c != nil; c = c.next {
+				if c.bucket != b {
+					t.Fatalf("seed %d: %s is in the bucket of count %d but points elsewhere", seed, c.name, b.count)
+				}
+				kept++
+			}
+		}
+		if kept != len(s.counters) {
+			t.Errorf("seed %d: %d counters in buckets, %d names kept", seed, kept, len(s.counters))
+		}
+	}
+}
+
+// A full sketch evicts the counter longest at the smallest count.
+func TestHubSketchEviction(t *testing.T) {
+	s := newHubSketch()
+	for i := range hubSketchSize {
+		s.add(fmt.Sprintf("name %d", i))
+	}
+	s.add("name 0")
+	s.add("new")
+	if _, ok := s.counters["name 1"]; ok {
+		t.Error("name 1 was kept")
+	}
+	if c := s.counters["new"]; c == nil || c.bucket.count != 2 || c.over != 1 {
+		t.Errorf("new counted %+v", c)
+	}
+	s.add("newer")
+	if _, ok := s.counters["name 2"]; ok {
+		t.Error("name 2 was kept")
+	}
+}
+
+// A hub planted in the input leads the live hubs once a tenth of the names
+// are done.
+func TestLiveHubsPlanted(t *testing.T) {
+	names := []string{`"hub name"`}
+	words := []string{`"hub": ["hub"]`}
+	var variants []string
+	for i := range 500 {
+		names = append(names, fmt.Sprintf(`"hub name%d"`, i), fmt.Sprintf(`"given%d family%d"`, i, i), fmt.Sprintf(`"given%d familie%d"`, i, i))
+		variants = append(variants, fmt.Sprintf(`"name%d"`, i))
+		words = append(words, fmt.Sprintf(`"name%d": ["name%d", "name"]`, i, i), fmt.Sprintf(`"given%d": ["given%d"]`, i, i),
+			fmt.Sprintf(`"family%d": ["family%d", "familie%d"]`, i, i, i), fmt.Sprintf(`"familie%d": ["familie%d", "family%d"]`, i, i, i))
+	}
+	words = append(words, `"name": ["name", `+strings.Join(variants, ", ")+`]`)
+	data, err := compare.Load(strings.NewReader(`{"all_names": [` + strings.Join(names, ", ") + `], "word_to_matches": {` + strings.Join(words, ", ") + `}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const workers = 4
+	hubs := NewHubTracker(workers)
+	var done atomic.Int64
+	var early []Hub
+	var once sync.Once
+	matcher := compare.NewMatcher(data, compare.Options{
+		Workers: workers,
+		OnNameDone: func(int, int) error {
+			if done.Add(1) == int64(len(names)/10) {
+				once.Do(func() { early = hubs.Top(5) })
+			}
+			return nil
+		},
+	})
+	if err := matcher.Run(context.Background(), hubs.Add); err != nil {
+		t.Fatal(err)
+	}
+	if len(early) == 0 || early[0].Name != "hub name" {
+		t.Errorf("top hubs a tenth into the run: %s", FormatHubs(early))
+	}
+	if final := FormatHubs(hubs.Top(1)); final != `"hub name" ~1000` {
+		t.Errorf("top hub at the end: %s", final)
+	}
+}
@@ -0,0 +1,171 @@
+// Package progress reports how far a run has got while its workers go: the
+// names processed, the rate, the ETA and, with --live-hubs, the names with
+// the most matches so far.
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// The monitor reports progress every second. On a terminal the report
+// overwrites a single line; redirected to a file it is written as a full
+// line every logEvery instead, so logs don't fill with fragments. The json
+// mode writes one object per logEvery to stderr for orchestrators to
+// scrape, and nothing to stdout. The rate is measured over the last window,
+// so the ETA follows the current pace of the run.
+
+const (
+	window   = 30 * time.Second
+	logEvery = 10 * time.Second
+	// Hubs reported per event
+	topHubs = 5
+)
+
+type sample struct {
+	at        time.Time
+	processed uint64
+}
+
+type Reporter struct {
+	// "text", "json" or "none"
+	mode     string
+	terminal bool
+	total    uint64
+	hubs     *HubTracker
+	start    time.Time
+	lastLog  time.Time
+	samples  []sample
+	// The text report goes to stdout, the json events to stderr
+	stdout, stderr io.Writer
+}
+
+// event is one json progress report.
+type event struct {
+	Processed      uint64   `json:"processed"`
+	Total          uint64   `json:"total"`
+	Rate           float64  `json:"rate"`
+	ETASeconds     float64  `json:"eta_seconds"`
+	Pairs          uint64   `json:"pairs"`
+	ElapsedSeconds *float64 `json:"elapsed_seconds,omitempty"`
+	Hubs           []Hub    `json:"hubs,omitempty"`
+}
+
+// New returns a Reporter of a run over total names in mode, which reports
+// the top names of hubs too unless hubs is nil.
+func New(mode string, total uint64, hubs *HubTracker) *Reporter {
+	info, err := os.Stdout.Stat()
+	terminal := err == nil && info.Mode()&os.ModeCharDevice != 0
+	now := time.Now()
+	return &Reporter{
+		mode:     mode,
+		terminal: terminal,
+		total:    total,
+		hubs:     hubs,
+		start:    now,
+		lastLog:  now,
+		samples:  []sample{{at: now}},
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+	}
+}
+
+// rate returns the names processed per second over the sliding window.
+func (r *Reporter) rate(now time.Time, processed uint64) float64 {
+	r.samples = append(r.samples, sample{at: now, processed: processed})
+	for len(r.samples) > 2 && now.Sub(r.samples[1].at) >= window {
+		r.samples = r.samples[1:]
+	}
+	first := r.samples[0]
+	secs := now.Sub(first.at).Seconds()
+	if secs <= 0 || processed < first.processed {
+		return 0
+	}
+	return float64(processed-first.processed) / secs
+}
+
+func (r *Reporter) topHubs() []Hub {
+	if r.hubs == nil {
+		return nil
+	}
+	return r.hubs.Top(topHubs)
+}
+
+// Tick reports processed names and pairs found so far, and how many of the
+// workers' files an early merge has taken in.
+func (r *Reporter) Tick(processed, pairs uint64, mergedFiles, workers int) {
+	now := time.Now()
+	rate := r.rate(now, processed)
+	eta := -1.0
+	if rate > 0 {
+		eta = float64(r.total-min(processed, r.total)) / rate
+	}
+	logDue := now.Sub(r.lastLog) >= logEvery
+	switch {
+	case r.mode == "json" && logDue:
+		r.writeEvent(event{Processed: processed, Total: r.total, Rate: rate, ETASeconds: eta, Pairs: pairs, Hubs: r.topHubs()})
+	case r.mode == "text" && (r.terminal || logDue):
+		line := fmt.Sprintf("Progress: %d / %d (%.2f%%), %.0f names/s, ETA %s",
+			processed, r.total, float64(processed)/float64(r.total)*100, rate, formatETA(eta))
+		if mergedFiles > 0 {
+			line += fmt.Sprintf(", merging: %d / %d worker files", mergedFiles, workers)
+		}
+		if hubs := r.topHubs(); len(hubs) > 0 {
+			line += ", top hubs (approximate): " + FormatHubs(hubs)
+		}
+		if r.terminal {
+			// Pad over the remains of a longer previous line
+			fmt.Fprintf(r.stdout, "\r%-100s", line)
+		} else {
+			fmt.Fprintln(r.stdout, line)
+		}
+	default:
+		return
+	}
+	if logDue {
+		r.lastLog = now
+	}
+}
+
+// Finish reports the end of the matching phase.
+func (r *Reporter) Finish(pairs uint64) {
+	elapsed := time.Since(r.start)
+	switch r.mode {
+	case "json":
+		secs := math.Round(elapsed.Seconds()*10) / 10
+		r.writeEvent(event{Processed: r.total, Total: r.total, Rate: float64(r.total) / max(elapsed.Seconds(), 1e-9),
+			Pairs: pairs, ElapsedSeconds: &secs, Hubs: r.topHubs()})
+	case "text":
+		line := fmt.Sprintf("Progress: %d / %d (100.00%%) in %s, %d pairs found", r.total, r.total, elapsed.Round(100*time.Millisecond), pairs)
+		if r.terminal {
+			fmt.Fprintf(r.stdout, "\r%-100s\n", line)
+		} else {
+			fmt.Fprintln(r.stdout, line)
+		}
+		if hubs := r.topHubs(); len(hubs) > 0 {
+			fmt.Fprintf(r.stdout, "Top hubs (approximate): %s\n", FormatHubs(hubs))
+		}
+	}
+}
+
+func (r *Reporter) writeEvent(e event) {
+	e.Rate = math.Round(e.Rate*10) / 10
+	e.ETASeconds = math.Round(e.ETASeconds)
+	line, err := json.Marshal(e)
+	if err != nil {
+		// Only plain numbers and strings, so this can't happen
+		panic(err)
+	}
+	r.stderr.Write(append(line, '\n'))
+}
+
+func formatETA(secs float64) string {
+	if secs < 0 {
+		return "unknown"
+	}
+	return (time.Duration(secs) * time.Second).String()
+}
@@ -0,0 +1,69 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+)
+
+// reporter returns a Reporter writing to buffers, due to log on its first
+// tick, with a hub tracker that has counted "hub" three times.
+func reporter(mode string) (r *Reporter, stdout, stderr *bytes.Buffer) {
+	hubs := NewHubTracker(1)
+	for _, b := range []string{"a", "b", "c"} {
+		hubs.Add(compare.Pair{A: "hub", B: b})
+	}
+	r = New(mode, 10, hubs)
+	r.terminal = false
+	r.lastLog = time.Time{}
+	stdout, stderr = &bytes.Buffer{}, &bytes.Buffer{}
+	r.stdout, r.stderr = stdout, stderr
+	return r, stdout, stderr
+}
+
+// The json events carry the top hubs, and nothing goes to stdout.
+func TestProgressJSONHubs(t *testing.T) {
+	r, stdout, stderr := reporter("json")
+	r.Tick(4, 3, 0, 1)
+	r.Finish(3)
+	if stdout.Len() != 0 {
+		t.Errorf("stdout: %q", stdout)
+	}
+	lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("events: %q", lines)
+	}
+	for _, line := range lines {
+		var e event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if len(e.Hubs) == 0 || e.Hubs[0] != (Hub{Name: "hub", Count: 3}) {
+			t.Errorf("event %s: hubs %+v", line, e.Hubs)
+		}
+	}
+}
+
+func TestProgressTextHubs(t *testing.T) {
+	r, stdout, stderr := reporter("text")
+	r.Tick(4, 3, 0, 1)
+	if want := `top hubs (approximate): "hub" ~3`; !strings.Contains(stdout.String(), want) {
+		t.Errorf("progress line lacks %q: %q", want, stdout)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("stderr: %q", stderr)
+	}
+}
+
+func TestProgressNone(t *testing.T) {
+	r, stdout, stderr := reporter("none")
+	r.Tick(4, 3, 0, 1)
+	r.Finish(3)
+	if stdout.Len() != 0 || stderr.Len() != 0 {
+		t.Errorf("reported %q %q", stdout, stderr)
+	}
+}
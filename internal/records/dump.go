@@ -0,0 +1,44 @@
+package records
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Dump writes every record of src to w, one per line with its block, type
+// and length, followed by the stream's version and the record counts of each
+// type. With summary only the counts are written. A corrupt block stops the
+// dump, after the counts of what came before it, with its error.
+func Dump(w io.Writer, src io.Reader, summary bool) error {
+	r := NewReader(src)
+	counts := make(map[Type]int)
+	blocks := 0
+	var readErr error
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+		counts[rec.Type]++
+		blocks = rec.Block + 1
+		if !summary {
+			fmt.Fprintf(w, "block %d @%d %s %d: %s\n", rec.Block, rec.Offset, rec.Type, len(rec.Payload), strconv.Quote(string(rec.Payload)))
+		}
+	}
+	fmt.Fprintf(w, "version %d, %d blocks\n", r.Version(), blocks)
+	types := make([]Type, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	for _, t := range types {
+		fmt.Fprintf(w, "%s records: %d\n", t, counts[t])
+	}
+	return readErr
+}
@@ -0,0 +1,427 @@
+// Package records is the framing shared by the intermediate files a run
+// writes (worker output, spill partitions). Every file starts with a magic
+// and version, followed by blocks of typed records, and each block carries
+// a CRC32C so that a truncated or corrupted file is reported instead of
+// being merged as if it were complete.
+//
+// Layout:
+//
+//	header: "CNRS" | version (1 byte) | 3 zero bytes
+//	block:  body length (uint32 LE) | CRC32C of body (uint32 LE) | body
+//	body:   record*, each type (uvarint) | length (uvarint) | payload
+//
+// The framing never changes between versions; a newer version may only add
+// record types, so readers accept any version and callers skip types they
+// don't know. Version 2 added TypeDeflate, which the Reader unpacks itself.
+package records
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const (
+	Magic   = "CNRS"
+	Version = 2
+
+	headerSize      = 8
+	blockHeaderSize = 8
+	// A block is written once its body reaches this size
+	blockSize = 64 << 10
+	// Larger length fields can only come from corruption
+	maxBlockSize = 1 << 30
+)
+
+// Type tags what a record holds.
+type Type uint64
+
+const (
+	// TypeLine is one line of output, without the newline
+	TypeLine Type = 1
+	// TypeString is an opaque string, as stored in spill partitions
+	TypeString Type = 2
+	// TypeIndex is the index of a name as a uint32 LE, as logged in
+	// checkpoints
+	TypeIndex Type = 3
+	// TypeDeflate is the only record of a compressed block and holds the
+	// block's records, deflated. Readers never return it.
+	TypeDeflate Type = 4
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeLine:
+		return "line"
+	case TypeString:
+		return "string"
+	case TypeIndex:
+		return "index"
+	case TypeDeflate:
+		return "deflate"
+	}
+	return fmt.Sprintf("type-%d", uint64(t))
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptError reports where a stream stopped making sense.
+type CorruptError struct {
+	Block  int
+	Offset int64 // of the block's start
+	Reason string
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("block %d at offset %d: %s", e.Block, e.Offset, e.Reason)
+}
+
+// --- WRITER ---
+
+// Writer buffers records into blocks. Like a bufio.Writer, nothing reaches
+// the underlying writer until a block fills up or Flush is called.
+type Writer struct {
+	w          io.Writer
+	needHeader bool
+	body       []byte
+	err        error
+
+	// Set by Compress
+	deflate    *flate.Writer
+	compressed bytes.Buffer
+}
+
+// NewWriter starts a new stream on w, header included.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, needHeader: true}
+}
+
+// NewAppendWriter continues a stream that already has its header, such as a
+// file reopened for append.
+func NewAppendWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Compress makes every following block a single TypeDeflate record. It
+// trades CPU for disk on runs whose output doesn't fit the temp directory.
+func (w *Writer) Compress() {
+	if w.deflate == nil {
+		w.deflate, _ = flate.NewWriter(&w.compressed, flate.BestSpeed)
+	}
+}
+
+// Reset discards unflushed data and starts a new stream on w.
+func (w *Writer) Reset(dst io.Writer) {
+	w.w, w.needHeader, w.body, w.err = dst, true, w.body[:0], nil
+}
+
+// ResetAppend is Reset for a stream that already has its header, like
+// NewAppendWriter.
+func (w *Writer) ResetAppend(dst io.Writer) {
+	w.Reset(dst)
+	w.needHeader = false
+}
+
+func (w *Writer) Write(t Type, payload []byte) error {
+	return writeRecord(w, t, payload)
+}
+
+func (w *Writer) WriteString(t Type, payload string) error {
+	return writeRecord(w, t, payload)
+}
+
+// writeRecord appends a record to the pending block, flushing the block once
+// it is full. It takes either payload type without copying it.
+func writeRecord[P string | []byte](w *Writer, t Type, payload P) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.body = binary.AppendUvarint(w.body, uint64(t))
+	w.body = binary.AppendUvarint(w.body, uint64(len(payload)))
+	w.body = append(w.body, payload...)
+	if len(w.body) >= blockSize {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush writes the pending records as one block (and the header, if the
+// stream doesn't have it yet).
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	var out []byte
+	if w.needHeader {
+		out = append(out, Magic...)
+		out = append(out, Version, 0, 0, 0)
+	}
+	if len(w.body) > 0 {
+		body := w.body
+		if w.deflate != nil {
+			var err error
+			if body, err = w.deflateBody(); err != nil {
+				w.err = err
+				return err
+			}
+		}
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(body)))
+		out = binary.LittleEndian.AppendUint32(out, crc32.Checksum(body, castagnoli))
+		out = append(out, body...)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	if _, err := w.w.Write(out); err != nil {
+		w.err = err
+		return err
+	}
+	w.needHeader = false
+	w.body = w.body[:0]
+	return nil
+}
+
+// deflateBody returns the pending records wrapped in a TypeDeflate record.
+func (w *Writer) deflateBody() ([]byte, error) {
+	w.compressed.Reset()
+	w.deflate.Reset(&w.compressed)
+	if _, err := w.deflate.Write(w.body); err != nil {
+		return nil, err
+	}
+	if err := w.deflate.Close(); err != nil {
+		return nil, err
+	}
+	body := binary.AppendUvarint(nil, uint64(TypeDeflate))
+	body = binary.AppendUvarint(body, uint64(w.compressed.Len()))
+	return append(body, w.compressed.Bytes()...), nil
+}
+
+// --- READER ---
+
+// Record is one record read from a stream. Payload is only valid until the
+// next call to Next.
+type Record struct {
+	Type    Type
+	Payload []byte
+	// Index and offset of the record's block
+	Block  int
+	Offset int64
+}
+
+// Reader reads the records of a stream in order.
+type Reader struct {
+	r       *bufio.Reader
+	version byte
+	started bool
+
+	offset  int64 // of the next block
+	block   int
+	blockAt int64
+	raw     []byte
+	body    []byte // raw, or the records unpacked from it
+	pos     int
+
+	inflate  io.ReadCloser
+	inflated bytes.Buffer
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, blockSize), block: -1}
+}
+
+// Version returns the stream's version once the first Next has returned.
+func (r *Reader) Version() int {
+	return int(r.version)
+}
+
+func (r *Reader) readHeader() error {
+	var hdr [headerSize]byte
+	n, err := io.ReadFull(r.r, hdr[:])
+	if n == 0 && err == io.EOF {
+		// An empty file is an empty stream
+		return io.EOF
+	}
+	if err != nil {
+		return &CorruptError{Block: -1, Offset: 0, Reason: "truncated header"}
+	}
+	if string(hdr[:4]) != Magic {
+		return &CorruptError{Block: -1, Offset: 0, Reason: fmt.Sprintf("bad magic %q", hdr[:4])}
+	}
+	if hdr[4] == 0 {
+		return &CorruptError{Block: -1, Offset: 0, Reason: "version 0"}
+	}
+	r.version = hdr[4]
+	r.offset = headerSize
+	return nil
+}
+
+func (r *Reader) nextBlock() error {
+	var hdr [blockHeaderSize]byte
+	n, err := io.ReadFull(r.r, hdr[:])
+	if n == 0 && err == io.EOF {
+		return io.EOF
+	}
+	corrupt := func(reason string) error {
+		return &CorruptError{Block: r.block + 1, Offset: r.offset, Reason: reason}
+	}
+	if err != nil {
+		return corrupt("truncated block header")
+	}
+	size := binary.LittleEndian.Uint32(hdr[:4])
+	sum := binary.LittleEndian.Uint32(hdr[4:])
+	if size == 0 || size > maxBlockSize {
+		return corrupt(fmt.Sprintf("bad block length %d", size))
+	}
+	if cap(r.raw) < int(size) {
+		r.raw = make([]byte, size)
+	}
+	r.raw = r.raw[:size]
+	if _, err := io.ReadFull(r.r, r.raw); err != nil {
+		return corrupt(fmt.Sprintf("truncated block (want %d bytes)", size))
+	}
+	if crc32.Checksum(r.raw, castagnoli) != sum {
+		return corrupt("checksum mismatch")
+	}
+	r.block++
+	r.blockAt = r.offset
+	r.offset += blockHeaderSize + int64(size)
+	r.body, r.pos = r.raw, 0
+	if t, n := binary.Uvarint(r.raw); n > 0 && Type(t) == TypeDeflate {
+		return r.unpack(n)
+	}
+	return nil
+}
+
+// unpack replaces the body of a compressed block with the records it holds.
+// pos is just past the TypeDeflate record's type.
+func (r *Reader) unpack(pos int) error {
+	corrupt := func(reason string) error {
+		return &CorruptError{Block: r.block, Offset: r.blockAt, Reason: reason}
+	}
+	size, n := binary.Uvarint(r.raw[pos:])
+	if n <= 0 || size != uint64(len(r.raw)-pos-n) {
+		return corrupt("bad deflate record length")
+	}
+	src := bytes.NewReader(r.raw[pos+n:])
+	if r.inflate == nil {
+		r.inflate = flate.NewReader(src)
+	} else {
+		r.inflate.(flate.Resetter).Reset(src, nil)
+	}
+	r.inflated.Reset()
+	if _, err := r.inflated.ReadFrom(r.inflate); err != nil {
+		return corrupt(fmt.Sprintf("bad deflate data: %v", err))
+	}
+	r.body = r.inflated.Bytes()
+	return nil
+}
+
+// Next returns the next record, io.EOF at the clean end of the stream, or a
+// *CorruptError.
+func (r *Reader) Next() (Record, error) {
+	if !r.started {
+		r.started = true
+		if err := r.readHeader(); err != nil {
+			return Record{}, err
+		}
+	}
+	for r.pos >= len(r.body) {
+		if err := r.nextBlock(); err != nil {
+			return Record{}, err
+		}
+	}
+	corrupt := func(reason string) error {
+		return &CorruptError{Block: r.block, Offset: r.blockAt, Reason: reason}
+	}
+	t, n := binary.Uvarint(r.body[r.pos:])
+	if n <= 0 {
+		return Record{}, corrupt("bad record type")
+	}
+	r.pos += n
+	size, n := binary.Uvarint(r.body[r.pos:])
+	if n <= 0 || size > uint64(len(r.body)-r.pos-n) {
+		return Record{}, corrupt("bad record length")
+	}
+	r.pos += n
+	payload := r.body[r.pos : r.pos+int(size)]
+	r.pos += int(size)
+	return Record{Type: Type(t), Payload: payload, Block: r.block, Offset: r.blockAt}, nil
+}
+
+// ForEach calls fn with the payload of every record of type t in the file
+// at path, as a string. Records of other types are skipped.
+func ForEach(path string, t Type, fn func(string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return ForEachIn(f, t, fn)
+}
+
+// ForEachIn is ForEach over an open stream.
+func ForEachIn(src io.Reader, t Type, fn func(string) error) error {
+	r := NewReader(src)
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if name := nameOf(src); name != "" {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			return err
+		}
+		if rec.Type != t {
+			continue
+		}
+		if err := fn(string(rec.Payload)); err != nil {
+			return err
+		}
+	}
+}
+
+func nameOf(r io.Reader) string {
+	if f, ok := r.(*os.File); ok {
+		return f.Name()
+	}
+	return ""
+}
+
+// TruncateToValid cuts a file back to its last intact block, dropping a
+// block that was being written when a run died. A file whose header is
+// incomplete is emptied.
+func TruncateToValid(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := NewReader(f)
+	valid := int64(0)
+	for {
+		_, err := r.Next()
+		var corrupt *CorruptError
+		switch {
+		case err == nil:
+			valid = r.offset
+			continue
+		case err == io.EOF:
+			return nil
+		case errors.As(err, &corrupt):
+			if corrupt.Block < 0 {
+				valid = 0
+			}
+			return f.Truncate(valid)
+		default:
+			return err
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package records
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeBlocks writes each group of strings as a block of its own and
+// returns the stream and the offset of every block.
+func writeBlocks(t *testing.T, compress bool, groups ...[]string) ([]byte, []int64) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if compress {
+		w.Compress()
+	}
+	var offsets []int64
+	for _, group := range groups {
+		offsets = append(offsets, max(int64(buf.Len()), headerSize))
+		for _, s := range group {
+			if err := w.WriteString(TypeLine, s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes(), offsets
+}
+
+// readAll returns the line records of a stream and the error it ended with.
+func readAll(stream []byte) ([]string, error) {
+	var lines []string
+	err := ForEachIn(bytes.NewReader(stream), TypeLine, func(s string) error {
+		lines = append(lines, s)
+		return nil
+	})
+	return lines, err
+}
+
+func TestRoundTrip(t *testing.T) {
+	long := strings.Repeat("x", blockSize)
+	for _, compress := range []bool{false, true} {
+		stream, _ := writeBlocks(t, compress, []string{"a", "", "josé"}, []string{long, "b"})
+		lines, err := readAll(stream)
+		if err != nil {
+			t.Fatalf("compress %v: %v", compress, err)
+		}
+		if want := []string{"a", "", "josé", long, "b"}; !slices.Equal(lines, want) {
+			t.Errorf("compress %v: read %d lines, want %d", compress, len(lines), len(want))
+		}
+	}
+}
+
+func TestEmptyStream(t *testing.T) {
+	if lines, err := readAll(nil); err != nil || len(lines) != 0 {
+		t.Errorf("empty stream: %q, %v", lines, err)
+	}
+}
+
+// corruptAt reads stream and returns the *CorruptError it must end with.
+func corruptAt(t *testing.T, stream []byte) *CorruptError {
+	t.Helper()
+	_, err := readAll(stream)
+	var corrupt *CorruptError
+	if !errors.As(err, &corrupt) {
+		t.Fatalf("read ended with %v, want a *CorruptError", err)
+	}
+	return corrupt
+}
+
+func TestFlippedByte(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		stream, offsets := writeBlocks(t, compress, []string{"first"}, []string{"second", "third"}, []string{"fourth"})
+		// A byte in the body of the second block
+		stream[offsets[1]+blockHeaderSize+2] ^= 0x40
+		corrupt := corruptAt(t, stream)
+		if corrupt.Block != 1 || corrupt.Offset != offsets[1] || corrupt.Reason != "checksum mismatch" {
+			t.Errorf("compress %v: %v, want checksum mismatch in block 1 at offset %d", compress, corrupt, offsets[1])
+		}
+	}
+}
+
+func TestTruncatedFrame(t *testing.T) {
+	stream, offsets := writeBlocks(t, false, []string{"first"}, []string{"second"})
+	for _, c := range []struct {
+		name   string
+		cut    int64
+		reason string
+	}{
+		{"mid header", offsets[1] + 3, "truncated block header"},
+		{"mid body", int64(len(stream)) - 2, "truncated block"},
+	} {
+		corrupt := corruptAt(t, stream[:c.cut])
+		if corrupt.Block != 1 || corrupt.Offset != offsets[1] || !strings.HasPrefix(corrupt.Reason, c.reason) {
+			t.Errorf("%s: %v, want %q in block 1 at offset %d", c.name, corrupt, c.reason, offsets[1])
+		}
+	}
+	if corrupt := corruptAt(t, stream[:5]); corrupt.Block != -1 || corrupt.Reason != "truncated header" {
+		t.Errorf("cut header: %v", corrupt)
+	}
+}
+
+func TestBadHeader(t *testing.T) {
+	stream, _ := writeBlocks(t, false, []string{"line"})
+
+	badMagic := slices.Clone(stream)
+	copy(badMagic, "CNRX")
+	if corrupt := corruptAt(t, badMagic); corrupt.Block != -1 || !strings.HasPrefix(corrupt.Reason, "bad magic") {
+		t.Errorf("bad magic: %v", corrupt)
+	}
+
+	version0 := slices.Clone(stream)
+	version0[4] = 0
+	if corrupt := corruptAt(t, version0); corrupt.Reason != "version 0" {
+		t.Errorf("version 0: %v", corrupt)
+	}
+}
+
+// A newer version may add record types; readers skip the ones they don't
+// know and read the rest.
+func TestNewerVersion(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteString(TypeLine, "before")
+	w.WriteString(Type(99), "from the future")
+	w.WriteString(TypeLine, "after")
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+	stream[4] = Version + 5
+
+	r := NewReader(bytes.NewReader(stream))
+	var types []Type
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, rec.Type)
+	}
+	if r.Version() != Version+5 {
+		t.Errorf("Version() = %d", r.Version())
+	}
+	if want := []Type{TypeLine, 99, TypeLine}; !slices.Equal(types, want) {
+		t.Errorf("types %v, want %v", types, want)
+	}
+	if lines, err := readAll(stream); err != nil || !slices.Equal(lines, []string{"before", "after"}) {
+		t.Errorf("lines %q, %v", lines, err)
+	}
+}
+
+func TestTruncateToValid(t *testing.T) {
+	stream, offsets := writeBlocks(t, false, []string{"first"}, []string{"second"})
+	path := filepath.Join(t.TempDir(), "worker.rec")
+	for _, c := range []struct {
+		size int64
+		want int64
+	}{
+		{int64(len(stream)), int64(len(stream))},
+		{int64(len(stream)) - 1, offsets[1]},
+		{offsets[1] + 2, offsets[1]},
+		{3, 0},
+	} {
+		if err := os.WriteFile(path, stream[:c.size], 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := TruncateToValid(path); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != c.want {
+			t.Errorf("%d bytes truncated to %d, want %d", c.size, info.Size(), c.want)
+		}
+	}
+}
+
+func TestHashSpill(t *testing.T) {
+	s, err := NewHashSpill(t.TempDir(), "part", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	words := []string{"ann", "bob", "ann", "cy", "dee", "bob", "eve"}
+	for _, w := range words {
+		if err := s.Write(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var all []string
+	partition := make(map[string]int)
+	for i := range s.Partitions() {
+		err := s.ReadPartition(i, func(w string) {
+			all = append(all, w)
+			if p, ok := partition[w]; ok && p != i {
+				t.Errorf("%q in partitions %d and %d", w, p, i)
+			}
+			partition[w] = i
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	slices.Sort(all)
+	want := slices.Sorted(slices.Values(words))
+	if !slices.Equal(all, want) {
+		t.Errorf("read %q, want %q", all, want)
+	}
+}
+
+// Dump lists the records before a corrupt block and the counts of what it
+// read, then reports the corruption.
+func TestDump(t *testing.T) {
+	stream, offsets := writeBlocks(t, false, []string{"a", "b"}, []string{"c"})
+	stream[offsets[1]+blockHeaderSize] ^= 0xff
+	var out bytes.Buffer
+	err := Dump(&out, bytes.NewReader(stream), false)
+	var corrupt *CorruptError
+	if !errors.As(err, &corrupt) {
+		t.Fatalf("Dump() = %v, want a CorruptError", err)
+	}
+	want := "block 0 @8 line 1: \"a\"\nblock 0 @8 line 1: \"b\"\nversion 2, 1 blocks\nline records: 2\n"
+	if out.String() != want {
+		t.Errorf("dump:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
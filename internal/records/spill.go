@@ -0,0 +1,74 @@
+package records
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// --- HASH SPILL ---
+// Spreads strings over a fixed number of partition files chosen by hash, so
+// that equal strings always land in the same partition index and each
+// partition can later be processed in memory on its own.
+
+// HashSpill writes strings into partition files by hash.
+type HashSpill struct {
+	files   []*os.File
+	writers []*Writer
+}
+
+// NewHashSpill creates the partition files <prefix>_<i>.bin in dir.
+func NewHashSpill(dir, prefix string, partitions int) (*HashSpill, error) {
+	s := &HashSpill{}
+	for i := 0; i < partitions; i++ {
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%s_%d.bin", prefix, i)))
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.files = append(s.files, f)
+		s.writers = append(s.writers, NewWriter(f))
+	}
+	return s, nil
+}
+
+// Partitions returns the number of partition files.
+func (s *HashSpill) Partitions() int {
+	return len(s.files)
+}
+
+func (s *HashSpill) Write(str string) error {
+	h := fnv.New64a()
+	h.Write([]byte(str))
+	return s.writers[h.Sum64()%uint64(len(s.writers))].WriteString(TypeString, str)
+}
+
+// Flush must be called before any partition is read.
+func (s *HashSpill) Flush() error {
+	for _, w := range s.writers {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *HashSpill) Close() {
+	for _, f := range s.files {
+		f.Close()
+	}
+}
+
+// ReadPartition calls fn for every string stored in partition i.
+func (s *HashSpill) ReadPartition(i int, fn func(string)) error {
+	f := s.files[i]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return ForEachIn(f, TypeString, func(str string) error {
+		fn(str)
+		return nil
+	})
+}
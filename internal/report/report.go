@@ -0,0 +1,163 @@
+// Package report renders what a run or a subcommand found out for a
+// terminal: explanations of a pair, the resolved rules of words, the
+// diagnosis of a run that found too few pairs and the names a capped run cut
+// short.
+package report
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/output"
+)
+
+// --- EXPLAIN ---
+
+var ruleDescriptions = map[string]string{
+	"suffix":             "both names have a generational suffix and they differ, with --class-policy suffix=strict",
+	"strict-length":      "a name whose length is in --strict-lengths has a mismatch against a name at least as long",
+	"min-common-words":   "one name has fewer than --min-common-words words matching the other",
+	"max-mismatches":     "one name has more than --max-mismatches mismatched words",
+	"mismatch-table":     "one name has more mismatched words than --mismatch-table allows for the names' lengths",
+	"min-score":          "the score is below --min-score",
+	"min-weighted-score": "the IDF-weighted score is below --min-weighted-score",
+	"word-blocklist":     "the names differ on a word pair of --exclude-word-pairs",
+}
+
+// Explanation writes how a pair goes through the run, word by word and rule
+// by rule.
+func Explanation(w io.Writer, e *compare.Explanation) {
+	explainedWords(w, e.NameA, e.WordsA)
+	explainedWords(w, e.NameB, e.WordsB)
+	fmt.Fprintf(w, "Pair keys of %q: %s\n", e.NameA, strings.Join(e.PairKeysA, " "))
+	fmt.Fprintf(w, "Pair keys of %q: %s\n", e.NameB, strings.Join(e.PairKeysB, " "))
+	if len(e.SharedKeys) == 0 {
+		fmt.Fprintln(w, "Shared buckets: none, so the pair is never compared")
+	} else {
+		fmt.Fprintf(w, "Shared buckets: %s\n", strings.Join(e.SharedKeys, " "))
+	}
+	fmt.Fprintf(w, "Mismatches: %d of %d words in %q, %d of %d words in %q\n",
+		e.MismatchesA, e.LengthA, e.NameA, e.MismatchesB, e.LengthB, e.NameB)
+	fmt.Fprintf(w, "Score: %s\n", output.FormatScore(e.Score))
+	if e.WeightedScore != nil {
+		fmt.Fprintf(w, "Weighted score: %s\n", output.FormatScore(*e.WeightedScore))
+	}
+	if e.Valid {
+		fmt.Fprintln(w, "Validation: passed")
+	} else {
+		description, ok := ruleDescriptions[e.RejectedBy]
+		if !ok {
+			description = "a rule of --validation-rules"
+		}
+		fmt.Fprintf(w, "Validation: rejected by %s (%s)\n", e.RejectedBy, description)
+	}
+	if e.Review != "" {
+		fmt.Fprintf(w, "Review state: %s\n", e.Review)
+	}
+	if e.Output {
+		fmt.Fprintln(w, "Result: the pair is in the output")
+	} else {
+		fmt.Fprintln(w, "Result: the pair is not in the output")
+	}
+}
+
+func explainedWords(w io.Writer, name string, words []compare.ExplainedWord) {
+	fmt.Fprintf(w, "Words of %q:\n", name)
+	for _, word := range words {
+		line := fmt.Sprintf("  %-12s %-13s", word.Word, word.Outcome)
+		if word.Class != "" {
+			line += " class " + word.Class + ";"
+		}
+		if len(word.MatchedBy) > 0 {
+			line += " matched by " + strings.Join(word.MatchedBy, ", ") + ";"
+		}
+		if len(word.Tradeouts) > 0 {
+			line += " tradeouts " + strings.Join(word.Tradeouts, ", ")
+		}
+		fmt.Fprintln(w, strings.TrimRight(line, " ;"))
+	}
+}
+
+// Rules writes the resolved match set of a word, one matching word per line
+// with where the match comes from and what it is used for.
+func Rules(w io.Writer, rules compare.WordRules) {
+	if !rules.Known {
+		fmt.Fprintf(w, "%s (class %s): not in the input\n", rules.Word, rules.Class)
+		return
+	}
+	fmt.Fprintf(w, "%s (class %s, in %d names)\n", rules.Word, rules.Class, rules.Frequency)
+	for _, m := range rules.Matches {
+		var uses []string
+		if m.Validation {
+			uses = append(uses, "validation")
+		}
+		if m.Tradeout {
+			uses = append(uses, "tradeout")
+		}
+		fmt.Fprintf(w, "  %-16s %-16s %s\n", m.Word, m.Source, strings.Join(uses, ", "))
+	}
+}
+
+// --- RUN REPORTS ---
+
+// FewMatches writes where the candidates of a run that found found pairs,
+// fewer than min, went.
+func FewMatches(w io.Writer, d *compare.Diagnosis, found, min uint64) {
+	fmt.Fprintf(w, "\nWarning: the run found %d pairs, fewer than --min-expected-matches %d. Diagnosing...\n", found, min)
+	percent := func(n, of uint64) string {
+		if of == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(of))
+	}
+	fmt.Fprintf(w, "  Names with two or more words:     %d of %d\n", d.NamesWithKeys, d.Names)
+	fmt.Fprintf(w, "  Words with word_to_matches rules: %d of %d (%s)\n", d.WordsWithRules, d.Words, percent(uint64(d.WordsWithRules), uint64(d.Words)))
+	fmt.Fprintf(w, "  Pair key lookups finding a bucket: %d of %d (%s)\n", d.Hits, d.Lookups, percent(d.Hits, d.Lookups))
+	fmt.Fprintf(w, "  Names with any candidate:         %d of %d\n", d.NamesWithCandidates, d.NamesWithKeys)
+	fmt.Fprintf(w, "  Candidates: %d; validated %d, passed %d\n", d.Candidates, d.Validated, d.Passed)
+	for _, r := range d.TopRejections() {
+		fmt.Fprintf(w, "    rejected by %s: %d\n", r.Rule, r.Pairs)
+	}
+	fmt.Fprintf(w, "  Likely cause: %s\n", d.Cause())
+}
+
+// Truncated writes the evaluation total of a --max-total-evaluations run
+// and lists the names the cap cut short in path, one per line. The names
+// aren't listed when path is empty.
+func Truncated(w io.Writer, matcher *compare.Matcher, max uint64, path string) error {
+	fmt.Fprintf(w, "Evaluated %d of at most %d candidate pairs\n", matcher.Evaluations(), max)
+	truncated := matcher.Truncated()
+	if len(truncated) == 0 {
+		return nil
+	}
+	if path == "" {
+		fmt.Fprintf(w, "%d names truncated by --max-total-evaluations\n", len(truncated))
+		return nil
+	}
+	var b strings.Builder
+	for _, name := range truncated {
+		b.WriteString(name)
+		b.WriteByte('\n')
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%d names truncated by --max-total-evaluations, listed in %s\n", len(truncated), path)
+	return nil
+}
+
+// BucketSizes summarizes the distribution of pair_to_names bucket sizes.
+func BucketSizes(data *compare.Data) string {
+	sizes := data.BucketSizes()
+	if len(sizes) == 0 {
+		return "no buckets"
+	}
+	sort.Ints(sizes)
+	at := func(q float64) int { return sizes[int(q*float64(len(sizes)-1))] }
+	return fmt.Sprintf("%d buckets, size p50 %d, p90 %d, p99 %d, max %d",
+		len(sizes), at(0.5), at(0.9), at(0.99), sizes[len(sizes)-1])
+}
@@ -1,500 +1,642 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"runtime"
-	"sort"
+	"runtime/debug"
 	"strings"
-	"sync"
 	"sync/atomic"
+	"syscall"
+	"text/template"
 	"time"
-)
-
-// InputData matches the JSON structure
-type InputData struct {
-	AllNames      []string            `json:"all_names"`
-	WordToMatches map[string][]string `json:"word_to_matches"`
-	PairToNames   map[string][]string `json:"pair_to_names"`
-}
-
-// --- INTERNING SYSTEM ---
-// We convert strings to uint32 to avoid string hashing in the hot path
-type Dictionary struct {
-	strToInt map[string]uint32
-	intToStr []string
-}
-
-func NewDictionary() *Dictionary {
-	return &Dictionary{
-		strToInt: make(map[string]uint32),
-		intToStr: make([]string, 0),
-	}
-}
-
-func (d *Dictionary) GetID(s string) uint32 {
-	if id, ok := d.strToInt[s]; ok {
-		return id
-	}
-	id := uint32(len(d.intToStr))
-	d.intToStr = append(d.intToStr, s)
-	d.strToInt[s] = id
-	return id
-}
-
-func (d *Dictionary) GetStr(id uint32) string {
-	return d.intToStr[id]
-}
-
-// --- DATA STRUCTURES ---
 
-type ProcessedData struct {
-	// Names converted to lists of word IDs
-	NameWords map[string][]uint32
-	// Matches converted to lists of word IDs
-	WordToMatches map[uint32][]uint32
-	// Tradeouts converted to lists of word IDs
-	TradeoutSets map[uint32][]uint32
-	// Pair keys remain strings as they are composite keys, 
-	// but we could optimize this too if needed.
-	PairToNames map[string][]string
-	
-	Dict *Dictionary
-}
-
-var namesProcessed uint64
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/bundle"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/exitstatus"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/input"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/memory"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/output"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/progress"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/report"
+)
 
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: ./pair_comparator <input.json> <output.txt>")
+	if len(os.Args) > 1 && os.Args[1] == "input-diff" {
+		runInputDiff(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "inspect-temp" {
+		runInspectTemp(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 2 && os.Args[1] == "rules" && os.Args[2] == "show" {
+		runRulesShow(os.Args[3:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "explain" {
+		runExplain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 2 && os.Args[1] == "dict" && os.Args[2] == "export" {
+		runDictExport(os.Args[3:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "build-index" {
+		runBuildIndex(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "make-fixture" {
+		runMakeFixture(os.Args[2:])
 		return
 	}
-	inputPath := os.Args[1]
-	outputPath := os.Args[2]
+	outputFormatFlag := flag.String("output-format", "tuple", "output line format: tuple, csv or jsonl, or sqlite for a database with a matches table")
+	lineTemplate := flag.String("format", "", "Go text/template for each output line instead of --output-format, e.g. '{{.A}}\\t{{.B}}\\t{{.Score}}' (fields: A, B, Score, Tag, TokensA, TokensB, MismatchesA, MismatchesB; funcs: csv, json, sql)")
+	withScores := flag.Bool("with-scores", false, "add each pair's score (matched word fraction averaged over both names) to its output line")
+	match := newMatchFlags(flag.CommandLine)
+	pairsFile := flag.String("pairs-file", "", "only check the tab-separated name pairs in this file and write the verdicts to the output")
+	compareNames := flag.Bool("compare", false, "only check one pair: <input.json> <name a> <name b>")
+	sortOutput := flag.Bool("sort-output", false, "sort the output lines bytewise, so runs over the same input produce identical files")
+	allowDuplicates := flag.Bool("allow-duplicates", false, "skip the cross-worker dedupe and concatenate worker output as-is")
+	ignoreForecast := flag.Bool("ignore-memory-forecast", false, "start the workers even if the memory forecast exceeds available memory")
+	maxMemory := flag.String("max-memory", "", "memory budget such as 8GiB: the Go heap is held to it, the memory forecast is checked against it instead of available memory, and an index input from build-index keeps its pair buckets on disk, cached within what the budget leaves")
+	checkpointDir := flag.String("checkpoint", "", "keep worker output and a log of completed names in this directory so the run can be resumed")
+	resume := flag.Bool("resume", false, "continue the run in the --checkpoint directory, skipping names already completed (the input and the flags deciding which pairs are found must not change)")
+	exitStatusPath := flag.String("exit-status", "", "on exit, write the exit status, its class (such as invalid_input or interrupted) and the error's details as JSON to this file")
+	dumpPairIndex := flag.String("dump-pair-index", "", "when pair_to_names had to be built, write the completed input document here for reuse")
+	compressFlag := flag.String("compress", "", "compress the output: gzip or zstd (implied by an output path ending in .gz or .zst); the worker temp files are then compressed too, always with deflate")
+	compressOutput := flag.Bool("compress-output", false, "same as --compress gzip")
+	publishEvery := flag.Duration("publish-every", 0, "publish newly found pairs to <output>.partial-<k> files at this interval (e.g. 30m)")
+	failureBundlePath := flag.String("on-failure-bundle", "", "on an error exit, write a tar.gz of config, log tail, environment and input sample here")
+	noInputSample := flag.Bool("no-input-sample", false, "leave the input sample out of the --on-failure-bundle archive")
+	queryFile := flag.String("query-file", "", "two-list mode: only match the names in this file (one per line) against the input's names")
+	excludePairs := flag.String("exclude-pairs", "", "file of known false-positive pairs (tuple, csv or jsonl, like the output) that are never written")
+	previousOutput := flag.String("previous-output", "", "output of an earlier run (any format, optionally gzipped); its pairs are not written again")
+	changedNames := flag.String("changed-names", "", "only process the names in this file (one per line), for runs where the other names are unchanged since --previous-output")
+	skipQueryPairs := flag.Bool("skip-query-pairs", false, "in two-list mode, leave out pairs where both names are query names")
+	progressFlag := flag.String("progress", "text", "progress report: text on stdout, or json for one object per interval on stderr")
+	quiet := flag.Bool("quiet", false, "don't report progress")
+	liveHubs := flag.Bool("live-hubs", false, "report the names with the most matches so far (approximate counts) with the progress")
+	minExpected := flag.Uint64("min-expected-matches", 0, "diagnose the run when it finds fewer pairs than this (0 never does)")
+	onFewMatches := flag.String("on-few-matches", "warn", "when a run finds fewer than --min-expected-matches pairs: warn (print a diagnosis and exit 3), fail (print it and exit 1) or ignore")
+	skipSymmetric := flag.Bool("skip-symmetric", false, "validate each pair from one of its names only, when every name is found back by its candidates (the pair index is the simple one and tradeouts go both ways); the output is the same, found with about half the validations")
+	bitsetBuffer := flag.Bool("bitset-buffer", false, "mark word matches in a bitset per worker instead of a buffer of 8 bytes per dictionary word: 64 times less worker memory on large dictionaries, for somewhat slower validation")
+	workers := flag.Int("workers", 0, "number of worker goroutines (0 for GOMAXPROCS, which defaults to the CPUs available); fewer to share a machine, more when reading pair buckets from disk")
+	jobBatch := flag.Int("job-batch", compare.DefaultJobBatch, "most names a worker takes at once; larger batches mean less contention between workers, while expensive names still go out one at a time")
+	collapseDuplicates := flag.Bool("collapse-duplicates", false, "process names with the same words (in any order) once, writing its pairs for each of them; the output is the same, for less work on inputs with many such names; ignored in two-list runs and with --validation-rules")
+	maxEvaluations := flag.Uint64("max-total-evaluations", 0, "validate at most this many candidate pairs over the whole run, shared out across names by estimated cost (0 for no limit)")
+	flag.Parse()
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: ./pair_comparator [flags] <input.json> <output.txt>   (input - reads stdin; http(s):// and s3:// URLs are fetched; output - writes stdout)")
+		fmt.Println("       ./pair_comparator input-diff [--json] <old.json> <new.json>")
+		fmt.Println("       ./pair_comparator dict export [--fold-case-compare] [--normalize steps] <input.json> <dict.tsv>")
+		fmt.Println("       ./pair_comparator --compare [flags] <input.json> <name a> <name b>")
+		fmt.Println("       ./pair_comparator --pg-dsn <dsn> [--pg-synonyms <query>] [flags] <names query> <output.txt>")
+		fmt.Println("       ./pair_comparator --pairs-file <pairs.tsv> [flags] <input.json> <output.tsv>")
+		fmt.Println("       ./pair_comparator explain [--json] [flags] <input.json> <name a> <name b>")
+		fmt.Println("       ./pair_comparator rules show [--json] [flags] <input.json> [word...]")
+		fmt.Println("       ./pair_comparator inspect-temp [--summary] <file>")
+		fmt.Println("       ./pair_comparator make-fixture [--names n] [--redact hmac:<key>] <input.json> <out.json>")
+		flag.PrintDefaults()
+		return
+	}
+	inputPath := flag.Arg(0)
+	outputPath := flag.Arg(1)
+	if outputPath == "-" && !*compareNames {
+		// The matches go to stdout (see output.Stdout), so everything else
+		// the run prints goes to stderr
+		os.Stdout = os.Stderr
+	}
+	// stdin can't be read again for the bundle's input sample, a URL isn't
+	// worth fetching again, and with --pg-dsn the input is a query
+	failure := bundle.New(*failureBundlePath, inputPath, *noInputSample || inputPath == "-" || input.IsURL(inputPath) || *match.pgDSN != "", flag.CommandLine)
+	defer failure.Recover()
+	writeStatus := func(class exitstatus.Class, err error) {
+		if err := exitstatus.Write(*exitStatusPath, exitstatus.New(class, err)); err != nil {
+			fmt.Fprintln(os.Stderr, "could not write exit status:", err)
+		}
+	}
+	// exit ends a failed run with the exit code of class
+	exit := func(class exitstatus.Class, err error) {
+		writeStatus(class, err)
+		failure.Exit(class.Code(), err.Error())
+	}
+	fail := func(err error) {
+		fmt.Fprintln(os.Stderr, err)
+		exit(exitstatus.Classify(err), err)
+	}
+	usageError := func(msg ...any) {
+		fmt.Fprintln(os.Stderr, msg...)
+		exit(exitstatus.Usage, errors.New(strings.TrimSuffix(fmt.Sprintln(msg...), "\n")))
+	}
 
-	// 1. Load Data
-	fmt.Println("Loading JSON data...")
-	rawData, err := loadData(inputPath)
+	compression, err := output.ParseCompression(*compressFlag, *compressOutput, outputPath)
 	if err != nil {
-		panic(err)
+		usageError(err)
 	}
-
-	// 2. Preprocess & Intern Strings (The Speedup Layer)
-	fmt.Println("Interning strings to integers...")
-	data := preprocessData(rawData)
-	
-	totalNames := len(rawData.AllNames)
-	allNamesList := rawData.AllNames
-	
-	// Clear raw data to free massive RAM
-	rawData = nil
-	runtime.GC()
-
-	// 3. Setup Workers
-	numWorkers := runtime.NumCPU()
-	jobs := make(chan string, 1000)
-	var wg sync.WaitGroup
-
-	tempDir, err := os.MkdirTemp("", "name_match_batches")
+	format, err := output.ParseFormat(*outputFormatFlag)
+	if err != nil {
+		usageError(err)
+	}
+	if *workers < 0 {
+		usageError("--workers must be 0 or more")
+	}
+	var tmpl *template.Template
+	if *lineTemplate != "" {
+		if *outputFormatFlag != "tuple" {
+			usageError("--format replaces --output-format; pass only one of them")
+		}
+		if tmpl, err = output.ParseTemplate(*lineTemplate); err != nil {
+			usageError(err)
+		}
+	}
+	// The matches table always has a score column
+	scores := *withScores || format == output.SQLite
+	if format == output.SQLite {
+		switch {
+		case compression != output.CompressNone:
+			usageError("--compress doesn't apply to sqlite output")
+		case outputPath == "-":
+			usageError("sqlite output needs a database path, not -")
+		}
+	}
+	opts, err := match.options()
 	if err != nil {
-		panic(err)
+		usageError(err)
+	}
+	progressMode := *progressFlag
+	if progressMode != "text" && progressMode != "json" {
+		usageError(fmt.Sprintf("unknown --progress %q (want text or json)", progressMode))
+	}
+	if *quiet {
+		progressMode = "none"
+	}
+	switch *onFewMatches {
+	case "warn", "fail", "ignore":
+	default:
+		usageError(fmt.Sprintf("unknown --on-few-matches %q (want warn, fail or ignore)", *onFewMatches))
+	}
+	if *resume && *checkpointDir == "" {
+		usageError("--resume requires --checkpoint")
+	}
+	if outputPath == "-" && *publishEvery > 0 {
+		usageError("--publish-every needs an output file to name the partial files after")
+	}
+	if *compareNames && flag.NArg() != 3 {
+		usageError("--compare takes <input.json> <name a> <name b>")
+	}
+	budget, err := memory.ParseByteSize(*maxMemory)
+	if err != nil {
+		usageError(fmt.Sprintf("--max-memory: %v", err))
+	}
+	if budget > 0 {
+		debug.SetMemoryLimit(int64(budget))
+		// The buckets are the bulk of an index; sized below once the
+		// rest is loaded
+		match.budgetPairs = *match.pairsOnDisk == 0 && input.IsIndexFile(inputPath)
 	}
-	defer os.RemoveAll(tempDir)
 
-	fmt.Printf("Processing %d names with %d workers...\n", totalNames, numWorkers)
+	var memBefore runtime.MemStats
+	runtime.ReadMemStats(&memBefore)
 
-	// Start Monitor
-	doneMonitor := make(chan bool)
-	go func() {
-		ticker := time.NewTicker(1 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-doneMonitor:
-				return
-			case <-ticker.C:
-				current := atomic.LoadUint64(&namesProcessed)
-				percent := (float64(current) / float64(totalNames)) * 100
-				fmt.Printf("\rProgress: %d / %d (%.2f%%)", current, totalNames, percent)
+	// 1. Load Data, interning strings as they are read (The Speedup Layer)
+	failure.SetStage("load")
+	fmt.Println("Loading and interning JSON data...")
+	data, err := match.load(inputPath)
+	if err != nil {
+		fail(err)
+	}
+	if *queryFile != "" {
+		queries, err := input.ReadNameList(*queryFile)
+		if err != nil {
+			fail(err)
+		}
+		if data.DiskPairs != nil {
+			for _, name := range queries {
+				if _, ok := data.NameWords[name]; !ok {
+					fail(&compare.MismatchError{Field: "--query-file", Err: fmt.Errorf("%q is not in the index, and --pairs-on-disk can't add names to its buckets", name)})
+				}
 			}
 		}
-	}()
-
-	// Launch Workers
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-			// Pass the dictionary size to pre-allocate buffers
-			processBatch(workerID, tempDir, jobs, data, len(data.Dict.intToStr))
-		}(i)
+		added := data.AddQueries(queries)
+		fmt.Printf("Query names: %d (%d not in the input)\n", len(queries), added)
 	}
-
-	for _, name := range allNamesList {
-		jobs <- name
+	if *changedNames != "" {
+		// Only changed names are processed, exactly like query names in
+		// two-list mode; names no longer in the input are dropped
+		names, err := input.ReadNameList(*changedNames)
+		if err != nil {
+			fail(err)
+		}
+		present := names[:0]
+		for _, name := range names {
+			if _, ok := data.NameIDs[name]; ok {
+				present = append(present, name)
+			}
+		}
+		data.AddQueries(present)
+		fmt.Printf("Changed names: %d (%d not in the input)\n", len(present), len(names)-len(present))
 	}
-	close(jobs)
-
-	wg.Wait()
-	doneMonitor <- true
-	fmt.Printf("\rProgress: %d / %d (100.00%%)\n", totalNames, totalNames)
-
-	fmt.Println("Merging results...")
-	if err := mergeFiles(tempDir, outputPath); err != nil {
-		panic(err)
+	// Pairs of the previous output and the exclusion list share one set
+	excludeAbsent := 0
+	if *previousOutput != "" || *excludePairs != "" {
+		opts.Exclude = compare.NewPairSet(data)
 	}
-	fmt.Println("Done.")
-}
-
-func preprocessData(raw *InputData) *ProcessedData {
-	dict := NewDictionary()
-	
-	// Convert WordToMatches
-	w2m := make(map[uint32][]uint32, len(raw.WordToMatches))
-	tradeouts := make(map[uint32][]uint32)
-
-	for k, v := range raw.WordToMatches {
-		kID := dict.GetID(k)
-		
-		// Convert match list to IDs
-		matchIDs := make([]uint32, len(v))
-		for i, m := range v {
-			matchIDs[i] = dict.GetID(m)
-		}
-		w2m[kID] = matchIDs
-
-		// Logic: v if len(k) != 1 else set(k)
-		if len(k) != 1 {
-			// Use the slice we just created (read-only shared is fine)
-			tradeouts[kID] = matchIDs
-		} else {
-			tradeouts[kID] = []uint32{kID}
+	if *previousOutput != "" {
+		if err := output.ReadPairs(*previousOutput, opts.Exclude.Add); err != nil {
+			fail(err)
 		}
+		fmt.Printf("Previous output: %d pairs, %d more naming names not in the input\n", opts.Exclude.Len(), opts.Exclude.Absent())
 	}
-
-	// Pre-tokenize all names so we don't do strings.Fields repeatedly
-	nameWords := make(map[string][]uint32, len(raw.AllNames))
-	for _, name := range raw.AllNames {
-		parts := strings.Fields(name)
-		ids := make([]uint32, len(parts))
-		for i, p := range parts {
-			ids[i] = dict.GetID(p)
+	if *excludePairs != "" {
+		before, absentBefore := opts.Exclude.Len(), opts.Exclude.Absent()
+		if err := output.ReadPairs(*excludePairs, opts.Exclude.Add); err != nil {
+			fail(err)
 		}
-		nameWords[name] = ids
+		excludeAbsent = opts.Exclude.Absent() - absentBefore
+		fmt.Printf("Excluded pairs: %d\n", opts.Exclude.Len()-before)
 	}
-
-	return &ProcessedData{
-		NameWords:     nameWords,
-		WordToMatches: w2m,
-		TradeoutSets:  tradeouts,
-		PairToNames:   raw.PairToNames,
-		Dict:          dict,
+	if data.Mask != nil {
+		for _, c := range data.Mask.Counts() {
+			fmt.Printf("Masked %d words matching %s\n", c.Words, c.Pattern)
+		}
 	}
-}
-
-func processBatch(
-	id int,
-	tempDir string,
-	jobs <-chan string,
-	data *ProcessedData,
-	dictSize int,
-) {
-	tempFileName := filepath.Join(tempDir, fmt.Sprintf("worker_%d.txt", id))
-	f, _ := os.Create(tempFileName)
-	defer f.Close()
-	writer := bufio.NewWriter(f)
-
-	matchesBuffer := make([]uint64, dictSize)
-	
-	// FIX: Start higher to avoid 0 issues, though unlikely
-	currentGen := uint64(10) 
-
-	seenMatches := make(map[string]struct{})
-
-	for name := range jobs {
-		atomic.AddUint64(&namesProcessed, 1)
-		
-		namePartsIDs := data.NameWords[name]
-		if len(namePartsIDs) < 2 {
-			continue
-		}
-		
-		for k := range seenMatches { delete(seenMatches, k) }
-
-		pairs := buildExpandedPairMappings(namePartsIDs, data.TradeoutSets, data.Dict)
-
-		for _, pairStr := range pairs {
-			otherNames, exists := data.PairToNames[pairStr]
-			if !exists {
-				continue
+	totalNames := len(data.AllNames)
+	// Names the workers process, which progress is reported against
+	jobNames := totalNames
+	if data.Queries != nil {
+		jobNames = len(data.Queries)
+	}
+	if data.PairIndexBuilt {
+		fmt.Printf("Built pair_to_names: %d buckets\n", data.Buckets())
+		if *dumpPairIndex != "" {
+			if err := writeInputFile(*dumpPairIndex, data); err != nil {
+				fail(err)
 			}
+			fmt.Printf("Wrote input with pair index to %s\n", *dumpPairIndex)
+		}
+	}
+	runtime.GC()
 
-			for _, other := range otherNames {
-				if other == name {
-					continue
-				}
-				
-				n1, n2 := name, other
-				if n1 > n2 {
-					n1, n2 = n2, n1
-				}
-
-				ids1 := data.NameWords[n1]
-				ids2 := data.NameWords[n2]
+	if err := match.prepare(data, &opts); err != nil {
+		fail(err)
+	}
+	if opts.Review != nil {
+		confirmed, rejected, unsure, absent := opts.Review.Counts()
+		fmt.Printf("Review state: %d confirmed, %d rejected, %d unsure (%d rows reference names not in the corpus)\n",
+			confirmed, rejected, unsure, absent)
+	}
+	if b := opts.Match.Blocklist; b != nil {
+		fmt.Printf("Word blocklist: %d pairs (%d naming words not in the input)\n", b.Len(), b.Unknown())
+	}
 
-				// FIX: Increment by 2!
-				// We use (gen) for Step 1 and (gen+1) for Step 2
-				// This ensures the next iteration (gen+2) hits clean RAM.
-				currentGen += 2
-				
-				if validateOptimized(ids1, ids2, data.WordToMatches, matchesBuffer, currentGen) {
-					matchStr := fmt.Sprintf("(\"%s\", \"%s\")", n1, n2)
-					if _, seen := seenMatches[matchStr]; !seen {
-						seenMatches[matchStr] = struct{}{}
-						writer.WriteString(matchStr + "\n")
-					}
-				}
-			}
+	// Pair queries validate the given pairs only and skip the full run
+	if *compareNames || *pairsFile != "" {
+		matcher := compare.NewMatcher(data, opts)
+		if *compareNames {
+			ok, score := matcher.Validate(flag.Arg(1), flag.Arg(2))
+			fmt.Println(output.Verdict(ok), output.FormatScore(score))
+			writeStatus(exitstatus.OK, nil)
+			return
 		}
-		
-		if writer.Buffered() > 4096 {
-			writer.Flush()
+		failure.SetStage("pairs")
+		if err := output.CheckPairs(matcher, *pairsFile, outputPath); err != nil {
+			fail(err)
 		}
+		fmt.Println("Done.")
+		writeStatus(exitstatus.OK, nil)
+		return
 	}
-	writer.Flush()
-}
-
-// validateOptimized performs the check with ZERO allocations
-func validateOptimized(
-	partsA []uint32,
-	partsB []uint32,
-	wordToMatches map[uint32][]uint32,
-	matchesBuffer []uint64,
-	gen uint64,
-) bool {
-	lenA := len(partsA)
-	lenB := len(partsB)
 
-	// --- Step 1: Check Mismatches in A (relative to B) ---
-	// We use 'gen' for this phase
-	
-	// Populate Buffer with matches from B
-	for _, wordID := range partsB {
-		if matches, ok := wordToMatches[wordID]; ok {
-			for _, matchID := range matches {
-				// Bounds check to be safe, though dictSize should cover it
-				if int(matchID) < len(matchesBuffer) {
-					matchesBuffer[matchID] = gen
-				}
-			}
-		}
+	// 2. Setup Workers
+	numWorkers := *workers
+	if numWorkers == 0 {
+		numWorkers = runtime.GOMAXPROCS(0)
 	}
 
-	// Check A against Buffer
-	mismatchesA := 0
-	for i := 0; i < len(partsA); i++ {
-		wID := partsA[i]
-		// Naive dupe check
-		isDupe := false
-		for k := 0; k < i; k++ {
-			if partsA[k] == wID {
-				isDupe = true
-				break
-			}
+	forecast := memory.NewForecast(data, numWorkers, *bitsetBuffer, &memBefore)
+	if budget > 0 {
+		forecast.Available, forecast.Budget = budget, true
+	}
+	fmt.Println(forecast)
+	if err := forecast.Err(); err != nil && !*ignoreForecast {
+		fmt.Fprintf(os.Stderr, "Aborting: %s (pass --ignore-memory-forecast to run anyway)\n", forecast.AbortReason())
+		exit(exitstatus.ResourceLimit, err)
+	}
+	if data.DiskPairs != nil {
+		cache := int64(*match.pairsOnDisk) << 20
+		if match.budgetPairs {
+			cache = max(int64(float64(budget)*memory.SafetyFactor)-int64(forecast.Total()), 0)
+			data.DiskPairs.SetCacheLimit(cache)
 		}
-		if isDupe { continue }
-
-		if int(wID) < len(matchesBuffer) {
-			if matchesBuffer[wID] != gen {
-				mismatchesA++
-			}
-		} else {
-			mismatchesA++
+		access := "read"
+		if data.DiskPairs.Mapped() {
+			access = "mapped"
 		}
+		fmt.Printf("Pair buckets on disk (%s): %d, cached up to %s\n", access, data.Buckets(), memory.FormatBytes(uint64(cache)))
 	}
 
-	// --- Step 2: Check Mismatches in B (relative to A) ---
-	// FIX: Use 'gen + 1' for this phase so we don't have to clear the buffer
-	gen2 := gen + 1
-	
-	// Populate Buffer with matches from A
-	for _, wordID := range partsA {
-		if matches, ok := wordToMatches[wordID]; ok {
-			for _, matchID := range matches {
-				if int(matchID) < len(matchesBuffer) {
-					matchesBuffer[matchID] = gen2
-				}
-			}
+	var tempDir string
+	var completed []bool
+	numCompleted := 0
+	if *checkpointDir != "" {
+		tempDir = *checkpointDir
+		inputHash, err := data.Hash()
+		if err != nil {
+			fail(err)
+		}
+		configHash, err := output.MatchConfigHash(flag.CommandLine)
+		if err != nil {
+			fail(err)
+		}
+		completed, numCompleted, err = output.OpenCheckpoint(tempDir, output.CheckpointMeta{
+			Input:        inputPath,
+			TotalNames:   totalNames,
+			OutputFormat: output.DescribeLines(format, scores, *lineTemplate),
+			InputHash:    inputHash,
+			ConfigHash:   configHash,
+		}, *resume)
+		if err != nil {
+			fail(err)
+		}
+		if *resume {
+			fmt.Printf("Resuming: %d names already completed\n", numCompleted)
 		}
+	} else {
+		tempDir, err = os.MkdirTemp("", "name_match_batches")
+		if err != nil {
+			fail(err)
+		}
+		defer os.RemoveAll(tempDir)
 	}
 
-	// Check B against Buffer
-	mismatchesB := 0
-	for i := 0; i < len(partsB); i++ {
-		wID := partsB[i]
-		isDupe := false
-		for k := 0; k < i; k++ {
-			if partsB[k] == wID {
-				isDupe = true
-				break
+	outputs, err := output.OpenWorkerOutputs(tempDir, numWorkers, format, opts.Review != nil, scores, *checkpointDir != "", compression != output.CompressNone)
+	if err != nil {
+		fail(err)
+	}
+	outputs.Template = tmpl
+	if *checkpointDir != "" {
+		opts.Skip = func(idx int) bool { return completed[idx] }
+	}
+	if *checkpointDir != "" || *publishEvery > 0 {
+		opts.OnNameDone = outputs.NameDone
+	}
+	mergeOpts := output.MergeOptions{
+		Header:          format.Header(opts.Review != nil, scores),
+		Footer:          format.Footer(),
+		LineEnd:         format.LineEnd(),
+		AllowDuplicates: *allowDuplicates,
+		Compression:     compression,
+		SQLite:          format == output.SQLite,
+		Sorted:          *sortOutput,
+	}
+	// Workers that run out of names hand their file to the merger, which
+	// starts on it while the rest of the run finishes
+	merge := output.NewMerger(tempDir, mergeOpts, numWorkers)
+	handoff := make(chan string, numWorkers)
+	mergeDone := make(chan error, 1)
+	// Files the merger took in, and those handed over before the last
+	// worker finished
+	var mergedEarly, handedEarly atomic.Int32
+	go func() {
+		var err error
+		for path := range handoff {
+			if err == nil {
+				err = merge.Add(path)
+				mergedEarly.Add(1)
 			}
 		}
-		if isDupe { continue }
-
-		if int(wID) < len(matchesBuffer) {
-			if matchesBuffer[wID] != gen2 {
-				mismatchesB++
-			}
-		} else {
-			mismatchesB++
+		mergeDone <- err
+	}()
+	var finishedWorkers atomic.Int32
+	opts.OnWorkerDone = func(worker int) error {
+		path, err := outputs.FinishWorker(worker)
+		if err != nil {
+			return err
+		}
+		if int(finishedWorkers.Add(1)) < numWorkers {
+			handedEarly.Add(1)
 		}
+		handoff <- path
+		return nil
+	}
+	opts.Workers = numWorkers
+	opts.MaxEvaluations = *maxEvaluations
+	opts.SkipQueryPairs = *skipQueryPairs
+	opts.CollapseDuplicates = *collapseDuplicates
+	opts.JobBatch = *jobBatch
+	opts.SkipSymmetric = *skipSymmetric
+	opts.BitsetBuffer = *bitsetBuffer
+	matcher := compare.NewMatcher(data, opts)
+	failure.SetProgress(func() (uint64, uint64) {
+		return uint64(numCompleted) + matcher.Processed(), uint64(jobNames)
+	})
+
+	var pub *output.Publisher
+	if *publishEvery > 0 {
+		pub = output.NewPublisher(outputs, outputPath, numWorkers, mergeOpts)
+		go pub.Run(*publishEvery)
 	}
 
-	// --- Step 3: Thresholds (Variable Mapping Correction) ---
-	// Python: num_mismatches_a = len(set(name_b) - matches_of_a)
-	// Go: mismatchesA = words in A - matches of B (This maps to Python's mismatches_b)
-	
-	// Python: if (len_a == 3) and (num_mismatches_a) and (len_b >= 3): return False
-	// (Where len_a is name_b length). 
-	// So strict translation: if len(B)==3 and mismatches(in B relative to A) > 0 and len(A) >= 3
-	
-	if lenB == 3 && mismatchesB > 0 && lenA >= 3 {
-		return false
-	}
-	if lenA == 3 && mismatchesA > 0 && lenB >= 3 {
-		return false
-	}
-	
-	// Python: if (len_b - num_mismatches_b < 2) or (len_a - num_mismatches_a < 2)
-	// Python len_b is Name A. Python num_mismatches_b is mismatches in A.
-	
-	if (lenA - mismatchesA < 2) || (lenB - mismatchesB < 2) {
-		return false
+	if data.Queries != nil {
+		fmt.Printf("Processing %d query names against %d names with %d workers...\n", jobNames, totalNames, numWorkers)
+	} else {
+		fmt.Printf("Processing %d names with %d workers...\n", totalNames, numWorkers)
 	}
 
-	return true
-}
+	failure.SetStage("match")
 
-func buildExpandedPairMappings(parts []uint32, tradeoutSets map[uint32][]uint32, dict *Dictionary) []string {
-	// 1. Position Options (IDs)
-	positionOptions := make([][]uint32, len(parts))
-	for i, wordID := range parts {
-		opts := make([]uint32, 0, 5)
-		opts = append(opts, wordID)
-		
-		if replacements, ok := tradeoutSets[wordID]; ok {
-			opts = append(opts, replacements...)
-		}
-		
-		// Sort and Unique IDs (This is internal for the 'seenPairs' dedupe logic, 
-        // so sorting by ID here is fine and faster)
-		sort.Slice(opts, func(a, b int) bool { return opts[a] < opts[b] })
-		
-		uniqueOpts := opts[:0]
-		var last uint32
-		for j, v := range opts {
-			if j == 0 || v != last {
-				uniqueOpts = append(uniqueOpts, v)
-				last = v
-			}
+	emit := outputs.Emit
+	var hubs *progress.HubTracker
+	if *liveHubs {
+		hubs = progress.NewHubTracker(numWorkers)
+		emit = func(p compare.Pair) {
+			hubs.Add(p)
+			outputs.Emit(p)
 		}
-		positionOptions[i] = uniqueOpts
 	}
 
-	// 2. Pairs
-	seenPairs := make(map[string]struct{})
-	var results []string
-	var sb strings.Builder 
-
-	for i := 0; i < len(positionOptions); i++ {
-		for j := i + 1; j < len(positionOptions); j++ {
-			
-			// Deduplication Logic (Internal to Go, can use ID sorting)
-			sb.Reset()
-			writeIDs(&sb, positionOptions[i])
-			s1 := sb.String()
-			sb.Reset()
-			writeIDs(&sb, positionOptions[j])
-			s2 := sb.String()
-
-			first, second := s1, s2
-			if first > second {
-				first, second = second, first
-			}
-			key := first + "|" + second
-			
-			if _, ok := seenPairs[key]; ok {
-				continue
+	// Start Monitor
+	reporter := progress.New(progressMode, uint64(jobNames), hubs)
+	doneMonitor := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-doneMonitor:
+				return
+			case <-ticker.C:
+				reporter.Tick(uint64(numCompleted)+matcher.Processed(), matcher.Pairs(), int(mergedEarly.Load()), numWorkers)
 			}
-			seenPairs[key] = struct{}{}
-
-			// Generation Logic (External Map Lookup - MUST MATCH PYTHON)
-			for _, wI := range positionOptions[i] {
-				for _, wJ := range positionOptions[j] {
-                    // Retrieve the actual strings
-					str1 := dict.GetStr(wI)
-					str2 := dict.GetStr(wJ)
+		}
+	}()
 
-                    // FIX: Sort by STRING value, not ID value
-                    if str1 > str2 {
-                        str1, str2 = str2, str1
-                    }
-					
-					results = append(results, str1 + "_" + str2)
-				}
-			}
+	ctx, interrupted, stopSignals := interruptContext()
+	err = matcher.Run(ctx, emit)
+	stopSignals()
+	close(doneMonitor)
+	close(handoff)
+	if err := <-mergeDone; err != nil {
+		fail(err)
+	}
+	if pub != nil {
+		pub.Stop()
+	}
+	if err != nil && !interrupted() {
+		fail(err)
+	}
+	if data.DiskPairs != nil {
+		if err := data.DiskPairs.Err(); err != nil {
+			fail(err)
+		}
+	}
+	if err := outputs.Close(); err != nil {
+		fail(err)
+	}
+	if interrupted() {
+		// Keep what the finished names found; a --checkpoint run can
+		// still be resumed from where it stopped
+		failure.SetStage("merge")
+		partialPath := outputPath + ".partial"
+		if outputPath == "-" {
+			partialPath = "-"
+		}
+		fmt.Printf("\nInterrupted after %d of %d names, writing their pairs to %s...\n",
+			uint64(numCompleted)+matcher.Processed(), jobNames, outputName(partialPath))
+		if err := merge.WriteFile(partialPath); err != nil {
+			fail(err)
+		}
+		if *checkpointDir == "" {
+			os.RemoveAll(tempDir)
+		}
+		exit(exitstatus.Interrupted, &compare.InterruptedError{
+			Processed: uint64(numCompleted) + matcher.Processed(),
+			Total:     uint64(jobNames),
+			Resumable: *checkpointDir != "",
+			Err:       context.Canceled,
+		})
+	}
+	reporter.Finish(matcher.Pairs())
+	if n := matcher.Collapsed(); n > 0 {
+		fmt.Printf("Processed %d names with the same words as another name along with it\n", n)
+	}
+	if matcher.Symmetric() {
+		fmt.Println("Validated each pair from one of its names: candidates are symmetric")
+	}
+	if *maxEvaluations > 0 {
+		truncatedPath := outputPath + ".truncated"
+		if outputPath == "-" {
+			truncatedPath = ""
+		}
+		if err := report.Truncated(os.Stdout, matcher, *maxEvaluations, truncatedPath); err != nil {
+			fail(err)
 		}
 	}
-	return results
-}
 
-func writeIDs(sb *strings.Builder, ids []uint32) {
-	for k, id := range ids {
-		if k > 0 {
-			sb.WriteString(",")
+	failure.SetStage("merge")
+	fmt.Println("Merging results...")
+	if n := handedEarly.Load(); n > 0 {
+		fmt.Printf("Merge started on %d of %d worker files before the last worker finished\n", n, numWorkers)
+	}
+	if err := merge.WriteFile(outputPath); err != nil {
+		fail(err)
+	}
+	if pub != nil {
+		if err := pub.Complete(); err != nil {
+			fail(err)
 		}
-		fmt.Fprintf(sb, "%d", id)
 	}
+	if excludeAbsent > 0 {
+		fmt.Printf("Warning: ignored %d --exclude-pairs entries naming names not in all_names\n", excludeAbsent)
+	}
+	if status := fewMatchesStatus(*onFewMatches, merge.Written(), *minExpected); status != exitstatus.OK {
+		report.FewMatches(os.Stdout, matcher.Diagnosis(), merge.Written(), *minExpected)
+		err := fmt.Errorf("found %d pairs, expected at least %d", merge.Written(), *minExpected)
+		if status == exitstatus.Error {
+			exit(status, err)
+		}
+		fmt.Println("Done, with a warning.")
+		writeStatus(status, err)
+		os.Exit(status.Code())
+	}
+	fmt.Println("Done.")
+	writeStatus(exitstatus.OK, nil)
 }
 
-func loadData(path string) (*InputData, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+// fewMatchesStatus returns the exit status of a run that found found pairs
+// under --on-few-matches policy, or OK when the run found enough or the
+// policy is ignore.
+func fewMatchesStatus(policy string, found, min uint64) exitstatus.Class {
+	switch {
+	case policy == "ignore" || found >= min:
+		return exitstatus.OK
+	case policy == "fail":
+		return exitstatus.Error
 	}
-	defer file.Close()
-	var data InputData
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&data); err != nil {
-		return nil, err
+	return exitstatus.FewMatches
+}
+
+// interruptContext returns a context that is cancelled on the first SIGINT
+// or SIGTERM, so the run stops handing out names and lets the in-flight ones
+// finish. A second signal exits at once. interrupted reports whether the
+// context was cancelled by a signal. stop, called once the run has returned,
+// hands the signals back to their default action, so a signal during the
+// merge stops the process instead of being taken for an interrupted run.
+func interruptContext() (ctx context.Context, interrupted func() bool, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var got atomic.Bool
+	sigs := make(chan os.Signal, 2)
+	done, exited := make(chan struct{}), make(chan struct{})
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer close(exited)
+		var sig os.Signal
+		select {
+		case sig = <-sigs:
+		case <-done:
+			return
+		}
+		got.Store(true)
+		fmt.Fprintf(os.Stderr, "\nReceived %s: finishing the names in progress, then writing partial output (signal again to exit now)\n", sig)
+		cancel()
+		select {
+		case sig = <-sigs:
+		case <-done:
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Received %s again: exiting without writing output\n", sig)
+		os.Exit(130)
+	}()
+	stop = func() {
+		signal.Stop(sigs)
+		close(done)
+		// A signal already taken counts as an interrupted run
+		<-exited
 	}
-	return &data, nil
+	return ctx, got.Load, stop
 }
 
-func mergeFiles(tempDir, finalOutput string) error {
-	outFile, err := os.Create(finalOutput)
+func writeInputFile(path string, data *compare.Data) error {
+	f, err := os.Create(path)
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
-	bufWriter := bufio.NewWriter(outFile)
-	defer bufWriter.Flush()
-	files, err := os.ReadDir(tempDir)
-	if err != nil {
+	if err := data.WriteInput(f); err != nil {
+		f.Close()
 		return err
 	}
-	for _, fileEntry := range files {
-		path := filepath.Join(tempDir, fileEntry.Name())
-		in, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		_, err = io.Copy(bufWriter, in)
-		in.Close()
+	return f.Close()
+}
+
+// outputName is how messages refer to an output path.
+func outputName(path string) string {
+	if path == "-" {
+		return "stdout"
 	}
-	return nil
-}
\ No newline at end of file
+	return path
+}
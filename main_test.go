@@ -0,0 +1,597 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/exitstatus"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/report"
+)
+
+const testInput = `{
+	"all_names": ["john smith", "jon smith", "john smyth", "mary jones"],
+	"word_to_matches": {
+		"john": ["john", "jon"], "jon": ["jon", "john"],
+		"smith": ["smith", "smyth"], "smyth": ["smyth", "smith"],
+		"mary": ["mary"], "jones": ["jones"]
+	}
+}`
+
+// runFixture runs a Matcher over doc and returns it and the number of
+// distinct pairs it found.
+func runFixture(t *testing.T, doc string) (*compare.Matcher, uint64) {
+	t.Helper()
+	data, err := compare.Load(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher := compare.NewMatcher(data, compare.Options{})
+	var mu sync.Mutex
+	pairs := make(map[compare.Pair]bool)
+	err = matcher.Run(context.Background(), func(p compare.Pair) {
+		mu.Lock()
+		defer mu.Unlock()
+		pairs[compare.Pair{A: p.A, B: p.B}] = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matcher, uint64(len(pairs))
+}
+
+func TestFewMatches(t *testing.T) {
+	const rules = `"word_to_matches": {
+		"john": ["john", "jon"], "jon": ["jon", "john"],
+		"smith": ["smith", "smyth"], "smyth": ["smyth", "smith"],
+		"mary": ["mary"], "jones": ["jones"], "ann": ["ann"], "lee": ["lee"]
+	}`
+	for _, c := range []struct {
+		name   string
+		doc    string
+		policy string
+		min    uint64
+		found  uint64
+		status exitstatus.Class
+		report []string
+	}{
+		{
+			// pair_to_names built from upper-case names, so no key the
+			// names look up is in it
+			name: "mismatched key format",
+			doc: `{"all_names": ["john smith", "jon smith", "john smyth", "mary jones"], ` + rules + `,
+				"pair_to_names": {"JOHN_SMITH": ["john smith", "john smyth"], "JON_SMITH": ["jon smith"], "JOHN_SMYTH": ["john smyth"], "JONES_MARY": ["mary jones"]}}`,
+			policy: "warn", min: 1, found: 0, status: exitstatus.FewMatches,
+			report: []string{"Pair key lookups finding a bucket: 0 of 13 (0.0%)", "check the key format"},
+		},
+		{
+			name: "mismatched key format, fail",
+			doc: `{"all_names": ["john smith", "jon smith"], ` + rules + `,
+				"pair_to_names": {"JOHN_SMITH": ["john smith", "jon smith"]}}`,
+			policy: "fail", min: 1, found: 0, status: exitstatus.Error,
+			report: []string{"found 0 pairs", "check the key format"},
+		},
+		{
+			// Both names share a bucket, but only one of their words
+			// matches
+			name: "rejected",
+			doc: `{"all_names": ["john smith", "john jones"], ` + rules + `,
+				"pair_to_names": {"john_smith": ["john smith", "john jones"], "john_jones": ["john jones"]}}`,
+			policy: "warn", min: 1, found: 0, status: exitstatus.FewMatches,
+			report: []string{"validated 1, passed 0", "rejected by min-common-words: 1", "most by min-common-words"},
+		},
+		{
+			// One real pair among names that match nothing
+			name:   "sparse",
+			doc:    `{"all_names": ["john smith", "jon smith", "mary jones", "ann lee"], ` + rules + `}`,
+			policy: "warn", min: 5, found: 1, status: exitstatus.FewMatches,
+			report: []string{"Candidates: 2; validated 2, passed 2", "the corpus is sparse"},
+		},
+		{
+			name:   "sparse, ignored",
+			doc:    `{"all_names": ["john smith", "jon smith", "mary jones", "ann lee"], ` + rules + `}`,
+			policy: "ignore", min: 5, found: 1, status: exitstatus.OK,
+		},
+		{
+			name:   "enough",
+			doc:    `{"all_names": ["john smith", "jon smith", "mary jones", "ann lee"], ` + rules + `}`,
+			policy: "fail", min: 1, found: 1, status: exitstatus.OK,
+		},
+	} {
+		matcher, found := runFixture(t, c.doc)
+		if found != c.found {
+			t.Errorf("%s: found %d pairs, want %d", c.name, found, c.found)
+		}
+		status := fewMatchesStatus(c.policy, found, c.min)
+		if status != c.status {
+			t.Errorf("%s: exit status %v, want %v", c.name, status, c.status)
+		}
+		if status == exitstatus.OK {
+			continue
+		}
+		var out bytes.Buffer
+		report.FewMatches(&out, matcher.Diagnosis(), found, c.min)
+		for _, want := range c.report {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("%s: report lacks %q:\n%s", c.name, want, out.String())
+			}
+		}
+	}
+}
+
+// TestMainProcess is main in the child process runMain starts, with the
+// command line it passes as JSON in the environment.
+func TestMainProcess(t *testing.T) {
+	args, ok := os.LookupEnv("PAIR_COMPARATOR_ARGS")
+	if !ok {
+		t.Skip("only run by runMain")
+	}
+	if err := json.Unmarshal([]byte(args), &os.Args); err != nil {
+		t.Fatal(err)
+	}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	main()
+	os.Exit(0)
+}
+
+// runMain runs the command line args in a child process reading stdin and
+// returns its exit status, stdout and stderr.
+func runMain(t *testing.T, stdin string, args ...string) (status int, stdout, stderr string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMainProcess$")
+	argv, err := json.Marshal(append([]string{"pair_comparator"}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Env = append(os.Environ(), "PAIR_COMPARATOR_ARGS="+string(argv))
+	cmd.Stdin = strings.NewReader(stdin)
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatal(err)
+	}
+	return cmd.ProcessState.ExitCode(), out.String(), errOut.String()
+}
+
+func gzipBytes(t *testing.T, raw []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// A gzipped input and output give the output of the plain run, once
+// decompressed, whether the input is a file, stdin or a URL and the output
+// a file or stdout, and a truncated input fails instead of reading short.
+func TestGzipRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, raw []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, raw, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	plainIn := write("in.json", []byte(testInput))
+	gzipped := gzipBytes(t, []byte(testInput))
+	gzIn := write("in.json.gz", gzipped)
+	// Detected by its magic bytes
+	bareIn := write("in.data", gzipped)
+
+	// run returns the lines of the output, sorted as the workers write
+	// them in any order
+	run := func(args ...string) []string {
+		t.Helper()
+		if status, _, stderr := runMain(t, "", args...); status != 0 {
+			t.Fatalf("%q: exit status %d\n%s", args, status, stderr)
+		}
+		out := args[len(args)-1]
+		f, err := os.Open(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var r io.Reader = f
+		if strings.HasSuffix(out, ".gz") || slices.Contains(args, "--compress-output") {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatalf("%s: %v", out, err)
+			}
+			r = gz
+		}
+		raw, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", out, err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+		slices.Sort(lines)
+		return lines
+	}
+	want := run(plainIn, filepath.Join(dir, "plain.txt"))
+	if !strings.Contains(strings.Join(want, "\n"), "john smith") {
+		t.Fatalf("plain run found no pairs: %q", want)
+	}
+	for _, args := range [][]string{
+		{gzIn, filepath.Join(dir, "out.txt")},
+		{bareIn, filepath.Join(dir, "out-magic.txt")},
+		{gzIn, filepath.Join(dir, "out.txt.gz")},
+		{"--compress-output", plainIn, filepath.Join(dir, "out-flag.txt")},
+	} {
+		if got := run(args...); !slices.Equal(got, want) {
+			t.Errorf("%q: output %q, want %q", args, got, want)
+		}
+	}
+
+	// Piped in as it is, still compressed, or fetched from a URL
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(gzipped)
+	}))
+	defer server.Close()
+	for _, c := range []struct{ name, stdin, input string }{
+		{"stdin", string(gzipped), "-"},
+		{"url", "", server.URL + "/in.json.gz?signature=x"},
+	} {
+		out := filepath.Join(dir, "out-"+c.name+".txt")
+		if status, _, stderr := runMain(t, c.stdin, c.input, out); status != 0 {
+			t.Fatalf("%s: exit status %d\n%s", c.name, status, stderr)
+		}
+		raw, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := strings.Split(strings.TrimSpace(string(raw)), "\n")
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: output %q, want %q", c.name, got, want)
+		}
+	}
+
+	// Written to stdout, with everything else the run prints on stderr
+	status, stdout, stderr := runMain(t, "", "--compress", "gzip", plainIn, "-")
+	if status != 0 || !strings.Contains(stderr, "Merging results") {
+		t.Fatalf("stdout: exit status %d\n%s", status, stderr)
+	}
+	gz, err := gzip.NewReader(strings.NewReader(stdout))
+	if err != nil {
+		t.Fatalf("stdout: %v", err)
+	}
+	raw, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("stdout: %v", err)
+	}
+	got := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("stdout: output %q, want %q", got, want)
+	}
+
+	truncated := write("short.json.gz", gzipped[:len(gzipped)*2/3])
+	status, _, stderr = runMain(t, "", truncated, filepath.Join(dir, "short.txt"))
+	if status == 0 || !strings.Contains(stderr, "truncated") {
+		t.Errorf("truncated input: exit status %d\n%s", status, stderr)
+	}
+}
+
+// readBundle returns the files of the failure bundle at path by name.
+func readBundle(t *testing.T, path string) map[string]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(body)
+	}
+}
+
+// An injected failure leaves a bundle describing it, and the run exits as it
+// would have without one.
+func TestFailureBundle(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.json.gz")
+	gzipped := gzipBytes(t, []byte(testInput))
+	if err := os.WriteFile(input, gzipped[:len(gzipped)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "in.json")
+	if err := os.WriteFile(plain, []byte(testInput), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	review := filepath.Join(dir, "review.csv")
+	if err := os.WriteFile(review, []byte("john smith,jon smith,maybe\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.txt")
+	for _, c := range []struct {
+		name   string
+		args   []string
+		status int
+		stage  string
+		stack  bool
+		sample string
+	}{
+		// A truncated input fails to load
+		{"truncated input", []string{input, out}, 4, "load", false, string(gzipped[:len(gzipped)/2])},
+		// The review state names an unknown state
+		{"invalid input", []string{"--review-state", review, plain, out}, 4, "load", false, testInput},
+		{"no input sample", []string{"--no-input-sample", "--review-state", review, plain, out}, 4, "load", false, ""},
+	} {
+		bundle := filepath.Join(dir, c.name+".tar.gz")
+		status, _, stderr := runMain(t, "", append([]string{"--on-failure-bundle", bundle}, c.args...)...)
+		if status != c.status {
+			t.Errorf("%s: exit status %d, want %d\n%s", c.name, status, c.status, stderr)
+		}
+		files := readBundle(t, bundle)
+		var config struct{ Flags map[string]string }
+		if err := json.Unmarshal([]byte(files["config.json"]), &config); err != nil {
+			t.Errorf("%s: config.json: %v", c.name, err)
+		} else if config.Flags["on-failure-bundle"] != bundle {
+			t.Errorf("%s: config.json flags %v", c.name, config.Flags)
+		}
+		var report struct{ Error, Stage string }
+		if err := json.Unmarshal([]byte(files["report.json"]), &report); err != nil || report.Error == "" || report.Stage != c.stage {
+			t.Errorf("%s: report.json %q (%v), want stage %q", c.name, files["report.json"], err, c.stage)
+		}
+		var env struct {
+			GOOS   string `json:"goos"`
+			NumCPU int    `json:"num_cpu"`
+		}
+		if err := json.Unmarshal([]byte(files["environment.json"]), &env); err != nil || env.GOOS != runtime.GOOS || env.NumCPU != runtime.NumCPU() {
+			t.Errorf("%s: environment.json %q (%v)", c.name, files["environment.json"], err)
+		}
+		if !strings.Contains(files["log.txt"], "Loading and interning JSON data...") {
+			t.Errorf("%s: log.txt %q", c.name, files["log.txt"])
+		}
+		if _, ok := files["stack.txt"]; ok != c.stack {
+			t.Errorf("%s: stack.txt present %v, want %v", c.name, ok, c.stack)
+		}
+		if got := files["input_sample.bin"]; got != c.sample {
+			t.Errorf("%s: input sample %q, want %q", c.name, got, c.sample)
+		}
+	}
+
+	// A bundle that can't be written is reported and changes nothing else
+	status, _, stderr := runMain(t, "", "--on-failure-bundle", filepath.Join(dir, "missing", "bundle.tar.gz"), "--review-state", review, plain, out)
+	if status != 4 || !strings.Contains(stderr, "could not write failure bundle") {
+		t.Errorf("unwritable bundle: exit status %d\n%s", status, stderr)
+	}
+}
+
+// Every exit writes --exit-status, with the exit code, the class of the
+// error and its details.
+func TestExitStatus(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, raw []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, raw, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	plain := write("in.json", []byte(testInput))
+	bad := write("bad.json", []byte("{\"all_names\": [\n\"john smith\",\n5]}"))
+	review := write("review.csv", []byte("john smith,jon smith,maybe\n"))
+	fewer := write("fewer.json", []byte(`{"all_names": ["john smith", "jon smith"]}`))
+	data, err := compare.Load(strings.NewReader(testInput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index bytes.Buffer
+	if err := data.WriteIndex(&index); err != nil {
+		t.Fatal(err)
+	}
+	oldIndex := index.Bytes()
+	oldIndex[len(compare.IndexMagic)]++
+	old := write("old.cnix", oldIndex)
+	checkpoint := filepath.Join(dir, "checkpoint")
+	out := filepath.Join(dir, "out.txt")
+	if status, _, stderr := runMain(t, "", "--checkpoint", checkpoint, plain, out); status != 0 {
+		t.Fatalf("checkpoint run: exit status %d\n%s", status, stderr)
+	}
+
+	for _, c := range []struct {
+		name string
+		args []string
+		want exitstatus.Status
+	}{
+		{"ok", []string{plain, out}, exitstatus.Status{Class: exitstatus.OK}},
+		{"usage", []string{"--workers", "-1", plain, out}, exitstatus.Status{Status: 2, Class: exitstatus.Usage}},
+		{"few matches", []string{"--min-expected-matches", "100", plain, out}, exitstatus.Status{Status: 3, Class: exitstatus.FewMatches}},
+		{"invalid input", []string{bad, out}, exitstatus.Status{Status: 4, Class: exitstatus.InvalidInput, Field: "all_names", Line: 3}},
+		{"invalid review state", []string{"--review-state", review, plain, out},
+			exitstatus.Status{Status: 4, Class: exitstatus.InvalidInput, Line: 1}},
+		{"schema version", []string{old, out}, exitstatus.Status{Status: 5, Class: exitstatus.SchemaVersion, Field: "index",
+			GotVersion: compare.IndexVersion + 1, WantVersion: compare.IndexVersion}},
+		{"mismatch", []string{"--checkpoint", checkpoint, "--resume", fewer, out}, exitstatus.Status{Status: 6, Class: exitstatus.IndexCorpusMismatch, Field: "checkpoint"}},
+		{"resource limit", []string{"--max-memory", "1KiB", plain, out}, exitstatus.Status{Status: 7, Class: exitstatus.ResourceLimit, Limit: "max-memory"}},
+	} {
+		path := filepath.Join(dir, c.name+".json")
+		status, _, stderr := runMain(t, "", append([]string{"--exit-status", path}, c.args...)...)
+		if status != c.want.Status {
+			t.Errorf("%s: exit status %d, want %d\n%s", c.name, status, c.want.Status, stderr)
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		var got exitstatus.Status
+		if err := json.Unmarshal(raw, &got); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if (got.Error == "") != (c.want.Class == exitstatus.OK) {
+			t.Errorf("%s: error %q", c.name, got.Error)
+		}
+		// Forecasts vary; that they are set is enough
+		if c.want.Limit != "" && got.Need > 0 && got.Available > 0 {
+			got.Need, got.Available = 0, 0
+		}
+		got.Error = ""
+		if got != c.want {
+			t.Errorf("%s: exit status %s", c.name, raw)
+		}
+	}
+}
+
+// bob matches through word_to_matches, phonetic codes and nicknames; j is a
+// one-letter word, whose only tradeout is itself.
+const rulesShowInput = `{
+	"all_names": ["bob smith", "bobby smith", "robert smith", "bob jones", "rob jones", "bub smith", "bop smith", "j smith"],
+	"word_to_matches": {"bob": ["bob", "bobby"], "bobby": ["bobby", "bob"], "j": ["john"], "smith": ["smith"], "jones": ["jones"]}
+}`
+
+const rulesShowGolden = `bob (class word, in 2 names)
+  bob              word_to_matches  validation, tradeout
+  bobby            word_to_matches  validation, tradeout
+  bub              phonetic         validation, tradeout
+  bop              phonetic         validation, tradeout
+  robert           nicknames        validation, tradeout
+  rob              nicknames        validation, tradeout
+j (class initial, in 1 names)
+  john             word_to_matches  validation
+  j                word_to_matches  tradeout
+zed (class word): not in the input
+`
+
+func TestRulesShowGolden(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "in.json")
+	if err := os.WriteFile(input, []byte(rulesShowInput), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	layers := []string{"--phonetic", "soundex", "--nicknames", input}
+	for _, c := range []struct {
+		name  string
+		stdin string
+		args  []string
+	}{
+		{"arguments", "", append(append([]string{"rules", "show"}, layers...), "bob", "j", "zed")},
+		{"stdin", "bob\n\nj\n  zed  \n", append([]string{"rules", "show"}, layers...)},
+	} {
+		status, stdout, stderr := runMain(t, c.stdin, c.args...)
+		if status != 0 {
+			t.Fatalf("%s: exit status %d\n%s", c.name, status, stderr)
+		}
+		if stdout != rulesShowGolden {
+			t.Errorf("%s: output\n%s\nwant\n%s", c.name, stdout, rulesShowGolden)
+		}
+	}
+
+	status, stdout, _ := runMain(t, "", append(append([]string{"rules", "show", "--json"}, layers...), "bob")...)
+	if status != 0 {
+		t.Fatalf("--json: exit status %d", status)
+	}
+	var rules compare.WordRules
+	if err := json.Unmarshal([]byte(stdout), &rules); err != nil {
+		t.Fatal(err)
+	}
+	sources := make(map[string]int)
+	for _, m := range rules.Matches {
+		sources[m.Source]++
+	}
+	if !rules.Known || rules.Frequency != 2 || sources[compare.SourceWordToMatches] != 2 ||
+		sources[compare.SourcePhonetic] != 2 || sources[compare.SourceNicknames] != 2 {
+		t.Errorf("--json: %+v", rules)
+	}
+}
+
+// make-fixture writes a redacted sample and prints the bucket statistics of
+// the corpus and of the fixture, for comparing the two.
+func TestMakeFixture(t *testing.T) {
+	dir := t.TempDir()
+	input, out := filepath.Join(dir, "in.json"), filepath.Join(dir, "out.json")
+	if err := os.WriteFile(input, []byte(testInput), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	status, stdout, stderr := runMain(t, "", "make-fixture", "--names", "3", "--redact", "hmac:key", input, out)
+	if status != 0 {
+		t.Fatalf("exit status %d\n%s", status, stderr)
+	}
+	for _, want := range []string{
+		"Wrote 3 of 4 names to " + out,
+		"Corpus:  4 buckets, size p50 1, p90 1, p99 1, max 1",
+		"Fixture: 3 buckets, size p50 1, p90 1, p99 1, max 1",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("output lacks %q:\n%s", want, stdout)
+		}
+	}
+	raw, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "smith") {
+		t.Errorf("fixture not redacted: %s", raw)
+	}
+}
+
+// Once the run has returned, a signal no longer counts as interrupting it.
+func TestInterruptContextStop(t *testing.T) {
+	ctx, interrupted, stop := interruptContext()
+	stop()
+	// Catch the signal here, or its default action ends the test binary
+	caught := make(chan os.Signal, 1)
+	signal.Notify(caught, os.Interrupt)
+	defer signal.Stop(caught)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-caught:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGINT not delivered")
+	}
+	select {
+	case <-ctx.Done():
+		t.Error("context cancelled by a signal after stop")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if interrupted() {
+		t.Error("interrupted by a signal after stop")
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/input"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/output"
+)
+
+// matchFlags are the flags that decide which pairs match. A run and the
+// explain subcommand share them so an explanation uses the same rules.
+type matchFlags struct {
+	classPolicy    *string
+	particles      *string
+	particlesPath  *string
+	minCommonWords *int
+	strictLengths  *string
+	maxMismatches  *int
+	mismatchTable  *string
+	asymmetric     *bool
+	minScore       *float64
+	minWeighted    *float64
+	rulesPath      *string
+	blocklistPath  *string
+	jaroWinkler    *float64
+	editDistance   *int
+	editMinLength  *int
+	phonetic       *string
+	nicknames      *bool
+	foldCase       *bool
+	normalize      *string
+	maskPath       *string
+	pairsOnDisk    *int
+	// Set by --max-memory: load an index input with its buckets on disk,
+	// whose cache the run sizes once the rest is loaded
+	budgetPairs  bool
+	dictPath     *string
+	reviewPath   *string
+	includePath  *string
+	csvColumns   *string
+	csvMatches   *string
+	csvPairs     *string
+	synonymsPath *string
+	wordMatches  *string
+	pairNames    *string
+	pgDSN        *string
+	pgSynonyms   *string
+}
+
+func newMatchFlags(fs *flag.FlagSet) *matchFlags {
+	return &matchFlags{
+		classPolicy:    fs.String("class-policy", "", "per token class matching policies, e.g. initial=first-letter,particle=ignore,suffix=strict"),
+		particles:      fs.String("particles", "", "comma-separated words treated as particles by --class-policy (default: a built-in list)"),
+		particlesPath:  fs.String("particles-file", "", "file of stop words treated as particles, one or more per line, added to those of --particles (either replaces the built-in list); with --class-policy particle=ignore they neither count as mismatches nor find candidates"),
+		minCommonWords: fs.Int("min-common-words", 2, "words each name must have that match the other name"),
+		strictLengths:  fs.String("strict-lengths", "3", "comma-separated name lengths that allow no mismatch against an equal or longer name (empty for none)"),
+		maxMismatches:  fs.Int("max-mismatches", -1, "maximum mismatched words per name, -1 for no limit"),
+		mismatchTable:  fs.String("mismatch-table", "", "mismatches allowed per name by name lengths, e.g. 2-3/2-3=0,4+/4+=1,7+/7+=2 (lengths a/lengths b=mismatches; n, lo-hi, lo+ or *; first matching entry applies)"),
+		asymmetric:     fs.Bool("asymmetric", false, "only the shorter name's mismatches count, so a short alias matches a longer name containing it"),
+		minScore:       fs.Float64("min-score", 0, "drop pairs scoring below this, even if they pass the other rules"),
+		minWeighted:    fs.Float64("min-weighted-score", 0, "drop pairs whose IDF-weighted score is below this: like the score, but each word counts by how rare it is among the names (0 turns it off; set --min-common-words 0 and --strict-lengths '' to use it instead of the mismatch rules)"),
+		rulesPath:      fs.String("validation-rules", "", "JSON file of further validation rules on the word and mismatch counts, checked after the thresholds (see compare/policy.go)"),
+		blocklistPath:  fs.String("exclude-word-pairs", "", "file of word pairs, one per line (e.g. inc,llc), that never match: a pair is dropped when one name has one word and the other name the other"),
+		jaroWinkler:    fs.Float64("jaro-winkler", 0, "a word matching nothing through word_to_matches still matches a word of the other name this Jaro-Winkler similar, e.g. 0.92 (0 turns it off)"),
+		editDistance:   fs.Int("edit-distance", 0, "a word matching nothing through word_to_matches still matches a word of the other name within this many edits (0 turns it off)"),
+		editMinLength:  fs.Int("edit-min-length", 5, "--edit-distance only compares words of at least this many characters"),
+		phonetic:       fs.String("phonetic", "", "words of the names sharing a phonetic code also match: soundex or double-metaphone (both can group many words, so check them with 'rules show')"),
+		nicknames:      fs.Bool("nicknames", false, "words of the names that are nicknames of each other also match, from a built-in English table (e.g. bob and robert)"),
+		foldCase:       fs.Bool("fold-case-compare", false, "compare words case-insensitively; output keeps each name's original case"),
+		normalize:      fs.String("normalize", "", "normalize tokens before interning: comma-separated accents, case, punct, translit (Cyrillic and Greek to Latin), or all; output keeps the original names"),
+		maskPath:       fs.String("mask-tokens", "", "file of regular expressions, one per line; words matching any of them are dropped from names"),
+		pairsOnDisk:    fs.Int("pairs-on-disk", 0, "with an index input from build-index, leave the pair_to_names buckets in the file and cache up to this many MiB of them, for indexes too large for memory (0 loads them all)"),
+		dictPath:       fs.String("dict", "", "dictionary TSV from 'dict export'; the input then references words by ID"),
+		reviewPath:     fs.String("review-state", "", "CSV of reviewed pairs (name_a,name_b,state) to apply to the output"),
+		includePath:    fs.String("include-pairs", "", "file of pairs (tuple, csv or jsonl, like the output) always written, without validation, as confirmed pairs; --review-state rows for the same pairs take precedence"),
+		csvColumns:     fs.String("csv-name-columns", "", "for a .csv input: comma-separated columns, by header or 1-based position, joined into each name (default: the first)"),
+		csvMatches:     fs.String("csv-word-matches", "", "for a .csv input: CSV of word,match[,match...] rows to use as word_to_matches"),
+		csvPairs:       fs.String("csv-pair-names", "", "for a .csv input: CSV of pair,name[,name...] rows to use as pair_to_names (default: built from the names)"),
+		synonymsPath:   fs.String("synonyms", "", "for a .txt input of one name per line: file of synonym groups, one per line, whose words match each other"),
+		wordMatches:    fs.String("word-to-matches", "", "for a JSON input: file holding just the word_to_matches object, read after the input"),
+		pairNames:      fs.String("pair-to-names", "", "for a JSON input: file holding just the pair_to_names object, read after the input"),
+		pgDSN:          fs.String("pg-dsn", "", "read the names from Postgres through psql with this connection string; the input is then the SQL query selecting them (keep passwords in PGPASSWORD or ~/.pgpass)"),
+		pgSynonyms:     fs.String("pg-synonyms", "", "with --pg-dsn: SQL query selecting synonym groups, one row each, whose words match each other"),
+	}
+}
+
+// options checks the flags that don't need the input.
+func (f *matchFlags) options() (compare.Options, error) {
+	policies, err := compare.ParseClassPolicies(*f.classPolicy)
+	if err != nil {
+		return compare.Options{}, err
+	}
+	cfg := compare.MatchConfig{
+		MinCommonWords:   *f.minCommonWords,
+		MaxMismatches:    *f.maxMismatches,
+		MinScore:         *f.minScore,
+		Asymmetric:       *f.asymmetric,
+		MinWeightedScore: *f.minWeighted,
+		FuzzyThreshold:   *f.jaroWinkler,
+		MaxEditDistance:  *f.editDistance,
+		EditMinLength:    *f.editMinLength,
+	}
+	if cfg.FuzzyThreshold < 0 || cfg.FuzzyThreshold > 1 {
+		return compare.Options{}, fmt.Errorf("--jaro-winkler %v: want a similarity between 0 and 1", cfg.FuzzyThreshold)
+	}
+	if cfg.MinWeightedScore < 0 || cfg.MinWeightedScore > 1 {
+		return compare.Options{}, fmt.Errorf("--min-weighted-score %v: want a score between 0 and 1", cfg.MinWeightedScore)
+	}
+	if cfg.MaxEditDistance < 0 {
+		return compare.Options{}, fmt.Errorf("--edit-distance %d: want 0 or more", cfg.MaxEditDistance)
+	}
+	if cfg.StrictLengths, err = parseIntList(*f.strictLengths); err != nil {
+		return compare.Options{}, fmt.Errorf("--strict-lengths: %w", err)
+	}
+	if cfg.MismatchTable, err = compare.ParseMismatchTable(*f.mismatchTable); err != nil {
+		return compare.Options{}, fmt.Errorf("--mismatch-table: %w", err)
+	}
+	if *f.rulesPath != "" {
+		file, err := os.Open(*f.rulesPath)
+		if err != nil {
+			return compare.Options{}, err
+		}
+		cfg.Rules, err = compare.ParseValidationRules(file)
+		file.Close()
+		if err != nil {
+			return compare.Options{}, fmt.Errorf("--validation-rules %s: %w", *f.rulesPath, err)
+		}
+	}
+	return compare.Options{ClassPolicies: policies, Match: &cfg}, nil
+}
+
+// load loads the input at inputPath with the companion files and
+// tokenization of the flags.
+func (f *matchFlags) load(inputPath string) (*compare.Data, error) {
+	norm, err := compare.ParseNormalization(*f.normalize)
+	if err != nil {
+		return nil, err
+	}
+	opts := compare.LoadOptions{FoldCase: *f.foldCase, Normalize: norm}
+	if *f.maskPath != "" {
+		file, err := os.Open(*f.maskPath)
+		if err != nil {
+			return nil, err
+		}
+		opts.Mask, err = compare.ParseTokenMask(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("--mask-tokens %s: %w", *f.maskPath, err)
+		}
+	}
+	src := input.Source{
+		Dict:        *f.dictPath,
+		WordMatches: *f.wordMatches,
+		PairNames:   *f.pairNames,
+		CSVMatches:  *f.csvMatches,
+		CSVPairs:    *f.csvPairs,
+		Synonyms:    *f.synonymsPath,
+		PGDSN:       *f.pgDSN,
+		PGSynonyms:  *f.pgSynonyms,
+		PairsOnDisk: int64(*f.pairsOnDisk) << 20,
+		DiskPairs:   f.budgetPairs,
+	}
+	if *f.csvColumns != "" {
+		src.CSVColumns = strings.Split(*f.csvColumns, ",")
+	}
+	return src.Load(inputPath, opts)
+}
+
+// prepare applies the flags that depend on the loaded input.
+func (f *matchFlags) prepare(data *compare.Data, opts *compare.Options) error {
+	var particles []string
+	if *f.particles != "" {
+		particles = strings.Split(*f.particles, ",")
+	}
+	if *f.particlesPath != "" {
+		err := input.ForEachLine(*f.particlesPath, func(line string) error {
+			if line = strings.TrimSpace(line); !strings.HasPrefix(line, "#") {
+				particles = append(particles, strings.FieldsFunc(line, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })...)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("--particles-file: %w", err)
+		}
+	}
+	if particles != nil {
+		data.ClassifyTokens(particles)
+	}
+	alg, err := compare.ParsePhoneticAlgorithm(*f.phonetic)
+	if err != nil {
+		return fmt.Errorf("--phonetic: %w", err)
+	}
+	data.AddPhoneticMatches(alg)
+	if *f.nicknames {
+		data.AddNicknames()
+	}
+	if *f.minWeighted > 0 && opts.Match != nil {
+		opts.Match.Weights = data.IDFWeights()
+	}
+	if *f.blocklistPath != "" && opts.Match != nil {
+		file, err := os.Open(*f.blocklistPath)
+		if err != nil {
+			return err
+		}
+		opts.Match.Blocklist, err = compare.ParseWordBlocklist(file, data)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("--exclude-word-pairs %s: %w", *f.blocklistPath, err)
+		}
+	}
+	if *f.reviewPath != "" {
+		review, err := input.ReadReviewStates(*f.reviewPath, data)
+		if err != nil {
+			return err
+		}
+		opts.Review = review
+	}
+	if *f.includePath != "" {
+		if opts.Review == nil {
+			opts.Review = compare.NewReviewStates()
+		}
+		err := output.ReadPairs(*f.includePath, func(a, b string) { opts.Review.AddConfirmed(data, a, b) })
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseIntList(s string) ([]int, error) {
+	var out []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
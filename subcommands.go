@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/JohnnyWeymouth/compare-all-the-names/compare"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/exitstatus"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/input"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/inputdiff"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/records"
+	"github.com/JohnnyWeymouth/compare-all-the-names/internal/report"
+)
+
+// runDictExport writes the dictionary Load builds for an input, so another
+// producer can emit the ID-based input form against it.
+func runDictExport(args []string) {
+	fs := flag.NewFlagSet("dict export", flag.ExitOnError)
+	foldCase := fs.Bool("fold-case-compare", false, "export the dictionary of a --fold-case-compare run")
+	normalize := fs.String("normalize", "", "export the dictionary of a --normalize run")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		subcommandUsage(fs, "dict export [--fold-case-compare] [--normalize steps] <input.json> <dict.tsv>")
+	}
+	args = fs.Args()
+	norm, err := compare.ParseNormalization(*normalize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitstatus.Usage.Code())
+	}
+	data, err := input.LoadFile(args[0], "", compare.LoadOptions{FoldCase: *foldCase, Normalize: norm})
+	if err != nil {
+		subcommandFail(err)
+	}
+	out, err := os.Create(args[1])
+	if err == nil {
+		err = data.WriteDictionary(out)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		subcommandFail(err)
+	}
+	fmt.Printf("Wrote %d words to %s\n", data.Dict.Len(), args[1])
+}
+
+// runBuildIndex loads an input once and writes it as an index file, which
+// later runs, explain and rules show take in place of the input.
+func runBuildIndex(args []string) {
+	fs := flag.NewFlagSet("build-index", flag.ExitOnError)
+	match := newMatchFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		subcommandUsage(fs, "build-index [flags] <input.json> <index.cnix>")
+	}
+	data, err := match.load(fs.Arg(0))
+	if err == nil {
+		err = writeIndexFile(fs.Arg(1), data)
+	}
+	if err != nil {
+		subcommandFail(err)
+	}
+	fmt.Printf("Wrote index of %d names, %d words and %d pair keys to %s\n", len(data.AllNames), data.Dict.Len(), data.Buckets(), fs.Arg(1))
+}
+
+func writeIndexFile(path string, data *compare.Data) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := data.WriteIndex(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// subcommandUsage reports a subcommand called with the wrong arguments: it
+// prints usage and the subcommand's flags to stderr and exits 2.
+func subcommandUsage(fs *flag.FlagSet, usage string) {
+	fmt.Fprintln(os.Stderr, "Usage: ./pair_comparator "+usage)
+	fs.PrintDefaults()
+	os.Exit(exitstatus.Usage.Code())
+}
+
+// subcommandFail reports err and exits with the code of its class, like a
+// run does.
+func subcommandFail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(exitstatus.Classify(err).Code())
+}
+
+// runInputDiff compares two inputs section by section (see inputdiff.Diff).
+func runInputDiff(args []string) {
+	fs := flag.NewFlagSet("input-diff", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	samples := fs.Int("samples", 10, "number of example entries to show per category")
+	resizeThreshold := fs.Float64("resize-threshold", 0.25, "relative size change for a pair bucket to count as resized")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		subcommandUsage(fs, "input-diff [--json] <old.json> <new.json>")
+	}
+
+	diff, err := inputdiff.Diff(fs.Arg(0), fs.Arg(1), *samples, *resizeThreshold)
+	if err != nil {
+		subcommandFail(err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diff); err != nil {
+			subcommandFail(err)
+		}
+		return
+	}
+	diff.WriteText(os.Stdout)
+}
+
+// --- EXPLAIN ---
+
+func runExplain(args []string) {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the explanation as JSON")
+	match := newMatchFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 3 {
+		subcommandUsage(fs, "explain [--json] [flags] <input.json> <name a> <name b>")
+	}
+	opts, err := match.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitstatus.Usage.Code())
+	}
+	data, err := match.load(fs.Arg(0))
+	if err == nil {
+		err = match.prepare(data, &opts)
+	}
+	if err != nil {
+		subcommandFail(err)
+	}
+	e, err := compare.NewMatcher(data, opts).Explain(fs.Arg(1), fs.Arg(2))
+	if err != nil {
+		subcommandFail(err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(e)
+		return
+	}
+	report.Explanation(os.Stdout, e)
+}
+
+// runRulesShow prints the resolved match set of each word given, or of each
+// word read from stdin (one per line) when none are.
+func runRulesShow(args []string) {
+	fs := flag.NewFlagSet("rules show", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print one JSON object per word")
+	match := newMatchFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		subcommandUsage(fs, "rules show [--json] [flags] <input.json> [word...]")
+	}
+	data, err := match.load(fs.Arg(0))
+	if err == nil {
+		var opts compare.Options
+		err = match.prepare(data, &opts)
+	}
+	if err != nil {
+		subcommandFail(err)
+	}
+	resolver := compare.NewRuleResolver(data)
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	show := func(word string) {
+		rules := resolver.Resolve(word)
+		if *asJSON {
+			raw, _ := json.Marshal(rules)
+			out.Write(append(raw, '\n'))
+			return
+		}
+		report.Rules(out, rules)
+	}
+
+	if fs.NArg() > 1 {
+		for _, word := range fs.Args()[1:] {
+			show(word)
+		}
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if word := strings.TrimSpace(scanner.Text()); word != "" {
+			show(word)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		out.Flush()
+		subcommandFail(err)
+	}
+}
+
+// --- INSPECT TEMP ---
+
+// runInspectTemp dumps any intermediate record file, stopping with an error
+// at the first corrupt block.
+func runInspectTemp(args []string) {
+	fs := flag.NewFlagSet("inspect-temp", flag.ExitOnError)
+	summary := fs.Bool("summary", false, "only print the record counts")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		subcommandUsage(fs, "inspect-temp [--summary] <file>")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		subcommandFail(err)
+	}
+	defer f.Close()
+	if err := records.Dump(os.Stdout, f, *summary); err != nil {
+		subcommandFail(err)
+	}
+}
+
+// --- FIXTURES ---
+
+// runMakeFixture writes a connected, optionally pseudonymized sample of an
+// input (see Data.WriteFixture) and prints the bucket sizes of both, so the
+// sample can be checked against the corpus it stands in for.
+func runMakeFixture(args []string) {
+	fs := flag.NewFlagSet("make-fixture", flag.ExitOnError)
+	names := fs.Int("names", 50000, "number of distinct names to sample")
+	seeds := fs.Int("seeds", 100, "number of random names the sample is grown from")
+	seed := fs.Uint64("seed", 1, "random seed; the same seed and input give the same fixture")
+	redact := fs.String("redact", "", "pseudonymize every word: hmac:<key>, or hmac:@<file> to read the key from a file")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		subcommandUsage(fs, "make-fixture [--names n] [--seeds n] [--seed n] [--redact hmac:<key>] <input.json> <out.json>")
+	}
+	opts := compare.FixtureOptions{Names: *names, Seeds: *seeds, RandomSeed: *seed}
+	if *redact != "" {
+		key, err := redactionKey(*redact)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitstatus.Usage.Code())
+		}
+		opts.Redact = compare.HMACRedactor(key)
+	}
+
+	data, err := input.LoadFile(fs.Arg(0), "", compare.LoadOptions{})
+	if err != nil {
+		subcommandFail(err)
+	}
+	out, err := os.Create(fs.Arg(1))
+	var written int
+	if err == nil {
+		written, err = data.WriteFixture(out, opts)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	var fixture *compare.Data
+	if err == nil {
+		fixture, err = input.LoadFile(fs.Arg(1), "", compare.LoadOptions{})
+	}
+	if err != nil {
+		subcommandFail(err)
+	}
+	fmt.Printf("Wrote %d of %d names to %s\n", written, len(data.Names), fs.Arg(1))
+	fmt.Printf("Corpus:  %s\n", report.BucketSizes(data))
+	fmt.Printf("Fixture: %s\n", report.BucketSizes(fixture))
+}
+
+// redactionKey reads the key of a --redact value.
+func redactionKey(spec string) ([]byte, error) {
+	key, ok := strings.CutPrefix(spec, "hmac:")
+	if !ok || key == "" {
+		return nil, fmt.Errorf("--redact %q: want hmac:<key> or hmac:@<file>", spec)
+	}
+	if path, ok := strings.CutPrefix(key, "@"); ok {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimSpace(raw), nil
+	}
+	return []byte(key), nil
+}